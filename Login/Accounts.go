@@ -0,0 +1,191 @@
+package Login
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Carsen/Qube/QbDB"
+)
+
+var (
+	ErrUserExists = errors.New("user already exists")
+	ErrNoUser     = errors.New("no such user")
+	ErrBadToken   = errors.New("token not recognised")
+)
+
+// AddUser creates an account without the prompt, for scripts and the
+// command line.
+func AddUser(name, password string) error {
+	if name == "" || password == "" {
+		return errors.New("username and password can't be empty")
+	}
+	exists, err := QbDB.CheckForKey(hashInput(name))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrUserExists
+	}
+	if err := QbDB.NewKeyValue(hashInput(name), hashInput(password)); err != nil {
+		return err
+	}
+	logger().Info("account created", "user", hex.EncodeToString(hashInput(name)[:8]))
+	return nil
+}
+
+// SetPassword replaces the password of an existing account.
+func SetPassword(name, password string) error {
+	if password == "" {
+		return errors.New("password can't be empty")
+	}
+	exists, err := QbDB.CheckForKey(hashInput(name))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoUser
+	}
+	if err := QbDB.NewKeyValue(hashInput(name), hashInput(password)); err != nil {
+		return err
+	}
+	logger().Info("password changed", "user", hex.EncodeToString(hashInput(name)[:8]))
+	return nil
+}
+
+// DeleteUser removes an account and every token issued for it.
+func DeleteUser(name string) error {
+	exists, err := QbDB.CheckForKey(hashInput(name))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoUser
+	}
+	if err := QbDB.DeleteRecord(string(hashInput(name))); err != nil {
+		return err
+	}
+	tokens, err := Tokens()
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.User == name {
+			if err := RevokeToken(t.ID); err != nil {
+				return err
+			}
+		}
+	}
+	logger().Info("account deleted", "user", hex.EncodeToString(hashInput(name)[:8]))
+	return nil
+}
+
+// UseLocal acts as name without a password. It is for local admin mode,
+// where whoever owns the database already has full access to it.
+func UseLocal(name string) {
+	user = name
+	logger().Info("local admin", "user", UserID())
+}
+
+// Token is an API token as stored; the secret itself is never kept, only
+// its hash, which is the record key.
+type Token struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+// tokenKey is half the hash, which keeps it under bitcask's key size
+// limit and is still far too long to guess.
+func tokenKey(secret string) string {
+	return "token:" + hex.EncodeToString(hashInput(secret)[:16])
+}
+
+// NewToken issues a token for an existing user and returns its secret,
+// which can't be recovered later.
+func NewToken(username, name string) (string, Token, error) {
+	exists, err := QbDB.CheckForKey(hashInput(username))
+	if err != nil {
+		return "", Token{}, err
+	}
+	if !exists {
+		return "", Token{}, ErrNoUser
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", Token{}, err
+	}
+	secret := "qube_" + hex.EncodeToString(b)
+	key := tokenKey(secret)
+	t := Token{ID: key[len("token:"):][:12], User: username, Name: name, Created: time.Now().UTC()}
+	if err := QbDB.SaveRecord(key, t); err != nil {
+		return "", Token{}, err
+	}
+	logger().Info("token issued", "user", hex.EncodeToString(hashInput(username)[:8]), "token", t.ID)
+	return secret, t, nil
+}
+
+// UseToken logs in as the user the token was issued to.
+func UseToken(secret string) error {
+	var t Token
+	if err := QbDB.LoadRecord(tokenKey(secret), &t); err != nil {
+		logger().Warn("unknown token")
+		return ErrBadToken
+	}
+	exists, err := QbDB.CheckForKey(hashInput(t.User))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBadToken
+	}
+	user = t.User
+	logger().Info("logged in", "user", UserID(), "token", t.ID)
+	return nil
+}
+
+// Tokens lists every issued token, oldest first.
+func Tokens() ([]Token, error) {
+	keys, err := QbDB.ListRecords("token:")
+	if err != nil {
+		return nil, err
+	}
+	var out []Token
+	for _, k := range keys {
+		var t Token
+		if QbDB.LoadRecord(k, &t) == nil {
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out, nil
+}
+
+// RevokeToken deletes the token whose ID is exactly id. Two tokens can
+// share an ID only by a 48-bit collision, but if they do neither is
+// revoked rather than guessing.
+func RevokeToken(id string) error {
+	keys, err := QbDB.ListRecords("token:")
+	if err != nil {
+		return err
+	}
+	var match []string
+	for _, k := range keys {
+		var t Token
+		if QbDB.LoadRecord(k, &t) == nil && t.ID == id {
+			match = append(match, k)
+		}
+	}
+	switch len(match) {
+	case 0:
+		return fmt.Errorf("no token %q", id)
+	case 1:
+		logger().Info("token revoked", "token", id)
+		return QbDB.DeleteRecord(match[0])
+	}
+	return fmt.Errorf("token id %q is ambiguous: %d tokens share it", id, len(match))
+}
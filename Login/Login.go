@@ -2,12 +2,52 @@ package Login
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"os/exec"
 
 	"github.com/Carsen/Qube/QbDB"
 )
 
+// user is whoever last logged in successfully.
+var user string
+
+// Login policy, set from the application's configuration.
+var (
+	// AllowSignup offers to create an account for a name not seen before.
+	AllowSignup = true
+	// MaxAttempts is how many tries the prompt allows.
+	MaxAttempts = 5
+)
+
+// Username returns the name given at login, or "" before Login succeeds.
+func Username() string {
+	return user
+}
+
+// UserID is a short stable key for the logged-in user, safe to use in
+// QbDB keys. It is derived from the username hash, not the name itself.
+func UserID() string {
+	if user == "" {
+		return ""
+	}
+	return hex.EncodeToString(hashInput(user)[:8])
+}
+
+// logger is where Login reports. Usernames stay out of the log; UserID
+// identifies who it was.
+func logger() *slog.Logger {
+	return slog.Default().With("component", "Login")
+}
+
+// dbFailed tells the user the user table can't be read; the details are
+// already in the log.
+func dbFailed(err error) bool {
+	fmt.Println("Qube can't read its user database:", err)
+	return false
+}
+
 func Login(running bool) bool {
 	var checker bool = false
 	var i int = -2
@@ -19,7 +59,7 @@ func Login(running bool) bool {
 		fmt.Scanln(&answer)
 
 		if answer == "y" {
-			i = 5
+			i = MaxAttempts
 			cls()
 		} else if answer == "n" {
 			cls()
@@ -39,7 +79,11 @@ func Login(running bool) bool {
 			fmt.Scanln(&inUsern)
 			hashUsern = hashInput(inUsern)
 
-			switch QbDB.CheckForKey(hashUsern) {
+			exists, err := QbDB.CheckForKey(hashUsern)
+			if err != nil {
+				return dbFailed(err)
+			}
+			switch exists {
 			case true:
 				fmt.Print("Please enter password: ")
 				var inPassw string
@@ -47,18 +91,31 @@ func Login(running bool) bool {
 				fmt.Scanln(&inPassw)
 				hashPassw = hashInput(inPassw)
 
-				switch QbDB.ValueMatchesKey(hashUsern, hashPassw) {
+				match, err := QbDB.ValueMatchesKey(hashUsern, hashPassw)
+				if err != nil {
+					return dbFailed(err)
+				}
+				switch match {
 				case true:
 					cls()
+					user = inUsern
+					logger().Info("logged in", "user", UserID())
 					checker = true
 					return checker
 				case false:
 					cls()
+					logger().Warn("wrong password", "user", hex.EncodeToString(hashUsern[:8]))
 					fmt.Println("Try again!")
 					i--
 				}
 
 			case false:
+				if !AllowSignup {
+					cls()
+					fmt.Println("No account by that name. Ask an administrator to add you.")
+					i--
+					continue
+				}
 				var mkAcc string
 				var runChk int
 				runChk = 5
@@ -77,7 +134,11 @@ func Login(running bool) bool {
 						if inPassw == matchPassw {
 							var hashPassw []byte
 							hashPassw = hashInput(inPassw)
-							QbDB.NewKeyValue(hashUsern, hashPassw)
+							if err := QbDB.NewKeyValue(hashUsern, hashPassw); err != nil {
+								return dbFailed(err)
+							}
+							user = inUsern
+							logger().Info("account created", "user", UserID())
 							checker = true
 							return checker
 						} else if inPassw != matchPassw {
@@ -102,6 +163,7 @@ func Login(running bool) bool {
 		}
 		if i == 0 {
 			cls()
+			logger().Warn("too many login attempts")
 			fmt.Println("Too many tries!")
 			checker = false
 			break
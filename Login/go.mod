@@ -24,3 +24,5 @@ require (
 	golang.org/x/term v0.17.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
 )
+
+replace github.com/Carsen/Qube/QbDB => ../QbDB
@@ -0,0 +1,429 @@
+package QCom
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// BPFInstruction mirrors the kernel's struct sock_filter.
+type BPFInstruction struct {
+	Op uint16
+	Jt uint8
+	Jf uint8
+	K  uint32
+}
+
+// Classic BPF opcode pieces.
+const (
+	bpfLD   = 0x00
+	bpfLDX  = 0x01
+	bpfALU  = 0x04
+	bpfJMP  = 0x05
+	bpfRET  = 0x06
+	bpfW    = 0x00
+	bpfH    = 0x08
+	bpfB    = 0x10
+	bpfABS  = 0x20
+	bpfIND  = 0x40
+	bpfMSH  = 0xa0
+	bpfAND  = 0x50
+	bpfJEQ  = 0x10
+	bpfJGT  = 0x20
+	bpfJGE  = 0x30
+	bpfJSET = 0x40
+	bpfK    = 0x00
+)
+
+// bpfTest is a single "load, optionally mask, compare" step. Offsets are
+// into the network header, wherever the link layer puts it; ind tests are
+// relative to the IPv4 header length loaded into X.
+type bpfTest struct {
+	size uint16 // bpfB, bpfH or bpfW
+	off  uint32
+	ind  bool
+	mask uint32
+	val  uint32
+	jset bool   // true when any bit in val is set
+	hi   uint32 // when non-zero, true for val <= A <= hi
+	link bool   // off is into the link header instead
+}
+
+type bpfNode struct {
+	op   string // "and", "or", "not", "test", "true", "ethertype"
+	l, r *bpfNode
+	test bpfTest
+}
+
+func bpfAnd(l, r *bpfNode) *bpfNode { return &bpfNode{op: "and", l: l, r: r} }
+func bpfOr(l, r *bpfNode) *bpfNode  { return &bpfNode{op: "or", l: l, r: r} }
+func bpfNot(x *bpfNode) *bpfNode    { return &bpfNode{op: "not", l: x} }
+func bpfEq(size uint16, off, val uint32) *bpfNode {
+	return &bpfNode{op: "test", test: bpfTest{size: size, off: off, val: val}}
+}
+
+// The network protocol tests depend on the link type, so they are left as
+// "ethertype" nodes for the generator.
+var (
+	bpfIsIPv4 = &bpfNode{op: "ethertype", test: bpfTest{val: 0x0800}}
+	bpfIsIPv6 = &bpfNode{op: "ethertype", test: bpfTest{val: 0x86dd}}
+	bpfIsARP  = &bpfNode{op: "ethertype", test: bpfTest{val: 0x0806}}
+)
+
+func bpfIPProto(proto uint32) *bpfNode {
+	return bpfOr(
+		bpfAnd(bpfIsIPv4, bpfEq(bpfB, 9, proto)),
+		bpfAnd(bpfIsIPv6, bpfEq(bpfB, 6, proto)),
+	)
+}
+
+// CompileBPF turns a tcpdump-style expression into a classic BPF program
+// for frames of linkType, which must be Ethernet or raw IP. It covers the
+// common primitives: ip, ip6, arp, tcp, udp, icmp, icmp6, [src|dst] host
+// ADDR, [src|dst] net CIDR, [src|dst] port N and portrange A-B, joined
+// with and/or/not and parentheses.
+func CompileBPF(expr string, linkType uint32, snaplen int) ([]BPFInstruction, error) {
+	if snaplen <= 0 {
+		snaplen = 262144
+	}
+	g := &bpfGen{}
+	p := &bpfParser{toks: bpfTokens(expr)}
+	var root *bpfNode
+	if len(p.toks) == 0 {
+		root = &bpfNode{op: "true"}
+	} else {
+		switch linkType {
+		case LinkTypeEthernet:
+			g.hdr = 14
+		case LinkTypeRaw:
+			g.raw = true
+		default:
+			return nil, fmt.Errorf("bpf: can't filter link type %d", linkType)
+		}
+		var err error
+		if root, err = p.or(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.toks) {
+			return nil, fmt.Errorf("bpf: unexpected %q", p.toks[p.pos])
+		}
+	}
+
+	accept, reject := g.label(), g.label()
+	g.gen(root, accept, reject)
+	g.place(accept)
+	g.emit(BPFInstruction{Op: bpfRET | bpfK, K: uint32(snaplen)})
+	g.place(reject)
+	g.emit(BPFInstruction{Op: bpfRET | bpfK, K: 0})
+	return g.resolve()
+}
+
+func bpfTokens(expr string) []string {
+	expr = strings.NewReplacer("(", " ( ", ")", " ) ", "&&", " and ", "||", " or ", "!", " not ").Replace(expr)
+	return strings.Fields(strings.ToLower(expr))
+}
+
+type bpfParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *bpfParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *bpfParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *bpfParser) or() (*bpfNode, error) {
+	l, err := p.and()
+	for err == nil && p.peek() == "or" {
+		p.pos++
+		var r *bpfNode
+		if r, err = p.and(); err == nil {
+			l = bpfOr(l, r)
+		}
+	}
+	return l, err
+}
+
+func (p *bpfParser) and() (*bpfNode, error) {
+	l, err := p.unary()
+	for err == nil && p.peek() != "" && p.peek() != "or" && p.peek() != ")" {
+		// tcpdump treats juxtaposition like "tcp port 80" as an implicit and.
+		if p.peek() == "and" {
+			p.pos++
+		}
+		var r *bpfNode
+		if r, err = p.unary(); err == nil {
+			l = bpfAnd(l, r)
+		}
+	}
+	return l, err
+}
+
+func (p *bpfParser) unary() (*bpfNode, error) {
+	switch p.peek() {
+	case "not":
+		p.pos++
+		x, err := p.unary()
+		return bpfNot(x), err
+	case "(":
+		p.pos++
+		x, err := p.or()
+		if err == nil && p.next() != ")" {
+			err = errors.New("bpf: missing )")
+		}
+		return x, err
+	}
+	return p.primitive()
+}
+
+func (p *bpfParser) primitive() (*bpfNode, error) {
+	dir := ""
+	if t := p.peek(); t == "src" || t == "dst" {
+		dir = p.next()
+	}
+	switch t := p.next(); t {
+	case "ip":
+		return bpfIsIPv4, nil
+	case "ip6":
+		return bpfIsIPv6, nil
+	case "arp":
+		return bpfIsARP, nil
+	case "tcp":
+		return bpfIPProto(6), nil
+	case "udp":
+		return bpfIPProto(17), nil
+	case "icmp":
+		return bpfAnd(bpfIsIPv4, bpfEq(bpfB, 9, 1)), nil
+	case "icmp6":
+		return bpfAnd(bpfIsIPv6, bpfEq(bpfB, 6, 58)), nil
+	case "host":
+		a, err := netip.ParseAddr(p.next())
+		if err != nil {
+			return nil, fmt.Errorf("bpf: host needs an address")
+		}
+		return bpfHost(dir, netip.PrefixFrom(a, a.BitLen())), nil
+	case "net":
+		pfx, err := ParsePrefix(p.next())
+		if err != nil {
+			return nil, err
+		}
+		return bpfHost(dir, pfx), nil
+	case "port", "portrange":
+		lo, hi, err := bpfPortRange(t, p.next())
+		if err != nil {
+			return nil, err
+		}
+		return bpfPort(dir, lo, hi), nil
+	case "":
+		return nil, errors.New("bpf: expression ends early")
+	default:
+		return nil, fmt.Errorf("bpf: unknown primitive %q", t)
+	}
+}
+
+func bpfPortRange(kind, s string) (uint32, uint32, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if isRange != (kind == "portrange") {
+		return 0, 0, fmt.Errorf("bpf: bad %s %q", kind, s)
+	}
+	if !isRange {
+		hi = lo
+	}
+	a, err1 := strconv.ParseUint(lo, 10, 16)
+	b, err2 := strconv.ParseUint(hi, 10, 16)
+	if err1 != nil || err2 != nil || b < a {
+		return 0, 0, fmt.Errorf("bpf: bad %s %q", kind, s)
+	}
+	return uint32(a), uint32(b), nil
+}
+
+func bpfHost(dir string, pfx netip.Prefix) *bpfNode {
+	match := func(off uint32) *bpfNode {
+		b := pfx.Addr().AsSlice()
+		var n *bpfNode
+		for i := 0; i < len(b); i += 4 {
+			bits := pfx.Bits() - i*8
+			if bits <= 0 {
+				break
+			}
+			mask := uint32(0xffffffff)
+			if bits < 32 {
+				mask <<= 32 - bits
+			}
+			word := uint32(b[i])<<24 | uint32(b[i+1])<<16 | uint32(b[i+2])<<8 | uint32(b[i+3])
+			t := &bpfNode{op: "test", test: bpfTest{size: bpfW, off: off + uint32(i), mask: mask, val: word & mask}}
+			if n == nil {
+				n = t
+			} else {
+				n = bpfAnd(n, t)
+			}
+		}
+		if n == nil {
+			n = &bpfNode{op: "true"}
+		}
+		return n
+	}
+	ethType, src, dst := bpfIsIPv4, uint32(12), uint32(16)
+	if pfx.Addr().Is6() {
+		ethType, src, dst = bpfIsIPv6, 8, 24
+	}
+	switch dir {
+	case "src":
+		return bpfAnd(ethType, match(src))
+	case "dst":
+		return bpfAnd(ethType, match(dst))
+	}
+	return bpfAnd(ethType, bpfOr(match(src), match(dst)))
+}
+
+func bpfPort(dir string, lo, hi uint32) *bpfNode {
+	ports := func(ind bool, base uint32) *bpfNode {
+		var n *bpfNode
+		for _, off := range []uint32{0, 2} {
+			if (dir == "src" && off == 2) || (dir == "dst" && off == 0) {
+				continue
+			}
+			t := &bpfNode{op: "test", test: bpfTest{size: bpfH, off: base + off, ind: ind, val: lo, hi: hi}}
+			if n == nil {
+				n = t
+			} else {
+				n = bpfOr(n, t)
+			}
+		}
+		return n
+	}
+	l4 := func(off uint32) *bpfNode { return bpfOr(bpfEq(bpfB, off, 6), bpfEq(bpfB, off, 17)) }
+	// Only the first IPv4 fragment carries the transport header.
+	unfragmented := bpfNot(&bpfNode{op: "test", test: bpfTest{size: bpfH, off: 6, val: 0x1fff, jset: true}})
+	return bpfOr(
+		bpfAnd(bpfAnd(bpfIsIPv4, l4(9)), bpfAnd(unfragmented, ports(true, 0))),
+		bpfAnd(bpfAnd(bpfIsIPv6, l4(6)), ports(false, 40)),
+	)
+}
+
+type bpfJump struct {
+	at, label int
+	true      bool
+}
+
+type bpfGen struct {
+	prog   []BPFInstruction
+	labels []int
+	jumps  []bpfJump
+	// hdr is the link header length; raw links have none, and no
+	// ethertype either, so the IP version nibble stands in for it.
+	hdr uint32
+	raw bool
+}
+
+func (g *bpfGen) label() int {
+	g.labels = append(g.labels, -1)
+	return len(g.labels) - 1
+}
+
+func (g *bpfGen) place(l int) {
+	g.labels[l] = len(g.prog)
+}
+
+func (g *bpfGen) emit(i BPFInstruction) {
+	g.prog = append(g.prog, i)
+}
+
+func (g *bpfGen) gen(n *bpfNode, t, f int) {
+	switch n.op {
+	case "true":
+		g.emit(BPFInstruction{Op: bpfJMP}) // ja
+		g.jumps = append(g.jumps, bpfJump{at: len(g.prog) - 1, label: t, true: true})
+	case "and":
+		mid := g.label()
+		g.gen(n.l, mid, f)
+		g.place(mid)
+		g.gen(n.r, t, f)
+	case "or":
+		mid := g.label()
+		g.gen(n.l, t, mid)
+		g.place(mid)
+		g.gen(n.r, t, f)
+	case "not":
+		g.gen(n.l, f, t)
+	case "ethertype":
+		typ := n.test.val
+		switch {
+		case !g.raw:
+			g.gen(&bpfNode{op: "test", test: bpfTest{size: bpfH, off: 12, link: true, val: typ}}, t, f)
+		case typ == 0x0800 || typ == 0x86dd:
+			version := uint32(0x40)
+			if typ == 0x86dd {
+				version = 0x60
+			}
+			g.gen(&bpfNode{op: "test", test: bpfTest{size: bpfB, mask: 0xf0, val: version}}, t, f)
+		default:
+			// Nothing but IP travels on a raw link.
+			g.gen(&bpfNode{op: "true"}, f, t)
+		}
+	case "test":
+		x := n.test
+		off := x.off
+		if !x.link {
+			off += g.hdr
+		}
+		if x.ind {
+			g.emit(BPFInstruction{Op: bpfLDX | bpfB | bpfMSH, K: g.hdr})
+			g.emit(BPFInstruction{Op: bpfLD | x.size | bpfIND, K: off})
+		} else {
+			g.emit(BPFInstruction{Op: bpfLD | x.size | bpfABS, K: off})
+		}
+		if x.mask != 0 && x.mask != 0xffffffff {
+			g.emit(BPFInstruction{Op: bpfALU | bpfAND | bpfK, K: x.mask})
+		}
+		if x.hi != 0 && x.hi != x.val {
+			g.emit(BPFInstruction{Op: bpfJMP | bpfJGE | bpfK, K: x.val})
+			g.jumps = append(g.jumps, bpfJump{at: len(g.prog) - 1, label: f})
+			g.emit(BPFInstruction{Op: bpfJMP | bpfJGT | bpfK, K: x.hi})
+			at := len(g.prog) - 1
+			g.jumps = append(g.jumps, bpfJump{at: at, label: f, true: true}, bpfJump{at: at, label: t})
+			return
+		}
+		op := uint16(bpfJMP | bpfJEQ | bpfK)
+		if x.jset {
+			op = bpfJMP | bpfJSET | bpfK
+		}
+		g.emit(BPFInstruction{Op: op, K: x.val})
+		at := len(g.prog) - 1
+		g.jumps = append(g.jumps, bpfJump{at: at, label: t, true: true}, bpfJump{at: at, label: f})
+	}
+}
+
+// resolve patches label references into the relative forward offsets BPF
+// uses. Conditional jumps only reach 255 instructions ahead.
+func (g *bpfGen) resolve() ([]BPFInstruction, error) {
+	for _, j := range g.jumps {
+		off := g.labels[j.label] - j.at - 1
+		if off < 0 {
+			return nil, errors.New("bpf: internal error, backward jump")
+		}
+		in := &g.prog[j.at]
+		switch {
+		case in.Op == bpfJMP:
+			in.K = uint32(off)
+		case off > 255:
+			return nil, errors.New("bpf: filter too complex")
+		case j.true:
+			in.Jt = uint8(off)
+		default:
+			in.Jf = uint8(off)
+		}
+	}
+	return g.prog, nil
+}
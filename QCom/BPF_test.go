@@ -0,0 +1,164 @@
+package QCom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// runBPF interprets the subset of classic BPF that CompileBPF emits and
+// returns how many bytes the program keeps; out of range loads reject,
+// as in the kernel.
+func runBPF(t *testing.T, prog []BPFInstruction, pkt []byte) uint32 {
+	t.Helper()
+	var a, x uint32
+	load := func(size uint16, off uint32) (uint32, bool) {
+		n := map[uint16]uint32{bpfB: 1, bpfH: 2, bpfW: 4}[size]
+		if uint64(off)+uint64(n) > uint64(len(pkt)) {
+			return 0, false
+		}
+		switch size {
+		case bpfB:
+			return uint32(pkt[off]), true
+		case bpfH:
+			return uint32(binary.BigEndian.Uint16(pkt[off:])), true
+		}
+		return binary.BigEndian.Uint32(pkt[off:]), true
+	}
+	for pc := 0; pc < len(prog); pc++ {
+		in := prog[pc]
+		ok := true
+		switch class := in.Op & 0x07; {
+		case in.Op == bpfLDX|bpfB|bpfMSH:
+			if int(in.K) >= len(pkt) {
+				return 0
+			}
+			x = 4 * uint32(pkt[in.K]&0xf)
+		case class == bpfLD && in.Op&0xe0 == bpfABS:
+			a, ok = load(in.Op&0x18, in.K)
+		case class == bpfLD && in.Op&0xe0 == bpfIND:
+			a, ok = load(in.Op&0x18, x+in.K)
+		case in.Op == bpfALU|bpfAND|bpfK:
+			a &= in.K
+		case in.Op == bpfJMP:
+			pc += int(in.K)
+		case class == bpfJMP:
+			var taken bool
+			switch in.Op & 0xf0 {
+			case bpfJEQ:
+				taken = a == in.K
+			case bpfJGT:
+				taken = a > in.K
+			case bpfJGE:
+				taken = a >= in.K
+			case bpfJSET:
+				taken = a&in.K != 0
+			}
+			if taken {
+				pc += int(in.Jt)
+			} else {
+				pc += int(in.Jf)
+			}
+		case class == bpfRET:
+			return in.K
+		default:
+			t.Fatalf("unexpected instruction %#x", in.Op)
+		}
+		if !ok {
+			return 0
+		}
+	}
+	t.Fatal("program ran off the end")
+	return 0
+}
+
+func ipv4TCP(src, dst [4]byte, sport, dport uint16) []byte {
+	ip := []byte{0x45, 0, 0, 40, 0, 0, 0x40, 0, 64, 6, 0, 0}
+	ip = append(append(ip, src[:]...), dst[:]...)
+	tcp := binary.BigEndian.AppendUint16(nil, sport)
+	tcp = binary.BigEndian.AppendUint16(tcp, dport)
+	return append(ip, append(tcp, make([]byte, 16)...)...)
+}
+
+func ipv6UDP(src, dst [16]byte, sport, dport uint16) []byte {
+	ip := []byte{0x60, 0, 0, 0, 0, 8, 17, 64}
+	ip = append(append(ip, src[:]...), dst[:]...)
+	udp := binary.BigEndian.AppendUint16(nil, sport)
+	udp = binary.BigEndian.AppendUint16(udp, dport)
+	return append(ip, append(udp, 0, 8, 0, 0)...)
+}
+
+func ethernet(ethertype uint16, payload []byte) []byte {
+	b := make([]byte, 12)
+	b = binary.BigEndian.AppendUint16(b, ethertype)
+	return append(b, payload...)
+}
+
+func TestCompileBPF(t *testing.T) {
+	v4 := ipv4TCP([4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 2}, 40000, 80)
+	v6 := ipv6UDP([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}, [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 2}, 5353, 53)
+	arp := ethernet(0x0806, make([]byte, 28))
+
+	cases := []struct {
+		expr         string
+		v4, v6, arp  bool
+		rawV4, rawV6 bool
+	}{
+		{"", true, true, true, true, true},
+		{"ip", true, false, false, true, false},
+		{"ip6", false, true, false, false, true},
+		{"arp", false, false, true, false, false},
+		{"tcp", true, false, false, true, false},
+		{"udp", false, true, false, false, true},
+		{"tcp port 80", true, false, false, true, false},
+		{"dst port 80", true, false, false, true, false},
+		{"src port 80", false, false, false, false, false},
+		{"portrange 50-60", false, true, false, false, true},
+		{"host 10.0.0.2", true, false, false, true, false},
+		{"src net 10.0.0.0/8", true, false, false, true, false},
+		{"dst host 2001:db8::2", false, true, false, false, true},
+		{"not ip", false, true, true, false, true},
+		{"ip6 or (tcp and dst port 80)", true, true, false, true, true},
+	}
+	for _, c := range cases {
+		eth, err := CompileBPF(c.expr, LinkTypeEthernet, 1500)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		raw, err := CompileBPF(c.expr, LinkTypeRaw, 1500)
+		if err != nil {
+			t.Errorf("%q on a raw link: %v", c.expr, err)
+			continue
+		}
+		for _, p := range []struct {
+			name string
+			prog []BPFInstruction
+			pkt  []byte
+			want bool
+		}{
+			{"ethernet ipv4", eth, ethernet(0x0800, v4), c.v4},
+			{"ethernet ipv6", eth, ethernet(0x86dd, v6), c.v6},
+			{"ethernet arp", eth, arp, c.arp},
+			{"raw ipv4", raw, v4, c.rawV4},
+			{"raw ipv6", raw, v6, c.rawV6},
+		} {
+			if got := runBPF(t, p.prog, p.pkt) == 1500; got != p.want {
+				t.Errorf("%q on %s: matched %v, want %v", c.expr, p.name, got, p.want)
+			}
+		}
+	}
+}
+
+func TestCompileBPFErrors(t *testing.T) {
+	for _, expr := range []string{"tcp port", "port 70000", "portrange 9-3", "host nope", "(tcp", "bogus", "tcp )"} {
+		if _, err := CompileBPF(expr, LinkTypeEthernet, 0); err == nil {
+			t.Errorf("%q compiled", expr)
+		}
+	}
+	if _, err := CompileBPF("tcp", LinkTypeLinuxSLL, 0); err == nil {
+		t.Error("filter compiled for a link type it doesn't know")
+	}
+	if _, err := CompileBPF("", LinkTypeLinuxSLL, 0); err != nil {
+		t.Errorf("empty filter on an unknown link type: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package QCom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+var ErrCapturePermission = errors.New("live capture needs CAP_NET_RAW: run Qube as root or grant it with 'setcap cap_net_raw+ep qube'")
+
+// InterfaceLinkType is the link type frames captured on iface will carry.
+// Point-to-point devices such as tun have no link header at all.
+func InterfaceLinkType(iface string) uint32 {
+	ifi, err := net.InterfaceByName(iface)
+	if err == nil && len(ifi.HardwareAddr) == 0 && ifi.Flags&net.FlagLoopback == 0 {
+		return LinkTypeRaw
+	}
+	return LinkTypeEthernet
+}
+
+// PcapngWriter writes a single-interface pcapng stream with nanosecond
+// timestamps.
+type PcapngWriter struct {
+	w *bufio.Writer
+}
+
+func NewPcapngWriter(w io.Writer, ifaceName string, linkType uint32, snaplen int) (*PcapngWriter, error) {
+	pw := &PcapngWriter{w: bufio.NewWriter(w)}
+
+	shb := binary.LittleEndian.AppendUint32(nil, 0x1a2b3c4d)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	shb = appendNgOption(shb, 4, []byte("Qube")) // shb_userappl
+	shb = appendNgOption(shb, 0, nil)
+	pw.block(0x0a0d0d0a, shb)
+
+	idb := binary.LittleEndian.AppendUint16(nil, uint16(linkType))
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(snaplen))
+	idb = appendNgOption(idb, 2, []byte(ifaceName)) // if_name
+	idb = appendNgOption(idb, 9, []byte{9})         // if_tsresol: nanoseconds
+	idb = appendNgOption(idb, 0, nil)
+	pw.block(1, idb)
+	return pw, pw.w.Flush()
+}
+
+func appendNgOption(b []byte, code uint16, v []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(v)))
+	b = append(b, v...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func (pw *PcapngWriter) block(typ uint32, body []byte) error {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	total := uint32(12 + len(body))
+	b := binary.LittleEndian.AppendUint32(nil, typ)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	_, err := pw.w.Write(b)
+	return err
+}
+
+func (pw *PcapngWriter) WritePacket(p CapturedPacket) error {
+	ts := uint64(p.Time.UnixNano())
+	epb := binary.LittleEndian.AppendUint32(nil, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(p.Data)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(p.OrigLen))
+	epb = append(epb, p.Data...)
+	return pw.block(6, epb)
+}
+
+func (pw *PcapngWriter) Flush() error {
+	return pw.w.Flush()
+}
@@ -0,0 +1,100 @@
+package QCom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	ethPAll        = 0x0003
+	packetOutgoing = 4
+)
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
+
+// CaptureAvailable reports why live capture can't run, or nil if it can.
+func CaptureAvailable() error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, int(htons(ethPAll)))
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+			return ErrCapturePermission
+		}
+		return err
+	}
+	syscall.Close(fd)
+	return nil
+}
+
+// CaptureLive reads frames from iface through an AF_PACKET socket until ctx
+// is cancelled, calling fn for every frame the filter program accepts.
+func CaptureLive(ctx context.Context, iface string, prog []BPFInstruction, snaplen int, fn func(CapturedPacket)) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	// With protocol 0 the socket receives nothing until the bind below
+	// names one, so no frame from another interface, or one the filter
+	// would reject, can queue up before the filter is attached.
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+			return ErrCapturePermission
+		}
+		return err
+	}
+	defer syscall.Close(fd)
+
+	if len(prog) > 0 {
+		filter := make([]syscall.SockFilter, len(prog))
+		for i, in := range prog {
+			filter[i] = syscall.SockFilter{Code: in.Op, Jt: in.Jt, Jf: in.Jf, K: in.K}
+		}
+		if err := syscall.AttachLsf(fd, filter); err != nil {
+			return fmt.Errorf("attaching filter: %w", err)
+		}
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(ethPAll), Ifindex: ifi.Index}); err != nil {
+		return err
+	}
+	tv := syscall.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+
+	if snaplen <= 0 {
+		snaplen = 65535
+	}
+	linkType := InterfaceLinkType(iface)
+	loopback := ifi.Flags&net.FlagLoopback != 0
+	buf := make([]byte, 65536)
+	logger().Info("capture started", "iface", iface, "filtered", len(prog) > 0)
+	defer logger().Info("capture stopped", "iface", iface)
+	for ctx.Err() == nil {
+		n, from, err := syscall.Recvfrom(fd, buf, syscall.MSG_TRUNC)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			logger().Error("capture read", "iface", iface, "err", err)
+			return err
+		}
+		// Loopback hands us every frame twice, once on the way out and
+		// once on the way in; keep only the incoming copy like libpcap.
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && loopback && ll.Pkttype == packetOutgoing {
+			continue
+		}
+		capLen := min(n, snaplen, len(buf))
+		data := make([]byte, capLen)
+		copy(data, buf[:capLen])
+		fn(CapturedPacket{
+			Time:     time.Now(),
+			CapLen:   capLen,
+			OrigLen:  n,
+			LinkType: linkType,
+			Data:     data,
+		})
+	}
+	return nil
+}
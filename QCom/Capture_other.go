@@ -0,0 +1,18 @@
+//go:build !linux
+
+package QCom
+
+import (
+	"context"
+	"errors"
+)
+
+var errCaptureUnsupported = errors.New("live capture is only supported on Linux")
+
+func CaptureAvailable() error {
+	return errCaptureUnsupported
+}
+
+func CaptureLive(ctx context.Context, iface string, prog []BPFInstruction, snaplen int, fn func(CapturedPacket)) error {
+	return errCaptureUnsupported
+}
@@ -0,0 +1,294 @@
+package QCom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	CheckICMP = "icmp"
+	CheckTCP  = "tcp"
+	CheckHTTP = "http"
+	CheckDNS  = "dns"
+)
+
+var CheckKinds = []string{CheckICMP, CheckTCP, CheckHTTP, CheckDNS}
+
+const (
+	StateUnknown  = "unknown"
+	StateUp       = "up"
+	StateDegraded = "degraded"
+	StateDown     = "down"
+)
+
+// AlertRules decide when a check's results change its state. Zero values
+// switch a rule off, except FailAfter which is at least 1.
+type AlertRules struct {
+	FailAfter  int           `json:"fail_after"`
+	MaxLatency time.Duration `json:"max_latency"`
+	CertDays   int           `json:"cert_days"`
+}
+
+// Check is one monitored endpoint. Target is a host for ICMP, host:port
+// for TCP, a URL for HTTP and a name for DNS.
+type Check struct {
+	Name     string        `json:"name"`
+	Kind     string        `json:"kind"`
+	Target   string        `json:"target"`
+	Interval time.Duration `json:"interval"`
+	Timeout  time.Duration `json:"timeout"`
+	Rules    AlertRules    `json:"rules"`
+}
+
+type CheckResult struct {
+	Check   string        `json:"check"`
+	Time    time.Time     `json:"time"`
+	OK      bool          `json:"ok"`
+	Latency time.Duration `json:"latency"`
+	Err     string        `json:"err,omitempty"`
+	// CertExpiry is set for HTTPS checks.
+	CertExpiry time.Time `json:"cert_expiry"`
+}
+
+// CheckStatus is where a check stands after its latest result.
+type CheckStatus struct {
+	Check    Check
+	State    string
+	Reason   string
+	Since    time.Time
+	Failures int
+	Last     CheckResult
+}
+
+type Transition struct {
+	Check  string    `json:"check"`
+	Kind   string    `json:"kind"`
+	Target string    `json:"target"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+func (c Check) Validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("check needs a name")
+	case c.Target == "":
+		return errors.New("check needs a target")
+	case c.Interval < time.Second:
+		return errors.New("interval must be at least 1s")
+	}
+	for _, k := range CheckKinds {
+		if c.Kind == k {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown check kind %q", c.Kind)
+}
+
+// RunCheck performs a single probe for c.
+func RunCheck(ctx context.Context, c Check) CheckResult {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	r := CheckResult{Check: c.Name, Time: time.Now()}
+	var err error
+	switch c.Kind {
+	case CheckICMP:
+		p := NewPinger(c.Target, PingAuto, 80)
+		s := p.Ping(ctx, 1, timeout)
+		p.Close()
+		r.Latency = s.RTT
+		if s.Lost {
+			err = errors.New(s.Err)
+		}
+	case CheckTCP:
+		d := net.Dialer{Timeout: timeout}
+		var conn net.Conn
+		conn, err = d.DialContext(ctx, "tcp", c.Target)
+		r.Latency = time.Since(r.Time)
+		if err == nil {
+			conn.Close()
+		}
+	case CheckHTTP:
+		opts := DefaultHTTPProbeOptions()
+		opts.Timeout = timeout
+		var res *HTTPProbeResult
+		res, err = HTTPProbe(ctx, c.Target, opts)
+		r.Latency = time.Since(r.Time)
+		if hop := res.Final(); err == nil && hop != nil {
+			if hop.StatusCode >= 400 {
+				err = errors.New(hop.Status)
+			}
+			if hop.TLS != nil && len(hop.TLS.Chain) > 0 {
+				r.CertExpiry = hop.TLS.Chain[0].NotAfter
+			}
+		}
+	case CheckDNS:
+		opts := DefaultDNSQueryOptions()
+		opts.Timeout = timeout
+		var resp *DNSResponse
+		resp, err = DNSQuery(ctx, SystemResolver(), c.Target, TypeA, opts)
+		if err == nil {
+			r.Latency = resp.RTT
+			switch {
+			case resp.Msg.Header.Rcode != 0:
+				err = errors.New(DNSRcodeName(resp.Msg.Header.Rcode))
+			case len(resp.Msg.Answers) == 0:
+				err = errors.New("no answers")
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown check kind %q", c.Kind)
+	}
+	r.OK = err == nil
+	if err != nil {
+		r.Err = err.Error()
+	}
+	return r
+}
+
+// Evaluate folds r into st using the check's rules and reports the
+// transition, if the state changed.
+func (st *CheckStatus) Evaluate(r CheckResult) (Transition, bool) {
+	rules := st.Check.Rules
+	st.Last = r
+	state, reason := st.State, st.Reason
+	if !r.OK {
+		st.Failures++
+		if st.Failures >= max(rules.FailAfter, 1) {
+			state = StateDown
+			reason = fmt.Sprintf("%d consecutive failures: %s", st.Failures, r.Err)
+		}
+	} else {
+		st.Failures = 0
+		state, reason = StateUp, ""
+		if rules.MaxLatency > 0 && r.Latency > rules.MaxLatency {
+			state = StateDegraded
+			reason = fmt.Sprintf("latency %s above %s", r.Latency.Round(time.Millisecond), rules.MaxLatency)
+		}
+		if left := time.Until(r.CertExpiry); rules.CertDays > 0 && !r.CertExpiry.IsZero() && left < time.Duration(rules.CertDays)*24*time.Hour {
+			state = StateDegraded
+			reason = fmt.Sprintf("certificate expires in %d days", int(left.Hours()/24))
+		}
+	}
+	st.Reason = reason
+	if state == st.State {
+		return Transition{}, false
+	}
+	t := Transition{
+		Check: st.Check.Name, Kind: st.Check.Kind, Target: st.Check.Target,
+		From: st.State, To: state, Reason: reason, Time: r.Time,
+	}
+	st.State, st.Since = state, r.Time
+	return t, true
+}
+
+// CheckScheduler runs every check on its own interval, at most
+// MaxConcurrent probes at a time.
+type CheckScheduler struct {
+	MaxConcurrent int
+	// OnResult and OnTransition are called from scheduler goroutines.
+	OnResult     func(CheckStatus)
+	OnTransition func(Transition)
+
+	mu     sync.Mutex
+	status map[string]*CheckStatus
+	order  []string
+}
+
+func NewCheckScheduler(checks []Check) *CheckScheduler {
+	s := &CheckScheduler{MaxConcurrent: 16, status: map[string]*CheckStatus{}}
+	for _, c := range checks {
+		s.status[c.Name] = &CheckStatus{Check: c, State: StateUnknown}
+		s.order = append(s.order, c.Name)
+	}
+	return s
+}
+
+// Restore seeds a check with the state it had before a restart, so the
+// first result doesn't look like a change.
+func (s *CheckScheduler) Restore(name, state string, since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.status[name]; st != nil {
+		st.State, st.Since = state, since
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (s *CheckScheduler) Run(ctx context.Context) {
+	sem := make(chan struct{}, max(s.MaxConcurrent, 1))
+	var wg sync.WaitGroup
+	for _, name := range s.order {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+			s.runCheck(ctx, c, sem)
+		}(s.status[name].Check)
+	}
+	wg.Wait()
+}
+
+func (s *CheckScheduler) runCheck(ctx context.Context, c Check, sem chan struct{}) {
+	// Spread the first round so a long list doesn't fire all at once.
+	wait := rand.N(min(max(c.Interval, time.Second), 5*time.Second))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = c.Interval
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		r := RunCheck(ctx, c)
+		<-sem
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mu.Lock()
+		st := s.status[c.Name]
+		t, changed := st.Evaluate(r)
+		snap := *st
+		s.mu.Unlock()
+
+		if s.OnResult != nil {
+			s.OnResult(snap)
+		}
+		if changed {
+			level := slog.LevelInfo
+			if t.To != StateUp {
+				level = slog.LevelWarn
+			}
+			logger().Log(ctx, level, "check changed state", "check", t.Check, "from", t.From, "to", t.To, "reason", t.Reason)
+			if s.OnTransition != nil {
+				s.OnTransition(t)
+			}
+		}
+	}
+}
+
+// Status returns every check's current status in definition order.
+func (s *CheckScheduler) Status() []CheckStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]CheckStatus, 0, len(s.order))
+	for _, name := range s.order {
+		out = append(out, *s.status[name])
+	}
+	return out
+}
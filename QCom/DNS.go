@@ -0,0 +1,670 @@
+package QCom
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeA     uint16 = 1
+	TypeNS    uint16 = 2
+	TypeCNAME uint16 = 5
+	TypeSOA   uint16 = 6
+	TypePTR   uint16 = 12
+	TypeMX    uint16 = 15
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	TypeSRV   uint16 = 33
+	TypeOPT   uint16 = 41
+	TypeANY   uint16 = 255
+	TypeCAA   uint16 = 257
+
+	ClassIN uint16 = 1
+)
+
+// DNSTypes lists the record types the lookup tool offers, in display order.
+var DNSTypes = []uint16{TypeA, TypeAAAA, TypeCNAME, TypeMX, TypeNS, TypeTXT, TypeSRV, TypeSOA, TypePTR, TypeCAA}
+
+var dnsTypeNames = map[uint16]string{
+	TypeA: "A", TypeNS: "NS", TypeCNAME: "CNAME", TypeSOA: "SOA", TypePTR: "PTR",
+	TypeMX: "MX", TypeTXT: "TXT", TypeAAAA: "AAAA", TypeSRV: "SRV", TypeOPT: "OPT",
+	TypeANY: "ANY", TypeCAA: "CAA",
+}
+
+var dnsRcodeNames = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+func DNSTypeName(t uint16) string {
+	if n, ok := dnsTypeNames[t]; ok {
+		return n
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func ParseDNSType(s string) (uint16, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for t, n := range dnsTypeNames {
+		if n == s {
+			return t, nil
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(s, "TYPE")); err == nil && n > 0 && n < 65536 {
+		return uint16(n), nil
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+func DNSRcodeName(rc uint8) string {
+	if int(rc) < len(dnsRcodeNames) {
+		return dnsRcodeNames[rc]
+	}
+	return "RCODE" + strconv.Itoa(int(rc))
+}
+
+type DNSHeader struct {
+	ID     uint16
+	QR     bool // response
+	Opcode uint8
+	AA     bool // authoritative answer
+	TC     bool // truncated
+	RD     bool // recursion desired
+	RA     bool // recursion available
+	AD     bool // authenticated data
+	CD     bool // checking disabled
+	Rcode  uint8
+}
+
+func (h DNSHeader) Flags() string {
+	var f []string
+	for _, x := range []struct {
+		on   bool
+		name string
+	}{{h.QR, "qr"}, {h.AA, "aa"}, {h.TC, "tc"}, {h.RD, "rd"}, {h.RA, "ra"}, {h.AD, "ad"}, {h.CD, "cd"}} {
+		if x.on {
+			f = append(f, x.name)
+		}
+	}
+	return strings.Join(f, " ")
+}
+
+type DNSQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// DNSRecord is a decoded resource record. Data always holds the
+// presentation form; the typed fields are filled for the record types
+// that carry them so callers don't have to re-parse Data.
+type DNSRecord struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  string
+
+	Addr     netip.Addr // A, AAAA
+	Target   string     // NS, CNAME, PTR, MX, SRV
+	Priority uint16     // MX preference, SRV priority
+	Weight   uint16     // SRV
+	Port     uint16     // SRV
+	Text     []string   // TXT
+
+	RData []byte
+}
+
+func (r DNSRecord) String() string {
+	return fmt.Sprintf("%-30s %-6d IN %-6s %s", r.Name, r.TTL, DNSTypeName(r.Type), r.Data)
+}
+
+type DNSMessage struct {
+	Header     DNSHeader
+	Questions  []DNSQuestion
+	Answers    []DNSRecord
+	Authority  []DNSRecord
+	Additional []DNSRecord
+}
+
+func NewDNSQuery(name string, qtype uint16, recurse bool) *DNSMessage {
+	return &DNSMessage{
+		Header:    DNSHeader{ID: uint16(rand.Intn(1 << 16)), RD: recurse},
+		Questions: []DNSQuestion{{Name: FQDN(name), Type: qtype, Class: ClassIN}},
+	}
+}
+
+// FQDN returns name with a single trailing dot.
+func FQDN(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+var errDNSShort = errors.New("dns: message truncated")
+
+func (m *DNSMessage) Pack() ([]byte, error) {
+	h := m.Header
+	var flags uint16
+	flags |= uint16(h.Opcode&0xf) << 11
+	flags |= uint16(h.Rcode & 0xf)
+	for _, f := range []struct {
+		on  bool
+		bit uint16
+	}{{h.QR, 1 << 15}, {h.AA, 1 << 10}, {h.TC, 1 << 9}, {h.RD, 1 << 8}, {h.RA, 1 << 7}, {h.AD, 1 << 5}, {h.CD, 1 << 4}} {
+		if f.on {
+			flags |= f.bit
+		}
+	}
+	b := binary.BigEndian.AppendUint16(nil, h.ID)
+	b = binary.BigEndian.AppendUint16(b, flags)
+	for _, n := range []int{len(m.Questions), len(m.Answers), len(m.Authority), len(m.Additional)} {
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	}
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+	for _, sec := range [][]DNSRecord{m.Answers, m.Authority, m.Additional} {
+		for _, r := range sec {
+			if b, err = appendRecord(b, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, l := range strings.Split(name, ".") {
+			if len(l) == 0 || len(l) > 63 {
+				return nil, fmt.Errorf("dns: bad label in %q", name)
+			}
+			b = append(b, byte(len(l)))
+			b = append(b, l...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+func appendRecord(b []byte, r DNSRecord) ([]byte, error) {
+	var err error
+	if b, err = appendName(b, r.Name); err != nil {
+		return nil, err
+	}
+	class := r.Class
+	if class == 0 {
+		class = ClassIN
+	}
+	b = binary.BigEndian.AppendUint16(b, r.Type)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, r.TTL)
+
+	rdata := r.RData
+	if rdata == nil {
+		switch r.Type {
+		case TypeA, TypeAAAA:
+			rdata = r.Addr.AsSlice()
+		case TypeNS, TypeCNAME, TypePTR:
+			rdata, err = appendName(nil, r.Target)
+		case TypeMX:
+			rdata, err = appendName(binary.BigEndian.AppendUint16(nil, r.Priority), r.Target)
+		case TypeSRV:
+			rdata = binary.BigEndian.AppendUint16(nil, r.Priority)
+			rdata = binary.BigEndian.AppendUint16(rdata, r.Weight)
+			rdata = binary.BigEndian.AppendUint16(rdata, r.Port)
+			rdata, err = appendName(rdata, r.Target)
+		case TypeTXT:
+			for _, t := range r.Text {
+				if len(t) > 255 {
+					return nil, errors.New("dns: TXT string longer than 255 bytes")
+				}
+				rdata = append(rdata, byte(len(t)))
+				rdata = append(rdata, t...)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...), nil
+}
+
+func ParseDNSMessage(b []byte) (*DNSMessage, error) {
+	if len(b) < 12 {
+		return nil, errDNSShort
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	m := &DNSMessage{Header: DNSHeader{
+		ID:     binary.BigEndian.Uint16(b),
+		QR:     flags&(1<<15) != 0,
+		Opcode: uint8(flags>>11) & 0xf,
+		AA:     flags&(1<<10) != 0,
+		TC:     flags&(1<<9) != 0,
+		RD:     flags&(1<<8) != 0,
+		RA:     flags&(1<<7) != 0,
+		AD:     flags&(1<<5) != 0,
+		CD:     flags&(1<<4) != 0,
+		Rcode:  uint8(flags & 0xf),
+	}}
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(b[4+2*i:]))
+	}
+	off := 12
+	for i := 0; i < counts[0]; i++ {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(b) {
+			return nil, errDNSShort
+		}
+		m.Questions = append(m.Questions, DNSQuestion{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[off:]),
+			Class: binary.BigEndian.Uint16(b[off+2:]),
+		})
+		off += 4
+	}
+	for s, sec := range []*[]DNSRecord{&m.Answers, &m.Authority, &m.Additional} {
+		for i := 0; i < counts[s+1]; i++ {
+			r, n, err := readRecord(b, off)
+			if err != nil {
+				return nil, err
+			}
+			off = n
+			*sec = append(*sec, r)
+		}
+	}
+	return m, nil
+}
+
+// readName decodes a possibly compressed name at off and returns it along
+// with the offset just past it in the original (uncompressed) stream.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for hops := 0; ; hops++ {
+		if off >= len(b) || hops > 127 {
+			return "", 0, errDNSShort
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errDNSShort
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case l&0xc0 != 0:
+			return "", 0, fmt.Errorf("dns: unsupported label type %#x", l)
+		default:
+			if off+1+l > len(b) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(b[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+func readRecord(b []byte, off int) (DNSRecord, int, error) {
+	var r DNSRecord
+	name, off, err := readName(b, off)
+	if err != nil {
+		return r, 0, err
+	}
+	if off+10 > len(b) {
+		return r, 0, errDNSShort
+	}
+	r.Name = name
+	r.Type = binary.BigEndian.Uint16(b[off:])
+	r.Class = binary.BigEndian.Uint16(b[off+2:])
+	r.TTL = binary.BigEndian.Uint32(b[off+4:])
+	rdlen := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+rdlen > len(b) {
+		return r, 0, errDNSShort
+	}
+	r.RData = b[off : off+rdlen]
+	if err := decodeRData(&r, b, off); err != nil {
+		return r, 0, err
+	}
+	return r, off + rdlen, nil
+}
+
+// decodeRData fills the typed fields of r. Name-bearing records may point
+// back into the whole message, so it takes the message and RDATA offset.
+func decodeRData(r *DNSRecord, msg []byte, off int) error {
+	rd := r.RData
+	var err error
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		a, ok := netip.AddrFromSlice(rd)
+		if !ok {
+			return fmt.Errorf("dns: bad %s rdata", DNSTypeName(r.Type))
+		}
+		r.Addr = a
+		r.Data = a.String()
+	case TypeNS, TypeCNAME, TypePTR:
+		r.Target, _, err = readName(msg, off)
+		r.Data = r.Target
+	case TypeMX:
+		if len(rd) < 3 {
+			return errDNSShort
+		}
+		r.Priority = binary.BigEndian.Uint16(rd)
+		r.Target, _, err = readName(msg, off+2)
+		r.Data = fmt.Sprintf("%d %s", r.Priority, r.Target)
+	case TypeSRV:
+		if len(rd) < 7 {
+			return errDNSShort
+		}
+		r.Priority = binary.BigEndian.Uint16(rd)
+		r.Weight = binary.BigEndian.Uint16(rd[2:])
+		r.Port = binary.BigEndian.Uint16(rd[4:])
+		r.Target, _, err = readName(msg, off+6)
+		r.Data = fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case TypeTXT:
+		var quoted []string
+		for i := 0; i < len(rd); {
+			l := int(rd[i])
+			if i+1+l > len(rd) {
+				return errDNSShort
+			}
+			t := string(rd[i+1 : i+1+l])
+			r.Text = append(r.Text, t)
+			quoted = append(quoted, strconv.Quote(t))
+			i += 1 + l
+		}
+		r.Data = strings.Join(quoted, " ")
+	case TypeSOA:
+		mname, n, err := readName(msg, off)
+		if err != nil {
+			return err
+		}
+		rname, n, err := readName(msg, n)
+		if err != nil {
+			return err
+		}
+		// The counters must be inside this record, not whatever follows.
+		if n+20 > off+len(rd) {
+			return errDNSShort
+		}
+		v := make([]uint32, 5)
+		for i := range v {
+			v[i] = binary.BigEndian.Uint32(msg[n+4*i:])
+		}
+		r.Target = mname
+		r.Data = fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname, v[0], v[1], v[2], v[3], v[4])
+	case TypeCAA:
+		if len(rd) < 2 || 2+int(rd[1]) > len(rd) {
+			return errDNSShort
+		}
+		tag := string(rd[2 : 2+rd[1]])
+		r.Data = fmt.Sprintf("%d %s %q", rd[0], tag, rd[2+rd[1]:])
+	case TypeOPT:
+		r.Data = fmt.Sprintf("udp=%d", r.Class)
+	default:
+		r.Data = fmt.Sprintf("\\# %d %x", len(rd), rd)
+	}
+	return err
+}
+
+type DNSQueryOptions struct {
+	Timeout   time.Duration
+	Recursion bool
+	ForceTCP  bool
+	// BufSize is advertised via EDNS0 so large answers fit in one datagram;
+	// 0 leaves EDNS0 out entirely.
+	BufSize uint16
+}
+
+func DefaultDNSQueryOptions() DNSQueryOptions {
+	return DNSQueryOptions{Timeout: 3 * time.Second, Recursion: true, BufSize: 1232}
+}
+
+type DNSResponse struct {
+	Msg    *DNSMessage
+	Server string
+	Proto  string
+	RTT    time.Duration
+	Size   int
+}
+
+// DNSQuery asks server (host or host:port) a single question over UDP and
+// retries over TCP if the answer comes back truncated.
+func DNSQuery(ctx context.Context, server, name string, qtype uint16, opts DNSQueryOptions) (*DNSResponse, error) {
+	q := NewDNSQuery(name, qtype, opts.Recursion)
+	if opts.BufSize > 0 {
+		q.Additional = append(q.Additional, DNSRecord{Name: ".", Type: TypeOPT, Class: opts.BufSize, RData: []byte{}})
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultDNSQueryOptions().Timeout
+	}
+	server = withDefaultPort(server, "53")
+	network := "tcp"
+	if !opts.ForceTCP {
+		network = "udp"
+		resp, err := dnsExchange(ctx, network, server, q, opts.Timeout)
+		if err == nil && !resp.Msg.Header.TC {
+			return resp, nil
+		}
+		if err == nil {
+			logger().Debug("dns answer truncated, retrying over tcp", "server", server, "name", name)
+			network = "tcp"
+		} else {
+			logDNSFailure(ctx, server, name, network, err)
+			return nil, err
+		}
+	}
+	resp, err := dnsExchange(ctx, network, server, q, opts.Timeout)
+	if err != nil {
+		logDNSFailure(ctx, server, name, network, err)
+	}
+	return resp, err
+}
+
+// logDNSFailure records a failed exchange unless the caller gave up.
+func logDNSFailure(ctx context.Context, server, name, network string, err error) {
+	if ctx.Err() == nil {
+		logger().Warn("dns query failed", "server", server, "name", name, "proto", network, "err", err)
+	}
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+func dnsExchange(ctx context.Context, network, server string, q *DNSMessage, timeout time.Duration) (*DNSResponse, error) {
+	wire, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	start := time.Now()
+	var buf []byte
+	if network == "tcp" {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(wire)))
+		if _, err = conn.Write(append(framed, wire...)); err != nil {
+			return nil, err
+		}
+		var l [2]byte
+		if _, err = io.ReadFull(conn, l[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err = conn.Write(wire); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			// Ignore stray datagrams that don't answer our question.
+			if n >= 2 && binary.BigEndian.Uint16(buf) == q.Header.ID {
+				buf = buf[:n]
+				break
+			}
+		}
+	}
+	rtt := time.Since(start)
+
+	m, err := ParseDNSMessage(buf)
+	if err != nil {
+		return nil, err
+	}
+	if m.Header.ID != q.Header.ID {
+		return nil, errors.New("dns: response id mismatch")
+	}
+	return &DNSResponse{Msg: m, Server: server, Proto: network, RTT: rtt, Size: len(buf)}, nil
+}
+
+// SystemResolver returns the first nameserver from /etc/resolv.conf, or a
+// public resolver when none is configured.
+func SystemResolver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return withDefaultPort(fields[1], "53")
+			}
+		}
+	}
+	return "1.1.1.1:53"
+}
+
+// ReverseName turns an address into its in-addr.arpa or ip6.arpa name.
+func ReverseName(ip string) (string, error) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if a.Is4() || a.Is4In6() {
+		b := a.Unmap().As4()
+		fmt.Fprintf(&sb, "%d.%d.%d.%d.in-addr.arpa.", b[3], b[2], b[1], b[0])
+		return sb.String(), nil
+	}
+	b := a.As16()
+	const hex = "0123456789abcdef"
+	for i := len(b) - 1; i >= 0; i-- {
+		sb.WriteByte(hex[b[i]&0xf])
+		sb.WriteByte('.')
+		sb.WriteByte(hex[b[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa.")
+	return sb.String(), nil
+}
+
+// RootServers are the addresses a trace starts from.
+var RootServers = []string{"198.41.0.4", "199.9.14.201", "192.33.4.12", "199.7.91.13", "192.203.230.10"}
+
+type DNSTraceStep struct {
+	Zone     string
+	Server   string
+	Response *DNSResponse
+	Err      error
+}
+
+// DNSTrace follows referrals from the roots down to an authoritative
+// answer, the way dig +trace does. Nameservers without glue are resolved
+// through resolver.
+func DNSTrace(ctx context.Context, name string, qtype uint16, roots []string, resolver string, opts DNSQueryOptions) []DNSTraceStep {
+	if len(roots) == 0 {
+		roots = RootServers
+	}
+	opts.Recursion = false
+	servers, zone := roots, "."
+	var steps []DNSTraceStep
+	for hop := 0; hop < 16 && len(servers) > 0; hop++ {
+		var step DNSTraceStep
+		for _, s := range servers {
+			step = DNSTraceStep{Zone: zone, Server: s}
+			step.Response, step.Err = DNSQuery(ctx, s, name, qtype, opts)
+			if step.Err == nil {
+				break
+			}
+		}
+		steps = append(steps, step)
+		if step.Err != nil || ctx.Err() != nil {
+			return steps
+		}
+		m := step.Response.Msg
+		if len(m.Answers) > 0 || m.Header.Rcode != 0 || m.Header.AA {
+			return steps
+		}
+
+		servers = nil
+		glue := map[string][]string{}
+		for _, r := range m.Additional {
+			if r.Type == TypeA || r.Type == TypeAAAA {
+				glue[strings.ToLower(r.Name)] = append(glue[strings.ToLower(r.Name)], r.Addr.String())
+			}
+		}
+		for _, r := range m.Authority {
+			if r.Type != TypeNS {
+				continue
+			}
+			zone = r.Name
+			if addrs, ok := glue[strings.ToLower(r.Target)]; ok {
+				servers = append(servers, addrs...)
+				continue
+			}
+			if resp, err := DNSQuery(ctx, resolver, r.Target, TypeA, DefaultDNSQueryOptions()); err == nil {
+				for _, a := range resp.Msg.Answers {
+					if a.Type == TypeA {
+						servers = append(servers, a.Addr.String())
+					}
+				}
+			}
+		}
+	}
+	return steps
+}
@@ -0,0 +1,288 @@
+package QCom
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDNS answers on UDP and TCP on the same localhost port from a fixed
+// set of records.
+type fakeDNS struct {
+	addr    string
+	records map[uint16][]DNSRecord
+	// truncate answers every UDP query with TC set and no records, so
+	// the client has to retry over TCP.
+	truncate bool
+
+	udp, tcp atomic.Int32
+}
+
+func startFakeDNS(t *testing.T, f *fakeDNS) *fakeDNS {
+	t.Helper()
+	var pc net.PacketConn
+	var ln net.Listener
+	for tries := 0; ; tries++ {
+		var err error
+		if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if ln, err = net.Listen("tcp", pc.LocalAddr().String()); err == nil {
+			break
+		}
+		pc.Close()
+		if tries == 10 {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { pc.Close(); ln.Close() })
+	f.addr = pc.LocalAddr().String()
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			f.udp.Add(1)
+			if resp := f.answer(buf[:n], true); resp != nil {
+				pc.WriteTo(resp, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.tcp.Add(1)
+			go func() {
+				defer conn.Close()
+				var l [2]byte
+				if _, err := io.ReadFull(conn, l[:]); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(l[:]))
+				if _, err := io.ReadFull(conn, q); err != nil {
+					return
+				}
+				if resp := f.answer(q, false); resp != nil {
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+				}
+			}()
+		}
+	}()
+	return f
+}
+
+func (f *fakeDNS) answer(wire []byte, udp bool) []byte {
+	q, err := ParseDNSMessage(wire)
+	if err != nil || len(q.Questions) != 1 {
+		return nil
+	}
+	m := &DNSMessage{
+		Header:    DNSHeader{ID: q.Header.ID, QR: true, RD: q.Header.RD, RA: true},
+		Questions: q.Questions,
+	}
+	switch rs, ok := f.records[q.Questions[0].Type]; {
+	case udp && f.truncate:
+		m.Header.TC = true
+	case ok:
+		m.Answers = rs
+	default:
+		m.Header.Rcode = 3
+	}
+	b, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func query(t *testing.T, f *fakeDNS, qtype uint16, opts DNSQueryOptions) *DNSResponse {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := DNSQuery(ctx, f.addr, "example.test", qtype, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+var exampleA = DNSRecord{Name: "example.test.", Type: TypeA, Class: ClassIN, TTL: 300, Addr: netip.MustParseAddr("192.0.2.7")}
+
+func TestDNSQueryUDP(t *testing.T) {
+	f := startFakeDNS(t, &fakeDNS{records: map[uint16][]DNSRecord{TypeA: {exampleA}}})
+	resp := query(t, f, TypeA, DefaultDNSQueryOptions())
+	if resp.Proto != "udp" || f.tcp.Load() != 0 {
+		t.Errorf("answered over %s with %d TCP connections, want udp only", resp.Proto, f.tcp.Load())
+	}
+	if len(resp.Msg.Answers) != 1 || resp.Msg.Answers[0].Data != "192.0.2.7" {
+		t.Fatalf("answers = %v", resp.Msg.Answers)
+	}
+	if h := resp.Msg.Header; !h.QR || !h.RA || h.Rcode != 0 {
+		t.Errorf("header flags %q rcode %d", h.Flags(), h.Rcode)
+	}
+}
+
+func TestDNSQueryTruncatedRetriesTCP(t *testing.T) {
+	f := startFakeDNS(t, &fakeDNS{truncate: true, records: map[uint16][]DNSRecord{TypeA: {exampleA}}})
+	resp := query(t, f, TypeA, DefaultDNSQueryOptions())
+	if resp.Proto != "tcp" || f.udp.Load() != 1 || f.tcp.Load() != 1 {
+		t.Errorf("answered over %s after %d UDP and %d TCP queries, want tcp after 1 and 1",
+			resp.Proto, f.udp.Load(), f.tcp.Load())
+	}
+	if resp.Msg.Header.TC || len(resp.Msg.Answers) != 1 {
+		t.Fatalf("TC %v with %d answers", resp.Msg.Header.TC, len(resp.Msg.Answers))
+	}
+}
+
+func TestDNSQueryForceTCP(t *testing.T) {
+	f := startFakeDNS(t, &fakeDNS{records: map[uint16][]DNSRecord{TypeA: {exampleA}}})
+	opts := DefaultDNSQueryOptions()
+	opts.ForceTCP = true
+	if resp := query(t, f, TypeA, opts); resp.Proto != "tcp" || f.udp.Load() != 0 {
+		t.Errorf("answered over %s after %d UDP queries", resp.Proto, f.udp.Load())
+	}
+}
+
+func TestDNSQueryNXDOMAIN(t *testing.T) {
+	f := startFakeDNS(t, &fakeDNS{})
+	resp := query(t, f, TypeMX, DefaultDNSQueryOptions())
+	if rc := DNSRcodeName(resp.Msg.Header.Rcode); rc != "NXDOMAIN" {
+		t.Errorf("rcode %s, want NXDOMAIN", rc)
+	}
+}
+
+func soaRData(t *testing.T, mname, rname string, counters ...uint32) []byte {
+	t.Helper()
+	b, err := appendName(nil, mname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = appendName(b, rname); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range counters {
+		b = binary.BigEndian.AppendUint32(b, c)
+	}
+	return b
+}
+
+func TestDNSRecordTypes(t *testing.T) {
+	for _, c := range []struct {
+		in   DNSRecord
+		data string
+		want DNSRecord // typed fields to compare
+	}{
+		{DNSRecord{Type: TypeA, Addr: netip.MustParseAddr("192.0.2.1")}, "192.0.2.1",
+			DNSRecord{Addr: netip.MustParseAddr("192.0.2.1")}},
+		{DNSRecord{Type: TypeAAAA, Addr: netip.MustParseAddr("2001:db8::1")}, "2001:db8::1",
+			DNSRecord{Addr: netip.MustParseAddr("2001:db8::1")}},
+		{DNSRecord{Type: TypeNS, Target: "ns1.example.test."}, "ns1.example.test.",
+			DNSRecord{Target: "ns1.example.test."}},
+		{DNSRecord{Type: TypeCNAME, Target: "www.example.test."}, "www.example.test.",
+			DNSRecord{Target: "www.example.test."}},
+		{DNSRecord{Type: TypePTR, Target: "host.example.test."}, "host.example.test.",
+			DNSRecord{Target: "host.example.test."}},
+		{DNSRecord{Type: TypeMX, Priority: 10, Target: "mail.example.test."}, "10 mail.example.test.",
+			DNSRecord{Priority: 10, Target: "mail.example.test."}},
+		{DNSRecord{Type: TypeSRV, Priority: 1, Weight: 5, Port: 5060, Target: "sip.example.test."},
+			"1 5 5060 sip.example.test.",
+			DNSRecord{Priority: 1, Weight: 5, Port: 5060, Target: "sip.example.test."}},
+		{DNSRecord{Type: TypeTXT, Text: []string{"v=spf1 -all", `say "hi"`}}, `"v=spf1 -all" "say \"hi\""`,
+			DNSRecord{Text: []string{"v=spf1 -all", `say "hi"`}}},
+		{DNSRecord{Type: TypeSOA, RData: soaRData(t, "ns1.example.test.", "admin.example.test.", 2024010101, 7200, 3600, 1209600, 300)},
+			"ns1.example.test. admin.example.test. 2024010101 7200 3600 1209600 300",
+			DNSRecord{Target: "ns1.example.test."}},
+		{DNSRecord{Type: TypeCAA, RData: append([]byte{0, 5}, "issueletsencrypt.org"...)}, `0 issue "letsencrypt.org"`,
+			DNSRecord{}},
+		{DNSRecord{Type: 99, RData: []byte{0xde, 0xad}}, `\# 2 dead`, DNSRecord{}},
+	} {
+		c.in.Name, c.in.TTL = "example.test.", 60
+		b, err := (&DNSMessage{Header: DNSHeader{QR: true}, Answers: []DNSRecord{c.in}}).Pack()
+		if err != nil {
+			t.Errorf("%s: pack: %v", DNSTypeName(c.in.Type), err)
+			continue
+		}
+		m, err := ParseDNSMessage(b)
+		if err != nil {
+			t.Errorf("%s: parse: %v", DNSTypeName(c.in.Type), err)
+			continue
+		}
+		r := m.Answers[0]
+		if r.Name != "example.test." || r.Type != c.in.Type || r.Class != ClassIN || r.TTL != 60 || r.Data != c.data {
+			t.Errorf("%s: got %s", DNSTypeName(c.in.Type), r)
+		}
+		got := DNSRecord{Addr: r.Addr, Target: r.Target, Priority: r.Priority, Weight: r.Weight, Port: r.Port, Text: r.Text}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: typed fields %+v, want %+v", DNSTypeName(c.in.Type), got, c.want)
+		}
+	}
+}
+
+// message builds a response with one question for example.test and the
+// given answer bytes, which may point back at the question name at 12.
+func message(answers int, rest ...byte) []byte {
+	b := []byte{0, 1, 0x81, 0x80, 0, 1, 0, byte(answers), 0, 0, 0, 0}
+	b, _ = appendName(b, "example.test.")
+	b = append(b, 0, 1, 0, 1)
+	return append(b, rest...)
+}
+
+func TestDNSCompressedNames(t *testing.T) {
+	// A CNAME whose owner is a pointer to the question and whose target
+	// is "www" followed by the same pointer.
+	b := message(1,
+		0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 6,
+		3, 'w', 'w', 'w', 0xc0, 12)
+	m, err := ParseDNSMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := m.Answers[0]; r.Name != "example.test." || r.Target != "www.example.test." {
+		t.Errorf("got %s -> %s", r.Name, r.Target)
+	}
+
+	// A pointer to itself must not loop forever.
+	if _, err := ParseDNSMessage(message(1, 0xc0, 30)); err == nil {
+		t.Error("self-referencing pointer parsed")
+	}
+}
+
+func TestDNSShortMessages(t *testing.T) {
+	full := message(1, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, 1)
+	if _, err := ParseDNSMessage(full); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(full); n++ {
+		if _, err := ParseDNSMessage(full[:n]); err == nil {
+			t.Errorf("parsed a message cut to %d of %d bytes", n, len(full))
+		}
+	}
+}
+
+func TestDNSSOAInsideRData(t *testing.T) {
+	// The SOA's RDATA holds only the two names; the counters it would
+	// read are really the A record after it.
+	names := soaRData(t, "ns1.example.test.", "admin.example.test.")
+	rest := []byte{0xc0, 12, 0, 6, 0, 1, 0, 0, 0, 60, 0, byte(len(names))}
+	rest = append(rest, names...)
+	for i := 0; i < 3; i++ {
+		rest = append(rest, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 192, 0, 2, byte(i))
+	}
+	_, err := ParseDNSMessage(message(4, rest...))
+	if !errors.Is(err, errDNSShort) {
+		t.Fatalf("got %v, want %v", err, errDNSShort)
+	}
+}
@@ -0,0 +1,446 @@
+package QCom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Field is one decoded value, named the way display filters refer to it
+// (ip.src, tcp.dstport, ...).
+type Field struct {
+	Name  string
+	Value string
+}
+
+type Layer struct {
+	Name   string
+	Fields []Field
+}
+
+func (l *Layer) add(name string, v any) {
+	l.Fields = append(l.Fields, Field{Name: name, Value: fmt.Sprint(v)})
+}
+
+type IPInfo struct {
+	Src, Dst netip.Addr
+	Proto    uint8
+	TTL      uint8
+}
+
+const (
+	TCPFin = 1 << iota
+	TCPSyn
+	TCPRst
+	TCPPsh
+	TCPAck
+	TCPUrg
+)
+
+type TCPInfo struct {
+	SrcPort, DstPort uint16
+	Seq, Ack         uint32
+	Flags            uint8
+	Window           uint16
+	Payload          []byte
+}
+
+type UDPInfo struct {
+	SrcPort, DstPort uint16
+	Payload          []byte
+}
+
+// Packet is a captured frame together with everything the decoder could
+// make of it. Layers drive the detail view and display filters; IP, TCP
+// and UDP give typed access for flow tracking.
+type Packet struct {
+	Num      int
+	Time     time.Time
+	Len      int
+	Data     []byte
+	LinkType uint32
+
+	Layers []Layer
+	Src    string
+	Dst    string
+	Proto  string
+	Info   string
+
+	IP  *IPInfo
+	TCP *TCPInfo
+	UDP *UDPInfo
+}
+
+// Field returns every value of the named field in the packet.
+func (p *Packet) Field(name string) []string {
+	var out []string
+	for _, l := range p.Layers {
+		for _, f := range l.Fields {
+			if f.Name == name {
+				out = append(out, f.Value)
+			}
+		}
+	}
+	return out
+}
+
+func (p *Packet) HasLayer(name string) bool {
+	for _, l := range p.Layers {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func DecodePacket(num int, c CapturedPacket) *Packet {
+	p := &Packet{Num: num, Time: c.Time, Len: c.OrigLen, Data: c.Data, LinkType: c.LinkType}
+	frame := Layer{Name: "frame"}
+	frame.add("frame.number", num)
+	frame.add("frame.len", c.OrigLen)
+	frame.add("frame.cap_len", c.CapLen)
+	p.Layers = append(p.Layers, frame)
+	p.Proto = "Frame"
+
+	b := c.Data
+	switch c.LinkType {
+	case LinkTypeEthernet:
+		p.decodeEthernet(b)
+	case LinkTypeLinuxSLL:
+		if len(b) >= 16 {
+			p.decodeEtherType(binary.BigEndian.Uint16(b[14:]), b[16:])
+		}
+	case LinkTypeRaw:
+		p.decodeIP(b)
+	case LinkTypeNull:
+		if len(b) >= 4 {
+			p.decodeIP(b[4:])
+		}
+	default:
+		p.Info = fmt.Sprintf("unsupported link type %d", c.LinkType)
+	}
+	return p
+}
+
+func macString(b []byte) string {
+	return net.HardwareAddr(b).String()
+}
+
+func (p *Packet) decodeEthernet(b []byte) {
+	if len(b) < 14 {
+		return
+	}
+	l := Layer{Name: "eth"}
+	l.add("eth.dst", macString(b[0:6]))
+	l.add("eth.src", macString(b[6:12]))
+	typ := binary.BigEndian.Uint16(b[12:])
+	l.add("eth.type", fmt.Sprintf("0x%04x", typ))
+	p.Layers = append(p.Layers, l)
+	p.Src, p.Dst, p.Proto = macString(b[6:12]), macString(b[0:6]), "Ethernet"
+	p.decodeEtherType(typ, b[14:])
+}
+
+func (p *Packet) decodeEtherType(typ uint16, b []byte) {
+	switch typ {
+	case 0x8100, 0x88a8:
+		if len(b) < 4 {
+			return
+		}
+		tci := binary.BigEndian.Uint16(b)
+		l := Layer{Name: "vlan"}
+		l.add("vlan.id", tci&0x0fff)
+		l.add("vlan.priority", tci>>13)
+		next := binary.BigEndian.Uint16(b[2:])
+		l.add("vlan.etype", fmt.Sprintf("0x%04x", next))
+		p.Layers = append(p.Layers, l)
+		p.Proto = "VLAN"
+		p.decodeEtherType(next, b[4:])
+	case 0x0806:
+		p.decodeARP(b)
+	case 0x0800, 0x86dd:
+		p.decodeIP(b)
+	}
+}
+
+func (p *Packet) decodeARP(b []byte) {
+	if len(b) < 28 {
+		return
+	}
+	l := Layer{Name: "arp"}
+	op := binary.BigEndian.Uint16(b[6:])
+	l.add("arp.opcode", op)
+	l.add("arp.src.hw_mac", macString(b[8:14]))
+	spa := netip.AddrFrom4([4]byte(b[14:18]))
+	tpa := netip.AddrFrom4([4]byte(b[24:28]))
+	l.add("arp.src.proto_ipv4", spa)
+	l.add("arp.dst.hw_mac", macString(b[18:24]))
+	l.add("arp.dst.proto_ipv4", tpa)
+	p.Layers = append(p.Layers, l)
+	p.Proto = "ARP"
+	if op == 1 {
+		p.Info = fmt.Sprintf("Who has %s? Tell %s", tpa, spa)
+	} else {
+		p.Info = fmt.Sprintf("%s is at %s", spa, macString(b[8:14]))
+	}
+}
+
+func (p *Packet) decodeIP(b []byte) {
+	if len(b) < 1 {
+		return
+	}
+	switch b[0] >> 4 {
+	case 4:
+		p.decodeIPv4(b)
+	case 6:
+		p.decodeIPv6(b)
+	}
+}
+
+func (p *Packet) decodeIPv4(b []byte) {
+	if len(b) < 20 {
+		return
+	}
+	ihl := int(b[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(b[2:]))
+	if ihl < 20 || len(b) < ihl {
+		return
+	}
+	if total >= ihl && total < len(b) {
+		b = b[:total] // strip Ethernet padding
+	}
+	ip := &IPInfo{
+		Src:   netip.AddrFrom4([4]byte(b[12:16])),
+		Dst:   netip.AddrFrom4([4]byte(b[16:20])),
+		Proto: b[9],
+		TTL:   b[8],
+	}
+	frag := binary.BigEndian.Uint16(b[6:])
+	l := Layer{Name: "ip"}
+	l.add("ip.version", 4)
+	l.add("ip.hdr_len", ihl)
+	l.add("ip.len", total)
+	l.add("ip.id", fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(b[4:])))
+	l.add("ip.flags.df", frag>>14&1)
+	l.add("ip.flags.mf", frag>>13&1)
+	l.add("ip.frag_offset", frag&0x1fff)
+	l.add("ip.ttl", ip.TTL)
+	l.add("ip.proto", ip.Proto)
+	l.add("ip.src", ip.Src)
+	l.add("ip.dst", ip.Dst)
+	p.Layers = append(p.Layers, l)
+	p.IP = ip
+	p.Src, p.Dst, p.Proto = ip.Src.String(), ip.Dst.String(), "IPv4"
+	p.Info = fmt.Sprintf("protocol %d", ip.Proto)
+	if frag&0x1fff != 0 {
+		p.Info = "fragment"
+		return
+	}
+	p.decodeTransport(ip.Proto, b[ihl:])
+}
+
+func (p *Packet) decodeIPv6(b []byte) {
+	if len(b) < 40 {
+		return
+	}
+	ip := &IPInfo{
+		Src: netip.AddrFrom16([16]byte(b[8:24])),
+		Dst: netip.AddrFrom16([16]byte(b[24:40])),
+		TTL: b[7],
+	}
+	next := b[6]
+	plen := int(binary.BigEndian.Uint16(b[4:]))
+	payload := b[40:]
+	if plen < len(payload) {
+		payload = payload[:plen]
+	}
+	// Walk hop-by-hop, routing, destination options and fragment headers.
+	for next == 0 || next == 43 || next == 60 || next == 44 {
+		if len(payload) < 8 {
+			return
+		}
+		hl := (int(payload[1]) + 1) * 8
+		if next == 44 {
+			hl = 8
+			if binary.BigEndian.Uint16(payload[2:])&0xfff8 != 0 {
+				next = 0xff // non-first fragment, nothing to decode
+				break
+			}
+		}
+		if hl > len(payload) {
+			return
+		}
+		next, payload = payload[0], payload[hl:]
+	}
+	ip.Proto = next
+	l := Layer{Name: "ipv6"}
+	l.add("ipv6.version", 6)
+	l.add("ipv6.plen", plen)
+	l.add("ipv6.nxt", next)
+	l.add("ipv6.hlim", ip.TTL)
+	l.add("ipv6.src", ip.Src)
+	l.add("ipv6.dst", ip.Dst)
+	p.Layers = append(p.Layers, l)
+	p.IP = ip
+	p.Src, p.Dst, p.Proto = ip.Src.String(), ip.Dst.String(), "IPv6"
+	p.Info = fmt.Sprintf("next header %d", next)
+	p.decodeTransport(next, payload)
+}
+
+func (p *Packet) decodeTransport(proto uint8, b []byte) {
+	switch proto {
+	case 6:
+		p.decodeTCP(b)
+	case 17:
+		p.decodeUDP(b)
+	case 1:
+		p.decodeICMP("icmp", b)
+	case 58:
+		p.decodeICMP("icmpv6", b)
+	}
+}
+
+func tcpFlagString(f uint8) string {
+	var s []string
+	for i, n := range []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG"} {
+		if f&(1<<i) != 0 {
+			s = append(s, n)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func (p *Packet) decodeTCP(b []byte) {
+	if len(b) < 20 {
+		return
+	}
+	off := int(b[12]>>4) * 4
+	if off < 20 || off > len(b) {
+		return
+	}
+	t := &TCPInfo{
+		SrcPort: binary.BigEndian.Uint16(b),
+		DstPort: binary.BigEndian.Uint16(b[2:]),
+		Seq:     binary.BigEndian.Uint32(b[4:]),
+		Ack:     binary.BigEndian.Uint32(b[8:]),
+		Flags:   b[13],
+		Window:  binary.BigEndian.Uint16(b[14:]),
+		Payload: b[off:],
+	}
+	l := Layer{Name: "tcp"}
+	l.add("tcp.srcport", t.SrcPort)
+	l.add("tcp.dstport", t.DstPort)
+	l.add("tcp.seq", t.Seq)
+	l.add("tcp.ack", t.Ack)
+	l.add("tcp.hdr_len", off)
+	l.add("tcp.flags", fmt.Sprintf("0x%02x", t.Flags))
+	for i, n := range []string{"fin", "syn", "reset", "push", "ack", "urg"} {
+		l.add("tcp.flags."+n, t.Flags>>i&1)
+	}
+	l.add("tcp.window_size", t.Window)
+	l.add("tcp.len", len(t.Payload))
+	p.Layers = append(p.Layers, l)
+	p.TCP = t
+	p.Proto = "TCP"
+	p.Info = fmt.Sprintf("%d → %d [%s] Seq=%d Ack=%d Win=%d Len=%d",
+		t.SrcPort, t.DstPort, tcpFlagString(t.Flags), t.Seq, t.Ack, t.Window, len(t.Payload))
+	if (t.SrcPort == 53 || t.DstPort == 53) && len(t.Payload) > 2 {
+		p.decodeDNS(t.Payload[2:])
+	}
+}
+
+func (p *Packet) decodeUDP(b []byte) {
+	if len(b) < 8 {
+		return
+	}
+	u := &UDPInfo{
+		SrcPort: binary.BigEndian.Uint16(b),
+		DstPort: binary.BigEndian.Uint16(b[2:]),
+		Payload: b[8:],
+	}
+	l := Layer{Name: "udp"}
+	l.add("udp.srcport", u.SrcPort)
+	l.add("udp.dstport", u.DstPort)
+	l.add("udp.length", binary.BigEndian.Uint16(b[4:]))
+	p.Layers = append(p.Layers, l)
+	p.UDP = u
+	p.Proto = "UDP"
+	p.Info = fmt.Sprintf("%d → %d Len=%d", u.SrcPort, u.DstPort, len(u.Payload))
+	for _, port := range []uint16{53, 5353} {
+		if u.SrcPort == port || u.DstPort == port {
+			p.decodeDNS(u.Payload)
+			return
+		}
+	}
+}
+
+func (p *Packet) decodeICMP(name string, b []byte) {
+	if len(b) < 4 {
+		return
+	}
+	l := Layer{Name: name}
+	l.add(name+".type", b[0])
+	l.add(name+".code", b[1])
+	p.Layers = append(p.Layers, l)
+	p.Proto = strings.ToUpper(name)
+	p.Info = fmt.Sprintf("type %d code %d", b[0], b[1])
+	switch {
+	case name == "icmp" && b[0] == 8, name == "icmpv6" && b[0] == 128:
+		p.Info = "Echo (ping) request"
+	case name == "icmp" && b[0] == 0, name == "icmpv6" && b[0] == 129:
+		p.Info = "Echo (ping) reply"
+	case name == "icmp" && b[0] == 11, name == "icmpv6" && b[0] == 3:
+		p.Info = "Time-to-live exceeded"
+	case name == "icmp" && b[0] == 3, name == "icmpv6" && b[0] == 1:
+		p.Info = "Destination unreachable"
+	}
+}
+
+func (p *Packet) decodeDNS(b []byte) {
+	m, err := ParseDNSMessage(b)
+	if err != nil {
+		return
+	}
+	l := Layer{Name: "dns"}
+	l.add("dns.id", fmt.Sprintf("0x%04x", m.Header.ID))
+	l.add("dns.flags.response", map[bool]int{false: 0, true: 1}[m.Header.QR])
+	l.add("dns.flags.rcode", m.Header.Rcode)
+	var qs []string
+	for _, q := range m.Questions {
+		l.add("dns.qry.name", strings.TrimSuffix(q.Name, "."))
+		l.add("dns.qry.type", DNSTypeName(q.Type))
+		qs = append(qs, DNSTypeName(q.Type)+" "+strings.TrimSuffix(q.Name, "."))
+	}
+	var as []string
+	for _, a := range m.Answers {
+		l.add("dns.resp.name", strings.TrimSuffix(a.Name, "."))
+		l.add("dns.resp.ttl", a.TTL)
+		switch a.Type {
+		case TypeA:
+			l.add("dns.a", a.Data)
+		case TypeAAAA:
+			l.add("dns.aaaa", a.Data)
+		case TypeCNAME:
+			l.add("dns.cname", a.Data)
+		}
+		as = append(as, DNSTypeName(a.Type)+" "+a.Data)
+	}
+	p.Layers = append(p.Layers, l)
+	p.Proto = "DNS"
+	if p.UDP != nil && (p.UDP.SrcPort == 5353 || p.UDP.DstPort == 5353) {
+		p.Proto = "MDNS"
+	}
+	kind := "query"
+	if m.Header.QR {
+		kind = "response"
+	}
+	p.Info = fmt.Sprintf("Standard %s 0x%04x %s", kind, m.Header.ID, strings.Join(qs, ", "))
+	if len(as) > 0 {
+		p.Info += " " + strings.Join(as, " ")
+	}
+	if m.Header.Rcode != 0 {
+		p.Info += " " + DNSRcodeName(m.Header.Rcode)
+	}
+}
@@ -0,0 +1,143 @@
+package QCom
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// ipPacket wraps payload in an IPv4 or IPv6 header, whichever src is.
+func ipPacket(src, dst string, proto uint8, payload []byte) []byte {
+	s, d := netip.MustParseAddr(src), netip.MustParseAddr(dst)
+	if s.Is4() {
+		b := []byte{0x45, 0}
+		b = binary.BigEndian.AppendUint16(b, uint16(20+len(payload)))
+		b = append(b, 0x12, 0x34, 0x40, 0, 64, proto, 0, 0)
+		b = append(b, s.AsSlice()...)
+		b = append(b, d.AsSlice()...)
+		return append(b, payload...)
+	}
+	b := []byte{0x60, 0, 0, 0}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	b = append(b, proto, 64)
+	b = append(b, s.AsSlice()...)
+	b = append(b, d.AsSlice()...)
+	return append(b, payload...)
+}
+
+func tcpSegment(sport, dport uint16, seq, ack uint32, flags uint8, payload string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, sport)
+	b = binary.BigEndian.AppendUint16(b, dport)
+	b = binary.BigEndian.AppendUint32(b, seq)
+	b = binary.BigEndian.AppendUint32(b, ack)
+	b = append(b, 5<<4, flags)
+	b = binary.BigEndian.AppendUint16(b, 65535)
+	b = append(b, 0, 0, 0, 0)
+	return append(b, payload...)
+}
+
+func udpDatagram(sport, dport uint16, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, sport)
+	b = binary.BigEndian.AppendUint16(b, dport)
+	b = binary.BigEndian.AppendUint16(b, uint16(8+len(payload)))
+	b = append(b, 0, 0)
+	return append(b, payload...)
+}
+
+func etherFrame(ethertype uint16, payload []byte) []byte {
+	b := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0, 0, 0, 0, 1}
+	b = binary.BigEndian.AppendUint16(b, ethertype)
+	return append(b, payload...)
+}
+
+func decode(linkType uint32, b []byte) *Packet {
+	return DecodePacket(1, CapturedPacket{LinkType: linkType, CapLen: len(b), OrigLen: len(b), Data: b})
+}
+
+func TestDecodePacket(t *testing.T) {
+	v4TCP := ipPacket("192.0.2.1", "192.0.2.2", 6, tcpSegment(40000, 443, 1000, 0, TCPSyn, ""))
+	v6UDP := ipPacket("2001:db8::1", "2001:db8::2", 17, udpDatagram(5000, 6000, []byte("hi")))
+	dnsQuery := []byte{0x12, 0x34, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1}
+	// A hop-by-hop options header in front of the UDP datagram.
+	hopByHop := ipPacket("2001:db8::1", "2001:db8::2", 0, append([]byte{17, 0, 1, 4, 0, 0, 0, 0}, udpDatagram(1, 2, nil)...))
+	fragment := ipPacket("192.0.2.1", "192.0.2.2", 17, udpDatagram(1, 2, nil))
+	fragment[7] = 10
+	arp := []byte{0, 1, 8, 0, 6, 4, 0, 1, 2, 0, 0, 0, 0, 1, 192, 0, 2, 1, 0, 0, 0, 0, 0, 0, 192, 0, 2, 2}
+	sll := append(make([]byte, 14), 0x86, 0xdd)
+
+	for _, c := range []struct {
+		name     string
+		link     uint32
+		b        []byte
+		proto    string
+		src, dst string
+		info     string
+		fields   map[string]string
+	}{
+		{"ethernet ipv4 tcp", LinkTypeEthernet, etherFrame(0x0800, v4TCP), "TCP", "192.0.2.1", "192.0.2.2",
+			"40000 → 443 [SYN] Seq=1000 Ack=0 Win=65535 Len=0",
+			map[string]string{"eth.type": "0x0800", "ip.ttl": "64", "ip.flags.df": "1", "tcp.dstport": "443", "tcp.flags.syn": "1", "tcp.flags.ack": "0"}},
+		// Ethernet pads short frames to 60 bytes; ip.len says where to stop.
+		{"padded frame", LinkTypeEthernet, etherFrame(0x0800, append(ipPacket("192.0.2.1", "192.0.2.2", 6, tcpSegment(1, 2, 0, 0, TCPAck, "")), 0, 0, 0, 0, 0, 0)), "TCP", "192.0.2.1", "192.0.2.2",
+			"1 → 2 [ACK] Seq=0 Ack=0 Win=65535 Len=0", map[string]string{"tcp.len": "0"}},
+		{"raw ipv6 udp", LinkTypeRaw, v6UDP, "UDP", "2001:db8::1", "2001:db8::2", "5000 → 6000 Len=2",
+			map[string]string{"ipv6.nxt": "17", "ipv6.hlim": "64", "udp.length": "10"}},
+		{"ipv6 extension header", LinkTypeRaw, hopByHop, "UDP", "2001:db8::1", "2001:db8::2", "1 → 2 Len=0", map[string]string{"ipv6.nxt": "17"}},
+		{"vlan", LinkTypeEthernet, etherFrame(0x8100, append([]byte{0x20, 0x64, 0x08, 0x00}, v4TCP...)), "TCP", "192.0.2.1", "192.0.2.2", "",
+			map[string]string{"vlan.id": "100", "vlan.priority": "1"}},
+		{"linux cooked", LinkTypeLinuxSLL, append(sll, v6UDP...), "UDP", "2001:db8::1", "2001:db8::2", "", nil},
+		{"loopback", LinkTypeNull, append([]byte{2, 0, 0, 0}, v4TCP...), "TCP", "192.0.2.1", "192.0.2.2", "", nil},
+		{"dns", LinkTypeRaw, ipPacket("192.0.2.1", "192.0.2.53", 17, udpDatagram(5000, 53, dnsQuery)), "DNS", "192.0.2.1", "192.0.2.53",
+			"Standard query 0x1234 A example.com", map[string]string{"dns.qry.name": "example.com"}},
+		{"arp", LinkTypeEthernet, etherFrame(0x0806, arp), "ARP", "02:00:00:00:00:01", "ff:ff:ff:ff:ff:ff", "Who has 192.0.2.2? Tell 192.0.2.1", nil},
+		{"icmp", LinkTypeRaw, ipPacket("192.0.2.1", "192.0.2.2", 1, []byte{8, 0, 0, 0}), "ICMP", "192.0.2.1", "192.0.2.2", "Echo (ping) request", nil},
+		{"fragment", LinkTypeRaw, fragment, "IPv4", "192.0.2.1", "192.0.2.2", "fragment", map[string]string{"ip.frag_offset": "10"}},
+	} {
+		p := decode(c.link, c.b)
+		if p.Proto != c.proto || p.Src != c.src || p.Dst != c.dst || c.info != "" && p.Info != c.info {
+			t.Errorf("%s: got %s %s → %s %q", c.name, p.Proto, p.Src, p.Dst, p.Info)
+		}
+		for name, want := range c.fields {
+			if got := p.Field(name); len(got) != 1 || got[0] != want {
+				t.Errorf("%s: %s = %q, want %q", c.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	// Cutting a packet anywhere must never panic, and a layer is only
+	// reported once its whole header is there.
+	full := etherFrame(0x0800, ipPacket("192.0.2.1", "192.0.2.2", 6, tcpSegment(1, 2, 3, 4, TCPAck|TCPPsh, "data")))
+	v6 := ipPacket("2001:db8::1", "2001:db8::2", 0, append([]byte{17, 0, 1, 4, 0, 0, 0, 0}, udpDatagram(1, 2, []byte("x"))...))
+	for n := range full {
+		p := decode(LinkTypeEthernet, full[:n])
+		if p.TCP != nil && n < 14+20+20 {
+			t.Errorf("TCP decoded from %d bytes", n)
+		}
+		if p.IP != nil && n < 14+20 {
+			t.Errorf("IP decoded from %d bytes", n)
+		}
+	}
+	for n := range v6 {
+		if p := decode(LinkTypeRaw, v6[:n]); p.UDP != nil && n < 40+8+8 {
+			t.Errorf("UDP decoded from %d bytes", n)
+		}
+	}
+
+	// A header length pointing past the data is ignored.
+	bad := ipPacket("192.0.2.1", "192.0.2.2", 6, tcpSegment(1, 2, 3, 4, TCPAck, ""))
+	bad[20+12] = 15 << 4
+	if p := decode(LinkTypeRaw, bad); p.TCP != nil || p.Proto != "IPv4" {
+		t.Errorf("TCP with an oversized header decoded as %s", p.Proto)
+	}
+	bad = ipPacket("192.0.2.1", "192.0.2.2", 6, nil)
+	bad[0] = 0x44
+	if p := decode(LinkTypeRaw, bad); p.IP != nil {
+		t.Error("IPv4 with a 16-byte header decoded")
+	}
+	if p := decode(99, []byte{1, 2, 3}); !strings.Contains(p.Info, "unsupported link type 99") {
+		t.Errorf("unknown link type: %q", p.Info)
+	}
+}
@@ -0,0 +1,372 @@
+package QCom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MaxDiscoverHosts keeps a sweep to a /22 or smaller; past that the ARP
+// table can't hold the results anyway.
+const MaxDiscoverHosts = 1024
+
+type ARPEntry struct {
+	IP        netip.Addr
+	MAC       net.HardwareAddr
+	Interface string
+}
+
+// ouiVendors is a short list of prefixes common on home and lab networks,
+// not a full registry.
+var ouiVendors = map[string]string{
+	"00:00:0c": "Cisco",
+	"00:03:93": "Apple",
+	"00:04:4b": "NVIDIA",
+	"00:05:69": "VMware",
+	"00:08:9b": "QNAP",
+	"00:0a:95": "Apple",
+	"00:0c:29": "VMware",
+	"00:10:18": "Broadcom",
+	"00:11:32": "Synology",
+	"00:14:22": "Dell",
+	"00:15:5d": "Microsoft Hyper-V",
+	"00:17:88": "Philips Lighting",
+	"00:17:f2": "Apple",
+	"00:18:0a": "Cisco Meraki",
+	"00:1a:11": "Google",
+	"00:1b:21": "Intel",
+	"00:1b:63": "Apple",
+	"00:1c:42": "Parallels",
+	"00:27:22": "Ubiquiti",
+	"00:50:56": "VMware",
+	"00:e0:4c": "Realtek",
+	"08:00:27": "VirtualBox",
+	"18:b4:30": "Nest Labs",
+	"24:a4:3c": "Ubiquiti",
+	"28:cd:c1": "Raspberry Pi",
+	"52:54:00": "QEMU/KVM",
+	"b8:27:eb": "Raspberry Pi",
+	"d8:3a:dd": "Raspberry Pi",
+	"dc:a6:32": "Raspberry Pi",
+	"e4:5f:01": "Raspberry Pi",
+}
+
+// MACVendor names the maker of mac from its OUI where known. Locally
+// administered addresses are usually randomized by phones and laptops.
+func MACVendor(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
+	}
+	if v, ok := ouiVendors[mac[:3].String()]; ok {
+		return v
+	}
+	switch {
+	case mac[0] == 0x02 && mac[1] == 0x42:
+		return "Docker"
+	case mac[0]&0x02 != 0:
+		return "(randomized)"
+	}
+	return ""
+}
+
+// InterfacePrefix returns the IPv4 network iface is attached to.
+func InterfacePrefix(iface string) (netip.Prefix, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.To4() == nil {
+			continue
+		}
+		ip, _ := netip.AddrFromSlice(ipn.IP.To4())
+		ones, _ := ipn.Mask.Size()
+		return netip.PrefixFrom(ip, ones).Masked(), nil
+	}
+	return netip.Prefix{}, fmt.Errorf("%s has no IPv4 address", iface)
+}
+
+// PrefixInterface returns the interface whose IPv4 network overlaps p,
+// or "" when p is not on any local link.
+func PrefixInterface(p netip.Prefix) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok || ipn.IP.To4() == nil {
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(ipn.IP.To4())
+			ones, _ := ipn.Mask.Size()
+			if netip.PrefixFrom(ip, ones).Masked().Overlaps(p) {
+				return ifi.Name
+			}
+		}
+	}
+	return ""
+}
+
+type DiscoverOptions struct {
+	// Interface is the link Prefix is on; ARP entries from other links
+	// are ignored and mDNS listens there. Leave it empty for a prefix
+	// that isn't directly attached.
+	Interface string
+	Prefix    netip.Prefix
+	Workers   int
+	Timeout   time.Duration
+	// TCPPorts are tried when ICMP gets no answer; a refusal counts as
+	// alive just like an accept.
+	TCPPorts []int
+	// MDNS listens for mDNS and DNS-SD responses during the sweep.
+	MDNS bool
+	// Names does reverse DNS lookups for hosts found.
+	Names bool
+}
+
+func DefaultDiscoverOptions() DiscoverOptions {
+	return DiscoverOptions{
+		Workers:  64,
+		Timeout:  time.Second,
+		TCPPorts: []int{22, 80, 443, 445, 139, 62078},
+		MDNS:     true,
+		Names:    true,
+	}
+}
+
+type DiscoveredHost struct {
+	IP       netip.Addr
+	MAC      string
+	Vendor   string
+	Hostname string
+	Services []string
+	// Via lists what revealed the host: icmp, tcp, arp or mdns.
+	Via []string
+	RTT time.Duration
+}
+
+type discoverSet struct {
+	mu    sync.Mutex
+	hosts map[netip.Addr]*DiscoveredHost
+}
+
+func (s *discoverSet) get(ip netip.Addr, via string) *DiscoveredHost {
+	h := s.hosts[ip]
+	if h == nil {
+		h = &DiscoveredHost{IP: ip}
+		s.hosts[ip] = h
+	}
+	for _, v := range h.Via {
+		if v == via {
+			return h
+		}
+	}
+	h.Via = append(h.Via, via)
+	return h
+}
+
+// Discover sweeps opts.Prefix and returns every host that answered a
+// probe, shows up in the ARP table or announced itself over mDNS. progress,
+// if set, is called after each probe, one call at a time.
+func Discover(ctx context.Context, opts DiscoverOptions, progress func(done, total int)) ([]DiscoveredHost, error) {
+	p := opts.Prefix.Masked()
+	if !p.IsValid() || !p.Addr().Is4() {
+		return nil, errors.New("discovery needs an IPv4 prefix")
+	}
+	if bits := 32 - p.Bits(); bits > 10 {
+		return nil, fmt.Errorf("prefix %s is larger than %d hosts", p, MaxDiscoverHosts)
+	}
+	var targets []netip.Addr
+	for a := p.Addr(); p.Contains(a); a = a.Next() {
+		targets = append(targets, a)
+	}
+	// Skip the network and broadcast addresses where they exist.
+	if p.Bits() < 31 {
+		targets = targets[1 : len(targets)-1]
+	}
+
+	set := &discoverSet{hosts: map[netip.Addr]*DiscoveredHost{}}
+	logger().Info("discovery started", "prefix", p, "iface", opts.Interface, "targets", len(targets))
+
+	var mdnsDone chan struct{}
+	mctx, mcancel := context.WithCancel(ctx)
+	defer mcancel()
+	if opts.MDNS {
+		mdnsDone = make(chan struct{})
+		go func() {
+			defer close(mdnsDone)
+			MDNSListen(mctx, opts.Interface, []string{ServicesEnumName}, 2*time.Second, func(m MDNSMessage) {
+				if !p.Contains(m.From) {
+					return
+				}
+				set.mu.Lock()
+				addMDNS(set.get(m.From, "mdns"), m)
+				set.mu.Unlock()
+			})
+		}()
+	}
+
+	work := make(chan netip.Addr)
+	var wg sync.WaitGroup
+	var done int
+	for range max(opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range work {
+				via, rtt := probeHost(ctx, ip, opts)
+				set.mu.Lock()
+				if via != "" {
+					set.get(ip, via).RTT = rtt
+				}
+				done++
+				// Called under the lock so progress needn't be thread-safe.
+				if progress != nil {
+					progress(done, len(targets))
+				}
+				set.mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, ip := range targets {
+		select {
+		case work <- ip:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	// Probes that got nothing back still made the kernel ARP for the
+	// address, so firewalled hosts turn up here.
+	if arp, err := ReadARPTable(); err == nil {
+		set.mu.Lock()
+		for _, e := range arp {
+			if !p.Contains(e.IP) || (opts.Interface != "" && e.Interface != opts.Interface) {
+				continue
+			}
+			h := set.get(e.IP, "arp")
+			h.MAC, h.Vendor = e.MAC.String(), MACVendor(e.MAC)
+		}
+		set.mu.Unlock()
+	}
+
+	if mdnsDone != nil {
+		// Give slow responders a moment after the last probe.
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+		}
+		mcancel()
+		<-mdnsDone
+	}
+
+	hosts := make([]DiscoveredHost, 0, len(set.hosts))
+	for _, h := range set.hosts {
+		hosts = append(hosts, *h)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].IP.Less(hosts[j].IP) })
+
+	if opts.Names {
+		var nwg sync.WaitGroup
+		sem := make(chan struct{}, 16)
+		for i := range hosts {
+			if hosts[i].Hostname != "" {
+				continue
+			}
+			nwg.Add(1)
+			go func(h *DiscoveredHost) {
+				defer nwg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				lctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+				defer cancel()
+				if names, err := net.DefaultResolver.LookupAddr(lctx, h.IP.String()); err == nil && len(names) > 0 {
+					h.Hostname = strings.TrimSuffix(names[0], ".")
+				}
+			}(&hosts[i])
+		}
+		nwg.Wait()
+	}
+	logger().Info("discovery finished", "prefix", p, "hosts", len(hosts), "err", ctx.Err())
+	return hosts, ctx.Err()
+}
+
+func probeHost(ctx context.Context, ip netip.Addr, opts DiscoverOptions) (string, time.Duration) {
+	p := NewPinger(ip.String(), PingICMP, 0)
+	s := p.Ping(ctx, 1, opts.Timeout)
+	p.Close()
+	if !s.Lost {
+		return "icmp", s.RTT
+	}
+	for _, port := range opts.TCPPorts {
+		if ctx.Err() != nil {
+			break
+		}
+		d := net.Dialer{Timeout: opts.Timeout}
+		start := time.Now()
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if err == nil {
+			conn.Close()
+			return "tcp", time.Since(start)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return "tcp", time.Since(start)
+		}
+	}
+	return "", 0
+}
+
+// addMDNS picks the host name and service types out of an mDNS response.
+func addMDNS(h *DiscoveredHost, m MDNSMessage) {
+	records := append(append([]DNSRecord{}, m.Msg.Answers...), m.Msg.Additional...)
+	for _, r := range records {
+		switch r.Type {
+		case TypeA, TypeAAAA:
+			if r.Addr == m.From && h.Hostname == "" {
+				h.Hostname = strings.TrimSuffix(r.Name, ".")
+			}
+		case TypeSRV:
+			// Instance._type._proto.local → _type._proto
+			parts := strings.SplitN(r.Name, "._", 2)
+			if len(parts) == 2 {
+				h.addService("_" + strings.TrimSuffix(strings.TrimSuffix(parts[1], "."), ".local"))
+			}
+		case TypePTR:
+			if strings.EqualFold(r.Name, ServicesEnumName) {
+				h.addService(strings.TrimSuffix(strings.TrimSuffix(r.Target, "."), ".local"))
+			}
+		}
+	}
+}
+
+func (h *DiscoveredHost) addService(s string) {
+	for _, x := range h.Services {
+		if x == s {
+			return
+		}
+	}
+	h.Services = append(h.Services, s)
+	sort.Strings(h.Services)
+}
@@ -0,0 +1,40 @@
+package QCom
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadARPTable returns the kernel's complete IPv4 neighbour entries.
+func ReadARPTable() ([]ARPEntry, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []ARPEntry
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		fs := strings.Fields(sc.Text())
+		if len(fs) < 6 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fs[0])
+		if err != nil {
+			continue
+		}
+		// ATF_COM marks a resolved entry; anything else has no usable MAC.
+		flags, _ := strconv.ParseUint(fs[2], 0, 32)
+		mac, err := net.ParseMAC(fs[3])
+		if flags&0x2 == 0 || err != nil {
+			continue
+		}
+		out = append(out, ARPEntry{IP: ip, MAC: mac, Interface: fs[5]})
+	}
+	return out, sc.Err()
+}
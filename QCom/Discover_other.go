@@ -0,0 +1,9 @@
+//go:build !linux
+
+package QCom
+
+import "errors"
+
+func ReadARPTable() ([]ARPEntry, error) {
+	return nil, errors.New("reading the ARP table is only supported on Linux")
+}
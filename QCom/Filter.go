@@ -0,0 +1,303 @@
+package QCom
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// DisplayFilter is a compiled Wireshark-style expression such as
+//
+//	ip.src == 10.0.0.0/8 && (tcp.port == 443 || dns)
+//
+// Bare names test for a protocol or field being present; comparisons use
+// ==, !=, <, <=, >, >= or contains, and combine with and/or/not.
+type DisplayFilter struct {
+	src  string
+	root filterNode
+}
+
+func (f *DisplayFilter) String() string { return f.src }
+
+// Match reports whether p satisfies the filter. A nil filter matches all.
+func (f *DisplayFilter) Match(p *Packet) bool {
+	if f == nil || f.root == nil {
+		return true
+	}
+	return f.root.eval(p)
+}
+
+// filterAliases expand fields that match either direction.
+var filterAliases = map[string][]string{
+	"tcp.port":  {"tcp.srcport", "tcp.dstport"},
+	"udp.port":  {"udp.srcport", "udp.dstport"},
+	"ip.addr":   {"ip.src", "ip.dst"},
+	"ipv6.addr": {"ipv6.src", "ipv6.dst"},
+	"eth.addr":  {"eth.src", "eth.dst"},
+}
+
+type filterNode interface {
+	eval(p *Packet) bool
+}
+
+type andNode struct{ l, r filterNode }
+type orNode struct{ l, r filterNode }
+type notNode struct{ x filterNode }
+type existsNode struct{ name string }
+type compareNode struct {
+	field string
+	op    string
+	value string
+}
+
+func (n andNode) eval(p *Packet) bool { return n.l.eval(p) && n.r.eval(p) }
+func (n orNode) eval(p *Packet) bool  { return n.l.eval(p) || n.r.eval(p) }
+func (n notNode) eval(p *Packet) bool { return !n.x.eval(p) }
+
+func (n existsNode) eval(p *Packet) bool {
+	if !strings.Contains(n.name, ".") {
+		return p.HasLayer(n.name)
+	}
+	return len(filterValues(p, n.name)) > 0
+}
+
+func filterValues(p *Packet, name string) []string {
+	if names, ok := filterAliases[name]; ok {
+		var out []string
+		for _, n := range names {
+			out = append(out, p.Field(n)...)
+		}
+		return out
+	}
+	return p.Field(name)
+}
+
+func (n compareNode) eval(p *Packet) bool {
+	values := filterValues(p, n.field)
+	if n.op == "!=" {
+		// Like Wireshark's !=, true only when no value equals the operand.
+		for _, v := range values {
+			if compareValue(v, "==", n.value) {
+				return false
+			}
+		}
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if compareValue(v, n.op, n.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareValue(v, op, want string) bool {
+	if op == "contains" {
+		return strings.Contains(strings.ToLower(v), strings.ToLower(want))
+	}
+	// Addresses compare against a single address or a whole prefix.
+	if a, err := netip.ParseAddr(v); err == nil {
+		if pfx, err := ParsePrefix(want); err == nil && op == "==" {
+			return pfx.Contains(a)
+		}
+	}
+	if x, err := strconv.ParseFloat(normalizeNumber(v), 64); err == nil {
+		if y, err := strconv.ParseFloat(normalizeNumber(want), 64); err == nil {
+			switch op {
+			case "==":
+				return x == y
+			case "<":
+				return x < y
+			case "<=":
+				return x <= y
+			case ">":
+				return x > y
+			case ">=":
+				return x >= y
+			}
+		}
+	}
+	switch op {
+	case "==":
+		return strings.EqualFold(v, want)
+	case "<":
+		return v < want
+	case "<=":
+		return v <= want
+	case ">":
+		return v > want
+	case ">=":
+		return v >= want
+	}
+	return false
+}
+
+// normalizeNumber lets hex literals like 0x0800 compare numerically.
+func normalizeNumber(s string) string {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		if n, err := strconv.ParseUint(s[2:], 16, 64); err == nil {
+			return strconv.FormatUint(n, 10)
+		}
+	}
+	return s
+}
+
+func CompileFilter(expr string) (*DisplayFilter, error) {
+	toks, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	f := &DisplayFilter{src: strings.TrimSpace(expr)}
+	if len(toks) == 0 {
+		return f, nil
+	}
+	ps := &filterParser{toks: toks}
+	f.root, err = ps.or()
+	if err != nil {
+		return nil, err
+	}
+	if ps.pos < len(toks) {
+		return nil, fmt.Errorf("filter: unexpected %q", toks[ps.pos].text)
+	}
+	return f, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func lexFilter(s string) ([]filterToken, error) {
+	var toks []filterToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, filterToken{text: string(c)})
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="),
+			strings.HasPrefix(s[i:], "<="), strings.HasPrefix(s[i:], ">="):
+			toks = append(toks, filterToken{text: s[i : i+2]})
+			i += 2
+		case c == '!' || c == '<' || c == '>':
+			toks = append(toks, filterToken{text: string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("filter: unterminated string")
+			}
+			toks = append(toks, filterToken{text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t()!=<>&|\"", rune(s[j])) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("filter: unexpected %q", s[i:i+1])
+			}
+			toks = append(toks, filterToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type filterParser struct {
+	toks []filterToken
+	pos  int
+}
+
+func (ps *filterParser) peek() string {
+	if ps.pos < len(ps.toks) && !ps.toks[ps.pos].quoted {
+		return strings.ToLower(ps.toks[ps.pos].text)
+	}
+	return ""
+}
+
+func (ps *filterParser) or() (filterNode, error) {
+	l, err := ps.and()
+	if err != nil {
+		return nil, err
+	}
+	for t := ps.peek(); t == "or" || t == "||"; t = ps.peek() {
+		ps.pos++
+		r, err := ps.and()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (ps *filterParser) and() (filterNode, error) {
+	l, err := ps.not()
+	if err != nil {
+		return nil, err
+	}
+	for t := ps.peek(); t == "and" || t == "&&"; t = ps.peek() {
+		ps.pos++
+		r, err := ps.not()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (ps *filterParser) not() (filterNode, error) {
+	if t := ps.peek(); t == "not" || t == "!" {
+		ps.pos++
+		x, err := ps.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	}
+	return ps.primary()
+}
+
+var filterOps = map[string]string{
+	"==": "==", "eq": "==", "!=": "!=", "ne": "!=",
+	"<": "<", "lt": "<", "<=": "<=", "le": "<=",
+	">": ">", "gt": ">", ">=": ">=", "ge": ">=",
+	"contains": "contains",
+}
+
+func (ps *filterParser) primary() (filterNode, error) {
+	if ps.pos >= len(ps.toks) {
+		return nil, fmt.Errorf("filter: expression ends early")
+	}
+	if ps.peek() == "(" {
+		ps.pos++
+		x, err := ps.or()
+		if err != nil {
+			return nil, err
+		}
+		if ps.peek() != ")" {
+			return nil, fmt.Errorf("filter: missing )")
+		}
+		ps.pos++
+		return x, nil
+	}
+	name := strings.ToLower(ps.toks[ps.pos].text)
+	ps.pos++
+	op, ok := filterOps[ps.peek()]
+	if !ok {
+		return existsNode{name}, nil
+	}
+	ps.pos++
+	if ps.pos >= len(ps.toks) {
+		return nil, fmt.Errorf("filter: %s %s needs a value", name, op)
+	}
+	v := ps.toks[ps.pos].text
+	ps.pos++
+	return compareNode{field: name, op: op, value: v}, nil
+}
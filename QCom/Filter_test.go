@@ -0,0 +1,77 @@
+package QCom
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, c := range []struct{ expr, err string }{
+		{"(tcp", "missing )"},
+		{"tcp)", `unexpected ")"`},
+		{"tcp &&", "ends early"},
+		{"not", "ends early"},
+		{"tcp.port ==", "needs a value"},
+		{`http contains "GET`, "unterminated string"},
+		{"tcp & udp", `unexpected "&"`},
+		{"tcp udp", `unexpected "udp"`},
+	} {
+		f, err := CompileFilter(c.expr)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("CompileFilter(%q) = %v, %v; want error containing %q", c.expr, f, err, c.err)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	web := decode(LinkTypeEthernet, etherFrame(0x0800, ipPacket("10.1.2.3", "192.0.2.80", 6, tcpSegment(40000, 443, 1, 0, TCPSyn, ""))))
+	dns := decode(LinkTypeRaw, ipPacket("2001:db8::1", "2001:db8::53", 17, udpDatagram(5000, 53,
+		[]byte{0, 1, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 7, 'E', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 28, 0, 1})))
+
+	for _, c := range []struct {
+		expr     string
+		web, dns bool
+	}{
+		{"", true, true},
+		{"tcp", true, false},
+		{"dns", false, true},
+		{"ip.src", true, false},
+		{"tcp.port == 443", true, false},
+		{"tcp.port == 40000", true, false},
+		{"tcp.port != 443", false, false},
+		{"tcp.port != 80", true, false},
+		{"udp.port eq 53", false, true},
+		{"ip.src == 10.0.0.0/8", true, false},
+		{"ip.addr == 192.0.2.80", true, false},
+		{"ipv6.addr == 2001:db8::/32", false, true},
+		{"ip.src == 10.0.0.0/8 && (tcp.port == 443 || dns)", true, false},
+		{"tcp or udp", true, true},
+		{"!tcp", false, true},
+		{"not (tcp and ip.ttl > 64)", true, true},
+		{"ip.ttl >= 64 and ip.ttl <= 64", true, false},
+		{"ip.ttl lt 64", false, false},
+		{"eth.type == 0x800", true, false},
+		{"tcp.flags == 0x02", true, false},
+		{`dns.qry.name contains "EXAMPLE"`, false, true},
+		{"dns.qry.name == example.com", false, true},
+		{"dns.qry.type == AAAA", false, true},
+		{"TCP.DSTPORT == 443", true, false},
+	} {
+		f, err := CompileFilter(c.expr)
+		if err != nil {
+			t.Errorf("CompileFilter(%q): %v", c.expr, err)
+			continue
+		}
+		if got := f.Match(web); got != c.web {
+			t.Errorf("%q on the TCP packet = %v", c.expr, got)
+		}
+		if got := f.Match(dns); got != c.dns {
+			t.Errorf("%q on the DNS packet = %v", c.expr, got)
+		}
+	}
+
+	var none *DisplayFilter
+	if !none.Match(web) {
+		t.Error("nil filter rejected a packet")
+	}
+}
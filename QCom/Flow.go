@@ -0,0 +1,307 @@
+package QCom
+
+import (
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MaxFlowPayload bounds the reassembled bytes kept per flow so a long
+// capture can't exhaust memory; counters keep going past it.
+const MaxFlowPayload = 8 << 20
+
+// maxFlowPending bounds out-of-order segments buffered per direction.
+const maxFlowPending = 256
+
+// FlowDir selects one or both directions of a conversation.
+type FlowDir int
+
+const (
+	FlowBoth FlowDir = iota
+	FlowAtoB
+	FlowBtoA
+)
+
+type flowChunk struct {
+	dir  FlowDir
+	data []byte
+}
+
+type tcpHalf struct {
+	started bool
+	next    uint32
+	pending map[uint32][]byte
+}
+
+// Flow is one TCP or UDP conversation. A is the side that sent the SYN, or
+// the first sender seen when the capture started mid-stream.
+type Flow struct {
+	Proto   string
+	A, B    netip.AddrPort
+	Start   time.Time
+	End     time.Time
+	Packets int
+	Bytes   int
+	PktsAB  int
+	PktsBA  int
+	BytesAB int
+	BytesBA int
+	Retrans int
+	Resets  int
+	Closed  bool
+	// RTT is SYN to SYN/ACK as seen at the capture point, ServerRTT the
+	// SYN/ACK to the final handshake ACK; on a client capture RTT is the
+	// whole round trip, on a server capture ServerRTT is.
+	RTT       time.Duration
+	ServerRTT time.Duration
+	Truncated bool
+
+	synAt, synAckAt time.Time
+	half            [2]tcpHalf
+	chunks          []flowChunk
+	stored          int
+}
+
+func (f *Flow) Duration() time.Duration { return f.End.Sub(f.Start) }
+
+// Payload returns the reassembled application bytes for dir. FlowBoth
+// interleaves both directions in the order they were delivered.
+func (f *Flow) Payload(dir FlowDir) []byte {
+	var out []byte
+	for _, c := range f.chunks {
+		if dir == FlowBoth || c.dir == dir {
+			out = append(out, c.data...)
+		}
+	}
+	return out
+}
+
+func (f *Flow) deliver(dir FlowDir, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	if f.stored+len(b) > MaxFlowPayload {
+		f.Truncated = true
+		return
+	}
+	f.stored += len(b)
+	if n := len(f.chunks); n > 0 && f.chunks[n-1].dir == dir {
+		f.chunks[n-1].data = append(f.chunks[n-1].data, b...)
+		return
+	}
+	f.chunks = append(f.chunks, flowChunk{dir, append([]byte(nil), b...)})
+}
+
+// segment feeds one TCP segment into the reassembler for dir.
+func (f *Flow) segment(dir FlowDir, t *TCPInfo) {
+	h := &f.half[dir-1]
+	if t.Flags&TCPSyn != 0 {
+		h.started, h.next = true, t.Seq+1
+		return
+	}
+	if !h.started {
+		h.started, h.next = true, t.Seq
+	}
+	seq, data := t.Seq, t.Payload
+	if len(data) == 0 {
+		return
+	}
+	rel := int32(seq - h.next)
+	switch {
+	case rel < 0:
+		f.Retrans++
+		if int32(seq+uint32(len(data))-h.next) <= 0 {
+			return
+		}
+		data = data[-rel:]
+	case rel > 0:
+		if h.pending == nil {
+			h.pending = map[uint32][]byte{}
+		}
+		if _, dup := h.pending[seq]; dup {
+			f.Retrans++
+		} else if len(h.pending) < maxFlowPending {
+			h.pending[seq] = append([]byte(nil), data...)
+		}
+		return
+	}
+	f.deliver(dir, data)
+	h.next += uint32(len(data))
+
+	// Drain buffered segments that now line up, trimming any overlap.
+	for len(h.pending) > 0 {
+		progressed := false
+		for s, d := range h.pending {
+			r := int32(s - h.next)
+			if r > 0 {
+				continue
+			}
+			delete(h.pending, s)
+			progressed = true
+			if end := int32(s + uint32(len(d)) - h.next); end > 0 {
+				f.deliver(dir, d[-r:])
+				h.next += uint32(end)
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+}
+
+type flowKey struct {
+	proto uint8
+	a, b  netip.AddrPort
+}
+
+// FlowTable groups decoded packets into conversations.
+type FlowTable struct {
+	flows map[flowKey]*Flow
+	order []*Flow
+}
+
+func NewFlowTable() *FlowTable {
+	return &FlowTable{flows: map[flowKey]*Flow{}}
+}
+
+// Add accounts p to its conversation. Packets that are not TCP or UDP over
+// IP are ignored.
+func (ft *FlowTable) Add(p *Packet) {
+	if p.IP == nil || (p.TCP == nil && p.UDP == nil) {
+		return
+	}
+	var src, dst netip.AddrPort
+	proto := "UDP"
+	if p.TCP != nil {
+		proto = "TCP"
+		src = netip.AddrPortFrom(p.IP.Src, p.TCP.SrcPort)
+		dst = netip.AddrPortFrom(p.IP.Dst, p.TCP.DstPort)
+	} else {
+		src = netip.AddrPortFrom(p.IP.Src, p.UDP.SrcPort)
+		dst = netip.AddrPortFrom(p.IP.Dst, p.UDP.DstPort)
+	}
+
+	f, dir := ft.flows[flowKey{p.IP.Proto, src, dst}], FlowAtoB
+	if f == nil {
+		f, dir = ft.flows[flowKey{p.IP.Proto, dst, src}], FlowBtoA
+	}
+	// A SYN/ACK seen before its SYN still names the client correctly.
+	if f == nil {
+		a, b := src, dst
+		dir = FlowAtoB
+		if p.TCP != nil && p.TCP.Flags&(TCPSyn|TCPAck) == TCPSyn|TCPAck {
+			a, b, dir = dst, src, FlowBtoA
+		}
+		f = &Flow{Proto: proto, A: a, B: b, Start: p.Time}
+		ft.flows[flowKey{p.IP.Proto, a, b}] = f
+		ft.order = append(ft.order, f)
+	}
+
+	f.End = p.Time
+	f.Packets++
+	f.Bytes += p.Len
+	if dir == FlowAtoB {
+		f.PktsAB++
+		f.BytesAB += p.Len
+	} else {
+		f.PktsBA++
+		f.BytesBA += p.Len
+	}
+
+	if p.UDP != nil {
+		f.deliver(dir, p.UDP.Payload)
+		return
+	}
+	t := p.TCP
+	switch {
+	case t.Flags&(TCPSyn|TCPAck) == TCPSyn:
+		if f.synAt.IsZero() {
+			f.synAt = p.Time
+		} else {
+			f.Retrans++
+		}
+	case t.Flags&(TCPSyn|TCPAck) == TCPSyn|TCPAck:
+		if f.synAckAt.IsZero() {
+			f.synAckAt = p.Time
+			if !f.synAt.IsZero() {
+				f.RTT = p.Time.Sub(f.synAt)
+			}
+		} else {
+			f.Retrans++
+		}
+	case t.Flags&TCPAck != 0 && dir == FlowAtoB && !f.synAckAt.IsZero() && f.ServerRTT == 0:
+		f.ServerRTT = p.Time.Sub(f.synAckAt)
+	}
+	if t.Flags&TCPRst != 0 {
+		f.Resets++
+		f.Closed = true
+	}
+	if t.Flags&TCPFin != 0 {
+		f.Closed = true
+	}
+	f.segment(dir, t)
+}
+
+// Flows returns every conversation in the order it was first seen.
+func (ft *FlowTable) Flows() []*Flow {
+	return append([]*Flow(nil), ft.order...)
+}
+
+// Talker is an aggregate of traffic for one host or one port.
+type Talker struct {
+	Key     string
+	Packets int
+	Bytes   int
+	Flows   int
+}
+
+// TopHosts ranks addresses by bytes sent and received across all flows.
+func (ft *FlowTable) TopHosts(n int) []Talker {
+	return ft.top(n, func(f *Flow) []string {
+		return []string{f.A.Addr().String(), f.B.Addr().String()}
+	})
+}
+
+// TopPorts ranks server-side ports, e.g. "TCP/443", by bytes.
+func (ft *FlowTable) TopPorts(n int) []Talker {
+	return ft.top(n, func(f *Flow) []string {
+		return []string{f.Proto + "/" + strconv.Itoa(int(f.B.Port()))}
+	})
+}
+
+func (ft *FlowTable) top(n int, keys func(*Flow) []string) []Talker {
+	byKey := map[string]*Talker{}
+	for _, f := range ft.order {
+		seen := map[string]bool{}
+		for _, k := range keys(f) {
+			// A host talking to itself counts once per flow.
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			t := byKey[k]
+			if t == nil {
+				t = &Talker{Key: k}
+				byKey[k] = t
+			}
+			t.Packets += f.Packets
+			t.Bytes += f.Bytes
+			t.Flows++
+		}
+	}
+	out := make([]Talker, 0, len(byKey))
+	for _, t := range byKey {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Key < out[j].Key
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+package QCom
+
+import (
+	"testing"
+	"time"
+)
+
+// tcpConv feeds a TCP conversation between a client at 10.0.0.1:40000
+// and a server at 10.0.0.2:80 into a flow table.
+type tcpConv struct {
+	ft *FlowTable
+	t0 time.Time
+}
+
+func newTCPConv() *tcpConv {
+	return &tcpConv{ft: NewFlowTable(), t0: time.Unix(1700000000, 0)}
+}
+
+func (c *tcpConv) add(fromClient bool, ms int, seq uint32, flags uint8, payload string) {
+	src, dst, sport, dport := "10.0.0.1", "10.0.0.2", uint16(40000), uint16(80)
+	if !fromClient {
+		src, dst, sport, dport = dst, src, dport, sport
+	}
+	p := decode(LinkTypeRaw, ipPacket(src, dst, 6, tcpSegment(sport, dport, seq, 0, flags, payload)))
+	p.Time = c.t0.Add(time.Duration(ms) * time.Millisecond)
+	c.ft.Add(p)
+}
+
+func (c *tcpConv) client(seq uint32, payload string) { c.add(true, 100, seq, TCPAck|TCPPsh, payload) }
+
+func (c *tcpConv) flow(t *testing.T) *Flow {
+	t.Helper()
+	fs := c.ft.Flows()
+	if len(fs) != 1 {
+		t.Fatalf("%d flows, want 1", len(fs))
+	}
+	return fs[0]
+}
+
+func TestFlowHandshake(t *testing.T) {
+	c := newTCPConv()
+	c.add(true, 0, 100, TCPSyn, "")
+	c.add(false, 30, 500, TCPSyn|TCPAck, "")
+	c.add(true, 31, 101, TCPAck, "")
+	c.add(true, 40, 101, TCPAck|TCPPsh, "GET / ")
+	c.add(false, 70, 501, TCPAck|TCPPsh, "200 OK")
+	c.add(true, 80, 107, TCPAck|TCPPsh, "again")
+	c.add(true, 90, 112, TCPAck|TCPFin, "")
+
+	f := c.flow(t)
+	if f.A.String() != "10.0.0.1:40000" || f.B.String() != "10.0.0.2:80" || f.Proto != "TCP" {
+		t.Errorf("flow %s %s → %s", f.Proto, f.A, f.B)
+	}
+	if f.RTT != 30*time.Millisecond || f.ServerRTT != time.Millisecond {
+		t.Errorf("RTT %s, server RTT %s", f.RTT, f.ServerRTT)
+	}
+	if f.Packets != 7 || f.PktsAB != 5 || f.PktsBA != 2 || f.Retrans != 0 || !f.Closed || f.Duration() != 90*time.Millisecond {
+		t.Errorf("got %+v", f)
+	}
+	for _, c := range []struct {
+		dir  FlowDir
+		want string
+	}{
+		{FlowAtoB, "GET / again"},
+		{FlowBtoA, "200 OK"},
+		{FlowBoth, "GET / 200 OKagain"},
+	} {
+		if got := string(f.Payload(c.dir)); got != c.want {
+			t.Errorf("payload %d = %q, want %q", c.dir, got, c.want)
+		}
+	}
+}
+
+func TestFlowHandshakeOddities(t *testing.T) {
+	// A SYN/ACK seen first still makes its receiver the client.
+	c := newTCPConv()
+	c.add(false, 0, 500, TCPSyn|TCPAck, "")
+	c.add(true, 5, 101, TCPAck|TCPPsh, "hi")
+	f := c.flow(t)
+	if f.A.String() != "10.0.0.1:40000" || f.RTT != 0 || f.ServerRTT != 5*time.Millisecond {
+		t.Errorf("SYN/ACK first: A %s, RTT %s, server RTT %s", f.A, f.RTT, f.ServerRTT)
+	}
+	if got := string(f.Payload(FlowAtoB)); got != "hi" {
+		t.Errorf("payload %q", got)
+	}
+
+	// Retransmitted SYNs and SYN/ACKs count, and RTT runs from the first.
+	c = newTCPConv()
+	c.add(true, 0, 100, TCPSyn, "")
+	c.add(true, 1000, 100, TCPSyn, "")
+	c.add(false, 1020, 500, TCPSyn|TCPAck, "")
+	c.add(false, 1040, 500, TCPSyn|TCPAck, "")
+	if f := c.flow(t); f.Retrans != 2 || f.RTT != 1020*time.Millisecond {
+		t.Errorf("retransmitted handshake: %d retransmissions, RTT %s", f.Retrans, f.RTT)
+	}
+
+	// A capture started mid-stream takes the first sender as A and its
+	// first sequence number as the start.
+	c = newTCPConv()
+	c.add(false, 0, 9000, TCPAck|TCPPsh, "tail ")
+	c.add(false, 1, 9005, TCPAck|TCPPsh, "end")
+	f = c.flow(t)
+	if f.A.String() != "10.0.0.2:80" || string(f.Payload(FlowAtoB)) != "tail end" {
+		t.Errorf("mid-stream: A %s, payload %q", f.A, f.Payload(FlowAtoB))
+	}
+
+	c = newTCPConv()
+	c.add(true, 0, 100, TCPSyn, "")
+	c.add(false, 1, 500, TCPRst|TCPAck, "")
+	if f := c.flow(t); f.Resets != 1 || !f.Closed {
+		t.Errorf("reset: %d resets, closed %v", f.Resets, f.Closed)
+	}
+}
+
+func TestFlowReassembly(t *testing.T) {
+	type seg struct {
+		seq  uint32
+		data string
+	}
+	for _, c := range []struct {
+		name    string
+		segs    []seg
+		want    string
+		retrans int
+	}{
+		{"in order", []seg{{101, "abc"}, {104, "def"}}, "abcdef", 0},
+		{"out of order", []seg{{101, "abc"}, {107, "ghi"}, {104, "def"}}, "abcdefghi", 0},
+		{"reversed", []seg{{107, "ghi"}, {104, "def"}, {101, "abc"}}, "abcdefghi", 0},
+		{"retransmitted", []seg{{101, "abc"}, {101, "abc"}, {104, "def"}}, "abcdef", 1},
+		{"overlap", []seg{{101, "abcdef"}, {104, "defghi"}}, "abcdefghi", 1},
+		{"retransmitted while buffered", []seg{{107, "ghi"}, {107, "ghi"}, {101, "abcdef"}}, "abcdefghi", 1},
+		{"buffered overlap", []seg{{105, "efgh"}, {107, "ghi"}, {101, "abcd"}}, "abcdefghi", 0},
+		{"buffered and stale", []seg{{104, "de"}, {101, "abcdef"}, {107, "g"}}, "abcdefg", 0},
+		{"gap never filled", []seg{{101, "abc"}, {110, "xyz"}}, "abc", 0},
+	} {
+		conv := newTCPConv()
+		conv.add(true, 0, 100, TCPSyn, "")
+		for _, s := range c.segs {
+			conv.client(s.seq, s.data)
+		}
+		f := conv.flow(t)
+		if got := string(f.Payload(FlowAtoB)); got != c.want || f.Retrans != c.retrans {
+			t.Errorf("%s: payload %q with %d retransmissions, want %q and %d", c.name, got, f.Retrans, c.want, c.retrans)
+		}
+		if len(f.Payload(FlowBtoA)) != 0 {
+			t.Errorf("%s: data in the other direction", c.name)
+		}
+	}
+
+	// Sequence numbers wrap.
+	conv := newTCPConv()
+	conv.add(true, 0, 0xfffffffd, TCPSyn, "")
+	conv.client(0, "cd")
+	conv.client(0xfffffffe, "ab")
+	conv.client(0xfffffffe, "abc")
+	if f := conv.flow(t); string(f.Payload(FlowAtoB)) != "abcd" || f.Retrans != 1 {
+		t.Errorf("across the wrap: %q, %d retransmissions", f.Payload(FlowAtoB), f.Retrans)
+	}
+}
+
+func TestFlowUDP(t *testing.T) {
+	ft := NewFlowTable()
+	for _, d := range []struct {
+		src, dst     string
+		sport, dport uint16
+		data         string
+	}{
+		{"10.0.0.1", "10.0.0.53", 5000, 53, "query"},
+		{"10.0.0.53", "10.0.0.1", 53, 5000, "answer"},
+		{"10.0.0.1", "10.0.0.53", 5001, 53, "other"},
+	} {
+		ft.Add(decode(LinkTypeRaw, ipPacket(d.src, d.dst, 17, udpDatagram(d.sport, d.dport, []byte(d.data)))))
+	}
+	// Neither TCP nor UDP, so not a flow.
+	ft.Add(decode(LinkTypeRaw, ipPacket("10.0.0.1", "10.0.0.2", 1, []byte{8, 0, 0, 0})))
+
+	fs := ft.Flows()
+	if len(fs) != 2 {
+		t.Fatalf("%d flows, want 2", len(fs))
+	}
+	f := fs[0]
+	if f.Proto != "UDP" || f.PktsAB != 1 || f.PktsBA != 1 || string(f.Payload(FlowBoth)) != "queryanswer" {
+		t.Errorf("got %s %d/%d packets, payload %q", f.Proto, f.PktsAB, f.PktsBA, f.Payload(FlowBoth))
+	}
+	if ports := ft.TopPorts(0); len(ports) != 1 || ports[0].Key != "UDP/53" || ports[0].Flows != 2 {
+		t.Errorf("top ports %+v", ports)
+	}
+}
@@ -0,0 +1,341 @@
+package QCom
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxProbeBody caps how much of a response body is read for timing.
+const maxProbeBody = 10 << 20
+
+type HTTPProbeOptions struct {
+	Method       string
+	Header       http.Header
+	Timeout      time.Duration
+	MaxRedirects int
+	// Insecure keeps going when the certificate doesn't verify; the chain
+	// is reported either way.
+	Insecure bool
+	// ExpiryWarn flags certificates that expire within this window.
+	ExpiryWarn time.Duration
+	// RootCAs replaces the system roots, mostly for private CAs.
+	RootCAs *x509.CertPool
+}
+
+func DefaultHTTPProbeOptions() HTTPProbeOptions {
+	return HTTPProbeOptions{
+		Method:       http.MethodGet,
+		Timeout:      15 * time.Second,
+		MaxRedirects: 10,
+		ExpiryWarn:   30 * 24 * time.Hour,
+	}
+}
+
+// HTTPTiming splits a request the way curl's -w timings do, but as
+// durations of each phase rather than offsets from the start.
+type HTTPTiming struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration // request written to first response byte
+	Total   time.Duration
+}
+
+type CertInfo struct {
+	Subject   string
+	Issuer    string
+	SANs      []string
+	NotBefore time.Time
+	NotAfter  time.Time
+	KeyType   string
+	SigAlg    string
+	Serial    string
+	IsCA      bool
+}
+
+type TLSInfo struct {
+	Version    string
+	Cipher     string
+	ServerName string
+	ALPN       string
+	Chain      []CertInfo
+	// VerifyErr is empty when the chain verified against the roots.
+	VerifyErr string
+}
+
+// HTTPHop is one request in a redirect chain.
+type HTTPHop struct {
+	URL        string
+	RemoteAddr string
+	Proto      string
+	Status     string
+	StatusCode int
+	Header     http.Header
+	BodySize   int64
+	Timing     HTTPTiming
+	TLS        *TLSInfo
+}
+
+type HTTPProbeResult struct {
+	Hops     []HTTPHop
+	Warnings []string
+}
+
+// Final is the last hop, the one that wasn't a redirect.
+func (r *HTTPProbeResult) Final() *HTTPHop {
+	if r == nil || len(r.Hops) == 0 {
+		return nil
+	}
+	return &r.Hops[len(r.Hops)-1]
+}
+
+// HTTPProbe requests rawURL, following redirects itself so every hop gets
+// its own fresh connection and timing. Hops gathered before an error are
+// returned along with it.
+func HTTPProbe(ctx context.Context, rawURL string, opts HTTPProbeOptions) (*HTTPProbeResult, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+
+	res := &HTTPProbeResult{}
+	method := opts.Method
+	for {
+		hop, next, err := probeOnce(ctx, method, u, opts)
+		if hop != nil {
+			res.Hops = append(res.Hops, *hop)
+			res.Warnings = append(res.Warnings, certWarnings(hop, opts.ExpiryWarn, time.Now())...)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logger().Warn("http probe failed", "url", u.String(), "method", method, "err", err)
+			}
+			return res, err
+		}
+		if next == nil {
+			return res, nil
+		}
+		if len(res.Hops) > opts.MaxRedirects {
+			logger().Warn("http probe gave up on redirects", "url", rawURL, "redirects", opts.MaxRedirects)
+			return res, fmt.Errorf("stopped after %d redirects", opts.MaxRedirects)
+		}
+		if u.Scheme == "https" && next.Scheme == "http" {
+			res.Warnings = append(res.Warnings, "redirect downgrades to plain HTTP: "+next.String())
+		}
+		// Like browsers, only 307 and 308 keep the original method.
+		if c := hop.StatusCode; c != http.StatusTemporaryRedirect && c != http.StatusPermanentRedirect {
+			method = http.MethodGet
+		}
+		u = next
+	}
+}
+
+func probeOnce(ctx context.Context, method string, u *url.URL, opts HTTPProbeOptions) (*HTTPHop, *url.URL, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	hop := &HTTPHop{URL: u.String()}
+
+	var (
+		start                         = time.Now()
+		dnsStart, connStart, tlsStart time.Time
+		wroteAt                       time.Time
+	)
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !dnsStart.IsZero() {
+				hop.Timing.DNS = time.Since(dnsStart)
+			}
+		},
+		ConnectStart: func(_, _ string) { connStart = time.Now() },
+		ConnectDone: func(_, addr string, err error) {
+			if err == nil {
+				hop.Timing.Connect = time.Since(connStart)
+				hop.RemoteAddr = addr
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			hop.Timing.TLS = time.Since(tlsStart)
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wroteAt = time.Now() },
+		GotFirstResponseByte: func() { hop.Timing.TTFB = time.Since(wroteAt) },
+	}
+
+	tlsConf := &tls.Config{
+		ServerName: u.Hostname(),
+		// Verification happens in VerifyConnection so the chain can be
+		// recorded even when it doesn't verify.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			hop.TLS = describeTLS(cs, u.Hostname(), opts.RootCAs)
+			if hop.TLS.VerifyErr != "" && !opts.Insecure {
+				return errors.New(hop.TLS.VerifyErr)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   tlsConf,
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: true,
+		DialContext:       (&net.Dialer{}).DialContext,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range opts.Header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "Qube-probe/1")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		hop.Timing.Total = time.Since(start)
+		return hop, nil, err
+	}
+	defer resp.Body.Close()
+	hop.BodySize, err = io.Copy(io.Discard, io.LimitReader(resp.Body, maxProbeBody))
+	hop.Timing.Total = time.Since(start)
+	hop.Proto, hop.Status, hop.StatusCode, hop.Header = resp.Proto, resp.Status, resp.StatusCode, resp.Header
+	if err != nil {
+		return hop, nil, err
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		if loc := resp.Header.Get("Location"); loc != "" {
+			next, err := u.Parse(loc)
+			if err != nil {
+				return hop, nil, fmt.Errorf("bad Location %q: %w", loc, err)
+			}
+			return hop, next, nil
+		}
+	}
+	return hop, nil, nil
+}
+
+// describeTLS records the negotiated session and checks the chain against
+// host; cs.ServerName is empty for IP literals, which send no SNI.
+func describeTLS(cs tls.ConnectionState, host string, roots *x509.CertPool) *TLSInfo {
+	info := &TLSInfo{
+		Version:    tls.VersionName(cs.Version),
+		Cipher:     tls.CipherSuiteName(cs.CipherSuite),
+		ServerName: host,
+		ALPN:       cs.NegotiatedProtocol,
+	}
+	for _, c := range cs.PeerCertificates {
+		info.Chain = append(info.Chain, describeCert(c))
+	}
+	if len(cs.PeerCertificates) == 0 {
+		info.VerifyErr = "server sent no certificate"
+		return info
+	}
+	inter := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: inter,
+	})
+	if err != nil {
+		info.VerifyErr = err.Error()
+	}
+	return info
+}
+
+func describeCert(c *x509.Certificate) CertInfo {
+	ci := CertInfo{
+		Subject:   c.Subject.String(),
+		Issuer:    c.Issuer.String(),
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+		SigAlg:    c.SignatureAlgorithm.String(),
+		Serial:    c.SerialNumber.Text(16),
+		IsCA:      c.IsCA,
+	}
+	ci.SANs = append(ci.SANs, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		ci.SANs = append(ci.SANs, ip.String())
+	}
+	ci.SANs = append(ci.SANs, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		ci.SANs = append(ci.SANs, u.String())
+	}
+	switch k := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		ci.KeyType = fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		ci.KeyType = "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		ci.KeyType = "Ed25519"
+	default:
+		ci.KeyType = c.PublicKeyAlgorithm.String()
+	}
+	return ci
+}
+
+func certWarnings(hop *HTTPHop, window time.Duration, now time.Time) []string {
+	if hop.TLS == nil {
+		return nil
+	}
+	var w []string
+	if hop.TLS.VerifyErr != "" {
+		w = append(w, hop.URL+": certificate not trusted: "+hop.TLS.VerifyErr)
+	}
+	for _, c := range hop.TLS.Chain {
+		left := c.NotAfter.Sub(now)
+		switch {
+		case left < 0:
+			w = append(w, fmt.Sprintf("%s: %s expired %s ago", hop.URL, c.Subject, roundDays(-left)))
+		case left < window:
+			w = append(w, fmt.Sprintf("%s: %s expires in %s", hop.URL, c.Subject, roundDays(left)))
+		case now.Before(c.NotBefore):
+			w = append(w, fmt.Sprintf("%s: %s not valid until %s", hop.URL, c.Subject, c.NotBefore.Format(time.DateOnly)))
+		}
+	}
+	return w
+}
+
+func roundDays(d time.Duration) string {
+	if d < 48*time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
@@ -0,0 +1,220 @@
+package QCom
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, url string, opts HTTPProbeOptions) (*HTTPProbeResult, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return HTTPProbe(ctx, url, opts)
+}
+
+func trusting(srv *httptest.Server) HTTPProbeOptions {
+	opts := DefaultHTTPProbeOptions()
+	opts.RootCAs = x509.NewCertPool()
+	opts.RootCAs.AddCert(srv.Certificate())
+	return opts
+}
+
+func TestHTTPProbeTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	res, err := probe(t, srv.URL, trusting(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hops) != 1 || len(res.Warnings) != 0 {
+		t.Fatalf("got %d hops and warnings %q", len(res.Hops), res.Warnings)
+	}
+	h := res.Final()
+	if h.StatusCode != 200 || h.BodySize != 5 || h.RemoteAddr != srv.Listener.Addr().String() {
+		t.Errorf("got %s, %d bytes from %s", h.Status, h.BodySize, h.RemoteAddr)
+	}
+	if h.TLS == nil || h.TLS.VerifyErr != "" || len(h.TLS.Chain) == 0 || h.TLS.Version == "" {
+		t.Fatalf("TLS info %+v", h.TLS)
+	}
+	tm := h.Timing
+	if tm.Connect <= 0 || tm.TLS <= 0 || tm.TTFB <= 0 || tm.Total < tm.Connect+tm.TLS {
+		t.Errorf("timings don't add up: %+v", tm)
+	}
+	// The address is dialled directly, so there is no lookup to time.
+	if tm.DNS != 0 {
+		t.Errorf("DNS time %s for an IP literal", tm.DNS)
+	}
+}
+
+func TestHTTPProbeInsecure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// The test CA isn't in the system roots.
+	res, err := probe(t, srv.URL, DefaultHTTPProbeOptions())
+	if err == nil {
+		t.Fatal("untrusted certificate accepted")
+	}
+	if h := res.Final(); h == nil || h.TLS == nil || h.TLS.VerifyErr == "" || len(h.TLS.Chain) == 0 {
+		t.Fatalf("chain not recorded for the failed hop: %+v", h)
+	}
+
+	opts := DefaultHTTPProbeOptions()
+	opts.Insecure = true
+	res, err = probe(t, srv.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Final().StatusCode != 200 {
+		t.Errorf("status %s", res.Final().Status)
+	}
+	if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "certificate not trusted") {
+		t.Errorf("warnings %q", res.Warnings)
+	}
+}
+
+func TestHTTPProbeRedirects(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+
+	var mu sync.Mutex
+	var seen []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/keep":
+			http.Redirect(w, r, "/found", http.StatusTemporaryRedirect)
+		case "/found":
+			http.Redirect(w, r, "/done", http.StatusFound)
+		case "/done":
+			http.Redirect(w, r, plain.URL+"/end", http.StatusMovedPermanently)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	opts := trusting(srv)
+	opts.Method = http.MethodPost
+	res, err := probe(t, srv.URL+"/keep", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 307 keeps POST; 302 turns it into GET.
+	want := []string{"POST /keep", "POST /found", "GET /done"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("server saw %q, want %q", seen, want)
+	}
+	var codes []int
+	for _, h := range res.Hops {
+		codes = append(codes, h.StatusCode)
+	}
+	if len(codes) != 4 || codes[0] != 307 || codes[1] != 302 || codes[2] != 301 || codes[3] != 200 {
+		t.Errorf("status chain %v", codes)
+	}
+	if res.Final().URL != plain.URL+"/end" || res.Final().TLS != nil {
+		t.Errorf("final hop %s, TLS %v", res.Final().URL, res.Final().TLS)
+	}
+	if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "downgrades to plain HTTP") {
+		t.Errorf("warnings %q", res.Warnings)
+	}
+
+	opts = trusting(srv)
+	opts.MaxRedirects = 3
+	res, err = probe(t, srv.URL+"/loop", opts)
+	if err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Errorf("redirect loop: %v", err)
+	}
+	if len(res.Hops) != 4 {
+		t.Errorf("%d hops before giving up, want 4", len(res.Hops))
+	}
+}
+
+// shortLivedServer serves with a self-signed certificate for 127.0.0.1
+// that is valid from notBefore to notAfter.
+func shortLivedServer(t *testing.T, notBefore, notAfter time.Time) *httptest.Server {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "short.test"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPProbeExpiryWarnings(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		name              string
+		notBefore, expiry time.Time
+		want              string
+	}{
+		{"expiring", now.Add(-time.Hour), now.Add(5 * 24 * time.Hour), "CN=short.test expires in 4 days"},
+		{"expired", now.Add(-10 * 24 * time.Hour), now.Add(-3 * 24 * time.Hour), "CN=short.test expired 3 days ago"},
+		{"not yet valid", now.Add(24 * time.Hour), now.Add(90 * 24 * time.Hour), "CN=short.test not valid until"},
+	} {
+		srv := shortLivedServer(t, c.notBefore, c.expiry)
+		opts := DefaultHTTPProbeOptions()
+		opts.Insecure = true
+		res, err := probe(t, srv.URL, opts)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		found := false
+		for _, w := range res.Warnings {
+			found = found || strings.Contains(w, c.want)
+		}
+		if !found {
+			t.Errorf("%s: warnings %q, want one containing %q", c.name, res.Warnings, c.want)
+		}
+	}
+
+	// A window of zero turns the "expires in" warning off.
+	srv := shortLivedServer(t, now.Add(-time.Hour), now.Add(5*24*time.Hour))
+	opts := DefaultHTTPProbeOptions()
+	opts.Insecure, opts.ExpiryWarn = true, 0
+	res, err := probe(t, srv.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range res.Warnings {
+		if strings.Contains(w, "expires in") {
+			t.Errorf("unexpected warning %q", w)
+		}
+	}
+}
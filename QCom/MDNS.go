@@ -0,0 +1,435 @@
+package QCom
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	mdnsGroup4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsGroup6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// ServicesEnumName is the DNS-SD meta-query that lists every service type
+// on the link.
+const ServicesEnumName = "_services._dns-sd._udp.local."
+
+// MDNSMessage is a multicast DNS packet and where it came from.
+type MDNSMessage struct {
+	From netip.Addr
+	Msg  *DNSMessage
+}
+
+// MDNSConn is a pair of sockets joined to the IPv4 and, where available,
+// IPv6 mDNS groups.
+type MDNSConn struct {
+	iface string
+	v4    *net.UDPConn
+	v6    *net.UDPConn
+}
+
+// ListenMDNS joins the mDNS groups on iface, or the default interface when
+// it is empty. IPv6 is best effort; only an IPv4 failure is an error.
+func ListenMDNS(iface string) (*MDNSConn, error) {
+	var ifi *net.Interface
+	if iface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(iface); err != nil {
+			logger().Warn("mdns interface not found", "iface", iface, "err", err)
+			return nil, err
+		}
+	}
+	c := &MDNSConn{iface: iface}
+	var err error
+	if c.v4, err = net.ListenMulticastUDP("udp4", ifi, mdnsGroup4); err != nil {
+		logger().Error("joining mdns group", "iface", iface, "group", mdnsGroup4, "err", err)
+		return nil, err
+	}
+	if c.v6, err = net.ListenMulticastUDP("udp6", ifi, mdnsGroup6); err != nil {
+		logger().Debug("mdns over IPv6 unavailable", "iface", iface, "err", err)
+		c.v6 = nil
+	}
+	return c, nil
+}
+
+func (c *MDNSConn) Close() {
+	c.v4.Close()
+	if c.v6 != nil {
+		c.v6.Close()
+	}
+}
+
+// Query multicasts one message asking all of qs.
+func (c *MDNSConn) Query(qs ...DNSQuestion) error {
+	if len(qs) == 0 {
+		return nil
+	}
+	b, err := (&DNSMessage{Questions: qs}).Pack()
+	if err != nil {
+		return err
+	}
+	if _, err := c.v4.WriteToUDP(b, mdnsGroup4); err != nil {
+		logger().Warn("sending mdns query", "iface", c.iface, "err", err)
+		return err
+	}
+	// Link-local multicast needs a zone to leave the host.
+	if c.v6 != nil && c.iface != "" {
+		g := *mdnsGroup6
+		g.Zone = c.iface
+		c.v6.WriteToUDP(b, &g)
+	}
+	return nil
+}
+
+// Serve passes every parsable message heard to fn until ctx is done. fn
+// may be called from two goroutines at once when IPv6 is up.
+func (c *MDNSConn) Serve(ctx context.Context, fn func(MDNSMessage)) error {
+	conns := []*net.UDPConn{c.v4}
+	if c.v6 != nil {
+		conns = append(conns, c.v6)
+	}
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+	errc := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn *net.UDPConn) {
+			buf := make([]byte, 9000)
+			for {
+				n, from, err := conn.ReadFromUDPAddrPort(buf)
+				if err != nil {
+					if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+						err = nil
+					}
+					errc <- err
+					return
+				}
+				msg, err := ParseDNSMessage(buf[:n])
+				if err != nil {
+					logger().Debug("skipping unparsable mdns packet", "from", from, "err", err)
+					continue
+				}
+				fn(MDNSMessage{From: from.Addr().Unmap(), Msg: msg})
+			}
+		}(conn)
+	}
+	var first error
+	for range conns {
+		if err := <-errc; err != nil && first == nil {
+			logger().Error("mdns read", "iface", c.iface, "err", err)
+			first = err
+			c.Close()
+		}
+	}
+	return first
+}
+
+// MDNSListen joins the mDNS groups on iface, sends each of queries as a
+// PTR question and passes every message heard to fn until ctx is done.
+// Queries are repeated every resend if it is non-zero, since responders
+// may miss the first one.
+func MDNSListen(ctx context.Context, iface string, queries []string, resend time.Duration, fn func(MDNSMessage)) error {
+	conn, err := ListenMDNS(iface)
+	if err != nil {
+		return err
+	}
+	var qs []DNSQuestion
+	for _, name := range queries {
+		qs = append(qs, DNSQuestion{Name: FQDN(name), Type: TypePTR, Class: ClassIN})
+	}
+	if err := conn.Query(qs...); err != nil {
+		conn.Close()
+		return err
+	}
+	if resend > 0 && len(qs) > 0 {
+		go func() {
+			t := time.NewTicker(resend)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					conn.Query(qs...)
+				}
+			}
+		}()
+	}
+	return conn.Serve(ctx, fn)
+}
+
+// ServiceInstance is one advertised DNS-SD service, e.g.
+// "Office Printer._ipp._tcp.local.".
+type ServiceInstance struct {
+	Name     string // full instance name
+	Instance string // the human-readable first label
+	Type     string // e.g. _ipp._tcp
+	Host     string
+	Port     uint16
+	Text     []string
+	Addrs    []netip.Addr
+	Updated  time.Time
+}
+
+// MDNSBrowser tracks DNS-SD state from mDNS messages. It does no I/O:
+// Handle returns the follow-up questions the caller should send, so the
+// whole browse logic can be driven with canned messages.
+type MDNSBrowser struct {
+	mu        sync.Mutex
+	types     map[string]bool
+	instances map[string]*ServiceInstance
+	hosts     map[string][]netip.Addr
+}
+
+func NewMDNSBrowser() *MDNSBrowser {
+	return &MDNSBrowser{
+		types:     map[string]bool{},
+		instances: map[string]*ServiceInstance{},
+		hosts:     map[string][]netip.Addr{},
+	}
+}
+
+// BrowseQuestion starts a browse by asking for every service type.
+func BrowseQuestion() DNSQuestion {
+	return DNSQuestion{Name: ServicesEnumName, Type: TypePTR, Class: ClassIN}
+}
+
+// isServiceType reports whether name looks like _service._tcp.local.
+func isServiceType(name string) bool {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".")
+	n := len(labels)
+	return n >= 3 && strings.HasPrefix(labels[n-3], "_") &&
+		(labels[n-2] == "_tcp" || labels[n-2] == "_udp")
+}
+
+// splitInstance breaks "My Printer._ipp._tcp.local." into its instance
+// label and service type. Instance labels may contain dots.
+func splitInstance(name string) (instance, typ string, ok bool) {
+	lower := strings.ToLower(name)
+	for _, proto := range []string{"._tcp.", "._udp."} {
+		i := strings.LastIndex(lower, proto)
+		if i < 0 {
+			continue
+		}
+		j := strings.LastIndex(lower[:i], "._")
+		if j < 0 {
+			continue
+		}
+		return name[:j], name[j+1 : i+len(proto)-1], true
+	}
+	return "", "", false
+}
+
+// Handle folds msg into the browser's state. It returns the questions
+// that would fill in what is still missing and whether anything visible
+// changed.
+func (b *MDNSBrowser) Handle(msg *DNSMessage, now time.Time) ([]DNSQuestion, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var follow []DNSQuestion
+	changed := false
+	ask := func(name string, t uint16) {
+		follow = append(follow, DNSQuestion{Name: name, Type: t, Class: ClassIN})
+	}
+	instance := func(name string) *ServiceInstance {
+		key := strings.ToLower(name)
+		si := b.instances[key]
+		if si == nil {
+			label, typ, _ := splitInstance(name)
+			si = &ServiceInstance{Name: name, Instance: label, Type: strings.ToLower(typ)}
+			b.instances[key] = si
+			changed = true
+		}
+		si.Updated = now
+		return si
+	}
+
+	records := make([]DNSRecord, 0, len(msg.Answers)+len(msg.Additional))
+	records = append(records, msg.Answers...)
+	records = append(records, msg.Additional...)
+
+	var unresolved []*ServiceInstance
+
+	// Addresses first so SRV targets in the same packet resolve at once.
+	for _, r := range records {
+		if r.Type != TypeA && r.Type != TypeAAAA {
+			continue
+		}
+		key := strings.ToLower(r.Name)
+		if r.TTL == 0 {
+			if addrs, ok := removeAddr(b.hosts[key], r.Addr); ok {
+				if len(addrs) == 0 {
+					delete(b.hosts, key)
+				} else {
+					b.hosts[key] = addrs
+				}
+				changed = true
+			}
+			continue
+		}
+		if !containsAddr(b.hosts[key], r.Addr) {
+			b.hosts[key] = append(b.hosts[key], r.Addr)
+			changed = true
+		}
+	}
+
+	for _, r := range records {
+		switch r.Type {
+		case TypePTR:
+			switch {
+			case strings.EqualFold(r.Name, ServicesEnumName):
+				t := strings.ToLower(r.Target)
+				if !b.types[t] {
+					b.types[t] = true
+					ask(r.Target, TypePTR)
+				}
+			case isServiceType(r.Name):
+				// A zero TTL is a goodbye: the service is going away.
+				if r.TTL == 0 {
+					if _, ok := b.instances[strings.ToLower(r.Target)]; ok {
+						delete(b.instances, strings.ToLower(r.Target))
+						changed = true
+					}
+					continue
+				}
+				b.types[strings.ToLower(r.Name)] = true
+				unresolved = append(unresolved, instance(r.Target))
+			}
+		case TypeSRV:
+			if _, _, ok := splitInstance(r.Name); !ok {
+				continue
+			}
+			// Without its SRV record an instance can't be reached.
+			if r.TTL == 0 {
+				if _, ok := b.instances[strings.ToLower(r.Name)]; ok {
+					delete(b.instances, strings.ToLower(r.Name))
+					changed = true
+				}
+				continue
+			}
+			si := instance(r.Name)
+			if si.Host != r.Target || si.Port != r.Port {
+				si.Host, si.Port = r.Target, r.Port
+				changed = true
+			}
+			if len(b.hosts[strings.ToLower(r.Target)]) == 0 {
+				ask(r.Target, TypeA)
+				ask(r.Target, TypeAAAA)
+			}
+		case TypeTXT:
+			if _, _, ok := splitInstance(r.Name); !ok {
+				continue
+			}
+			// A TXT goodbye clears the text but must not bring back an
+			// instance the same packet just said goodbye to.
+			if r.TTL == 0 {
+				if si := b.instances[strings.ToLower(r.Name)]; si != nil && si.Text != nil {
+					si.Text = nil
+					changed = true
+				}
+				continue
+			}
+			si := instance(r.Name)
+			if strings.Join(si.Text, "\x00") != strings.Join(r.Text, "\x00") {
+				si.Text = r.Text
+				changed = true
+			}
+		}
+	}
+	// Only ask for what the packet's additional section didn't carry.
+	for _, si := range unresolved {
+		if si.Host == "" {
+			ask(si.Name, TypeSRV)
+			ask(si.Name, TypeTXT)
+		}
+	}
+	return follow, changed
+}
+
+func containsAddr(list []netip.Addr, a netip.Addr) bool {
+	for _, x := range list {
+		if x == a {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAddr returns list without a and whether a was in it.
+func removeAddr(list []netip.Addr, a netip.Addr) ([]netip.Addr, bool) {
+	for i, x := range list {
+		if x == a {
+			return append(list[:i:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
+
+// Services returns every known instance with its host's addresses filled
+// in, ordered by type and then name.
+func (b *MDNSBrowser) Services() []ServiceInstance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]ServiceInstance, 0, len(b.instances))
+	for _, si := range b.instances {
+		s := *si
+		s.Addrs = append([]netip.Addr(nil), b.hosts[strings.ToLower(si.Host)]...)
+		sort.Slice(s.Addrs, func(i, j int) bool { return s.Addrs[i].Less(s.Addrs[j]) })
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Type != out[j].Type {
+			return out[i].Type < out[j].Type
+		}
+		return strings.ToLower(out[i].Instance) < strings.ToLower(out[j].Instance)
+	})
+	return out
+}
+
+// MDNSBrowse runs a browser on iface until ctx is done, re-asking for
+// service types every refresh and calling onChange whenever the set of
+// services changes.
+func MDNSBrowse(ctx context.Context, iface string, b *MDNSBrowser, refresh time.Duration, onChange func()) error {
+	conn, err := ListenMDNS(iface)
+	if err != nil {
+		return err
+	}
+	if err := conn.Query(BrowseQuestion()); err != nil {
+		conn.Close()
+		return err
+	}
+	if refresh > 0 {
+		go func() {
+			t := time.NewTicker(refresh)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					conn.Query(BrowseQuestion())
+				}
+			}
+		}()
+	}
+	return conn.Serve(ctx, func(m MDNSMessage) {
+		// Other hosts' queries carry no answers worth reading.
+		if !m.Msg.Header.QR {
+			return
+		}
+		follow, changed := b.Handle(m.Msg, time.Now())
+		if len(follow) > 0 {
+			conn.Query(follow...)
+		}
+		if changed && onChange != nil {
+			onChange()
+		}
+	})
+}
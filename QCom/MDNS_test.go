@@ -0,0 +1,194 @@
+package QCom
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mdnsAnswer builds a response as a responder would send it, with the
+// given records in the answer and additional sections.
+func mdnsAnswer(answers []DNSRecord, additional ...DNSRecord) *DNSMessage {
+	return &DNSMessage{Header: DNSHeader{QR: true, AA: true}, Answers: answers, Additional: additional}
+}
+
+func ptr(name, target string, ttl uint32) DNSRecord {
+	return DNSRecord{Name: name, Type: TypePTR, Class: ClassIN, TTL: ttl, Target: target}
+}
+
+func srv(name, host string, port uint16, ttl uint32) DNSRecord {
+	return DNSRecord{Name: name, Type: TypeSRV, Class: ClassIN, TTL: ttl, Target: host, Port: port}
+}
+
+func txt(name string, ttl uint32, text ...string) DNSRecord {
+	return DNSRecord{Name: name, Type: TypeTXT, Class: ClassIN, TTL: ttl, Text: text}
+}
+
+func addr(name, ip string, ttl uint32) DNSRecord {
+	a := netip.MustParseAddr(ip)
+	t := TypeA
+	if a.Is6() {
+		t = TypeAAAA
+	}
+	return DNSRecord{Name: name, Type: t, Class: ClassIN, TTL: ttl, Addr: a}
+}
+
+func questions(qs []DNSQuestion) []string {
+	var out []string
+	for _, q := range qs {
+		out = append(out, DNSTypeName(q.Type)+" "+q.Name)
+	}
+	return out
+}
+
+const printer = "Office Printer 2.1._ipp._tcp.local."
+
+func TestMDNSBrowserFollowUps(t *testing.T) {
+	b := NewMDNSBrowser()
+	now := time.Now()
+
+	// The type enumeration asks for the type's instances, once.
+	follow, changed := b.Handle(mdnsAnswer([]DNSRecord{ptr(ServicesEnumName, "_ipp._tcp.local.", 4500)}), now)
+	if want := []string{"PTR _ipp._tcp.local."}; !reflect.DeepEqual(questions(follow), want) || changed {
+		t.Fatalf("after enumeration asked %q (changed %v), want %q", questions(follow), changed, want)
+	}
+	if follow, _ = b.Handle(mdnsAnswer([]DNSRecord{ptr(ServicesEnumName, "_IPP._tcp.local.", 4500)}), now); len(follow) != 0 {
+		t.Errorf("asked again for a known type: %q", questions(follow))
+	}
+
+	// A bare PTR asks for the instance's SRV and TXT.
+	follow, changed = b.Handle(mdnsAnswer([]DNSRecord{ptr("_ipp._tcp.local.", printer, 4500)}), now)
+	want := []string{"SRV " + printer, "TXT " + printer}
+	if !reflect.DeepEqual(questions(follow), want) || !changed {
+		t.Fatalf("after PTR asked %q (changed %v), want %q", questions(follow), changed, want)
+	}
+
+	// The SRV names a host nobody has given an address for yet.
+	follow, _ = b.Handle(mdnsAnswer([]DNSRecord{srv(printer, "prn.local.", 631, 120)}, txt(printer, 4500, "rp=ipp/print")), now)
+	want = []string{"A prn.local.", "AAAA prn.local."}
+	if !reflect.DeepEqual(questions(follow), want) {
+		t.Fatalf("after SRV asked %q, want %q", questions(follow), want)
+	}
+
+	if _, changed = b.Handle(mdnsAnswer([]DNSRecord{addr("prn.local.", "192.0.2.9", 120)}), now); !changed {
+		t.Error("address not taken")
+	}
+	got := b.Services()
+	if len(got) != 1 {
+		t.Fatalf("got %d services", len(got))
+	}
+	s := got[0]
+	if s.Name != printer || s.Instance != "Office Printer 2.1" || s.Type != "_ipp._tcp" ||
+		s.Host != "prn.local." || s.Port != 631 || !reflect.DeepEqual(s.Text, []string{"rp=ipp/print"}) ||
+		len(s.Addrs) != 1 || s.Addrs[0].String() != "192.0.2.9" || !s.Updated.Equal(now) {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestMDNSBrowserOnePacket(t *testing.T) {
+	// Responders usually send everything at once; nothing is left to ask.
+	b := NewMDNSBrowser()
+	follow, changed := b.Handle(mdnsAnswer(
+		[]DNSRecord{ptr("_http._tcp.local.", "nas._http._tcp.local.", 4500)},
+		srv("nas._http._tcp.local.", "nas.local.", 80, 120),
+		txt("nas._http._tcp.local.", 4500, "path=/"),
+		addr("nas.local.", "192.0.2.20", 120),
+		addr("nas.local.", "fe80::20", 120),
+	), time.Now())
+	if len(follow) != 0 || !changed {
+		t.Errorf("asked %q (changed %v)", questions(follow), changed)
+	}
+	s := b.Services()
+	if len(s) != 1 || len(s[0].Addrs) != 2 || s[0].Addrs[0].String() != "192.0.2.20" {
+		t.Fatalf("got %+v", s)
+	}
+
+	// The same packet again changes nothing.
+	if _, changed = b.Handle(mdnsAnswer(
+		[]DNSRecord{ptr("_http._tcp.local.", "nas._http._tcp.local.", 4500)},
+		srv("nas._http._tcp.local.", "nas.local.", 80, 120),
+		addr("nas.local.", "192.0.2.20", 120),
+	), time.Now()); changed {
+		t.Error("repeat reported as a change")
+	}
+}
+
+func TestMDNSSplitInstance(t *testing.T) {
+	for _, c := range []struct {
+		name, instance, typ string
+		ok                  bool
+	}{
+		{"My Printer._ipp._tcp.local.", "My Printer", "_ipp._tcp", true},
+		{"v1.2.3 build._http._tcp.local.", "v1.2.3 build", "_http._tcp", true},
+		{"a._b._c._sip._udp.local.", "a._b._c", "_sip._udp", true},
+		{"_ipp._tcp.local.", "", "", false},
+		{"host.local.", "", "", false},
+	} {
+		instance, typ, ok := splitInstance(c.name)
+		if instance != c.instance || typ != c.typ || ok != c.ok {
+			t.Errorf("splitInstance(%q) = %q, %q, %v", c.name, instance, typ, ok)
+		}
+	}
+}
+
+func TestMDNSBrowserGoodbyes(t *testing.T) {
+	nas := "nas._http._tcp.local."
+	setup := func() *MDNSBrowser {
+		b := NewMDNSBrowser()
+		b.Handle(mdnsAnswer(
+			[]DNSRecord{ptr("_http._tcp.local.", nas, 4500), ptr("_ipp._tcp.local.", printer, 4500)},
+			srv(nas, "nas.local.", 80, 120),
+			txt(nas, 4500, "path=/"),
+			srv(printer, "prn.local.", 631, 120),
+			addr("nas.local.", "192.0.2.20", 120),
+			addr("nas.local.", "192.0.2.21", 120),
+		), time.Now())
+		return b
+	}
+	names := func(b *MDNSBrowser) []string {
+		var out []string
+		for _, s := range b.Services() {
+			out = append(out, s.Name)
+		}
+		return out
+	}
+
+	for _, c := range []struct {
+		name string
+		msg  *DNSMessage
+		want []string
+	}{
+		{"PTR", mdnsAnswer([]DNSRecord{ptr("_http._tcp.local.", nas, 0)}), []string{printer}},
+		{"SRV", mdnsAnswer([]DNSRecord{srv(nas, "nas.local.", 80, 0)}), []string{printer}},
+		// A full goodbye must not bring the instance back through its
+		// TXT or SRV after the PTR removed it.
+		{"everything", mdnsAnswer([]DNSRecord{ptr("_http._tcp.local.", nas, 0), txt(nas, 0), srv(nas, "nas.local.", 80, 0)}), []string{printer}},
+		{"unknown instance", mdnsAnswer([]DNSRecord{srv("gone._http._tcp.local.", "x.local.", 80, 0)}), []string{nas, printer}},
+	} {
+		b := setup()
+		b.Handle(c.msg, time.Now())
+		if got := names(b); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s goodbye: left %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	// A TXT goodbye only clears the text.
+	b := setup()
+	if _, changed := b.Handle(mdnsAnswer([]DNSRecord{txt(nas, 0)}), time.Now()); !changed {
+		t.Error("TXT goodbye not reported")
+	}
+	if s := b.Services()[0]; s.Name != nas || s.Text != nil || s.Port != 80 {
+		t.Errorf("after TXT goodbye got %+v", s)
+	}
+
+	// An address goodbye takes only that address.
+	b = setup()
+	b.Handle(mdnsAnswer([]DNSRecord{addr("nas.local.", "192.0.2.20", 0)}), time.Now())
+	if s := b.Services()[0]; len(s.Addrs) != 1 || s.Addrs[0].String() != "192.0.2.21" {
+		t.Errorf("after A goodbye addresses %v", s.Addrs)
+	}
+	if _, changed := b.Handle(mdnsAnswer([]DNSRecord{addr("nas.local.", "192.0.2.99", 0)}), time.Now()); changed {
+		t.Error("goodbye for an unknown address reported as a change")
+	}
+}
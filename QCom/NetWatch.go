@@ -0,0 +1,114 @@
+package QCom
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of NetEvent.
+const (
+	EventLinkUp       = "link-up"
+	EventLinkDown     = "link-down"
+	EventLinkAdded    = "link-added"
+	EventLinkRemoved  = "link-removed"
+	EventAddrAdded    = "addr-added"
+	EventAddrRemoved  = "addr-removed"
+	EventRouteAdded   = "route-added"
+	EventRouteRemoved = "route-removed"
+	EventDefaultRoute = "default-route"
+)
+
+// NetEvent is one change the kernel announced on the routing socket.
+type NetEvent struct {
+	Time      time.Time    `json:"time"`
+	Kind      string       `json:"kind"`
+	Interface string       `json:"interface"`
+	Addr      netip.Prefix `json:"addr"`
+	Route     *Route       `json:"route,omitempty"`
+	Detail    string       `json:"detail"`
+}
+
+type Route struct {
+	Dst       netip.Prefix `json:"dst"`
+	Gateway   netip.Addr   `json:"gateway"`
+	Src       netip.Addr   `json:"src"`
+	Interface string       `json:"interface"`
+	Metric    int          `json:"metric"`
+	Table     int          `json:"table"`
+	Protocol  string       `json:"protocol"`
+}
+
+func (r Route) IsDefault() bool { return r.Dst.IsValid() && r.Dst.Bits() == 0 }
+
+// String reads like a line of `ip route`.
+func (r Route) String() string {
+	var sb strings.Builder
+	if r.IsDefault() {
+		sb.WriteString("default")
+	} else {
+		sb.WriteString(r.Dst.String())
+	}
+	if r.Gateway.IsValid() {
+		sb.WriteString(" via " + r.Gateway.String())
+	}
+	if r.Interface != "" {
+		sb.WriteString(" dev " + r.Interface)
+	}
+	if r.Protocol != "" {
+		sb.WriteString(" proto " + r.Protocol)
+	}
+	if r.Src.IsValid() {
+		sb.WriteString(" src " + r.Src.String())
+	}
+	if r.Metric != 0 {
+		fmt.Fprintf(&sb, " metric %d", r.Metric)
+	}
+	return sb.String()
+}
+
+// LinkState is an interface as the interface view shows it.
+type LinkState struct {
+	Index int
+	Name  string
+	// Up is the administrative state; Running means the link has carrier.
+	Up      bool
+	Running bool
+	MTU     int
+	MAC     string
+	Addrs   []netip.Prefix
+}
+
+// Links lists every interface with its addresses, ordered by index.
+func Links() ([]LinkState, error) {
+	list, err := net.Interfaces()
+	if err != nil {
+		logger().Error("listing interfaces", "err", err)
+		return nil, err
+	}
+	out := make([]LinkState, 0, len(list))
+	for _, ifi := range list {
+		l := LinkState{
+			Index:   ifi.Index,
+			Name:    ifi.Name,
+			Up:      ifi.Flags&net.FlagUp != 0,
+			Running: ifi.Flags&net.FlagRunning != 0,
+			MTU:     ifi.MTU,
+			MAC:     ifi.HardwareAddr.String(),
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok {
+				ip, _ := netip.AddrFromSlice(ipn.IP)
+				ones, _ := ipn.Mask.Size()
+				l.Addrs = append(l.Addrs, netip.PrefixFrom(ip.Unmap(), ones))
+			}
+		}
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Index < out[j].Index })
+	return out, nil
+}
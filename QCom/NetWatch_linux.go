@@ -0,0 +1,336 @@
+package QCom
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rtnetlink multicast groups, from linux/rtnetlink.h; syscall lacks them.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+var routeProtocols = map[uint8]string{
+	syscall.RTPROT_KERNEL: "kernel",
+	syscall.RTPROT_BOOT:   "boot",
+	syscall.RTPROT_STATIC: "static",
+	syscall.RTPROT_DHCP:   "dhcp",
+	syscall.RTPROT_RA:     "ra",
+}
+
+// linkNames remembers index → name so events for a link that has just
+// gone away can still be labelled.
+type linkNames map[int]string
+
+func (n linkNames) name(index int) string {
+	if s, ok := n[index]; ok {
+		return s
+	}
+	if ifi, err := net.InterfaceByIndex(index); err == nil {
+		n[index] = ifi.Name
+		return ifi.Name
+	}
+	return fmt.Sprintf("if%d", index)
+}
+
+func attrAddr(b []byte) netip.Addr {
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// parseRoute decodes an RTM_NEWROUTE/RTM_DELROUTE body. It skips the
+// kernel's local table and anything that isn't plain unicast, which
+// otherwise floods in with every address change.
+func parseRoute(m *syscall.NetlinkMessage, names linkNames) (Route, bool) {
+	if len(m.Data) < syscall.SizeofRtMsg {
+		return Route{}, false
+	}
+	family, dstLen, table := m.Data[0], int(m.Data[1]), int(m.Data[4])
+	if m.Data[7] != syscall.RTN_UNICAST {
+		return Route{}, false
+	}
+	r := Route{Protocol: routeProtocols[m.Data[5]]}
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return Route{}, false
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case syscall.RTA_DST:
+			r.Dst = netip.PrefixFrom(attrAddr(a.Value), dstLen)
+		case syscall.RTA_GATEWAY:
+			r.Gateway = attrAddr(a.Value)
+		case syscall.RTA_PREFSRC:
+			r.Src = attrAddr(a.Value)
+		case syscall.RTA_OIF:
+			if len(a.Value) >= 4 {
+				r.Interface = names.name(int(binary.NativeEndian.Uint32(a.Value)))
+			}
+		case syscall.RTA_PRIORITY:
+			if len(a.Value) >= 4 {
+				r.Metric = int(binary.NativeEndian.Uint32(a.Value))
+			}
+		case syscall.RTA_TABLE:
+			if len(a.Value) >= 4 {
+				table = int(binary.NativeEndian.Uint32(a.Value))
+			}
+		}
+	}
+	if table == syscall.RT_TABLE_LOCAL {
+		return Route{}, false
+	}
+	r.Table = table
+	if !r.Dst.IsValid() {
+		// No RTA_DST means the zero prefix: a default route.
+		zero := netip.IPv4Unspecified()
+		if family == syscall.AF_INET6 {
+			zero = netip.IPv6Unspecified()
+		}
+		r.Dst = netip.PrefixFrom(zero, 0)
+	}
+	return r, true
+}
+
+// Routes dumps the IPv4 and IPv6 routing tables, leaving out the local
+// table. Default routes sort first.
+func Routes() ([]Route, error) {
+	b, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, syscall.AF_UNSPEC)
+	if err != nil {
+		logger().Error("dumping routes", "err", err)
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		logger().Error("parsing route dump", "err", err)
+		return nil, err
+	}
+	names := linkNames{}
+	var out []Route
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWROUTE {
+			continue
+		}
+		if r, ok := parseRoute(&msgs[i], names); ok {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Dst.Addr().Is4() != b.Dst.Addr().Is4() {
+			return a.Dst.Addr().Is4()
+		}
+		if a.Dst.Bits() != b.Dst.Bits() {
+			return a.Dst.Bits() < b.Dst.Bits()
+		}
+		if a.Dst.Addr() != b.Dst.Addr() {
+			return a.Dst.Addr().Less(b.Dst.Addr())
+		}
+		return a.Metric < b.Metric
+	})
+	return out, nil
+}
+
+// netState is what the watcher has already reported, so repeated
+// notifications (flag churn, IPv6 DAD finishing) don't become events.
+type netState struct {
+	names linkNames
+	oper  map[int]bool
+	addrs map[string]bool
+}
+
+func operUp(flags uint32) bool {
+	return flags&syscall.IFF_UP != 0 && flags&syscall.IFF_RUNNING != 0
+}
+
+func newNetState() *netState {
+	s := &netState{names: linkNames{}, oper: map[int]bool{}, addrs: map[string]bool{}}
+	links, _ := Links()
+	for _, l := range links {
+		s.names[l.Index] = l.Name
+		s.oper[l.Index] = l.Up && l.Running
+		for _, a := range l.Addrs {
+			s.addrs[fmt.Sprint(l.Index, a)] = true
+		}
+	}
+	return s
+}
+
+// events turns one rtnetlink message into the events it represents.
+func (s *netState) events(m *syscall.NetlinkMessage, now time.Time) []NetEvent {
+	switch m.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		if len(m.Data) < syscall.SizeofIfInfomsg {
+			return nil
+		}
+		index := int(binary.NativeEndian.Uint32(m.Data[4:8]))
+		flags := binary.NativeEndian.Uint32(m.Data[8:12])
+		if attrs, err := syscall.ParseNetlinkRouteAttr(m); err == nil {
+			for _, a := range attrs {
+				if a.Attr.Type == syscall.IFLA_IFNAME && len(a.Value) > 0 {
+					// The name is NUL-terminated.
+					s.names[index] = string(a.Value[:len(a.Value)-1])
+				}
+			}
+		}
+		name := s.names.name(index)
+		ev := NetEvent{Time: now, Interface: name}
+
+		if m.Header.Type == syscall.RTM_DELLINK {
+			delete(s.oper, index)
+			ev.Kind, ev.Detail = EventLinkRemoved, name+" was removed"
+			return []NetEvent{ev}
+		}
+		var out []NetEvent
+		was, known := s.oper[index]
+		up := operUp(flags)
+		s.oper[index] = up
+		if !known {
+			ev.Kind, ev.Detail = EventLinkAdded, name+" appeared"
+			out = append(out, ev)
+		}
+		switch {
+		case up && (!known || !was):
+			ev.Kind, ev.Detail = EventLinkUp, name+" is up"
+			out = append(out, ev)
+		case !up && known && was:
+			ev.Kind, ev.Detail = EventLinkDown, name+" is down"
+			if flags&syscall.IFF_UP != 0 {
+				ev.Detail += " (no carrier)"
+			}
+			out = append(out, ev)
+		}
+		return out
+
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return nil
+		}
+		bits := int(m.Data[1])
+		index := int(binary.NativeEndian.Uint32(m.Data[4:8]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return nil
+		}
+		var addr, local netip.Addr
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				addr = attrAddr(a.Value)
+			case syscall.IFA_LOCAL:
+				local = attrAddr(a.Value)
+			}
+		}
+		// On point-to-point links IFA_ADDRESS is the peer.
+		if local.IsValid() {
+			addr = local
+		}
+		if !addr.IsValid() {
+			return nil
+		}
+		p := netip.PrefixFrom(addr, bits)
+		key := fmt.Sprint(index, p)
+		name := s.names.name(index)
+		ev := NetEvent{Time: now, Interface: name, Addr: p}
+		if m.Header.Type == syscall.RTM_DELADDR {
+			if !s.addrs[key] {
+				return nil
+			}
+			delete(s.addrs, key)
+			ev.Kind, ev.Detail = EventAddrRemoved, fmt.Sprintf("%s removed from %s", p, name)
+			return []NetEvent{ev}
+		}
+		if s.addrs[key] {
+			return nil
+		}
+		s.addrs[key] = true
+		ev.Kind, ev.Detail = EventAddrAdded, fmt.Sprintf("%s added on %s", p, name)
+		return []NetEvent{ev}
+
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		r, ok := parseRoute(m, s.names)
+		if !ok {
+			return nil
+		}
+		added := m.Header.Type == syscall.RTM_NEWROUTE
+		ev := NetEvent{Time: now, Interface: r.Interface, Route: &r}
+		switch {
+		case r.IsDefault() && r.Table == syscall.RT_TABLE_MAIN:
+			via := strings.TrimPrefix(r.String(), "default ")
+			ev.Kind, ev.Detail = EventDefaultRoute, "new default route "+via
+			if !added {
+				ev.Detail = "default route " + via + " removed"
+			}
+		case added:
+			ev.Kind, ev.Detail = EventRouteAdded, "route added: "+r.String()
+		default:
+			ev.Kind, ev.Detail = EventRouteRemoved, "route removed: "+r.String()
+		}
+		return []NetEvent{ev}
+	}
+	return nil
+}
+
+// WatchNetwork subscribes to rtnetlink link, address and route changes
+// and calls fn for each until ctx is done.
+func WatchNetwork(ctx context.Context, fn func(NetEvent)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		logger().Error("opening netlink socket", "err", err)
+		return err
+	}
+	defer syscall.Close(fd)
+	groups := uint32(rtmgrpLink | rtmgrpIPv4Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Ifaddr | rtmgrpIPv6Route)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		logger().Error("subscribing to netlink groups", "err", err)
+		return err
+	}
+	// A VPN coming up can announce hundreds of routes at once.
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1<<20)
+	tv := syscall.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+
+	// Snapshot after subscribing so nothing falls between the two.
+	state := newNetState()
+	buf := make([]byte, 1<<16)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			if errors.Is(err, syscall.ENOBUFS) {
+				// We fell behind and lost messages; start over from
+				// the current state rather than report stale diffs.
+				logger().Warn("netlink overrun, resyncing")
+				state = newNetState()
+				continue
+			}
+			logger().Error("netlink read", "err", err)
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			logger().Debug("skipping unparsable netlink message", "len", n, "err", err)
+			continue
+		}
+		now := time.Now()
+		for i := range msgs {
+			for _, ev := range state.events(&msgs[i], now) {
+				fn(ev)
+			}
+		}
+	}
+	return nil
+}
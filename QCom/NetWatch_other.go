@@ -0,0 +1,18 @@
+//go:build !linux
+
+package QCom
+
+import (
+	"context"
+	"errors"
+)
+
+var errNetWatchUnsupported = errors.New("network event monitoring is only supported on Linux")
+
+func Routes() ([]Route, error) {
+	return nil, errNetWatchUnsupported
+}
+
+func WatchNetwork(ctx context.Context, fn func(NetEvent)) error {
+	return errNetWatchUnsupported
+}
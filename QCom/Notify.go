@@ -0,0 +1,65 @@
+package QCom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// NotifyCommand runs a shell command for a transition. The details are
+// passed in QUBE_* environment variables and as JSON on stdin.
+func NotifyCommand(ctx context.Context, command string, t Transition) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	body, _ := json.Marshal(t)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"QUBE_CHECK="+t.Check,
+		"QUBE_KIND="+t.Kind,
+		"QUBE_TARGET="+t.Target,
+		"QUBE_FROM="+t.From,
+		"QUBE_STATE="+t.To,
+		"QUBE_REASON="+t.Reason,
+		"QUBE_TIME="+t.Time.Format(time.RFC3339),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("notify command: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// NotifyWebhook POSTs the transition as JSON to url.
+func NotifyWebhook(ctx context.Context, url string, t Transition) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package QCom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Link types from the tcpdump.org registry that the decoder understands.
+const (
+	LinkTypeNull     uint32 = 0
+	LinkTypeEthernet uint32 = 1
+	LinkTypeRaw      uint32 = 101
+	LinkTypeLinuxSLL uint32 = 113
+)
+
+// maxCaptureRecord guards against corrupt length fields in capture files.
+const maxCaptureRecord = 256 * 1024
+
+type CapturedPacket struct {
+	Time      time.Time
+	CapLen    int
+	OrigLen   int
+	LinkType  uint32
+	Interface int
+	Data      []byte
+}
+
+// CaptureReader reads packets from a classic pcap or a pcapng stream.
+type CaptureReader struct {
+	r     *bufio.Reader
+	ng    bool
+	order binary.ByteOrder
+
+	// classic pcap
+	linkType uint32
+	nanos    bool
+
+	// pcapng
+	ifaces []ngInterface
+}
+
+type ngInterface struct {
+	linkType uint32
+	tsPerSec uint64 // timestamp ticks per second, from if_tsresol
+}
+
+var errNotCapture = errors.New("not a pcap or pcapng file")
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	c := &CaptureReader{r: bufio.NewReaderSize(r, 64*1024)}
+	magic, err := c.r.Peek(4)
+	if err != nil {
+		return nil, errNotCapture
+	}
+	switch {
+	case binary.BigEndian.Uint32(magic) == 0x0a0d0d0a:
+		c.ng = true
+		// The section header block sets the byte order for what follows.
+		if err := c.readSectionHeader(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	default:
+		return c, c.readPcapHeader()
+	}
+}
+
+func (c *CaptureReader) readPcapHeader() error {
+	var h [24]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return errNotCapture
+	}
+	switch m := binary.LittleEndian.Uint32(h[:]); m {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		c.order = binary.LittleEndian
+		c.nanos = m == 0xa1b23c4d
+	default:
+		switch binary.BigEndian.Uint32(h[:]) {
+		case 0xa1b2c3d4:
+			c.order = binary.BigEndian
+		case 0xa1b23c4d:
+			c.order, c.nanos = binary.BigEndian, true
+		default:
+			return errNotCapture
+		}
+	}
+	c.linkType = c.order.Uint32(h[20:]) & 0x0fffffff
+	return nil
+}
+
+// Next returns the next packet, or io.EOF at the end of the capture.
+func (c *CaptureReader) Next() (CapturedPacket, error) {
+	if c.ng {
+		return c.nextBlock()
+	}
+	var h [16]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return CapturedPacket{}, io.EOF
+		}
+		return CapturedPacket{}, err
+	}
+	sec, frac := c.order.Uint32(h[0:]), c.order.Uint32(h[4:])
+	capLen, origLen := c.order.Uint32(h[8:]), c.order.Uint32(h[12:])
+	if capLen > maxCaptureRecord {
+		return CapturedPacket{}, fmt.Errorf("pcap: record of %d bytes is too large", capLen)
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return CapturedPacket{}, io.EOF
+	}
+	nsec := int64(frac) * 1000
+	if c.nanos {
+		nsec = int64(frac)
+	}
+	return CapturedPacket{
+		Time:     time.Unix(int64(sec), nsec),
+		CapLen:   int(capLen),
+		OrigLen:  int(origLen),
+		LinkType: c.linkType,
+		Data:     data,
+	}, nil
+}
+
+func (c *CaptureReader) readBlock() (uint32, []byte, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, err
+	}
+	if binary.BigEndian.Uint32(h[:]) == 0x0a0d0d0a {
+		// A new section may switch byte order, so peek at its BOM first.
+		bom, err := c.r.Peek(4)
+		if err != nil {
+			return 0, nil, io.EOF
+		}
+		c.order = binary.LittleEndian
+		if binary.BigEndian.Uint32(bom) == 0x1a2b3c4d {
+			c.order = binary.BigEndian
+		}
+	}
+	typ, total := c.order.Uint32(h[0:]), c.order.Uint32(h[4:])
+	if total < 12 || total > maxCaptureRecord || total%4 != 0 {
+		return 0, nil, fmt.Errorf("pcapng: bad block length %d", total)
+	}
+	body := make([]byte, total-8)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, io.EOF
+	}
+	// Drop the trailing copy of the block length.
+	return typ, body[:len(body)-4], nil
+}
+
+func (c *CaptureReader) readSectionHeader() error {
+	typ, body, err := c.readBlock()
+	if err != nil || typ != 0x0a0d0d0a || len(body) < 16 {
+		return errNotCapture
+	}
+	c.ifaces = nil
+	return nil
+}
+
+func (c *CaptureReader) nextBlock() (CapturedPacket, error) {
+	for {
+		typ, body, err := c.readBlock()
+		if err != nil {
+			return CapturedPacket{}, err
+		}
+		switch typ {
+		case 0x0a0d0d0a:
+			c.ifaces = nil
+		case 1: // interface description
+			if len(body) < 8 {
+				return CapturedPacket{}, errors.New("pcapng: short interface block")
+			}
+			iface := ngInterface{linkType: uint32(c.order.Uint16(body)), tsPerSec: 1e6}
+			c.parseIfaceOptions(&iface, body[8:])
+			c.ifaces = append(c.ifaces, iface)
+		case 6: // enhanced packet
+			if len(body) < 20 {
+				return CapturedPacket{}, errors.New("pcapng: short packet block")
+			}
+			id := int(c.order.Uint32(body))
+			if id >= len(c.ifaces) {
+				return CapturedPacket{}, fmt.Errorf("pcapng: packet for unknown interface %d", id)
+			}
+			iface := c.ifaces[id]
+			ts := uint64(c.order.Uint32(body[4:]))<<32 | uint64(c.order.Uint32(body[8:]))
+			capLen := int(c.order.Uint32(body[12:]))
+			if 20+capLen > len(body) {
+				return CapturedPacket{}, errors.New("pcapng: packet overruns block")
+			}
+			return CapturedPacket{
+				Time:      ngTime(ts, iface.tsPerSec),
+				CapLen:    capLen,
+				OrigLen:   int(c.order.Uint32(body[16:])),
+				LinkType:  iface.linkType,
+				Interface: id,
+				Data:      body[20 : 20+capLen],
+			}, nil
+		case 3: // simple packet, always interface 0 and no timestamp
+			if len(body) < 4 || len(c.ifaces) == 0 {
+				continue
+			}
+			orig := int(c.order.Uint32(body))
+			data := body[4:]
+			if orig < len(data) {
+				data = data[:orig]
+			}
+			return CapturedPacket{CapLen: len(data), OrigLen: orig, LinkType: c.ifaces[0].linkType, Data: data}, nil
+		}
+	}
+}
+
+func (c *CaptureReader) parseIfaceOptions(iface *ngInterface, opts []byte) {
+	for len(opts) >= 4 {
+		code, l := c.order.Uint16(opts), int(c.order.Uint16(opts[2:]))
+		if code == 0 || 4+l > len(opts) {
+			return
+		}
+		if code == 9 && l >= 1 { // if_tsresol
+			v := opts[4]
+			if v&0x80 != 0 {
+				iface.tsPerSec = 1 << (v & 0x7f)
+			} else {
+				iface.tsPerSec = 1
+				for i := byte(0); i < v; i++ {
+					iface.tsPerSec *= 10
+				}
+			}
+		}
+		opts = opts[4+(l+3)&^3:]
+	}
+}
+
+func ngTime(ts, perSec uint64) time.Time {
+	if perSec == 0 {
+		perSec = 1e6
+	}
+	sec := ts / perSec
+	frac := ts % perSec
+	return time.Unix(int64(sec), int64(frac*1e9/perSec))
+}
+
+// ReadCaptureFile loads every packet in a pcap or pcapng file.
+func ReadCaptureFile(path string) ([]CapturedPacket, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := NewCaptureReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var out []CapturedPacket
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, p)
+	}
+}
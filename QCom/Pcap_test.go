@@ -0,0 +1,272 @@
+package QCom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// byteOrder can both read and append, as binary.LittleEndian and
+// binary.BigEndian do.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// pcapFile builds a classic pcap stream in the given byte order with one
+// record per packet, all stamped at ts.
+func pcapFile(order byteOrder, nanos bool, linkType uint32, ts time.Time, packets ...[]byte) []byte {
+	magic := uint32(0xa1b2c3d4)
+	frac := uint32(ts.Nanosecond() / 1000)
+	if nanos {
+		magic, frac = 0xa1b23c4d, uint32(ts.Nanosecond())
+	}
+	b := order.AppendUint32(nil, magic)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = order.AppendUint32(b, 65535)
+	b = order.AppendUint32(b, linkType)
+	for _, p := range packets {
+		b = order.AppendUint32(b, uint32(ts.Unix()))
+		b = order.AppendUint32(b, frac)
+		b = order.AppendUint32(b, uint32(len(p)))
+		b = order.AppendUint32(b, uint32(len(p)+4))
+		b = append(b, p...)
+	}
+	return b
+}
+
+// ngFile builds a pcapng stream block by block.
+type ngFile struct {
+	order byteOrder
+	b     []byte
+}
+
+func (f *ngFile) block(typ uint32, body []byte) *ngFile {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	total := uint32(12 + len(body))
+	f.b = f.order.AppendUint32(f.b, typ)
+	f.b = f.order.AppendUint32(f.b, total)
+	f.b = append(f.b, body...)
+	f.b = f.order.AppendUint32(f.b, total)
+	return f
+}
+
+func (f *ngFile) section() *ngFile {
+	b := f.order.AppendUint32(nil, 0x1a2b3c4d)
+	b = f.order.AppendUint16(b, 1)
+	b = f.order.AppendUint16(b, 0)
+	b = f.order.AppendUint64(b, ^uint64(0))
+	return f.block(0x0a0d0d0a, b)
+}
+
+// iface adds an interface; a tsresol of 0 leaves the option out.
+func (f *ngFile) iface(linkType uint16, tsresol byte) *ngFile {
+	b := f.order.AppendUint16(nil, linkType)
+	b = f.order.AppendUint16(b, 0)
+	b = f.order.AppendUint32(b, 65535)
+	if tsresol != 0 {
+		b = f.order.AppendUint16(b, 9)
+		b = f.order.AppendUint16(b, 1)
+		b = append(b, tsresol, 0, 0, 0)
+	}
+	return f.block(1, b)
+}
+
+func (f *ngFile) packet(id uint32, ts uint64, data []byte) *ngFile {
+	b := f.order.AppendUint32(nil, id)
+	b = f.order.AppendUint32(b, uint32(ts>>32))
+	b = f.order.AppendUint32(b, uint32(ts))
+	b = f.order.AppendUint32(b, uint32(len(data)))
+	b = f.order.AppendUint32(b, uint32(len(data)))
+	return f.block(6, append(b, data...))
+}
+
+func readCapture(b []byte) ([]CapturedPacket, error) {
+	r, err := NewCaptureReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var out []CapturedPacket
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, p)
+	}
+}
+
+func TestReadPcap(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+	for _, c := range []struct {
+		name  string
+		order byteOrder
+		nanos bool
+		want  time.Time
+	}{
+		{"little endian", binary.LittleEndian, false, ts.Truncate(time.Microsecond)},
+		{"big endian", binary.BigEndian, false, ts.Truncate(time.Microsecond)},
+		{"little endian ns", binary.LittleEndian, true, ts},
+		{"big endian ns", binary.BigEndian, true, ts},
+	} {
+		got, err := readCapture(pcapFile(c.order, c.nanos, LinkTypeRaw, ts, []byte{0x45, 1, 2}, []byte{0x60}))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(got) != 2 {
+			t.Errorf("%s: %d packets", c.name, len(got))
+			continue
+		}
+		p := got[0]
+		if !p.Time.Equal(c.want) || p.LinkType != LinkTypeRaw || p.CapLen != 3 || p.OrigLen != 7 || !bytes.Equal(p.Data, []byte{0x45, 1, 2}) {
+			t.Errorf("%s: got %+v", c.name, p)
+		}
+	}
+}
+
+func TestReadPcapCorrupt(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	good := pcapFile(binary.LittleEndian, false, LinkTypeEthernet, ts, make([]byte, 60), make([]byte, 60))
+	huge := pcapFile(binary.LittleEndian, false, LinkTypeEthernet, ts, make([]byte, 60))
+	binary.LittleEndian.PutUint32(huge[24+8:], maxCaptureRecord+1)
+
+	for _, c := range []struct {
+		name    string
+		b       []byte
+		packets int
+		err     string
+	}{
+		{"empty", nil, 0, errNotCapture.Error()},
+		{"short header", good[:20], 0, errNotCapture.Error()},
+		{"bad magic", append([]byte{1, 2, 3, 4}, good[4:]...), 0, errNotCapture.Error()},
+		// A capture cut off mid-record, as when tcpdump is killed, ends
+		// cleanly after the last whole packet.
+		{"cut record header", good[:24+16+60+8], 1, ""},
+		{"cut record data", good[:len(good)-1], 1, ""},
+		{"huge record", huge, 0, "too large"},
+	} {
+		got, err := readCapture(c.b)
+		if len(got) != c.packets {
+			t.Errorf("%s: %d packets, want %d", c.name, len(got), c.packets)
+		}
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestReadPcapng(t *testing.T) {
+	data := []byte{0x45, 0, 0, 20}
+	ts := time.Unix(1700000000, 123456789)
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		f := &ngFile{order: order}
+		f.section().
+			iface(uint16(LinkTypeEthernet), 0).
+			iface(uint16(LinkTypeRaw), 9).
+			packet(0, uint64(ts.UnixMicro()), data).
+			packet(1, uint64(ts.UnixNano()), data).
+			block(3, append(order.AppendUint32(nil, 2), data...)).
+			block(5, make([]byte, 8)) // statistics, skipped
+		got, err := readCapture(f.b)
+		if err != nil {
+			t.Fatalf("%s: %v", order, err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("%s: %d packets", order, len(got))
+		}
+		if p := got[0]; !p.Time.Equal(ts.Truncate(time.Microsecond)) || p.LinkType != LinkTypeEthernet || p.Interface != 0 || !bytes.Equal(p.Data, data) {
+			t.Errorf("%s: first packet %+v", order, p)
+		}
+		if p := got[1]; !p.Time.Equal(ts) || p.LinkType != LinkTypeRaw || p.Interface != 1 {
+			t.Errorf("%s: nanosecond packet %+v", order, p)
+		}
+		// A simple packet block holds no more than its original length.
+		if p := got[2]; p.LinkType != LinkTypeEthernet || p.OrigLen != 2 || !bytes.Equal(p.Data, data[:2]) {
+			t.Errorf("%s: simple packet %+v", order, p)
+		}
+	}
+
+	// A second section can switch byte order and starts with no interfaces.
+	le := &ngFile{order: binary.LittleEndian}
+	be := &ngFile{order: binary.BigEndian}
+	le.section().iface(uint16(LinkTypeEthernet), 0).packet(0, 0, data)
+	be.section().iface(uint16(LinkTypeRaw), 0).packet(0, 0, data)
+	got, err := readCapture(append(le.b, be.b...))
+	if err != nil || len(got) != 2 || got[0].LinkType != LinkTypeEthernet || got[1].LinkType != LinkTypeRaw {
+		t.Errorf("two sections: %+v, %v", got, err)
+	}
+}
+
+func TestPcapngRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := NewPcapngWriter(&buf, "eth0", LinkTypeEthernet, 65535)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := CapturedPacket{Time: time.Unix(1700000000, 987654321), OrigLen: 100, Data: []byte{1, 2, 3, 4, 5}}
+	if err := pw.WritePacket(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readCapture(buf.Bytes())
+	if err != nil || len(got) != 1 {
+		t.Fatalf("read back %d packets: %v", len(got), err)
+	}
+	if p := got[0]; !p.Time.Equal(in.Time) || p.OrigLen != 100 || p.LinkType != LinkTypeEthernet || !bytes.Equal(p.Data, in.Data) {
+		t.Errorf("read back %+v", p)
+	}
+}
+
+func TestReadPcapngCorrupt(t *testing.T) {
+	data := make([]byte, 8)
+	ok := func() *ngFile {
+		f := &ngFile{order: binary.LittleEndian}
+		return f.section().iface(uint16(LinkTypeEthernet), 0)
+	}
+	// A block whose length field is not a multiple of four.
+	misaligned := ok()
+	misaligned.b = binary.LittleEndian.AppendUint32(misaligned.b, 6)
+	misaligned.b = binary.LittleEndian.AppendUint32(misaligned.b, 14)
+	misaligned.b = append(misaligned.b, make([]byte, 6)...)
+	// A packet block claiming more data than it holds.
+	overrun := ok().packet(0, 0, data)
+	binary.LittleEndian.PutUint32(overrun.b[len(overrun.b)-4-len(data)-8:], 64)
+	whole := ok().packet(0, 0, data).packet(0, 0, data)
+
+	for _, c := range []struct {
+		name    string
+		b       []byte
+		packets int
+		err     string
+	}{
+		{"no section", (&ngFile{order: binary.LittleEndian}).iface(1, 0).b, 0, errNotCapture.Error()},
+		{"cut section", ok().b[:20], 0, errNotCapture.Error()},
+		{"misaligned block", misaligned.b, 0, "bad block length 14"},
+		{"short block", ok().block(6, nil).b, 0, "short packet block"},
+		{"short interface", ok().block(1, []byte{1, 0, 0, 0}).b, 0, "short interface block"},
+		{"unknown interface", ok().packet(3, 0, data).b, 0, "unknown interface 3"},
+		{"overrun", overrun.b, 0, "overruns block"},
+		{"cut block", whole.b[:len(whole.b)-3], 1, ""},
+	} {
+		got, err := readCapture(c.b)
+		if len(got) != c.packets {
+			t.Errorf("%s: %d packets, want %d", c.name, len(got), c.packets)
+		}
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error %v, want %q", c.name, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,670 @@
+package QCom
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// PerfPort is the default port for both the TCP control/data listener and
+// the UDP data socket, the same one iperf3 uses.
+const PerfPort = 5201
+
+// perfHeaderLen prefixes every UDP datagram: session, stream, sequence
+// number and the sender's clock in nanoseconds.
+const perfHeaderLen = 24
+
+type PerfConfig struct {
+	Proto    string        `json:"proto"` // "tcp" or "udp"
+	Streams  int           `json:"streams"`
+	Duration time.Duration `json:"duration"`
+	Interval time.Duration `json:"interval"`
+	BufSize  int           `json:"buf_size"`
+	// Bitrate caps UDP in bits per second across all streams.
+	Bitrate int64 `json:"bitrate"`
+}
+
+func DefaultPerfConfig() PerfConfig {
+	return PerfConfig{
+		Proto:    "tcp",
+		Streams:  1,
+		Duration: 10 * time.Second,
+		Interval: time.Second,
+		BufSize:  128 << 10,
+		Bitrate:  1_000_000,
+	}
+}
+
+func (c *PerfConfig) normalize() error {
+	if c.Proto != "tcp" && c.Proto != "udp" {
+		return fmt.Errorf("perf: unknown protocol %q", c.Proto)
+	}
+	c.Streams = min(max(c.Streams, 1), 128)
+	if c.Duration <= 0 {
+		c.Duration = 10 * time.Second
+	}
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	if c.Proto == "udp" {
+		// Stay under a typical MTU so datagrams aren't fragmented.
+		if c.BufSize <= 0 || c.BufSize > 1472 {
+			c.BufSize = 1400
+		}
+		if c.BufSize < perfHeaderLen {
+			c.BufSize = perfHeaderLen
+		}
+		if c.Bitrate <= 0 {
+			c.Bitrate = 1_000_000
+		}
+	} else if c.BufSize <= 0 {
+		c.BufSize = 128 << 10
+	}
+	return nil
+}
+
+// PerfInterval is what the receiver counted over one reporting interval.
+// Start and End are offsets from the start of the test.
+type PerfInterval struct {
+	Start   time.Duration `json:"start"`
+	End     time.Duration `json:"end"`
+	Bytes   int64         `json:"bytes"`
+	Packets int64         `json:"packets,omitempty"`
+	Lost    int64         `json:"lost,omitempty"`
+	// Dups are datagrams that arrived more than once; they count toward
+	// neither Bytes nor Packets.
+	Dups   int64         `json:"dups,omitempty"`
+	Jitter time.Duration `json:"jitter,omitempty"`
+}
+
+func (iv PerfInterval) BitsPerSec() float64 {
+	d := (iv.End - iv.Start).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(iv.Bytes) * 8 / d
+}
+
+// LossPercent is the share of UDP datagrams that never arrived.
+func (iv PerfInterval) LossPercent() float64 {
+	if total := iv.Packets + iv.Lost; total > 0 {
+		return 100 * float64(iv.Lost) / float64(total)
+	}
+	return 0
+}
+
+type PerfResult struct {
+	Config    PerfConfig     `json:"config"`
+	Intervals []PerfInterval `json:"intervals"`
+	// Total is the receiver's view; Sent is what the client wrote.
+	Total       PerfInterval `json:"total"`
+	Sent        int64        `json:"sent"`
+	StreamBytes []int64      `json:"stream_bytes"`
+}
+
+// FormatBits renders a rate like iperf does, e.g. "941 Mbit/s".
+func FormatBits(bps float64) string {
+	units := []string{"bit/s", "Kbit/s", "Mbit/s", "Gbit/s", "Tbit/s"}
+	i := 0
+	for bps >= 1000 && i < len(units)-1 {
+		bps /= 1000
+		i++
+	}
+	return strconv.FormatFloat(bps, 'f', 3-min(int(math.Log10(max(bps, 1))), 2), 64) + " " + units[i]
+}
+
+// ParseBitrate reads values like 10M, 1.5G or 500k as bits per second.
+func ParseBitrate(s string) (int64, error) {
+	mult := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mult, s = 1e3, s[:n-1]
+		case 'm', 'M':
+			mult, s = 1e6, s[:n-1]
+		case 'g', 'G':
+			mult, s = 1e9, s[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("bad bitrate %q", s)
+	}
+	return int64(f * mult), nil
+}
+
+// perfHello is the first line on every TCP connection.
+type perfHello struct {
+	Kind    string      `json:"kind"` // "control" or "data"
+	Config  *PerfConfig `json:"config,omitempty"`
+	Session uint32      `json:"session,omitempty"`
+	Stream  int         `json:"stream,omitempty"`
+}
+
+// perfMsg is every later line on the control connection.
+type perfMsg struct {
+	Session  uint32        `json:"session,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Interval *PerfInterval `json:"interval,omitempty"`
+	Done     bool          `json:"done,omitempty"`
+	Final    *PerfResult   `json:"final,omitempty"`
+}
+
+// perfSeqWindow is how far behind the highest sequence number a UDP
+// datagram can arrive and still be told apart from a duplicate.
+const perfSeqWindow = 1024
+
+type perfStream struct {
+	bytes   int64
+	nextSeq uint64
+	// got has a bit set for every sequence number in the window below
+	// nextSeq that has arrived.
+	got     [perfSeqWindow / 64]uint64
+	transit time.Duration
+	seen    bool
+}
+
+func (st *perfStream) has(seq uint64) bool {
+	return st.got[seq/64%uint64(len(st.got))]&(1<<(seq%64)) != 0
+}
+
+func (st *perfStream) mark(seq uint64, on bool) {
+	i, bit := seq/64%uint64(len(st.got)), uint64(1)<<(seq%64)
+	if on {
+		st.got[i] |= bit
+	} else {
+		st.got[i] &^= bit
+	}
+}
+
+type perfSession struct {
+	id    uint32
+	cfg   PerfConfig
+	start time.Time
+
+	mu      sync.Mutex
+	streams []perfStream
+	cur     PerfInterval
+	total   PerfInterval
+	jitter  float64
+}
+
+func (s *perfSession) addBytes(stream int, n int64) {
+	s.mu.Lock()
+	if stream >= 0 && stream < len(s.streams) {
+		s.streams[stream].bytes += n
+	}
+	s.cur.Bytes += n
+	s.total.Bytes += n
+	s.mu.Unlock()
+}
+
+// addDatagram accounts a UDP datagram, tracking gaps in the sequence as
+// loss and the RFC 3550 interarrival jitter. A datagram that fills an
+// earlier gap takes back its loss; one already seen, or too far behind
+// to tell, is a duplicate.
+func (s *perfSession) addDatagram(stream int, seq uint64, sent time.Time, n int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stream < 0 || stream >= len(s.streams) {
+		return
+	}
+	st := &s.streams[stream]
+	switch {
+	case seq >= st.nextSeq:
+		if seq-st.nextSeq >= perfSeqWindow {
+			st.got = [len(st.got)]uint64{}
+		} else {
+			for missing := st.nextSeq; missing < seq; missing++ {
+				st.mark(missing, false)
+			}
+		}
+		lost := int64(seq - st.nextSeq)
+		s.cur.Lost += lost
+		s.total.Lost += lost
+		st.nextSeq = seq + 1
+	case st.nextSeq-seq > perfSeqWindow || st.has(seq):
+		s.cur.Dups++
+		s.total.Dups++
+		return
+	default:
+		// A late arrival was already counted as lost.
+		if s.cur.Lost > 0 {
+			s.cur.Lost--
+		}
+		if s.total.Lost > 0 {
+			s.total.Lost--
+		}
+	}
+	st.mark(seq, true)
+	st.bytes += int64(n)
+	s.cur.Bytes += int64(n)
+	s.total.Bytes += int64(n)
+	s.cur.Packets++
+	s.total.Packets++
+	transit := now.Sub(sent)
+	if st.seen {
+		d := math.Abs(float64(transit - st.transit))
+		s.jitter += (d - s.jitter) / 16
+	}
+	st.transit, st.seen = transit, true
+	s.cur.Jitter = time.Duration(s.jitter)
+	s.total.Jitter = time.Duration(s.jitter)
+}
+
+func (s *perfSession) takeInterval(now time.Time) PerfInterval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	iv := s.cur
+	iv.End = now.Sub(s.start)
+	s.cur = PerfInterval{Start: iv.End, Jitter: iv.Jitter}
+	return iv
+}
+
+func (s *perfSession) result(now time.Time, intervals []PerfInterval) *PerfResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &PerfResult{Config: s.cfg, Intervals: intervals, Total: s.total}
+	r.Total.End = now.Sub(s.start)
+	for _, st := range s.streams {
+		r.StreamBytes = append(r.StreamBytes, st.bytes)
+	}
+	return r
+}
+
+// PerfServer accepts tests from RunPerfClient on one TCP and one UDP port.
+type PerfServer struct {
+	// OnInterval and OnDone, if set, see each session's reports as they
+	// are sent to the client. They run on server goroutines.
+	OnInterval func(remote string, cfg PerfConfig, iv PerfInterval)
+	OnDone     func(remote string, r *PerfResult)
+
+	ln   net.Listener
+	udp  *net.UDPConn
+	mu   sync.Mutex
+	next uint32
+	sess map[uint32]*perfSession
+}
+
+// ListenPerf opens the TCP and UDP sockets on addr, which may be a bare
+// host or empty to use PerfPort on every address.
+func ListenPerf(addr string) (*PerfServer, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(PerfPort))
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// The UDP socket shares the port the TCP listener actually got.
+	port := ln.Addr().(*net.TCPAddr).Port
+	host, _, _ := net.SplitHostPort(addr)
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(host), Port: port})
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &PerfServer{ln: ln, udp: udp, sess: map[uint32]*perfSession{}}, nil
+}
+
+func (s *PerfServer) Addr() net.Addr { return s.ln.Addr() }
+
+// Serve handles clients until ctx is done.
+func (s *PerfServer) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.ln.Close()
+		s.udp.Close()
+	}()
+	go s.serveUDP()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+func (s *PerfServer) session(id uint32) *perfSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sess[id]
+}
+
+func (s *PerfServer) serveUDP() {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := s.udp.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		if n < perfHeaderLen {
+			continue
+		}
+		sess := s.session(binary.BigEndian.Uint32(buf))
+		if sess == nil {
+			continue
+		}
+		stream := int(binary.BigEndian.Uint32(buf[4:]))
+		seq := binary.BigEndian.Uint64(buf[8:])
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64(buf[16:])))
+		sess.addDatagram(stream, seq, sent, n, now)
+	}
+}
+
+func (s *PerfServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := rd.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	var hello perfHello
+	if json.Unmarshal(line, &hello) != nil {
+		return
+	}
+	switch hello.Kind {
+	case "data":
+		sess := s.session(hello.Session)
+		if sess == nil {
+			return
+		}
+		buf := make([]byte, 128<<10)
+		for {
+			n, err := rd.Read(buf)
+			sess.addBytes(hello.Stream, int64(n))
+			if err != nil {
+				return
+			}
+		}
+	case "control":
+		s.control(ctx, conn, rd, hello)
+	}
+}
+
+func (s *PerfServer) control(ctx context.Context, conn net.Conn, rd *bufio.Reader, hello perfHello) {
+	enc := json.NewEncoder(conn)
+	if hello.Config == nil {
+		enc.Encode(perfMsg{Error: "missing test config"})
+		return
+	}
+	cfg := *hello.Config
+	if err := cfg.normalize(); err != nil {
+		enc.Encode(perfMsg{Error: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.next++
+	sess := &perfSession{id: s.next, cfg: cfg, start: time.Now(), streams: make([]perfStream, cfg.Streams)}
+	s.sess[sess.id] = sess
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sess, sess.id)
+		s.mu.Unlock()
+	}()
+	if enc.Encode(perfMsg{Session: sess.id}) != nil {
+		return
+	}
+	remote := conn.RemoteAddr().String()
+	logger().Info("perf test started", "client", remote, "proto", cfg.Proto, "streams", cfg.Streams)
+	defer logger().Info("perf test finished", "client", remote)
+
+	// The client says done on this connection, or just goes away.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dec := json.NewDecoder(rd)
+		for {
+			var m perfMsg
+			if dec.Decode(&m) != nil || m.Done {
+				return
+			}
+		}
+	}()
+
+	// A test can't legitimately outlive its duration by much.
+	limit := time.NewTimer(cfg.Duration + 10*time.Second)
+	defer limit.Stop()
+	tick := time.NewTicker(cfg.Interval)
+	defer tick.Stop()
+	var intervals []PerfInterval
+	for {
+		select {
+		case <-tick.C:
+			iv := sess.takeInterval(time.Now())
+			intervals = append(intervals, iv)
+			if s.OnInterval != nil {
+				s.OnInterval(remote, cfg, iv)
+			}
+			if enc.Encode(perfMsg{Interval: &iv}) != nil {
+				return
+			}
+		case <-done:
+			// Let datagrams still in flight land before the final count,
+			// but don't let the wait dilute the rate.
+			end := time.Now()
+			if cfg.Proto == "udp" {
+				time.Sleep(250 * time.Millisecond)
+			}
+			if iv := sess.takeInterval(end); iv.Bytes > 0 {
+				intervals = append(intervals, iv)
+				if s.OnInterval != nil {
+					s.OnInterval(remote, cfg, iv)
+				}
+				enc.Encode(perfMsg{Interval: &iv})
+			}
+			r := sess.result(end, intervals)
+			if s.OnDone != nil {
+				s.OnDone(remote, r)
+			}
+			enc.Encode(perfMsg{Final: r})
+			return
+		case <-limit.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunPerfClient runs one test against the server at addr (host or
+// host:port), calling onInterval with the server's receive reports.
+func RunPerfClient(ctx context.Context, addr string, cfg PerfConfig, onInterval func(PerfInterval)) (*PerfResult, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(PerfPort))
+	}
+	d := net.Dialer{Timeout: 5 * time.Second}
+	ctrl, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer ctrl.Close()
+	enc := json.NewEncoder(ctrl)
+	dec := json.NewDecoder(ctrl)
+	if err := enc.Encode(perfHello{Kind: "control", Config: &cfg}); err != nil {
+		return nil, err
+	}
+	var reply perfMsg
+	if err := dec.Decode(&reply); err != nil {
+		return nil, fmt.Errorf("perf: no answer from server: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, errors.New("perf: server: " + reply.Error)
+	}
+
+	// Senders stop at the deadline or when the caller gives up.
+	sendCtx, stop := context.WithTimeout(ctx, cfg.Duration)
+	defer stop()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		sent int64
+		errs []error
+	)
+	for i := range cfg.Streams {
+		wg.Add(1)
+		go func(stream int) {
+			defer wg.Done()
+			var n int64
+			var err error
+			if cfg.Proto == "udp" {
+				n, err = perfSendUDP(sendCtx, addr, reply.Session, stream, cfg)
+			} else {
+				n, err = perfSendTCP(sendCtx, addr, reply.Session, stream, cfg)
+			}
+			mu.Lock()
+			sent += n
+			if err != nil {
+				errs = append(errs, err)
+			}
+			mu.Unlock()
+		}(i)
+	}
+
+	// Interval reports arrive while the senders run.
+	msgs := make(chan perfMsg)
+	readErr := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			var m perfMsg
+			if err := dec.Decode(&m); err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case msgs <- m:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	sendersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(sendersDone)
+	}()
+
+	var intervals []PerfInterval
+	for {
+		select {
+		case <-sendersDone:
+			sendersDone = nil
+			if len(errs) > 0 && ctx.Err() == nil {
+				return nil, errs[0]
+			}
+			if err := enc.Encode(perfMsg{Done: true}); err != nil {
+				return nil, err
+			}
+		case m := <-msgs:
+			switch {
+			case m.Error != "":
+				return nil, errors.New("perf: server: " + m.Error)
+			case m.Interval != nil:
+				intervals = append(intervals, *m.Interval)
+				if onInterval != nil {
+					onInterval(*m.Interval)
+				}
+			case m.Final != nil:
+				m.Final.Sent = sent
+				return m.Final, nil
+			}
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				err = errors.New("perf: server closed the connection")
+			}
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func perfSendTCP(ctx context.Context, addr string, session uint32, stream int, cfg PerfConfig) (int64, error) {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(perfHello{Kind: "data", Session: session, Stream: stream}); err != nil {
+		return 0, err
+	}
+	go func() {
+		<-ctx.Done()
+		// Unblock a Write stuck on a full socket buffer.
+		conn.SetWriteDeadline(time.Now())
+	}()
+	buf := make([]byte, cfg.BufSize)
+	var total int64
+	for ctx.Err() == nil {
+		n, err := conn.Write(buf)
+		total += int64(n)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func perfSendUDP(ctx context.Context, addr string, session uint32, stream int, cfg PerfConfig) (int64, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	buf := make([]byte, cfg.BufSize)
+	binary.BigEndian.PutUint32(buf, session)
+	binary.BigEndian.PutUint32(buf[4:], uint32(stream))
+
+	// Pace each stream to its share of the bitrate.
+	rate := float64(cfg.Bitrate) / float64(cfg.Streams)
+	gap := time.Duration(float64(len(buf)*8) / rate * float64(time.Second))
+	start := time.Now()
+	var total int64
+	for seq := uint64(0); ctx.Err() == nil; seq++ {
+		if wait := time.Until(start.Add(time.Duration(seq) * gap)); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return total, nil
+			case <-time.After(wait):
+			}
+		}
+		binary.BigEndian.PutUint64(buf[8:], seq)
+		binary.BigEndian.PutUint64(buf[16:], uint64(time.Now().UnixNano()))
+		n, err := conn.Write(buf)
+		total += int64(n)
+		// A refused datagram (ICMP unreachable) shouldn't end the test.
+		if err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+			return total, err
+		}
+	}
+	return total, nil
+}
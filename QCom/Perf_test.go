@@ -0,0 +1,137 @@
+package QCom
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// perfServer serves on a free localhost port until the test ends and
+// returns its address and the results it reported.
+func perfServer(t *testing.T) (string, func() []*PerfResult) {
+	t.Helper()
+	srv, err := ListenPerf("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var done []*PerfResult
+	srv.OnDone = func(_ string, r *PerfResult) {
+		mu.Lock()
+		done = append(done, r)
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return srv.Addr().String(), func() []*PerfResult {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*PerfResult(nil), done...)
+	}
+}
+
+func TestPerfLoopback(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp"} {
+		t.Run(proto, func(t *testing.T) {
+			addr, reported := perfServer(t)
+			cfg := DefaultPerfConfig()
+			cfg.Proto, cfg.Streams = proto, 2
+			cfg.Duration, cfg.Interval = 400*time.Millisecond, 100*time.Millisecond
+			cfg.Bitrate = 20_000_000
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			var seen int
+			r, err := RunPerfClient(ctx, addr, cfg, func(PerfInterval) { seen++ })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Config.Proto != proto || r.Config.Streams != 2 {
+				t.Errorf("server ran %+v", r.Config)
+			}
+			if seen == 0 || seen != len(r.Intervals) {
+				t.Errorf("%d interval callbacks for %d intervals", seen, len(r.Intervals))
+			}
+			if r.Total.Bytes <= 0 || r.Total.Bytes > r.Sent {
+				t.Errorf("received %d of %d bytes sent", r.Total.Bytes, r.Sent)
+			}
+			if len(r.StreamBytes) != 2 || r.StreamBytes[0] <= 0 || r.StreamBytes[1] <= 0 {
+				t.Errorf("per-stream bytes %v", r.StreamBytes)
+			}
+			if proto == "udp" {
+				if r.Total.Packets <= 0 {
+					t.Errorf("no datagrams counted: %+v", r.Total)
+				}
+				// 20 Mbit/s for 0.4s is about 1 MB; the pacing shouldn't
+				// be off by anything like a factor of two.
+				if r.Sent > 2_000_000 {
+					t.Errorf("sent %d bytes at a 20 Mbit/s cap", r.Sent)
+				}
+			}
+			if got := reported(); len(got) != 1 || got[0].Total.Bytes != r.Total.Bytes {
+				t.Errorf("server reported %d results", len(got))
+			}
+		})
+	}
+}
+
+func TestPerfClientErrors(t *testing.T) {
+	addr, _ := perfServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cfg := DefaultPerfConfig()
+	cfg.Proto = "sctp"
+	if _, err := RunPerfClient(ctx, addr, cfg, nil); err == nil {
+		t.Error("unknown protocol accepted")
+	}
+
+	// Cancelling mid-test returns promptly with the context's error.
+	cfg = DefaultPerfConfig()
+	cfg.Duration = time.Minute
+	short, stop := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer stop()
+	start := time.Now()
+	if _, err := RunPerfClient(short, addr, cfg, nil); err == nil {
+		t.Error("cancelled test succeeded")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("cancel took %s", d)
+	}
+}
+
+func TestPerfDatagramAccounting(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		name               string
+		seqs               []uint64
+		packets, lost, dup int64
+	}{
+		{"in order", []uint64{0, 1, 2, 3}, 4, 0, 0},
+		{"gap", []uint64{0, 1, 4, 5}, 4, 2, 0},
+		{"reordered", []uint64{0, 2, 1, 3}, 4, 0, 0},
+		{"duplicate", []uint64{0, 1, 1, 2}, 3, 0, 1},
+		{"duplicate of a late arrival", []uint64{0, 2, 1, 1, 3}, 4, 0, 1},
+		{"duplicate with loss", []uint64{0, 3, 3, 3}, 2, 2, 2},
+		{"late past the window", []uint64{0, perfSeqWindow + 5, 1}, 2, perfSeqWindow + 4, 1},
+		{"late inside the window", []uint64{0, perfSeqWindow, 1}, 3, perfSeqWindow - 2, 0},
+	} {
+		s := &perfSession{streams: make([]perfStream, 1)}
+		for _, seq := range c.seqs {
+			s.addDatagram(0, seq, now, 100, now)
+		}
+		got := s.total
+		if got.Packets != c.packets || got.Lost != c.lost || got.Dups != c.dup || got.Bytes != 100*c.packets {
+			t.Errorf("%s: %d packets, %d bytes, %d lost, %d dups; want %d, %d, %d, %d",
+				c.name, got.Packets, got.Bytes, got.Lost, got.Dups, c.packets, 100*c.packets, c.lost, c.dup)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package QCom
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	PingAuto = "auto"
+	PingICMP = "icmp"
+	PingTCP  = "tcp"
+)
+
+// PingHistoryLen is how many recent samples each target keeps for graphing.
+const PingHistoryLen = 120
+
+var errICMPUnavailable = errors.New("unprivileged ICMP sockets are not available")
+
+type PingSample struct {
+	Target string        `json:"target"`
+	Seq    int           `json:"seq"`
+	Time   time.Time     `json:"time"`
+	RTT    time.Duration `json:"rtt"`
+	Lost   bool          `json:"lost"`
+	Method string        `json:"method"`
+	Err    string        `json:"err,omitempty"`
+}
+
+type PingStats struct {
+	Target   string
+	Method   string
+	Sent     int
+	Received int
+	Last     time.Duration
+	Min      time.Duration
+	Max      time.Duration
+	Avg      time.Duration
+	StdDev   time.Duration
+	Jitter   time.Duration
+	// History holds the most recent RTTs, oldest first, with -1 for a loss.
+	History []time.Duration
+
+	mean, m2, jitter float64
+	prev             time.Duration
+}
+
+func (s *PingStats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return 100 * float64(s.Sent-s.Received) / float64(s.Sent)
+}
+
+// Add folds one sample into the running statistics. Standard deviation
+// uses Welford's method and jitter the RFC 3550 smoothed estimator.
+func (s *PingStats) Add(p PingSample) {
+	s.Sent++
+	s.Method = p.Method
+	rtt := p.RTT
+	if p.Lost {
+		rtt = -1
+	} else {
+		s.Received++
+		s.Last = p.RTT
+		if s.Received == 1 || p.RTT < s.Min {
+			s.Min = p.RTT
+		}
+		if p.RTT > s.Max {
+			s.Max = p.RTT
+		}
+		x := float64(p.RTT)
+		d := x - s.mean
+		s.mean += d / float64(s.Received)
+		s.m2 += d * (x - s.mean)
+		s.Avg = time.Duration(s.mean)
+		if s.Received > 1 {
+			s.StdDev = time.Duration(math.Sqrt(s.m2 / float64(s.Received-1)))
+			s.jitter += (math.Abs(float64(p.RTT-s.prev)) - s.jitter) / 16
+			s.Jitter = time.Duration(s.jitter)
+		}
+		s.prev = p.RTT
+	}
+	s.History = append(s.History, rtt)
+	if len(s.History) > PingHistoryLen {
+		s.History = s.History[len(s.History)-PingHistoryLen:]
+	}
+}
+
+type PingMonitor struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Method   string
+	TCPPort  int
+	// OnSample is called from the probing goroutines after every probe.
+	OnSample func(PingSample)
+
+	mu      sync.Mutex
+	targets []string
+	stats   map[string]*PingStats
+}
+
+func NewPingMonitor(targets []string) *PingMonitor {
+	m := &PingMonitor{
+		Interval: time.Second,
+		Timeout:  2 * time.Second,
+		Method:   PingAuto,
+		TCPPort:  443,
+		targets:  targets,
+		stats:    map[string]*PingStats{},
+	}
+	for _, t := range targets {
+		m.stats[t] = &PingStats{Target: t}
+	}
+	return m
+}
+
+// Run probes every target until ctx is cancelled.
+func (m *PingMonitor) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range m.targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			m.runTarget(ctx, target)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (m *PingMonitor) runTarget(ctx context.Context, target string) {
+	p := NewPinger(target, m.Method, m.TCPPort)
+	defer p.Close()
+	tick := time.NewTicker(m.Interval)
+	defer tick.Stop()
+	for seq := 0; ; seq++ {
+		s := p.Ping(ctx, seq, m.Timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		m.mu.Lock()
+		m.stats[target].Add(s)
+		m.mu.Unlock()
+		if m.OnSample != nil {
+			m.OnSample(s)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Stats returns a copy of the current statistics in target order.
+func (m *PingMonitor) Stats() []PingStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]PingStats, 0, len(m.targets))
+	for _, t := range m.targets {
+		s := *m.stats[t]
+		s.History = append([]time.Duration(nil), s.History...)
+		out = append(out, s)
+	}
+	return out
+}
+
+// Pinger sends successive probes to one target, keeping its ICMP socket
+// open between them.
+type Pinger struct {
+	target  string
+	method  string
+	tcpPort int
+	icmp    *icmpConn
+	addr    *net.IPAddr
+}
+
+func NewPinger(target, method string, tcpPort int) *Pinger {
+	return &Pinger{target: target, method: method, tcpPort: tcpPort}
+}
+
+func (p *Pinger) Close() {
+	if p.icmp != nil {
+		p.icmp.Close()
+	}
+}
+
+func (p *Pinger) Ping(ctx context.Context, seq int, timeout time.Duration) PingSample {
+	s := PingSample{Target: p.target, Seq: seq, Time: time.Now()}
+	if p.method != PingTCP {
+		err := p.pingICMP(ctx, seq, timeout, &s)
+		if err == nil || p.method == PingICMP || !errors.Is(err, errICMPUnavailable) {
+			if err != nil {
+				s.Lost, s.Err = true, err.Error()
+				logger().Debug("ping lost", "target", p.target, "seq", seq, "err", err)
+			}
+			return s
+		}
+		// Fall back to TCP for good once ICMP turns out to be refused.
+		logger().Info("ICMP unavailable, pinging over TCP", "target", p.target, "port", p.tcpPort, "err", err)
+		p.method = PingTCP
+	}
+	s.Method = PingTCP
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	d := net.Dialer{}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(p.target, strconv.Itoa(p.tcpPort)))
+	s.RTT = time.Since(start)
+	switch {
+	case err == nil:
+		conn.Close()
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// A RST still proves the host is up and gives a round trip.
+	default:
+		s.Lost, s.Err, s.RTT = true, err.Error(), 0
+		logger().Debug("ping lost", "target", p.target, "seq", seq, "method", PingTCP, "err", err)
+	}
+	return s
+}
+
+func (p *Pinger) pingICMP(ctx context.Context, seq int, timeout time.Duration, s *PingSample) error {
+	s.Method = PingICMP
+	if p.addr == nil {
+		a, err := net.DefaultResolver.LookupIPAddr(ctx, p.target)
+		if err != nil {
+			logger().Warn("ping target not resolved", "target", p.target, "err", err)
+			return err
+		}
+		if len(a) == 0 {
+			return errors.New("no address for " + p.target)
+		}
+		p.addr = &a[0]
+	}
+	if p.icmp == nil {
+		c, err := listenICMP(p.addr.IP.To4() == nil)
+		if err != nil {
+			if !errors.Is(err, errICMPUnavailable) {
+				logger().Error("opening ICMP socket", "target", p.target, "err", err)
+			}
+			return err
+		}
+		p.icmp = c
+	}
+	rtt, err := p.icmp.echo(p.addr, uint16(seq), timeout)
+	s.RTT = rtt
+	return err
+}
+
+type icmpConn struct {
+	net.PacketConn
+	v6 bool
+}
+
+func (c *icmpConn) echo(dst *net.IPAddr, seq uint16, timeout time.Duration) (time.Duration, error) {
+	typ := byte(8)
+	if c.v6 {
+		typ = 128
+	}
+	payload := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	msg := []byte{typ, 0, 0, 0, 0, 0, byte(seq >> 8), byte(seq)}
+	msg = append(msg, payload...)
+	if !c.v6 {
+		// The kernel fills in the ICMPv6 checksum but not the IPv4 one.
+		ck := icmpChecksum(msg)
+		msg[2], msg[3] = byte(ck>>8), byte(ck)
+	}
+
+	start := time.Now()
+	if _, err := c.WriteTo(msg, &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}); err != nil {
+		return 0, err
+	}
+	c.SetReadDeadline(start.Add(timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		reply := byte(0)
+		if c.v6 {
+			reply = 129
+		}
+		if n >= 8 && buf[0] == reply && binary.BigEndian.Uint16(buf[6:]) == seq {
+			return time.Since(start), nil
+		}
+	}
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,32 @@
+package QCom
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenICMP opens an unprivileged ICMP datagram socket. Linux only allows
+// these for groups listed in net.ipv4.ping_group_range.
+func listenICMP(v6 bool) (*icmpConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if v6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EPROTONOSUPPORT) {
+			return nil, fmt.Errorf("%w: %v", errICMPUnavailable, err)
+		}
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return &icmpConn{PacketConn: c, v6: v6}, nil
+}
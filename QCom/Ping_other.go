@@ -0,0 +1,7 @@
+//go:build !linux
+
+package QCom
+
+func listenICMP(v6 bool) (*icmpConn, error) {
+	return nil, errICMPUnavailable
+}
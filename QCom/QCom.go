@@ -1,14 +1,20 @@
 package QCom
 
 import (
-	"log"
+	"log/slog"
 	"net"
 )
 
+// logger is where QCom reports; the application decides where that goes.
+func logger() *slog.Logger {
+	return slog.Default().With("component", "QCom")
+}
+
 func IfaceID() int {
 	intfc, err := net.Interfaces()
 	if err != nil {
-		log.Fatal(err)
+		logger().Error("listing interfaces", "err", err)
+		return 0
 	}
 
 	for _, Iface := range intfc {
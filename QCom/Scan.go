@@ -0,0 +1,322 @@
+package QCom
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxScanHosts caps how many addresses a single target spec may expand to,
+// so a typo like 10.0.0.0/8 doesn't queue sixteen million hosts.
+const MaxScanHosts = 65536
+
+type PortState string
+
+const (
+	PortOpen     PortState = "open"
+	PortClosed   PortState = "closed"
+	PortFiltered PortState = "filtered"
+)
+
+type ScanResult struct {
+	Host    string        `json:"host"`
+	Port    int           `json:"port"`
+	State   PortState     `json:"state"`
+	Banner  string        `json:"banner,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Time    time.Time     `json:"time"`
+}
+
+type ScanOptions struct {
+	Workers       int           // concurrent connection attempts across all hosts
+	HostRate      int           // connection attempts per second per host, 0 for unlimited
+	Timeout       time.Duration // connect timeout
+	BannerTimeout time.Duration // how long to wait for a service to speak first, 0 to skip
+	Dial          func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func DefaultScanOptions() ScanOptions {
+	return ScanOptions{
+		Workers:       100,
+		HostRate:      50,
+		Timeout:       2 * time.Second,
+		BannerTimeout: 1500 * time.Millisecond,
+	}
+}
+
+// ParseTargets expands a comma or space separated list of hostnames, IP
+// addresses, CIDR prefixes and a.b.c.d-e last-octet ranges.
+func ParseTargets(spec string) ([]string, error) {
+	var hosts []string
+	for _, f := range splitList(spec) {
+		switch {
+		case strings.Contains(f, "/"):
+			p, err := netip.ParsePrefix(f)
+			if err != nil {
+				return nil, fmt.Errorf("bad prefix %q: %w", f, err)
+			}
+			p = p.Masked()
+			if bits := p.Addr().BitLen() - p.Bits(); bits > 16 {
+				return nil, fmt.Errorf("prefix %s is larger than %d hosts", p, MaxScanHosts)
+			}
+			for a := p.Addr(); p.Contains(a); a = a.Next() {
+				hosts = append(hosts, a.String())
+			}
+		case isOctetRange(f):
+			lo, hi, _ := strings.Cut(f, "-")
+			start := netip.MustParseAddr(lo)
+			end, err := strconv.Atoi(hi)
+			last := int(start.As4()[3])
+			if err != nil || end < last || end > 255 {
+				return nil, fmt.Errorf("bad range %q", f)
+			}
+			for a, i := start, last; i <= end; a, i = a.Next(), i+1 {
+				hosts = append(hosts, a.String())
+			}
+		default:
+			hosts = append(hosts, f)
+		}
+		if len(hosts) > MaxScanHosts {
+			return nil, fmt.Errorf("target list expands to more than %d hosts", MaxScanHosts)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no targets given")
+	}
+	return hosts, nil
+}
+
+func isOctetRange(f string) bool {
+	lo, _, ok := strings.Cut(f, "-")
+	if !ok {
+		return false
+	}
+	a, err := netip.ParseAddr(lo)
+	return err == nil && a.Is4()
+}
+
+// ParsePorts accepts lists like "22,80,443" and ranges like "8000-8100".
+func ParsePorts(spec string) ([]int, error) {
+	seen := map[int]bool{}
+	var ports []int
+	for _, f := range splitList(spec) {
+		lo, hi, isRange := strings.Cut(f, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("bad port %q", f)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("bad port range %q", f)
+			}
+		}
+		if start < 1 || end > 65535 || end < start {
+			return nil, fmt.Errorf("port range %q out of bounds", f)
+		}
+		for p := start; p <= end; p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("no ports given")
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+func splitList(spec string) []string {
+	return strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// Scan runs TCP connect probes against every host/port pair and streams the
+// results as they complete. The channel is closed once every probe has
+// finished or ctx is cancelled.
+func Scan(ctx context.Context, hosts []string, ports []int, opts ScanOptions) <-chan ScanResult {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultScanOptions().Timeout
+	}
+	if opts.Dial == nil {
+		d := &net.Dialer{}
+		opts.Dial = d.DialContext
+	}
+
+	type job struct {
+		host string
+		port int
+	}
+	jobs := make(chan job)
+	out := make(chan ScanResult, opts.Workers)
+	logger().Info("scan started", "hosts", len(hosts), "ports", len(ports), "workers", opts.Workers)
+	limits := newHostLimiter(opts.HostRate)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if err := limits.wait(ctx, j.host); err != nil {
+					return
+				}
+				r := probePort(ctx, j.host, j.port, opts)
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// Interleave hosts per port so the per-host rate limit spreads load
+	// instead of serialising the whole pool behind one slow host.
+	go func() {
+		defer close(jobs)
+		for _, p := range ports {
+			for _, h := range hosts {
+				select {
+				case jobs <- job{h, p}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func probePort(ctx context.Context, host string, port int, opts ScanOptions) ScanResult {
+	r := ScanResult{Host: host, Port: port, Time: time.Now()}
+	dctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := opts.Dial(dctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	r.Latency = time.Since(start)
+	if err != nil {
+		r.State = PortFiltered
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			r.State = PortClosed
+		}
+		return r
+	}
+	defer conn.Close()
+	r.State = PortOpen
+	if opts.BannerTimeout > 0 {
+		r.Banner = readBanner(conn, opts.BannerTimeout)
+	}
+	return r
+}
+
+func readBanner(conn net.Conn, wait time.Duration) string {
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 512)
+	n, _ := conn.Read(buf)
+	return printable(buf[:n])
+}
+
+// printable keeps the first line of a banner and drops control bytes so it
+// can be shown in a table cell.
+func printable(b []byte) string {
+	var sb strings.Builder
+	for _, c := range string(b) {
+		if c == '\r' || c == '\n' {
+			if sb.Len() > 0 {
+				break
+			}
+			continue
+		}
+		if c < ' ' || c == 0x7f || c == utf8.RuneError {
+			c = '.'
+		}
+		sb.WriteRune(c)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+type hostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+func newHostLimiter(perSecond int) *hostLimiter {
+	l := &hostLimiter{next: map[string]time.Time{}}
+	if perSecond > 0 {
+		l.interval = time.Second / time.Duration(perSecond)
+	}
+	return l
+}
+
+// wait reserves the next free slot for host and sleeps until it arrives.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.interval == 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	l.mu.Unlock()
+
+	t := time.NewTimer(time.Until(at))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func WriteScanCSV(w io.Writer, results []ScanResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"host", "port", "state", "latency_ms", "banner", "time"})
+	for _, r := range results {
+		cw.Write([]string{
+			r.Host,
+			strconv.Itoa(r.Port),
+			string(r.State),
+			strconv.FormatFloat(float64(r.Latency)/float64(time.Millisecond), 'f', 2, 64),
+			r.Banner,
+			r.Time.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WriteScanJSON(w io.Writer, results []ScanResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
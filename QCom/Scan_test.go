@@ -0,0 +1,172 @@
+package QCom
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// bannerServer starts a localhost listener that greets each connection
+// with banner, or stays quiet when it is empty.
+func bannerServer(t *testing.T, banner string) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if banner != "" {
+				conn.Write([]byte(banner))
+			}
+			go func() {
+				time.Sleep(time.Second)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort is a port that was just free, so connecting is refused.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func scanAll(t *testing.T, hosts []string, ports []int, opts ScanOptions) map[int]ScanResult {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got := map[int]ScanResult{}
+	for r := range Scan(ctx, hosts, ports, opts) {
+		got[r.Port] = r
+	}
+	return got
+}
+
+func TestScanLocalhost(t *testing.T) {
+	ssh := bannerServer(t, "SSH-2.0-OpenSSH_9.6\r\nignored")
+	quiet := bannerServer(t, "")
+	closed := closedPort(t)
+
+	opts := DefaultScanOptions()
+	opts.HostRate = 0
+	opts.BannerTimeout = 200 * time.Millisecond
+	got := scanAll(t, []string{"127.0.0.1"}, []int{ssh, quiet, closed}, opts)
+
+	if len(got) != 3 {
+		t.Fatalf("got %d results, want 3: %v", len(got), got)
+	}
+	for _, c := range []struct {
+		port   int
+		state  PortState
+		banner string
+	}{
+		{ssh, PortOpen, "SSH-2.0-OpenSSH_9.6"},
+		{quiet, PortOpen, ""},
+		{closed, PortClosed, ""},
+	} {
+		r := got[c.port]
+		if r.Host != "127.0.0.1" || r.State != c.state || r.Banner != c.banner {
+			t.Errorf("port %d: got %s %q on %s, want %s %q", c.port, r.State, r.Banner, r.Host, c.state, c.banner)
+		}
+	}
+}
+
+func TestScanFiltered(t *testing.T) {
+	opts := DefaultScanOptions()
+	opts.Timeout = 50 * time.Millisecond
+	opts.Dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, errors.New("i/o timeout")
+	}
+	got := scanAll(t, []string{"192.0.2.1"}, []int{80}, opts)
+	if got[80].State != PortFiltered {
+		t.Fatalf("got %s, want filtered", got[80].State)
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	opts := DefaultScanOptions()
+	opts.Workers = 2
+	opts.Dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := Scan(ctx, []string{"192.0.2.1"}, []int{1, 2, 3, 4, 5, 6}, opts)
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		for range results {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("results channel not closed after cancel")
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want []int
+		err  bool
+	}{
+		{"22", []int{22}, false},
+		{"22,80 443", []int{22, 80, 443}, false},
+		{"8000-8002,22", []int{22, 8000, 8001, 8002}, false},
+		{"80,80", []int{80}, false},
+		{"0", nil, true},
+		{"65536", nil, true},
+		{"10-5", nil, true},
+		{"http", nil, true},
+	} {
+		got, err := ParsePorts(c.spec)
+		if (err != nil) != c.err {
+			t.Errorf("ParsePorts(%q) error = %v, want error %v", c.spec, err, c.err)
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParsePorts(%q) = %v, want %v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want []string
+	}{
+		{"127.0.0.1", []string{"127.0.0.1"}},
+		{"10.0.0.1-3", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"192.168.1.4/30", []string{"192.168.1.4", "192.168.1.5", "192.168.1.6", "192.168.1.7"}},
+		{"example.com, 10.0.0.9", []string{"example.com", "10.0.0.9"}},
+	} {
+		got, err := ParseTargets(c.spec)
+		if err != nil {
+			t.Errorf("ParseTargets(%q): %v", c.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseTargets(%q) = %v, want %v", c.spec, got, c.want)
+		}
+	}
+}
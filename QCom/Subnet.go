@@ -0,0 +1,196 @@
+package QCom
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+)
+
+// MaxSubnets caps how many prefixes a split may produce.
+const MaxSubnets = 4096
+
+type PrefixInfo struct {
+	Prefix    netip.Prefix
+	Network   netip.Addr
+	Last      netip.Addr // broadcast for IPv4
+	FirstHost netip.Addr
+	LastHost  netip.Addr
+	Netmask   netip.Addr // IPv4 only
+	Wildcard  netip.Addr // IPv4 only
+	Addresses *big.Int
+	Hosts     *big.Int
+}
+
+// ParsePrefixes reads a comma or space separated list of prefixes. Bare
+// addresses are taken as single-host prefixes.
+func ParsePrefixes(spec string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, f := range splitList(spec) {
+		p, err := ParsePrefix(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("bad prefix %q", s)
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+func PrefixDetails(p netip.Prefix) PrefixInfo {
+	p = p.Masked()
+	bits := p.Addr().BitLen()
+	hostBits := uint(bits - p.Bits())
+	info := PrefixInfo{
+		Prefix:    p,
+		Network:   p.Addr(),
+		Addresses: new(big.Int).Lsh(big.NewInt(1), hostBits),
+	}
+	info.Last = addrAdd(info.Network, new(big.Int).Sub(info.Addresses, big.NewInt(1)))
+	info.FirstHost, info.LastHost = info.Network, info.Last
+	info.Hosts = new(big.Int).Set(info.Addresses)
+
+	switch {
+	case p.Addr().Is6():
+		// No broadcast in IPv6; every address but the subnet-router anycast
+		// is usable, which is how most planners count it.
+		if hostBits > 0 {
+			info.FirstHost = info.Network.Next()
+			info.Hosts.Sub(info.Hosts, big.NewInt(1))
+		}
+	case hostBits >= 2:
+		info.FirstHost, info.LastHost = info.Network.Next(), info.Last.Prev()
+		info.Hosts.Sub(info.Hosts, big.NewInt(2))
+	}
+	// A /31 has two usable hosts and a /32 one (RFC 3021), so the defaults stand.
+
+	if p.Addr().Is4() {
+		mask := ^uint32(0) << hostBits
+		if hostBits == 32 {
+			mask = 0
+		}
+		info.Netmask = netip.AddrFrom4([4]byte{byte(mask >> 24), byte(mask >> 16), byte(mask >> 8), byte(mask)})
+		w := ^mask
+		info.Wildcard = netip.AddrFrom4([4]byte{byte(w >> 24), byte(w >> 16), byte(w >> 8), byte(w)})
+	}
+	return info
+}
+
+func addrToInt(a netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(a.AsSlice())
+}
+
+func intToAddr(i *big.Int, bits int) netip.Addr {
+	b := make([]byte, bits/8)
+	i.FillBytes(b)
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+func addrAdd(a netip.Addr, n *big.Int) netip.Addr {
+	return intToAddr(new(big.Int).Add(addrToInt(a), n), a.BitLen())
+}
+
+// SplitPrefix divides p into subnets of length newBits.
+func SplitPrefix(p netip.Prefix, newBits int) ([]netip.Prefix, error) {
+	p = p.Masked()
+	if newBits < p.Bits() || newBits > p.Addr().BitLen() {
+		return nil, fmt.Errorf("/%d does not fit inside %s", newBits, p)
+	}
+	if newBits-p.Bits() > 12 {
+		return nil, fmt.Errorf("splitting %s into /%d gives more than %d subnets", p, newBits, MaxSubnets)
+	}
+	n := 1 << (newBits - p.Bits())
+	step := new(big.Int).Lsh(big.NewInt(1), uint(p.Addr().BitLen()-newBits))
+	out := make([]netip.Prefix, 0, n)
+	cur := addrToInt(p.Addr())
+	for i := 0; i < n; i++ {
+		out = append(out, netip.PrefixFrom(intToAddr(cur, p.Addr().BitLen()), newBits))
+		cur.Add(cur, step)
+	}
+	return out, nil
+}
+
+// SplitPrefixN divides p into the smallest power of two subnets that is at
+// least n.
+func SplitPrefixN(p netip.Prefix, n int) ([]netip.Prefix, error) {
+	if n < 1 {
+		return nil, errors.New("subnet count must be at least 1")
+	}
+	extra := 0
+	for 1<<extra < n {
+		extra++
+	}
+	return SplitPrefix(p, p.Bits()+extra)
+}
+
+// SummarizePrefixes returns the smallest set of prefixes covering exactly
+// the same addresses as ps.
+func SummarizePrefixes(ps []netip.Prefix) []netip.Prefix {
+	list := make([]netip.Prefix, 0, len(ps))
+	for _, p := range ps {
+		list = append(list, p.Masked())
+	}
+	for {
+		sortPrefixes(list)
+		merged := list[:0]
+		changed := false
+		for _, p := range list {
+			if n := len(merged); n > 0 {
+				last := merged[n-1]
+				// Drop prefixes already covered by the previous one.
+				if last.Addr().BitLen() == p.Addr().BitLen() && last.Bits() <= p.Bits() && last.Contains(p.Addr()) {
+					changed = changed || last != p
+					continue
+				}
+				// Two halves of the same parent collapse into the parent.
+				if last.Bits() == p.Bits() && last.Bits() > 0 && last.Addr().BitLen() == p.Addr().BitLen() {
+					parent, _ := last.Addr().Prefix(last.Bits() - 1)
+					if parent.Contains(p.Addr()) {
+						merged[n-1] = parent
+						changed = true
+						continue
+					}
+				}
+			}
+			merged = append(merged, p)
+		}
+		list = merged
+		if !changed {
+			return list
+		}
+	}
+}
+
+func sortPrefixes(ps []netip.Prefix) {
+	sort.Slice(ps, func(i, j int) bool {
+		if c := ps[i].Addr().Compare(ps[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return ps[i].Bits() < ps[j].Bits()
+	})
+}
+
+// PrefixOverlaps lists every pair from a and b that shares an address.
+func PrefixOverlaps(a, b []netip.Prefix) [][2]netip.Prefix {
+	var out [][2]netip.Prefix
+	for _, x := range a {
+		for _, y := range b {
+			if x.Overlaps(y) {
+				out = append(out, [2]netip.Prefix{x, y})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,202 @@
+package QCom
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func prefixes(ss ...string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range ss {
+		out = append(out, netip.MustParsePrefix(s))
+	}
+	return out
+}
+
+func joinPrefixes(ps []netip.Prefix) string {
+	var ss []string
+	for _, p := range ps {
+		ss = append(ss, p.String())
+	}
+	return strings.Join(ss, " ")
+}
+
+func TestParsePrefix(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{"10.1.2.3", "10.1.2.3/32"},
+		{"0.0.0.0/0", "0.0.0.0/0"},
+		{"2001:db8::1/64", "2001:db8::/64"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"bad", ""},
+		{"10.0.0.0/33", ""},
+		{"2001:db8::/129", ""},
+	} {
+		p, err := ParsePrefix(c.in)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("ParsePrefix(%q) = %s, want an error", c.in, p)
+			}
+			continue
+		}
+		if err != nil || p.String() != c.want {
+			t.Errorf("ParsePrefix(%q) = %s, %v; want %s", c.in, p, err, c.want)
+		}
+	}
+
+	got, err := ParsePrefixes("10.0.0.0/8, 192.0.2.1 2001:db8::/32")
+	if err != nil || joinPrefixes(got) != "10.0.0.0/8 192.0.2.1/32 2001:db8::/32" {
+		t.Errorf("ParsePrefixes = %s, %v", joinPrefixes(got), err)
+	}
+	if _, err := ParsePrefixes("10.0.0.0/8, nope"); err == nil {
+		t.Error("ParsePrefixes accepted a bad entry")
+	}
+}
+
+func TestPrefixDetails(t *testing.T) {
+	for _, c := range []struct {
+		prefix                    string
+		network, last, first, end string
+		netmask, wildcard         string
+		addresses, hosts          string
+	}{
+		{"192.168.1.77/24", "192.168.1.0", "192.168.1.255", "192.168.1.1", "192.168.1.254", "255.255.255.0", "0.0.0.255", "256", "254"},
+		{"10.0.0.5/31", "10.0.0.4", "10.0.0.5", "10.0.0.4", "10.0.0.5", "255.255.255.254", "0.0.0.1", "2", "2"},
+		{"10.0.0.7/32", "10.0.0.7", "10.0.0.7", "10.0.0.7", "10.0.0.7", "255.255.255.255", "0.0.0.0", "1", "1"},
+		{"10.0.0.0/30", "10.0.0.0", "10.0.0.3", "10.0.0.1", "10.0.0.2", "255.255.255.252", "0.0.0.3", "4", "2"},
+		{"0.0.0.0/0", "0.0.0.0", "255.255.255.255", "0.0.0.1", "255.255.255.254", "0.0.0.0", "255.255.255.255", "4294967296", "4294967294"},
+		{"2001:db8::/64", "2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", "2001:db8::1", "2001:db8::ffff:ffff:ffff:ffff", "invalid IP", "invalid IP", "18446744073709551616", "18446744073709551615"},
+		{"2001:db8::1/127", "2001:db8::", "2001:db8::1", "2001:db8::1", "2001:db8::1", "invalid IP", "invalid IP", "2", "1"},
+		{"2001:db8::1/128", "2001:db8::1", "2001:db8::1", "2001:db8::1", "2001:db8::1", "invalid IP", "invalid IP", "1", "1"},
+		{"::/0", "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::1", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "invalid IP", "invalid IP", "340282366920938463463374607431768211456", "340282366920938463463374607431768211455"},
+	} {
+		d := PrefixDetails(netip.MustParsePrefix(c.prefix))
+		got := []string{d.Network.String(), d.Last.String(), d.FirstHost.String(), d.LastHost.String(),
+			d.Netmask.String(), d.Wildcard.String(), d.Addresses.String(), d.Hosts.String()}
+		want := []string{c.network, c.last, c.first, c.end, c.netmask, c.wildcard, c.addresses, c.hosts}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s:\n got %q\nwant %q", c.prefix, got, want)
+		}
+	}
+}
+
+func TestSplitPrefix(t *testing.T) {
+	for _, c := range []struct {
+		prefix string
+		bits   int
+		want   string
+	}{
+		{"10.0.0.0/24", 26, "10.0.0.0/26 10.0.0.64/26 10.0.0.128/26 10.0.0.192/26"},
+		{"10.0.0.99/24", 25, "10.0.0.0/25 10.0.0.128/25"},
+		{"10.0.0.0/24", 24, "10.0.0.0/24"},
+		{"10.0.0.0/31", 32, "10.0.0.0/32 10.0.0.1/32"},
+		{"0.0.0.0/0", 2, "0.0.0.0/2 64.0.0.0/2 128.0.0.0/2 192.0.0.0/2"},
+		{"2001:db8::/126", 127, "2001:db8::/127 2001:db8::2/127"},
+		{"2001:db8::/127", 128, "2001:db8::/128 2001:db8::1/128"},
+		{"::/0", 1, "::/1 8000::/1"},
+		{"10.0.0.0/24", 23, ""},
+		{"10.0.0.0/24", 33, ""},
+		{"2001:db8::/64", 129, ""},
+	} {
+		got, err := SplitPrefix(netip.MustParsePrefix(c.prefix), c.bits)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("split %s into /%d: got %s, want an error", c.prefix, c.bits, joinPrefixes(got))
+			}
+			continue
+		}
+		if err != nil || joinPrefixes(got) != c.want {
+			t.Errorf("split %s into /%d: %s, %v; want %s", c.prefix, c.bits, joinPrefixes(got), err, c.want)
+		}
+	}
+
+	// The cap is on the count, whatever the family.
+	if got, err := SplitPrefix(netip.MustParsePrefix("10.0.0.0/8"), 20); err != nil || len(got) != MaxSubnets {
+		t.Errorf("split into %d subnets: %d, %v", MaxSubnets, len(got), err)
+	}
+	if _, err := SplitPrefix(netip.MustParsePrefix("10.0.0.0/8"), 21); err == nil {
+		t.Error("split past MaxSubnets accepted")
+	}
+	if _, err := SplitPrefix(netip.MustParsePrefix("2001:db8::/32"), 64); err == nil {
+		t.Error("split of a /32 into /64s accepted")
+	}
+
+	for _, c := range []struct {
+		n    int
+		want string
+	}{
+		{1, "10.0.0.0/24"},
+		{2, "10.0.0.0/25 10.0.0.128/25"},
+		{3, "10.0.0.0/26 10.0.0.64/26 10.0.0.128/26 10.0.0.192/26"},
+		{4, "10.0.0.0/26 10.0.0.64/26 10.0.0.128/26 10.0.0.192/26"},
+	} {
+		got, err := SplitPrefixN(netip.MustParsePrefix("10.0.0.0/24"), c.n)
+		if err != nil || joinPrefixes(got) != c.want {
+			t.Errorf("split into %d: %s, %v; want %s", c.n, joinPrefixes(got), err, c.want)
+		}
+	}
+	if _, err := SplitPrefixN(netip.MustParsePrefix("10.0.0.0/24"), 0); err == nil {
+		t.Error("split into 0 accepted")
+	}
+	if _, err := SplitPrefixN(netip.MustParsePrefix("10.0.0.0/31"), 3); err == nil {
+		t.Error("split of a /31 into 3 accepted")
+	}
+}
+
+func TestSummarizePrefixes(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		in   []string
+		want string
+	}{
+		{"halves", []string{"10.0.0.128/25", "10.0.0.0/25"}, "10.0.0.0/24"},
+		{"cascade", []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25"}, "10.0.0.0/24"},
+		{"host routes", []string{"10.0.0.3/32", "10.0.0.1/32", "10.0.0.0/32", "10.0.0.2/32"}, "10.0.0.0/30"},
+		{"odd one out", []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24"}, "10.0.0.0/23 10.0.2.0/24"},
+		// Adjacent but not halves of one parent, so they can't merge.
+		{"not aligned", []string{"10.0.1.0/24", "10.0.2.0/24"}, "10.0.1.0/24 10.0.2.0/24"},
+		{"not aligned hosts", []string{"10.0.0.1/32", "10.0.0.2/32"}, "10.0.0.1/32 10.0.0.2/32"},
+		{"covered", []string{"10.0.5.0/24", "10.0.0.0/16", "10.0.0.0/16", "10.0.255.255/32"}, "10.0.0.0/16"},
+		{"unmasked", []string{"10.0.0.1/24", "10.0.0.0/24"}, "10.0.0.0/24"},
+		{"whole space", []string{"128.0.0.0/1", "0.0.0.0/1"}, "0.0.0.0/0"},
+		{"v6", []string{"2001:db8::2/127", "2001:db8::/127"}, "2001:db8::/126"},
+		{"v6 whole space", []string{"8000::/1", "::/1"}, "::/0"},
+		// The families never merge or cover each other.
+		{"mixed", []string{"::/0", "0.0.0.0/0", "10.0.0.0/8"}, "0.0.0.0/0 ::/0"},
+		{"empty", nil, ""},
+	} {
+		if got := joinPrefixes(SummarizePrefixes(prefixes(c.in...))); got != c.want {
+			t.Errorf("%s: %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPrefixOverlaps(t *testing.T) {
+	pairs := func(ps [][2]netip.Prefix) string {
+		var ss []string
+		for _, p := range ps {
+			ss = append(ss, p[0].String()+"~"+p[1].String())
+		}
+		return strings.Join(ss, " ")
+	}
+	for _, c := range []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{"inside", []string{"10.0.0.0/24"}, []string{"10.0.0.255/32", "10.0.1.0/24", "10.0.0.0/8"},
+			"10.0.0.0/24~10.0.0.255/32 10.0.0.0/24~10.0.0.0/8"},
+		{"touching", []string{"10.0.0.0/25"}, []string{"10.0.0.128/25"}, ""},
+		{"last address", []string{"10.0.0.0/25"}, []string{"10.0.0.127/32", "10.0.0.128/32"}, "10.0.0.0/25~10.0.0.127/32"},
+		{"/31", []string{"10.0.0.0/31"}, []string{"10.0.0.1/32", "10.0.0.2/31"}, "10.0.0.0/31~10.0.0.1/32"},
+		{"/0", []string{"0.0.0.0/0"}, []string{"192.0.2.0/24", "2001:db8::/32"}, "0.0.0.0/0~192.0.2.0/24"},
+		{"v6 /127", []string{"2001:db8::/127"}, []string{"2001:db8::1/128", "2001:db8::2/128"}, "2001:db8::/127~2001:db8::1/128"},
+		{"same", []string{"10.0.0.0/24", "2001:db8::/64"}, []string{"2001:db8::/64", "10.0.0.0/24"},
+			"10.0.0.0/24~10.0.0.0/24 2001:db8::/64~2001:db8::/64"},
+	} {
+		if got := pairs(PrefixOverlaps(prefixes(c.a...), prefixes(c.b...))); got != c.want {
+			t.Errorf("%s: %s, want %s", c.name, got, c.want)
+		}
+	}
+}
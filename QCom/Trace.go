@@ -0,0 +1,271 @@
+package QCom
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TraceUDP = "udp"
+	TraceTCP = "tcp"
+
+	traceBasePort = 33434
+)
+
+type TraceReplyKind int
+
+const (
+	TraceNoReply      TraceReplyKind = iota
+	TraceTimeExceeded                // an intermediate router answered
+	TraceReached                     // the destination answered
+	TraceUnreachable                 // a router reported the destination unreachable
+)
+
+// TraceReply is the outcome of a single probe.
+type TraceReply struct {
+	Kind     TraceReplyKind
+	From     netip.Addr
+	RTT      time.Duration
+	ICMPType uint8
+	ICMPCode uint8
+}
+
+const (
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+)
+
+// parseRecvErr decodes the payload of an IP_RECVERR/IPV6_RECVERR control
+// message: a struct sock_extended_err followed by the offender's sockaddr.
+// Kept free of syscalls so it can be fed synthetic bytes.
+func parseRecvErr(b []byte) (TraceReply, error) {
+	var r TraceReply
+	if len(b) < 16 {
+		return r, errors.New("trace: short extended error")
+	}
+	origin, typ, code := b[4], b[5], b[6]
+	r.ICMPType, r.ICMPCode = typ, code
+	from, err := parseSockaddr(b[16:])
+	if err != nil {
+		return r, err
+	}
+	r.From = from
+	switch origin {
+	case soEEOriginICMP:
+		r.Kind = classifyICMP(false, typ, code)
+	case soEEOriginICMP6:
+		r.Kind = classifyICMP(true, typ, code)
+	default:
+		return r, errors.New("trace: extended error is not from ICMP")
+	}
+	return r, nil
+}
+
+// classifyICMP maps an ICMP error to what it means for a UDP or TCP probe:
+// time exceeded is a hop, port unreachable comes from the target itself.
+func classifyICMP(v6 bool, typ, code uint8) TraceReplyKind {
+	if v6 {
+		switch {
+		case typ == 3:
+			return TraceTimeExceeded
+		case typ == 1 && code == 4:
+			return TraceReached
+		case typ == 1:
+			return TraceUnreachable
+		}
+		return TraceNoReply
+	}
+	switch {
+	case typ == 11:
+		return TraceTimeExceeded
+	case typ == 3 && code == 3:
+		return TraceReached
+	case typ == 3:
+		return TraceUnreachable
+	}
+	return TraceNoReply
+}
+
+func parseSockaddr(b []byte) (netip.Addr, error) {
+	if len(b) < 2 {
+		return netip.Addr{}, errors.New("trace: missing offender address")
+	}
+	switch binary.NativeEndian.Uint16(b) {
+	case 2: // AF_INET
+		if len(b) >= 8 {
+			return netip.AddrFrom4([4]byte(b[4:8])), nil
+		}
+	case 10: // AF_INET6
+		if len(b) >= 24 {
+			return netip.AddrFrom16([16]byte(b[8:24])), nil
+		}
+	}
+	return netip.Addr{}, errors.New("trace: bad offender address")
+}
+
+type TraceHop struct {
+	TTL     int
+	Addr    string
+	Name    string
+	Reached bool
+	Stats   PingStats
+}
+
+type Tracer struct {
+	Target   string
+	Mode     string
+	Port     int
+	MaxHops  int
+	Timeout  time.Duration
+	Interval time.Duration
+	// OnRound is called after every round of probes with a snapshot of the hops.
+	OnRound func([]TraceHop)
+	// Probe sends one probe; it defaults to the platform implementation and
+	// can be swapped out to drive the tracer with canned replies.
+	Probe func(dst netip.Addr, mode string, port, ttl int, timeout time.Duration) (TraceReply, error)
+
+	mu    sync.Mutex
+	hops  map[int]*TraceHop
+	names map[string]string
+	last  int
+}
+
+func NewTracer(target string) *Tracer {
+	return &Tracer{
+		Target:   target,
+		Mode:     TraceUDP,
+		MaxHops:  30,
+		Timeout:  2 * time.Second,
+		Interval: time.Second,
+		Probe:    probeHop,
+		hops:     map[int]*TraceHop{},
+		names:    map[string]string{},
+	}
+}
+
+// Run probes every TTL once per round until ctx is cancelled.
+func (t *Tracer) Run(ctx context.Context) error {
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", t.Target)
+	if err != nil {
+		logger().Warn("trace target not resolved", "target", t.Target, "err", err)
+		return err
+	}
+	dst := ips[0].Unmap()
+	t.last = t.MaxHops
+	for round := 0; ; round++ {
+		if err := t.round(ctx, dst, round); err != nil {
+			logger().Error("trace probe failed", "target", t.Target, "mode", t.Mode, "err", err)
+			return err
+		}
+		if t.OnRound != nil {
+			t.OnRound(t.Hops())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(t.Interval):
+		}
+	}
+}
+
+func (t *Tracer) round(ctx context.Context, dst netip.Addr, round int) error {
+	t.mu.Lock()
+	last := t.last
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var fatal error
+	for ttl := 1; ttl <= last; ttl++ {
+		wg.Add(1)
+		go func(ttl int) {
+			defer wg.Done()
+			port := t.Port
+			switch {
+			case port != 0:
+			case t.Mode == TraceTCP:
+				port = 80
+			default:
+				port = traceBasePort + ttl - 1
+			}
+			r, err := t.Probe(dst, t.Mode, port, ttl, t.Timeout)
+			if err != nil {
+				t.mu.Lock()
+				fatal = err
+				t.mu.Unlock()
+				return
+			}
+			t.record(ctx, ttl, round, r)
+		}(ttl)
+	}
+	wg.Wait()
+	return fatal
+}
+
+func (t *Tracer) record(ctx context.Context, ttl, round int, r TraceReply) {
+	t.mu.Lock()
+	h := t.hops[ttl]
+	if h == nil {
+		h = &TraceHop{TTL: ttl, Stats: PingStats{Target: t.Target}}
+		t.hops[ttl] = h
+	}
+	s := PingSample{Seq: round, Time: time.Now(), RTT: r.RTT, Method: t.Mode, Lost: r.Kind == TraceNoReply}
+	h.Stats.Add(s)
+	if r.Kind != TraceNoReply {
+		h.Addr = r.From.String()
+	}
+	if r.Kind == TraceReached || r.Kind == TraceUnreachable {
+		h.Reached = true
+		// Nothing past the destination can answer, so stop probing there.
+		if ttl < t.last {
+			t.last = ttl
+		}
+	}
+	addr := h.Addr
+	_, named := t.names[addr]
+	if addr != "" && !named {
+		t.names[addr] = ""
+	}
+	t.mu.Unlock()
+
+	if addr != "" && !named {
+		name := reverseLookup(ctx, addr)
+		t.mu.Lock()
+		t.names[addr] = name
+		t.mu.Unlock()
+	}
+}
+
+func reverseLookup(ctx context.Context, addr string) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, addr)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// Hops returns the hops seen so far, up to and including the destination.
+func (t *Tracer) Hops() []TraceHop {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []TraceHop
+	for ttl, h := range t.hops {
+		if ttl > t.last {
+			continue
+		}
+		c := *h
+		c.Name = t.names[h.Addr]
+		c.Stats.History = append([]time.Duration(nil), h.Stats.History...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TTL < out[j].TTL })
+	return out
+}
@@ -0,0 +1,83 @@
+package QCom
+
+import (
+	"errors"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// probeHop sends one UDP datagram or TCP SYN with the given TTL. Rather than
+// needing a raw socket, it sets IP_RECVERR so the kernel queues the ICMP
+// time-exceeded or unreachable reply on the probing socket's error queue.
+func probeHop(dst netip.Addr, mode string, port, ttl int, timeout time.Duration) (TraceReply, error) {
+	var r TraceReply
+	v6 := dst.Is6()
+	family, level, ttlOpt, errOpt := syscall.AF_INET, syscall.IPPROTO_IP, syscall.IP_TTL, syscall.IP_RECVERR
+	if v6 {
+		family, level, ttlOpt, errOpt = syscall.AF_INET6, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, syscall.IPV6_RECVERR
+	}
+	typ := syscall.SOCK_DGRAM
+	if mode == TraceTCP {
+		typ = syscall.SOCK_STREAM
+	}
+	fd, err := syscall.Socket(family, typ|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return r, err
+	}
+	defer syscall.Close(fd)
+
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	for _, o := range []struct{ level, opt, val int }{{level, ttlOpt, ttl}, {level, errOpt, 1}} {
+		if err := syscall.SetsockoptInt(fd, o.level, o.opt, o.val); err != nil {
+			return r, err
+		}
+	}
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_SNDTIMEO, &tv)
+
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{Port: port, Addr: dst.As4()}
+	if v6 {
+		sa = &syscall.SockaddrInet6{Port: port, Addr: dst.As16()}
+	}
+
+	start := time.Now()
+	if mode == TraceTCP {
+		err = syscall.Connect(fd, sa)
+	} else {
+		if err = syscall.Connect(fd, sa); err != nil {
+			return r, err
+		}
+		if _, err = syscall.Write(fd, []byte("qube-trace")); err == nil {
+			_, _, err = syscall.Recvfrom(fd, make([]byte, 512), 0)
+		}
+	}
+	r.RTT = time.Since(start)
+
+	if err == nil || (mode == TraceTCP && errors.Is(err, syscall.ECONNREFUSED)) {
+		r.Kind, r.From = TraceReached, dst
+		return r, nil
+	}
+
+	oob := make([]byte, 512)
+	_, oobn, _, _, rerr := syscall.Recvmsg(fd, make([]byte, 512), oob, syscall.MSG_ERRQUEUE)
+	if rerr != nil {
+		// Nothing queued: the probe simply went unanswered.
+		return TraceReply{}, nil
+	}
+	msgs, perr := syscall.ParseSocketControlMessage(oob[:oobn])
+	if perr != nil {
+		return TraceReply{}, nil
+	}
+	for _, m := range msgs {
+		if m.Header.Level == int32(level) && m.Header.Type == int32(errOpt) {
+			reply, err := parseRecvErr(m.Data)
+			if err != nil {
+				continue
+			}
+			reply.RTT = r.RTT
+			return reply, nil
+		}
+	}
+	return TraceReply{}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package QCom
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+)
+
+func probeHop(dst netip.Addr, mode string, port, ttl int, timeout time.Duration) (TraceReply, error) {
+	return TraceReply{}, errors.New("traceroute is only supported on Linux")
+}
@@ -0,0 +1,225 @@
+package QCom
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recvErr builds an IP_RECVERR payload: a struct sock_extended_err
+// (errno, origin, type, code, pad, info, data) and the offender's
+// sockaddr.
+func recvErr(origin, typ, code uint8, from netip.Addr) []byte {
+	b := binary.NativeEndian.AppendUint32(nil, 113) // EHOSTUNREACH, unused
+	b = append(b, origin, typ, code, 0)
+	b = binary.NativeEndian.AppendUint32(b, 0)
+	b = binary.NativeEndian.AppendUint32(b, 0)
+	return append(b, sockaddr(from)...)
+}
+
+func sockaddr(a netip.Addr) []byte {
+	if !a.IsValid() {
+		return nil
+	}
+	if a.Is4() {
+		b := binary.NativeEndian.AppendUint16(nil, 2)
+		b = append(b, 0, 0)
+		ip := a.As4()
+		return append(append(b, ip[:]...), make([]byte, 8)...)
+	}
+	b := binary.NativeEndian.AppendUint16(nil, 10)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	ip := a.As16()
+	return append(append(b, ip[:]...), 0, 0, 0, 0)
+}
+
+func TestParseRecvErr(t *testing.T) {
+	router := netip.MustParseAddr("10.0.0.1")
+	router6 := netip.MustParseAddr("2001:db8::1")
+	for _, c := range []struct {
+		name string
+		b    []byte
+		kind TraceReplyKind
+		from netip.Addr
+		err  bool
+	}{
+		{"ttl exceeded", recvErr(soEEOriginICMP, 11, 0, router), TraceTimeExceeded, router, false},
+		{"port unreachable", recvErr(soEEOriginICMP, 3, 3, router), TraceReached, router, false},
+		{"host unreachable", recvErr(soEEOriginICMP, 3, 1, router), TraceUnreachable, router, false},
+		{"admin prohibited", recvErr(soEEOriginICMP, 3, 13, router), TraceUnreachable, router, false},
+		{"echo reply", recvErr(soEEOriginICMP, 0, 0, router), TraceNoReply, router, false},
+		{"v6 hop limit", recvErr(soEEOriginICMP6, 3, 0, router6), TraceTimeExceeded, router6, false},
+		{"v6 port unreachable", recvErr(soEEOriginICMP6, 1, 4, router6), TraceReached, router6, false},
+		{"v6 no route", recvErr(soEEOriginICMP6, 1, 0, router6), TraceUnreachable, router6, false},
+		{"local origin", recvErr(1, 0, 0, router), 0, router, true},
+		{"short", recvErr(soEEOriginICMP, 11, 0, router)[:12], 0, netip.Addr{}, true},
+		{"no offender", recvErr(soEEOriginICMP, 11, 0, netip.Addr{}), 0, netip.Addr{}, true},
+		{"cut offender", recvErr(soEEOriginICMP, 11, 0, router)[:20], 0, netip.Addr{}, true},
+		{"cut v6 offender", recvErr(soEEOriginICMP6, 3, 0, router6)[:30], 0, netip.Addr{}, true},
+		{"unknown family", append(recvErr(soEEOriginICMP, 11, 0, netip.Addr{}), 99, 0, 0, 0, 1, 2, 3, 4), 0, netip.Addr{}, true},
+	} {
+		r, err := parseRecvErr(c.b)
+		if (err != nil) != c.err {
+			t.Errorf("%s: error = %v, want error %v", c.name, err, c.err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+		if r.Kind != c.kind || r.From != c.from {
+			t.Errorf("%s: got kind %d from %s, want %d from %s", c.name, r.Kind, r.From, c.kind, c.from)
+		}
+		if r.ICMPType != c.b[5] || r.ICMPCode != c.b[6] {
+			t.Errorf("%s: type/code %d/%d, want %d/%d", c.name, r.ICMPType, r.ICMPCode, c.b[5], c.b[6])
+		}
+	}
+}
+
+func TestICMPChecksum(t *testing.T) {
+	// An echo request for seq 1 with an 8-byte payload; a packet with
+	// its checksum filled in sums to zero.
+	msg := []byte{8, 0, 0, 0, 0, 0, 0, 1, 1, 2, 3, 4, 5, 6, 7, 8}
+	ck := icmpChecksum(msg)
+	if ck != 0xe7ea {
+		t.Errorf("checksum %#04x, want 0xe7ea", ck)
+	}
+	msg[2], msg[3] = byte(ck>>8), byte(ck)
+	if got := icmpChecksum(msg); got != 0 {
+		t.Errorf("checksum over a checksummed packet is %#04x, want 0", got)
+	}
+	// Odd lengths pad the last byte with zero.
+	if icmpChecksum([]byte{0xab}) != icmpChecksum([]byte{0xab, 0}) {
+		t.Error("odd-length packet not padded")
+	}
+}
+
+// cannedTracer traces 127.0.0.1 with probes answered by reply instead of
+// the network, counting the highest TTL probed.
+func cannedTracer(reply func(ttl int) TraceReply) (*Tracer, *atomic.Int32) {
+	var maxTTL atomic.Int32
+	t := NewTracer("127.0.0.1")
+	t.Interval = time.Millisecond
+	t.Probe = func(dst netip.Addr, mode string, port, ttl int, timeout time.Duration) (TraceReply, error) {
+		for {
+			m := maxTTL.Load()
+			if int32(ttl) <= m || maxTTL.CompareAndSwap(m, int32(ttl)) {
+				break
+			}
+		}
+		return reply(ttl), nil
+	}
+	return t, &maxTTL
+}
+
+// runRounds runs t until it has reported the given number of rounds and
+// returns the hops from the last one.
+func runRounds(t *testing.T, tr *Tracer, rounds int) []TraceHop {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var last []TraceHop
+	n := 0
+	tr.OnRound = func(hops []TraceHop) {
+		if n++; n == rounds {
+			last = hops
+			cancel()
+		}
+	}
+	if err := tr.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n != rounds {
+		t.Fatalf("stopped after %d rounds, want %d", n, rounds)
+	}
+	return last
+}
+
+func TestTracerReachesDestination(t *testing.T) {
+	tr, maxTTL := cannedTracer(func(ttl int) TraceReply {
+		switch {
+		case ttl == 2:
+			return TraceReply{Kind: TraceNoReply}
+		case ttl < 4:
+			return TraceReply{Kind: TraceTimeExceeded, From: netip.AddrFrom4([4]byte{127, 0, 1, byte(ttl)}), RTT: time.Duration(ttl) * time.Millisecond}
+		}
+		return TraceReply{Kind: TraceReached, From: netip.MustParseAddr("127.0.0.1"), RTT: 5 * time.Millisecond}
+	})
+	tr.MaxHops = 10
+	hops := runRounds(t, tr, 3)
+
+	want := []string{"127.0.1.1", "", "127.0.1.3", "127.0.0.1"}
+	if len(hops) != len(want) {
+		t.Fatalf("got %d hops, want %d: %+v", len(hops), len(want), hops)
+	}
+	for i, h := range hops {
+		if h.TTL != i+1 || h.Addr != want[i] || h.Reached != (i == 3) {
+			t.Errorf("hop %d: ttl %d addr %q reached %v", i+1, h.TTL, h.Addr, h.Reached)
+		}
+		if h.Stats.Sent != 3 {
+			t.Errorf("hop %d: %d probes sent, want 3", i+1, h.Stats.Sent)
+		}
+	}
+	if hops[1].Stats.Received != 0 || hops[3].Stats.Received != 3 {
+		t.Errorf("received %d at the silent hop and %d at the destination", hops[1].Stats.Received, hops[3].Stats.Received)
+	}
+	// The first round probes every TTL at once; later rounds stop at the
+	// destination, and the hops past it are not reported.
+	if got := maxTTL.Load(); got != 10 {
+		t.Errorf("highest TTL probed %d, want 10", got)
+	}
+}
+
+func TestTracerMaxHops(t *testing.T) {
+	tr, maxTTL := cannedTracer(func(ttl int) TraceReply {
+		if ttl == 1 {
+			return TraceReply{Kind: TraceTimeExceeded, From: netip.MustParseAddr("127.0.1.1")}
+		}
+		return TraceReply{Kind: TraceNoReply}
+	})
+	tr.MaxHops = 5
+	hops := runRounds(t, tr, 2)
+	if len(hops) != 5 || hops[4].TTL != 5 {
+		t.Fatalf("got %+v, want 5 hops", hops)
+	}
+	for _, h := range hops {
+		if h.Reached {
+			t.Errorf("hop %d reported as the destination", h.TTL)
+		}
+	}
+	if got := maxTTL.Load(); got != 5 {
+		t.Errorf("highest TTL probed %d, want 5", got)
+	}
+}
+
+func TestTracerStops(t *testing.T) {
+	// Cancelling during the wait between rounds returns promptly and
+	// without an error.
+	tr, _ := cannedTracer(func(int) TraceReply { return TraceReply{Kind: TraceNoReply} })
+	tr.MaxHops, tr.Interval = 3, time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	tr.OnRound = func([]TraceHop) { cancel() }
+	done := make(chan error, 1)
+	go func() { done <- tr.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("cancelled trace: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("trace kept running after cancel")
+	}
+
+	// A probe that can't be sent ends the trace with its error.
+	tr = NewTracer("127.0.0.1")
+	tr.MaxHops = 3
+	tr.Probe = func(netip.Addr, string, int, int, time.Duration) (TraceReply, error) {
+		return TraceReply{}, errors.New("no socket")
+	}
+	if err := tr.Run(context.Background()); err == nil || err.Error() != "no socket" {
+		t.Errorf("probe failure: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package QCom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// WakePort is the discard port most WoL tools send to; 7 also works.
+const WakePort = 9
+
+// MagicPacket builds the Wake-on-LAN payload for mac: six 0xFF bytes then
+// the address sixteen times, followed by the SecureOn password if any.
+func MagicPacket(mac net.HardwareAddr, password []byte) ([]byte, error) {
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("wake-on-lan needs a 48-bit MAC, got %q", mac)
+	}
+	if n := len(password); n != 0 && n != 4 && n != 6 {
+		return nil, errors.New("SecureOn password must be 4 or 6 bytes")
+	}
+	b := bytes.Repeat([]byte{0xff}, 6)
+	for range 16 {
+		b = append(b, mac...)
+	}
+	return append(b, password...), nil
+}
+
+// ParseSecureOn accepts a password written like a MAC (6 bytes) or like
+// an IPv4 address (4 bytes), the two forms NICs document it in.
+func ParseSecureOn(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(s).To4(); ip != nil && !bytes.ContainsAny([]byte(s), ":-") {
+		return []byte(ip), nil
+	}
+	hw, err := net.ParseMAC(s)
+	if err != nil || len(hw) != 6 {
+		return nil, fmt.Errorf("bad SecureOn password %q: use aa:bb:cc:dd:ee:ff or a.b.c.d", s)
+	}
+	return []byte(hw), nil
+}
+
+// InterfaceBroadcast returns the subnet-directed broadcast address of the
+// first IPv4 network on iface.
+func InterfaceBroadcast(iface string) (net.IP, net.IP, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.To4() == nil {
+			continue
+		}
+		ip, mask := ipn.IP.To4(), ipn.Mask
+		if len(mask) == 16 {
+			mask = mask[12:]
+		}
+		bc := make(net.IP, 4)
+		for i := range bc {
+			bc[i] = ip[i] | ^mask[i]
+		}
+		return bc, ip, nil
+	}
+	return nil, nil, fmt.Errorf("%s has no IPv4 address", iface)
+}
+
+// SendWake broadcasts a magic packet. With iface set the packet leaves
+// from that interface's address, and an empty bcast then means its
+// directed broadcast; otherwise bcast defaults to 255.255.255.255.
+func SendWake(mac net.HardwareAddr, password []byte, iface, bcast string, port int) error {
+	pkt, err := MagicPacket(mac, password)
+	if err != nil {
+		return err
+	}
+	if port == 0 {
+		port = WakePort
+	}
+	var local *net.UDPAddr
+	dst := net.IPv4bcast
+	if iface != "" {
+		ifBcast, ifIP, err := InterfaceBroadcast(iface)
+		if err != nil {
+			logger().Warn("wake interface unusable", "iface", iface, "err", err)
+			return err
+		}
+		local, dst = &net.UDPAddr{IP: ifIP}, ifBcast
+	}
+	if bcast != "" {
+		if dst = net.ParseIP(bcast); dst == nil {
+			return fmt.Errorf("bad broadcast address %q", bcast)
+		}
+	}
+	// Unconnected, so an ICMP unreachable from a unicast target can't
+	// fail the later copies.
+	conn, err := net.ListenUDP("udp4", local)
+	if err != nil {
+		logger().Error("opening wake socket", "local", local, "err", err)
+		return err
+	}
+	defer conn.Close()
+	to := &net.UDPAddr{IP: dst, Port: port}
+	// UDP gives no feedback, so send a few copies in case one drops.
+	for range 3 {
+		if _, err := conn.WriteToUDP(pkt, to); err != nil {
+			logger().Error("sending magic packet", "mac", mac, "to", to, "err", err)
+			return fmt.Errorf("sending to %s: %w", net.JoinHostPort(dst.String(), strconv.Itoa(port)), err)
+		}
+	}
+	return nil
+}
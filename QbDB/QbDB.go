@@ -2,50 +2,161 @@ package QbDB
 
 import (
 	"bytes"
-	"log"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
 
 	"go.mills.io/bitcask/v2"
 )
 
-func CheckForKey(usrk []byte) bool {
-	db, err := bitcask.Open("./db")
+// bitcask holds an exclusive file lock while open, so every caller in the
+// process takes turns through dbMu instead of racing for the lock.
+var dbMu sync.Mutex
+
+// path is the database directory.
+var path = "./db"
+
+// SetPath moves the database; call it before anything opens it.
+func SetPath(p string) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	path = p
+}
+
+// Path is the database directory.
+func Path() string {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	return path
+}
+
+// logger is where QbDB reports; the application decides where that goes.
+func logger() *slog.Logger {
+	return slog.Default().With("component", "QbDB")
+}
+
+// open is bitcask.Open on the one database path, logging failures.
+// Callers must hold dbMu.
+func open() (*bitcask.Bitcask, error) {
+	db, err := bitcask.Open(path)
+	if err != nil {
+		logger().Error("opening database", "path", path, "err", err)
+	}
+	return db, err
+}
+
+func CheckForKey(usrk []byte) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	db, err := open()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	return db.Has(usrk), nil
+}
+
+func ValueMatchesKey(userk []byte, userp []byte) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	db, err := open()
+	if err != nil {
+		return false, err
+	}
 	defer db.Close()
-	if err == nil {
-		t := db.Has(usrk)
-		return t
-	} else {
-		log.Fatal(err)
-		return false
+	get, err := db.Get(userk)
+	if err != nil {
+		logger().Error("reading user", "err", err)
+		return false, err
 	}
+	return bytes.Equal(userp, get), nil
 }
 
-func ValueMatchesKey(userk []byte, userp []byte) bool {
-	var checker bool = false
-	db, err1 := bitcask.Open("./db")
+func NewKeyValue(userk []byte, userp []byte) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	db, err := open()
+	if err != nil {
+		return err
+	}
 	defer db.Close()
-	if err1 == nil {
-		get, err2 := db.Get(userk)
-		if err2 == nil {
-			checker = bytes.Equal(userp, get)
-			return checker
-		} else {
-			log.Fatal(err2)
-			checker = false
-			return checker
+	if err := db.Put(userk, userp); err != nil {
+		logger().Error("adding user", "err", err)
+		return err
+	}
+	return nil
+}
+
+// SaveRecord stores v as JSON under key. Records live alongside the user
+// table, so keys should carry a "kind:" prefix such as "scan:".
+func SaveRecord(key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Put([]byte(key), b); err != nil {
+		logger().Error("saving record", "key", key, "err", err)
+		return err
+	}
+	return nil
+}
+
+func LoadRecord(key string, v any) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	b, err := db.Get([]byte(key))
+	if err != nil {
+		if !errors.Is(err, bitcask.ErrKeyNotFound) {
+			logger().Error("loading record", "key", key, "err", err)
 		}
-	} else {
-		log.Fatal(err1)
-		checker = false
-		return checker
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		// Usually a record written by an older build.
+		logger().Warn("decoding record", "key", key, "err", err)
+		return err
 	}
+	return nil
 }
 
-func NewKeyValue(userk []byte, userp []byte) {
-	db, err := bitcask.Open("./db")
+// ListRecords returns every record key starting with prefix.
+func ListRecords(prefix string) ([]string, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
 	defer db.Close()
-	if err == nil {
-		db.Put(userk, userp)
-	} else {
-		log.Fatal(err)
+	var keys []string
+	err = db.Scan([]byte(prefix), func(k bitcask.Key) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	return keys, err
+}
+
+func DeleteRecord(key string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	db, err := open()
+	if err != nil {
+		return err
 	}
+	defer db.Close()
+	logger().Debug("deleting record", "key", key)
+	return db.Delete([]byte(key))
 }
@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Carsen/Qube/QbDB"
+)
+
+// localAdmin reports whether -admin is allowed: for root, or for the user
+// that owns the database (its parent directory, before it exists).
+func localAdmin() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	fi, err := os.Stat(QbDB.Path())
+	if os.IsNotExist(err) {
+		fi, err = os.Stat(filepath.Dir(QbDB.Path()))
+	}
+	if err != nil {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Geteuid()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/rivo/tview"
+)
+
+func init() { registerPanel("capture", capturePanel) }
+
+func capturePanel(app *tview.Application) Panel {
+	var (
+		mu      sync.Mutex
+		cancel  context.CancelFunc
+		pending []QCom.CapturedPacket
+		total   int
+		dropped int
+		// done is closed once the session's capture goroutine has let go
+		// of pending and the save file; run counts sessions so a stopped
+		// one's last status update can tell it is stale. Both belong to
+		// the UI goroutine.
+		done chan struct{}
+		run  int
+	)
+
+	view := newPacketView()
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm().SetHorizontal(true)
+	body, toggleFlows := withFlows(app, view, form)
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+
+	var ifaces []string
+	if list, err := net.Interfaces(); err == nil {
+		for _, ifi := range list {
+			if ifi.Flags&net.FlagUp != 0 {
+				ifaces = append(ifaces, ifi.Name)
+			}
+		}
+	}
+
+	counts := func() string {
+		return fmt.Sprintf("%d captured, %d shown", total, len(view.shown))
+	}
+
+	// flush moves queued packets into the view; called on the UI goroutine
+	// so a busy link repaints a few times a second rather than per packet.
+	flush := func() {
+		mu.Lock()
+		batch := pending
+		pending = nil
+		mu.Unlock()
+		for _, c := range batch {
+			total++
+			if len(view.packets) >= config.Capture.MaxPackets {
+				dropped++
+				continue
+			}
+			view.add(QCom.DecodePacket(total, c))
+		}
+		if len(batch) > 0 && len(view.shown) > 0 {
+			view.list.ScrollToEnd()
+		}
+	}
+
+	// stop waits for the capture to let go, which takes up to the socket's
+	// 200ms read timeout.
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			cancel = nil
+			<-done
+		}
+	}
+
+	start := func() {
+		stop()
+		_, iface := form.GetFormItemByLabel("Interface").(*tview.DropDown).GetCurrentOption()
+		if iface == "" {
+			status.SetText("[red]no interface selected")
+			return
+		}
+		linkType := QCom.InterfaceLinkType(iface)
+		snaplen := config.Capture.Snaplen
+		prog, err := QCom.CompileBPF(field("Capture"), linkType, snaplen)
+		if err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		var out *os.File
+		var pw *QCom.PcapngWriter
+		path := field("Save to")
+		if path != "" {
+			if out, err = os.Create(path); err != nil {
+				status.SetText("[red]" + err.Error())
+				return
+			}
+			if pw, err = QCom.NewPcapngWriter(out, iface, linkType, snaplen); err != nil {
+				out.Close()
+				status.SetText("[red]" + err.Error())
+				return
+			}
+		}
+
+		view.reset()
+		total, dropped = 0, 0
+		mu.Lock()
+		pending = nil
+		mu.Unlock()
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		run++
+		gen, finished := run, done
+		status.SetText("Capturing on " + iface + "...")
+
+		// saveErr is the first error writing the file; the capture goes on
+		// without saving, and the status line keeps saying so.
+		var saveErr error
+		saveFailed := func(err error) {
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			first := saveErr == nil
+			if first {
+				saveErr = err
+			}
+			mu.Unlock()
+			if first {
+				toast(sevError, "Saving capture to %s failed: %v", path, err)
+			}
+		}
+		saveNote := func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			if saveErr == nil {
+				return ""
+			}
+			return " [red]not saved: " + saveErr.Error() + "[-]"
+		}
+
+		go func() {
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					app.QueueUpdateDraw(func() {
+						if gen != run {
+							return
+						}
+						flush()
+						status.SetText("Capturing on " + iface + ": " + counts() + saveNote())
+					})
+				}
+			}
+		}()
+
+		go func() {
+			err := QCom.CaptureLive(ctx, iface, prog, snaplen, func(c QCom.CapturedPacket) {
+				if pw != nil {
+					if err := pw.WritePacket(c); err != nil {
+						saveFailed(err)
+						pw = nil
+					}
+				}
+				mu.Lock()
+				pending = append(pending, c)
+				mu.Unlock()
+			})
+			if pw != nil {
+				saveFailed(pw.Flush())
+			}
+			if out != nil {
+				saveFailed(out.Close())
+			}
+			close(finished)
+			if err != nil {
+				toast(sevError, "Capture on %s stopped: %v", iface, err)
+			}
+			app.QueueUpdateDraw(func() {
+				if gen != run {
+					return
+				}
+				flush()
+				msg := "Stopped: " + counts()
+				if dropped > 0 {
+					msg += fmt.Sprintf(" ([orange]%d not kept in view[-])", dropped)
+				}
+				msg += saveNote()
+				if err != nil {
+					msg = "[red]" + err.Error() + "[-] " + msg
+				}
+				status.SetText(msg)
+			})
+		}()
+	}
+
+	apply := func() {
+		f, err := QCom.CompileFilter(field("Display"))
+		if err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		view.setFilter(f)
+		status.SetText(counts())
+	}
+
+	form.AddDropDown("Interface", ifaces, optionIndex(ifaces, config.Capture.Interface), nil).
+		AddInputField("Capture", config.Capture.Filter, 24, nil, nil).
+		AddInputField("Display", "", 24, nil, nil).
+		AddInputField("Save to", "", 20, nil, nil).
+		AddButton("Start", start).
+		AddButton("Stop", stop).
+		AddButton("Apply", apply).
+		AddButton("Flows", toggleFlows)
+
+	if err := QCom.CaptureAvailable(); err != nil {
+		msg := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true).
+			SetText("[orange]Live capture is unavailable.[-]\n\n" + tview.Escape(err.Error()) +
+				"\n\nSaved captures can still be opened under Capture Files.")
+		msg.SetBorder(true).SetTitle(" Live Capture ")
+		return &basicPanel{title: "Live Capture", prim: msg}
+	}
+
+	return &basicPanel{
+		title: "Live Capture",
+		prim: tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(form, 3, 0, true).
+			AddItem(body, 0, 1, false).
+			AddItem(status, 1, 0, false),
+		keys: flowKeys,
+		stop: stop,
+	}
+}
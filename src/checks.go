@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// alertSettings says how transitions are announced besides the history.
+type alertSettings struct {
+	Bell    bool   `json:"bell"`
+	Command string `json:"command"`
+	Webhook string `json:"webhook"`
+}
+
+type checkState struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+}
+
+func loadChecks() []QCom.Check {
+	keys, _ := QbDB.ListRecords("check:")
+	var checks []QCom.Check
+	for _, k := range keys {
+		var c QCom.Check
+		if QbDB.LoadRecord(k, &c) == nil {
+			checks = append(checks, c)
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+	return checks
+}
+
+// recordTransition stores t under a time-ordered key and trims the oldest
+// entries past checks.history.
+func recordTransition(t QCom.Transition) error {
+	if err := QbDB.SaveRecord(fmt.Sprintf("alert:%020d", t.Time.UnixNano()), t); err != nil {
+		return err
+	}
+	if err := QbDB.SaveRecord("checkstate:"+t.Check, checkState{t.To, t.Time}); err != nil {
+		return err
+	}
+	keys, err := QbDB.ListRecords("alert:")
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	for len(keys) > config.Checks.History {
+		QbDB.DeleteRecord(keys[0])
+		keys = keys[1:]
+	}
+	return nil
+}
+
+func loadTransitions(n int) []QCom.Transition {
+	keys, _ := QbDB.ListRecords("alert:")
+	sort.Strings(keys)
+	if len(keys) > n {
+		keys = keys[len(keys)-n:]
+	}
+	var out []QCom.Transition
+	for _, k := range keys {
+		var t QCom.Transition
+		if QbDB.LoadRecord(k, &t) == nil {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func stateColor(state string) tcell.Color {
+	switch state {
+	case QCom.StateUp:
+		return tcell.ColorGreen
+	case QCom.StateDegraded:
+		return tcell.ColorOrange
+	case QCom.StateDown:
+		return tcell.ColorRed
+	}
+	return tcell.ColorGray
+}
+
+func stateSeverity(state string) severity {
+	switch state {
+	case QCom.StateUp:
+		return sevSuccess
+	case QCom.StateDegraded:
+		return sevWarn
+	case QCom.StateDown:
+		return sevError
+	}
+	return sevInfo
+}
+
+func init() { registerPanel("uptime", checksPanel) }
+
+func checksPanel(app *tview.Application) Panel {
+	var (
+		mu       sync.Mutex
+		cancel   context.CancelFunc
+		sched    *QCom.CheckScheduler
+		checks   = loadChecks()
+		settings alertSettings
+	)
+	// The config's alerts apply until some are saved here.
+	if QbDB.LoadRecord("alertcfg", &settings) != nil {
+		settings = alertSettings{Bell: config.Checks.Bell, Command: config.Checks.Command, Webhook: config.Checks.Webhook}
+	}
+
+	board := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	board.SetBorder(true).SetTitle(" Status ")
+	history := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	history.SetBorder(true).SetTitle(" Transitions ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	setField := func(label, v string) {
+		form.GetFormItemByLabel(label).(*tview.InputField).SetText(v)
+	}
+	number := func(label string) int {
+		n, _ := strconv.Atoi(field(label))
+		return n
+	}
+
+	renderBoard := func() {
+		board.Clear()
+		for i, h := range []string{"Check", "Kind", "Target", "State", "Latency", "Last", "Since", "Reason"} {
+			board.SetCell(0, i, tview.NewTableCell(h).
+				SetTextColor(tcell.ColorYellow).
+				SetSelectable(false))
+		}
+		mu.Lock()
+		s := sched
+		mu.Unlock()
+		if s == nil {
+			return
+		}
+		for r, st := range s.Status() {
+			last, lat, since := "-", "-", "-"
+			if !st.Last.Time.IsZero() {
+				last = st.Last.Time.Format(time.TimeOnly)
+				if st.Last.OK {
+					lat = fmtRTT(st.Last.Latency)
+				}
+			}
+			if !st.Since.IsZero() {
+				since = time.Since(st.Since).Round(time.Second).String()
+			}
+			reason := st.Reason
+			if reason == "" && !st.Last.OK {
+				reason = st.Last.Err
+			}
+			for c, v := range []string{st.Check.Name, st.Check.Kind, st.Check.Target, st.State, lat, last, since, reason} {
+				cell := tview.NewTableCell(tview.Escape(v))
+				if c == 3 {
+					cell.SetTextColor(stateColor(st.State))
+				}
+				if c == 7 {
+					cell.SetExpansion(1)
+				}
+				board.SetCell(r+1, c, cell)
+			}
+		}
+	}
+
+	renderHistory := func() {
+		var sb strings.Builder
+		ts := loadTransitions(200)
+		for i := len(ts) - 1; i >= 0; i-- {
+			t := ts[i]
+			fmt.Fprintf(&sb, "%s  %-16s [%s]%s[-] → [%s]%s[-]  %s\n",
+				t.Time.Format(time.DateTime), tview.Escape(t.Check),
+				stateColor(t.From).Name(), t.From, stateColor(t.To).Name(), t.To,
+				tview.Escape(t.Reason))
+		}
+		history.SetText(sb.String())
+	}
+
+	// failed reports errors from recording or delivering an alert.
+	failed := func(t QCom.Transition, errs []string) {
+		slog.Warn("alert delivery failed", "check", t.Check, "err", strings.Join(errs, "; "))
+		toast(sevWarn, "Alert for %s not delivered: %s", t.Check, strings.Join(errs, "; "))
+		app.QueueUpdateDraw(func() {
+			status.SetText("[red]" + tview.Escape(strings.Join(errs, "; ")))
+		})
+	}
+
+	// deliver runs the alert hooks. A slow command or webhook can take
+	// tens of seconds, so it runs on its own goroutine rather than the
+	// check's, which would otherwise miss its next probe.
+	deliver := func(cfg alertSettings, t QCom.Transition) {
+		var errs []string
+		if cfg.Command != "" {
+			if err := QCom.NotifyCommand(context.Background(), cfg.Command, t); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if cfg.Webhook != "" {
+			if err := QCom.NotifyWebhook(context.Background(), cfg.Webhook, t); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			failed(t, errs)
+		}
+	}
+
+	notify := func(t QCom.Transition) {
+		mu.Lock()
+		cfg := settings
+		mu.Unlock()
+		recordErr := recordTransition(t)
+		// Coming out of unknown is just the first result, not news.
+		if t.From != QCom.StateUnknown {
+			if cfg.Bell {
+				bell()
+			}
+			if cfg.Command != "" || cfg.Webhook != "" {
+				go deliver(cfg, t)
+			}
+			msg := fmt.Sprintf("%s is %s", t.Check, t.To)
+			if t.Reason != "" {
+				msg += ": " + t.Reason
+			}
+			toast(stateSeverity(t.To), "%s", msg)
+		}
+		app.QueueUpdateDraw(func() {
+			renderHistory()
+			status.SetText(fmt.Sprintf("%s is %s", tview.Escape(t.Check), t.To))
+		})
+		if recordErr != nil {
+			failed(t, []string{recordErr.Error()})
+		}
+	}
+
+	// restart replaces the scheduler whenever the check list changes.
+	restart := func() {
+		mu.Lock()
+		if cancel != nil {
+			cancel()
+		}
+		s := QCom.NewCheckScheduler(checks)
+		for _, c := range checks {
+			var cs checkState
+			if QbDB.LoadRecord("checkstate:"+c.Name, &cs) == nil {
+				s.Restore(c.Name, cs.State, cs.Since)
+			}
+		}
+		s.OnResult = func(QCom.CheckStatus) { app.QueueUpdateDraw(renderBoard) }
+		s.OnTransition = notify
+		ctx, c := context.WithCancel(context.Background())
+		cancel, sched = c, s
+		mu.Unlock()
+		go s.Run(ctx)
+		// The Since column ages even when no check has reported.
+		go func() {
+			tick := time.NewTicker(5 * time.Second)
+			defer tick.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick.C:
+					app.QueueUpdateDraw(renderBoard)
+				}
+			}
+		}()
+		renderBoard()
+	}
+
+	add := func() {
+		_, kind := form.GetFormItemByLabel("Kind").(*tview.DropDown).GetCurrentOption()
+		c := QCom.Check{
+			Name:     field("Name"),
+			Kind:     kind,
+			Target:   field("Target"),
+			Interval: time.Duration(number("Interval s")) * time.Second,
+			Rules: QCom.AlertRules{
+				FailAfter:  number("Fail after"),
+				MaxLatency: time.Duration(number("Max ms")) * time.Millisecond,
+				CertDays:   number("Cert days"),
+			},
+		}
+		if err := c.Validate(); err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		// Keys are capped at 64 bytes, prefix included.
+		if len("checkstate:"+c.Name) > 64 {
+			status.SetText("[red]check name is too long")
+			return
+		}
+		if err := QbDB.SaveRecord("check:"+c.Name, c); err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		replaced := false
+		for i := range checks {
+			if checks[i].Name == c.Name {
+				checks[i], replaced = c, true
+			}
+		}
+		if !replaced {
+			checks = append(checks, c)
+		}
+		restart()
+		status.SetText("Saved check " + tview.Escape(c.Name))
+	}
+
+	remove := func() {
+		name := field("Name")
+		for i := range checks {
+			if checks[i].Name == name {
+				checks = append(checks[:i], checks[i+1:]...)
+				QbDB.DeleteRecord("check:" + name)
+				QbDB.DeleteRecord("checkstate:" + name)
+				restart()
+				status.SetText("Removed check " + tview.Escape(name))
+				return
+			}
+		}
+		status.SetText("[red]no check named " + tview.Escape(name))
+	}
+
+	saveAlerts := func() {
+		mu.Lock()
+		settings = alertSettings{
+			Bell:    form.GetFormItemByLabel("Bell").(*tview.Checkbox).IsChecked(),
+			Command: field("Command"),
+			Webhook: field("Webhook"),
+		}
+		cfg := settings
+		mu.Unlock()
+		if err := QbDB.SaveRecord("alertcfg", cfg); err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		status.SetText("Saved notification settings")
+	}
+
+	// Selecting a row loads the check into the form for editing.
+	board.SetSelectedFunc(func(row, _ int) {
+		if row < 1 || row-1 >= len(checks) {
+			return
+		}
+		mu.Lock()
+		st := sched.Status()[row-1]
+		mu.Unlock()
+		c := st.Check
+		setField("Name", c.Name)
+		setField("Target", c.Target)
+		setField("Interval s", strconv.Itoa(int(c.Interval/time.Second)))
+		setField("Fail after", strconv.Itoa(c.Rules.FailAfter))
+		setField("Max ms", strconv.Itoa(int(c.Rules.MaxLatency/time.Millisecond)))
+		setField("Cert days", strconv.Itoa(c.Rules.CertDays))
+		for i, k := range QCom.CheckKinds {
+			if k == c.Kind {
+				form.GetFormItemByLabel("Kind").(*tview.DropDown).SetCurrentOption(i)
+			}
+		}
+	})
+
+	form.AddInputField("Name", "", 0, nil, nil).
+		AddDropDown("Kind", QCom.CheckKinds, 1, nil).
+		AddInputField("Target", "", 0, nil, nil).
+		AddInputField("Interval s", strconv.Itoa(int(config.Checks.Interval/time.Second)), 6, tview.InputFieldInteger, nil).
+		AddInputField("Fail after", strconv.Itoa(config.Checks.FailAfter), 6, tview.InputFieldInteger, nil).
+		AddInputField("Max ms", "0", 6, tview.InputFieldInteger, nil).
+		AddInputField("Cert days", strconv.Itoa(config.Checks.CertDays), 6, tview.InputFieldInteger, nil).
+		AddCheckbox("Bell", settings.Bell, nil).
+		AddInputField("Command", settings.Command, 0, nil, nil).
+		AddInputField("Webhook", settings.Webhook, 0, nil, nil).
+		AddButton("Save", add).
+		AddButton("Remove", remove).
+		AddButton("Alerts", saveAlerts)
+	form.SetBorder(true).SetTitle(" Uptime Checks ")
+
+	renderHistory()
+
+	return &basicPanel{
+		title: "Uptime",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(board, 0, 2, false).
+				AddItem(history, 0, 1, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		keys: []KeyHint{{"Enter", "edit the selected check"}},
+		refresh: func() {
+			renderBoard()
+			renderHistory()
+		},
+		start: restart,
+		stop: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if cancel != nil {
+				cancel()
+				cancel = nil
+			}
+		},
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	osuser "os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Carsen/Qube/Login"
+	"gopkg.in/yaml.v3"
+)
+
+const cliUsage = `usage: qube [command] [flags] [args]
+
+With no command qube asks you to log in and starts the interactive tool.
+
+Commands:
+  ifaces                                      list network interfaces
+  scan [-p ports] [-all] targets...           TCP connect scan
+  dns [-t type] [-s server] [-tcp] [-x] name  look up a name or, with -x, an address
+  ping [-c count] [-i interval] [-m method] host
+  trace [-m udp|tcp] [-r rounds] [-max hops] host
+  http [-X method] [-k] url                   time a request and its redirects
+  subnet prefix...                            addresses, masks and host counts
+  discover -i iface [prefix]                  sweep the local network
+  wol [-i iface] [-b bcast] [-pass secureon] mac|device
+  user add|passwd|del name                    manage accounts; the password is read from stdin
+  token create -user name [label]             issue an API token
+  token list | token revoke id
+  config show                                 the effective configuration and where it came from
+  perf server|client ...                      throughput test, see "qube perf"
+
+Every command takes:
+  -json, -yaml   print machine-readable output instead of a table
+and all but perf, which needs no login, also take:
+  -token tok     authenticate with an API token, or set QUBE_TOKEN
+  -admin         local admin mode, for root or the owner of the database;
+                 user and token need it
+  -set key=val   override a config value, e.g. -set ping.count=10
+
+Configuration is read from /etc/qube/config.toml, then config.toml in the
+user config directory (either may be .yaml instead), then QUBE_SECTION_KEY
+environment variables such as QUBE_PING_COUNT, then flags. The auth keys
+are policy: anything after the system file can only tighten them.
+
+Exit status is 0 on success, 1 when the command failed or its result was
+negative (no reply, no open ports, an error status), 2 for usage errors
+and 3 when not authorised.`
+
+const (
+	exitOK    = 0
+	exitFail  = 1
+	exitUsage = 2
+	exitAuth  = 3
+)
+
+// cli is what a command needs to report back.
+type cli struct {
+	name   string
+	format string // "table", "json" or "yaml"
+	out    io.Writer
+	// configErr is what is wrong with the configuration. Only public
+	// commands run despite it.
+	configErr error
+}
+
+// cliCommand is one "qube <name>" subcommand.
+type cliCommand struct {
+	// admin commands need local admin mode; a token isn't enough. public
+	// ones need neither.
+	admin, public bool
+	// setup adds the command's own flags and returns what runs it.
+	setup func(fs *flag.FlagSet) func(ctx context.Context, c *cli, args []string) int
+}
+
+var cliCommands = map[string]cliCommand{
+	"ifaces":   {setup: ifacesCommand},
+	"scan":     {setup: scanCommand},
+	"dns":      {setup: dnsCommand},
+	"ping":     {setup: pingCommand},
+	"trace":    {setup: traceCommand},
+	"http":     {setup: httpCommand},
+	"subnet":   {setup: subnetCommand},
+	"discover": {setup: discoverCommand},
+	"wol":      {setup: wolCommand},
+	"user":     {admin: true, setup: userCommand},
+	"token":    {admin: true, setup: tokenCommand},
+	"config":   {public: true, setup: configCommand},
+}
+
+// runCLI runs "qube <command> ..." without the login prompt or the TUI
+// and returns the process exit code.
+func runCLI(args []string) int {
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Println(cliUsage)
+		return exitOK
+	case "perf":
+		return runPerf(args[1:])
+	}
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "qube: unknown command %q\n\n%s\n", args[0], cliUsage)
+		return exitUsage
+	}
+
+	c := &cli{name: args[0], format: "table", out: os.Stdout}
+	// Flags default to the configured values, so the files and the
+	// environment are read first. "config show" reports its own errors.
+	c.configErr = loadConfig()
+	if c.configErr != nil && !cmd.public {
+		return c.usage(c.configErr.Error())
+	}
+	fs := flag.NewFlagSet("qube "+c.name, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	asYAML := fs.Bool("yaml", false, "print YAML")
+	token := fs.String("token", "", "API token (default $QUBE_TOKEN)")
+	admin := fs.Bool("admin", false, "local admin mode")
+	fs.Var(setFlag{}, "set", "override a config value, as section.key=value")
+	run := cmd.setup(fs)
+	rest, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := f.Value.(*configValue); ok {
+			configFrom[v.key] = "flag -" + f.Name
+		}
+	})
+	if c.configErr == nil {
+		c.configErr = validateConfig()
+	}
+	if c.configErr != nil && !cmd.public {
+		return c.usage(c.configErr.Error())
+	}
+	applyConfig()
+	switch {
+	case *asJSON && *asYAML:
+		return c.usage("-json and -yaml can't be used together")
+	case *asJSON:
+		c.format = "json"
+	case *asYAML:
+		c.format = "yaml"
+	}
+	if *token == "" {
+		*token = os.Getenv("QUBE_TOKEN")
+	}
+	if !cmd.public {
+		if code := c.authorise(*token, *admin, cmd.admin); code != exitOK {
+			return code
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return run(ctx, c, rest)
+}
+
+// parseFlags lets flags come after the arguments as well as before, as in
+// "qube scan 10.0.0.1 -p 22".
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		left := fs.Args()
+		if n := len(args) - len(left); n > 0 && args[n-1] == "--" {
+			return append(rest, left...), nil
+		}
+		if len(left) == 0 {
+			return rest, nil
+		}
+		rest, args = append(rest, left[0]), left[1:]
+	}
+}
+
+// authorise logs in with the token, or as the local user in admin mode.
+func (c *cli) authorise(token string, admin, needAdmin bool) int {
+	switch {
+	case admin && !config.Auth.Admin:
+		return c.deny("local admin mode is turned off by auth.admin")
+	case admin:
+		if !localAdmin() {
+			return c.deny("-admin is only for root or the owner of the database")
+		}
+		name := "admin"
+		if u, err := osuser.Current(); err == nil {
+			name = u.Username
+		}
+		Login.UseLocal(name)
+		return exitOK
+	case needAdmin:
+		return c.deny("needs -admin")
+	case token == "":
+		return c.deny("not logged in: pass -token or set QUBE_TOKEN, or use -admin")
+	case !config.Auth.Tokens:
+		return c.deny("API tokens are turned off by auth.tokens")
+	}
+	if err := Login.UseToken(token); err != nil {
+		return c.deny(err.Error())
+	}
+	return exitOK
+}
+
+func (c *cli) deny(msg string) int {
+	fmt.Fprintf(os.Stderr, "qube %s: %s\n", c.name, msg)
+	return exitAuth
+}
+
+func (c *cli) usage(msg string) int {
+	fmt.Fprintf(os.Stderr, "qube %s: %s\n", c.name, msg)
+	return exitUsage
+}
+
+func (c *cli) fail(err error) int {
+	fmt.Fprintf(os.Stderr, "qube %s: %v\n", c.name, err)
+	return exitFail
+}
+
+// emit prints v as JSON or YAML for scripts, or has table print it for
+// people.
+func (c *cli) emit(v any, table func(w io.Writer)) error {
+	switch c.format {
+	case "json":
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = c.out.Write(b)
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// result turns emit's error and whether the outcome was good into an
+// exit code.
+func (c *cli) result(err error, ok bool) int {
+	switch {
+	case err != nil:
+		return c.fail(err)
+	case !ok:
+		return exitFail
+	}
+	return exitOK
+}
+
+// millis is d in milliseconds to two places, for output fields.
+func millis(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())/10) / 100
+}
+
+// readSecret takes the first line of stdin, so passwords can be piped in
+// rather than put on the command line.
+func readSecret() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", errors.New("expected the password on stdin")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+type userResult struct {
+	User   string `json:"user" yaml:"user"`
+	Action string `json:"action" yaml:"action"`
+}
+
+func userCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	return func(_ context.Context, c *cli, args []string) int {
+		if len(args) != 2 {
+			return c.usage("usage: qube user add|passwd|del name")
+		}
+		name := args[1]
+		var err error
+		var done string
+		switch args[0] {
+		case "add", "passwd":
+			var pw string
+			if pw, err = readSecret(); err != nil {
+				return c.fail(err)
+			}
+			if args[0] == "add" {
+				err, done = Login.AddUser(name, pw), "added"
+			} else {
+				err, done = Login.SetPassword(name, pw), "password changed"
+			}
+		case "del":
+			err, done = Login.DeleteUser(name), "deleted"
+		default:
+			return c.usage("usage: qube user add|passwd|del name")
+		}
+		if err != nil {
+			return c.fail(err)
+		}
+		return c.result(c.emit(userResult{name, done}, func(w io.Writer) {
+			fmt.Fprintf(w, "%s: %s\n", name, done)
+		}), true)
+	}
+}
+
+type tokenResult struct {
+	Login.Token `yaml:",inline"`
+	// Secret is only known when the token is created.
+	Secret string `json:"token,omitempty" yaml:"token,omitempty"`
+}
+
+func tokenCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	owner := fs.String("user", "", "user the new token acts as")
+	return func(_ context.Context, c *cli, args []string) int {
+		if len(args) == 0 {
+			return c.usage("usage: qube token create -user name [label] | list | revoke id")
+		}
+		switch args[0] {
+		case "create":
+			if *owner == "" || len(args) > 2 {
+				return c.usage("usage: qube token create -user name [label]")
+			}
+			label := ""
+			if len(args) == 2 {
+				label = args[1]
+			}
+			secret, t, err := Login.NewToken(*owner, label)
+			if err != nil {
+				return c.fail(err)
+			}
+			return c.result(c.emit(tokenResult{t, secret}, func(w io.Writer) {
+				fmt.Fprintf(w, "Token %s for %s. It won't be shown again:\n%s\n", t.ID, t.User, secret)
+			}), true)
+		case "list":
+			tokens, err := Login.Tokens()
+			if err != nil {
+				return c.fail(err)
+			}
+			out := make([]tokenResult, len(tokens))
+			for i, t := range tokens {
+				out[i] = tokenResult{Token: t}
+			}
+			return c.result(c.emit(out, func(w io.Writer) {
+				fmt.Fprintln(w, "ID\tUSER\tLABEL\tCREATED")
+				for _, t := range tokens {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ID, t.User, t.Name, t.Created.Local().Format(time.DateTime))
+				}
+			}), true)
+		case "revoke":
+			if len(args) != 2 {
+				return c.usage("usage: qube token revoke id")
+			}
+			if err := Login.RevokeToken(args[1]); err != nil {
+				return c.fail(err)
+			}
+			return c.result(c.emit(map[string]string{"revoked": args[1]}, func(w io.Writer) {
+				fmt.Fprintf(w, "Revoked %s\n", args[1])
+			}), true)
+		}
+		return c.usage("usage: qube token create -user name [label] | list | revoke id")
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+)
+
+type ifaceResult struct {
+	Name  string   `json:"name" yaml:"name"`
+	Index int      `json:"index" yaml:"index"`
+	MTU   int      `json:"mtu" yaml:"mtu"`
+	MAC   string   `json:"mac,omitempty" yaml:"mac,omitempty"`
+	Flags []string `json:"flags" yaml:"flags"`
+	Addrs []string `json:"addrs" yaml:"addrs"`
+}
+
+func ifacesCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	return func(_ context.Context, c *cli, args []string) int {
+		if len(args) > 0 {
+			return c.usage("takes no arguments")
+		}
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return c.fail(err)
+		}
+		out := []ifaceResult{}
+		for _, ifi := range ifaces {
+			r := ifaceResult{Name: ifi.Name, Index: ifi.Index, MTU: ifi.MTU, MAC: ifi.HardwareAddr.String(),
+				Flags: strings.Split(ifi.Flags.String(), "|"), Addrs: []string{}}
+			addrs, _ := ifi.Addrs()
+			for _, a := range addrs {
+				r.Addrs = append(r.Addrs, a.String())
+			}
+			out = append(out, r)
+		}
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "NAME\tMTU\tMAC\tFLAGS\tADDRESSES")
+			for _, r := range out {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", r.Name, r.MTU, r.MAC, strings.Join(r.Flags, ","), strings.Join(r.Addrs, " "))
+			}
+		}), true)
+	}
+}
+
+type scanResult struct {
+	Host      string  `json:"host" yaml:"host"`
+	Port      int     `json:"port" yaml:"port"`
+	State     string  `json:"state" yaml:"state"`
+	Banner    string  `json:"banner,omitempty" yaml:"banner,omitempty"`
+	LatencyMS float64 `json:"latency_ms" yaml:"latency_ms"`
+}
+
+// scanCommand exits 1 when no port was open.
+func scanCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	configFlag(fs, "p", "scan.ports", "ports, e.g. 22,80,8000-8100")
+	all := fs.Bool("all", false, "list closed and filtered ports too")
+	configFlag(fs, "timeout", "scan.timeout", "connect timeout")
+	configFlag(fs, "workers", "scan.workers", "concurrent probes")
+	return func(ctx context.Context, c *cli, args []string) int {
+		if len(args) == 0 {
+			return c.usage("no targets given")
+		}
+		hosts, err := QCom.ParseTargets(strings.Join(args, " "))
+		if err != nil {
+			return c.usage(err.Error())
+		}
+		list, err := QCom.ParsePorts(config.Scan.Ports)
+		if err != nil {
+			return c.usage(err.Error())
+		}
+		opts := QCom.DefaultScanOptions()
+		opts.Workers, opts.HostRate, opts.Timeout = config.Scan.Workers, config.Scan.HostRate, config.Scan.Timeout
+		out := []scanResult{}
+		open := 0
+		for r := range QCom.Scan(ctx, hosts, list, opts) {
+			if r.State == QCom.PortOpen {
+				open++
+			} else if !*all {
+				continue
+			}
+			out = append(out, scanResult{r.Host, r.Port, string(r.State), r.Banner, millis(r.Latency)})
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if out[i].Host != out[j].Host {
+				return out[i].Host < out[j].Host
+			}
+			return out[i].Port < out[j].Port
+		})
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "HOST\tPORT\tSTATE\tLATENCY\tBANNER")
+			for _, r := range out {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%.1fms\t%s\n", r.Host, r.Port, r.State, r.LatencyMS, r.Banner)
+			}
+			fmt.Fprintf(w, "\n%d open of %d ports on %d hosts\n", open, len(list)*len(hosts), len(hosts))
+		}), open > 0)
+	}
+}
+
+type dnsRow struct {
+	Name string `json:"name" yaml:"name"`
+	TTL  uint32 `json:"ttl" yaml:"ttl"`
+	Type string `json:"type" yaml:"type"`
+	Data string `json:"data" yaml:"data"`
+}
+
+type dnsResult struct {
+	Query     string   `json:"query" yaml:"query"`
+	Type      string   `json:"type" yaml:"type"`
+	Server    string   `json:"server" yaml:"server"`
+	Proto     string   `json:"proto" yaml:"proto"`
+	RTTMS     float64  `json:"rtt_ms" yaml:"rtt_ms"`
+	Rcode     string   `json:"rcode" yaml:"rcode"`
+	Flags     string   `json:"flags" yaml:"flags"`
+	Answers   []dnsRow `json:"answers" yaml:"answers"`
+	Authority []dnsRow `json:"authority,omitempty" yaml:"authority,omitempty"`
+}
+
+func dnsRows(rs []QCom.DNSRecord) []dnsRow {
+	out := []dnsRow{}
+	for _, r := range rs {
+		out = append(out, dnsRow{r.Name, r.TTL, QCom.DNSTypeName(r.Type), r.Data})
+	}
+	return out
+}
+
+// dnsCommand exits 1 unless the answer is NOERROR with at least one
+// record.
+func dnsCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	configFlag(fs, "t", "dns.type", "record type")
+	configFlag(fs, "s", "dns.server", "server to ask (default the system resolver)")
+	tcp := fs.Bool("tcp", false, "query over TCP only")
+	reverse := fs.Bool("x", false, "reverse lookup of an address")
+	return func(ctx context.Context, c *cli, args []string) int {
+		if len(args) != 1 {
+			return c.usage("give one name to look up")
+		}
+		name := args[0]
+		qtype, err := QCom.ParseDNSType(config.DNS.Type)
+		if err != nil {
+			return c.usage(err.Error())
+		}
+		if *reverse {
+			if name, err = QCom.ReverseName(name); err != nil {
+				return c.usage(err.Error())
+			}
+			qtype = QCom.TypePTR
+		}
+		server := config.DNS.Server
+		if server == "" {
+			server = QCom.SystemResolver()
+		}
+		opts := QCom.DefaultDNSQueryOptions()
+		opts.ForceTCP = *tcp
+		resp, err := QCom.DNSQuery(ctx, server, name, qtype, opts)
+		if err != nil {
+			return c.fail(err)
+		}
+		h := resp.Msg.Header
+		out := dnsResult{
+			Query: name, Type: QCom.DNSTypeName(qtype), Server: resp.Server, Proto: resp.Proto,
+			RTTMS: millis(resp.RTT), Rcode: QCom.DNSRcodeName(h.Rcode), Flags: h.Flags(),
+			Answers: dnsRows(resp.Msg.Answers), Authority: dnsRows(resp.Msg.Authority),
+		}
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintf(w, ";; %s from %s over %s in %.1fms, flags: %s\n", out.Rcode, out.Server, out.Proto, out.RTTMS, out.Flags)
+			for _, r := range resp.Msg.Answers {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", r.Name, r.TTL, QCom.DNSTypeName(r.Type), r.Data)
+			}
+		}), h.Rcode == 0 && len(out.Answers) > 0)
+	}
+}
+
+type pingResult struct {
+	Target   string       `json:"target" yaml:"target"`
+	Method   string       `json:"method" yaml:"method"`
+	Sent     int          `json:"sent" yaml:"sent"`
+	Received int          `json:"received" yaml:"received"`
+	LossPct  float64      `json:"loss_pct" yaml:"loss_pct"`
+	MinMS    float64      `json:"min_ms" yaml:"min_ms"`
+	AvgMS    float64      `json:"avg_ms" yaml:"avg_ms"`
+	MaxMS    float64      `json:"max_ms" yaml:"max_ms"`
+	JitterMS float64      `json:"jitter_ms" yaml:"jitter_ms"`
+	Samples  []pingSample `json:"samples" yaml:"samples"`
+}
+
+type pingSample struct {
+	Seq   int     `json:"seq" yaml:"seq"`
+	RTTMS float64 `json:"rtt_ms" yaml:"rtt_ms"`
+	Lost  bool    `json:"lost" yaml:"lost"`
+	Err   string  `json:"err,omitempty" yaml:"err,omitempty"`
+}
+
+// pingCommand exits 1 when no reply came back. Interrupting it still
+// prints the summary.
+func pingCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	configFlag(fs, "c", "ping.count", "probes to send, 0 until interrupted")
+	configFlag(fs, "i", "ping.interval", "time between probes")
+	configFlag(fs, "W", "ping.timeout", "time to wait for each reply")
+	configFlag(fs, "m", "ping.method", "auto, icmp or tcp")
+	configFlag(fs, "port", "ping.tcp_port", "port for tcp pings")
+	return func(ctx context.Context, c *cli, args []string) int {
+		if len(args) != 1 {
+			return c.usage("give one host to ping")
+		}
+		count := config.Ping.Count
+		p := QCom.NewPinger(args[0], strings.ToLower(config.Ping.Method), config.Ping.TCPPort)
+		defer p.Close()
+		stats := QCom.PingStats{Target: args[0]}
+		var samples []pingSample
+	probes:
+		for seq := 1; count <= 0 || seq <= count; seq++ {
+			if seq > 1 {
+				select {
+				case <-ctx.Done():
+					break probes
+				case <-time.After(config.Ping.Interval):
+				}
+			}
+			s := p.Ping(ctx, seq, config.Ping.Timeout)
+			if ctx.Err() != nil {
+				break
+			}
+			stats.Add(s)
+			samples = append(samples, pingSample{s.Seq, millis(s.RTT), s.Lost, s.Err})
+			if c.format == "table" {
+				if s.Lost {
+					fmt.Fprintf(c.out, "%s seq=%d lost: %s\n", args[0], s.Seq, s.Err)
+				} else {
+					fmt.Fprintf(c.out, "%s seq=%d %s time=%.2fms\n", args[0], s.Seq, s.Method, millis(s.RTT))
+				}
+			}
+		}
+		out := pingResult{
+			Target: args[0], Method: stats.Method, Sent: stats.Sent, Received: stats.Received,
+			LossPct: stats.Loss(), MinMS: millis(stats.Min), AvgMS: millis(stats.Avg), MaxMS: millis(stats.Max),
+			JitterMS: millis(stats.Jitter), Samples: samples,
+		}
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintf(w, "\n%d sent, %d received, %.1f%% loss\n", out.Sent, out.Received, out.LossPct)
+			if out.Received > 0 {
+				fmt.Fprintf(w, "min/avg/max/jitter = %.2f/%.2f/%.2f/%.2f ms\n", out.MinMS, out.AvgMS, out.MaxMS, out.JitterMS)
+			}
+		}), stats.Received > 0)
+	}
+}
+
+type traceRow struct {
+	TTL      int     `json:"ttl" yaml:"ttl"`
+	Addr     string  `json:"addr" yaml:"addr"`
+	Name     string  `json:"name,omitempty" yaml:"name,omitempty"`
+	Sent     int     `json:"sent" yaml:"sent"`
+	Received int     `json:"received" yaml:"received"`
+	LossPct  float64 `json:"loss_pct" yaml:"loss_pct"`
+	AvgMS    float64 `json:"avg_ms" yaml:"avg_ms"`
+	Reached  bool    `json:"reached" yaml:"reached"`
+}
+
+// traceCommand exits 1 when the destination never answered.
+func traceCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	configFlag(fs, "m", "trace.mode", "udp or tcp")
+	port := fs.Int("port", 0, "destination port (default depends on mode)")
+	configFlag(fs, "max", "trace.max_hops", "most hops to try")
+	configFlag(fs, "r", "trace.rounds", "probes per hop")
+	timeout := fs.Duration("W", 2*time.Second, "time to wait for each reply")
+	return func(ctx context.Context, c *cli, args []string) int {
+		if len(args) != 1 {
+			return c.usage("give one host to trace")
+		}
+		rounds := config.Trace.Rounds
+		t := QCom.NewTracer(args[0])
+		t.Mode, t.Port, t.MaxHops, t.Timeout = strings.ToLower(config.Trace.Mode), *port, config.Trace.MaxHops, *timeout
+		t.Interval = 200 * time.Millisecond
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		done := 0
+		t.OnRound = func([]QCom.TraceHop) {
+			if done++; done >= rounds {
+				cancel()
+			}
+		}
+		if err := t.Run(ctx); err != nil {
+			return c.fail(err)
+		}
+		out := []traceRow{}
+		reached := false
+		for _, h := range t.Hops() {
+			out = append(out, traceRow{h.TTL, h.Addr, h.Name, h.Stats.Sent, h.Stats.Received,
+				h.Stats.Loss(), millis(h.Stats.Avg), h.Reached})
+			reached = reached || h.Reached
+		}
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "TTL\tADDRESS\tNAME\tLOSS\tAVG")
+			for _, h := range out {
+				addr := h.Addr
+				if addr == "" {
+					addr = "*"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%.0f%%\t%.1fms\n", h.TTL, addr, h.Name, h.LossPct, h.AvgMS)
+			}
+		}), reached)
+	}
+}
+
+type httpHopResult struct {
+	URL        string  `json:"url" yaml:"url"`
+	Status     int     `json:"status" yaml:"status"`
+	Proto      string  `json:"proto" yaml:"proto"`
+	RemoteAddr string  `json:"remote_addr" yaml:"remote_addr"`
+	DNSMS      float64 `json:"dns_ms" yaml:"dns_ms"`
+	ConnectMS  float64 `json:"connect_ms" yaml:"connect_ms"`
+	TLSMS      float64 `json:"tls_ms" yaml:"tls_ms"`
+	TTFBMS     float64 `json:"ttfb_ms" yaml:"ttfb_ms"`
+	TotalMS    float64 `json:"total_ms" yaml:"total_ms"`
+	TLSVersion string  `json:"tls_version,omitempty" yaml:"tls_version,omitempty"`
+	CertExpiry string  `json:"cert_expiry,omitempty" yaml:"cert_expiry,omitempty"`
+}
+
+type httpResult struct {
+	Hops     []httpHopResult `json:"hops" yaml:"hops"`
+	Warnings []string        `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+	Error    string          `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// httpCommand exits 1 on a failed request or a final status of 400 or
+// more.
+func httpCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	configFlag(fs, "X", "http.method", "request method")
+	configFlag(fs, "k", "http.insecure", "carry on when the certificate doesn't verify")
+	configFlag(fs, "timeout", "http.timeout", "time allowed for the whole chain")
+	return func(ctx context.Context, c *cli, args []string) int {
+		if len(args) != 1 {
+			return c.usage("give one URL")
+		}
+		opts := QCom.DefaultHTTPProbeOptions()
+		opts.Method, opts.Insecure, opts.Timeout = config.HTTP.Method, config.HTTP.Insecure, config.HTTP.Timeout
+		opts.ExpiryWarn = time.Duration(config.HTTP.WarnDays) * 24 * time.Hour
+		res, err := QCom.HTTPProbe(ctx, args[0], opts)
+		out := httpResult{Hops: []httpHopResult{}}
+		if res != nil {
+			out.Warnings = res.Warnings
+			for _, h := range res.Hops {
+				r := httpHopResult{URL: h.URL, Status: h.StatusCode, Proto: h.Proto, RemoteAddr: h.RemoteAddr,
+					DNSMS: millis(h.Timing.DNS), ConnectMS: millis(h.Timing.Connect), TLSMS: millis(h.Timing.TLS),
+					TTFBMS: millis(h.Timing.TTFB), TotalMS: millis(h.Timing.Total)}
+				if h.TLS != nil {
+					r.TLSVersion = h.TLS.Version
+					if len(h.TLS.Chain) > 0 {
+						r.CertExpiry = h.TLS.Chain[0].NotAfter.Format(time.RFC3339)
+					}
+				}
+				out.Hops = append(out.Hops, r)
+			}
+		}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		final := res.Final()
+		ok := err == nil && final != nil && final.StatusCode < 400
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "STATUS\tURL\tPROTO\tDNS\tCONNECT\tTLS\tTTFB\tTOTAL")
+			for _, h := range out.Hops {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%.1fms\n",
+					h.Status, h.URL, h.Proto, h.DNSMS, h.ConnectMS, h.TLSMS, h.TTFBMS, h.TotalMS)
+			}
+			for _, warn := range out.Warnings {
+				fmt.Fprintln(w, "warning: "+warn)
+			}
+			if out.Error != "" {
+				fmt.Fprintln(w, "error: "+out.Error)
+			}
+		}), ok)
+	}
+}
+
+type subnetResult struct {
+	Prefix    string `json:"prefix" yaml:"prefix"`
+	Network   string `json:"network" yaml:"network"`
+	Last      string `json:"last" yaml:"last"`
+	FirstHost string `json:"first_host" yaml:"first_host"`
+	LastHost  string `json:"last_host" yaml:"last_host"`
+	Netmask   string `json:"netmask,omitempty" yaml:"netmask,omitempty"`
+	Wildcard  string `json:"wildcard,omitempty" yaml:"wildcard,omitempty"`
+	Addresses string `json:"addresses" yaml:"addresses"`
+	Hosts     string `json:"hosts" yaml:"hosts"`
+}
+
+// addrString leaves out the zero address rather than printing "invalid IP".
+func addrString(a netip.Addr) string {
+	if !a.IsValid() {
+		return ""
+	}
+	return a.String()
+}
+
+func subnetCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	return func(_ context.Context, c *cli, args []string) int {
+		if len(args) == 0 {
+			return c.usage("give at least one prefix")
+		}
+		prefixes, err := QCom.ParsePrefixes(strings.Join(args, " "))
+		if err != nil {
+			return c.usage(err.Error())
+		}
+		out := []subnetResult{}
+		for _, p := range prefixes {
+			d := QCom.PrefixDetails(p)
+			out = append(out, subnetResult{d.Prefix.String(), addrString(d.Network), addrString(d.Last),
+				addrString(d.FirstHost), addrString(d.LastHost), addrString(d.Netmask), addrString(d.Wildcard),
+				d.Addresses.String(), d.Hosts.String()})
+		}
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "PREFIX\tNETMASK\tFIRST\tLAST\tHOSTS")
+			for _, r := range out {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Prefix, r.Netmask, r.FirstHost, r.LastHost, r.Hosts)
+			}
+		}), true)
+	}
+}
+
+type discoverResult struct {
+	IP       string   `json:"ip" yaml:"ip"`
+	MAC      string   `json:"mac,omitempty" yaml:"mac,omitempty"`
+	Vendor   string   `json:"vendor,omitempty" yaml:"vendor,omitempty"`
+	Hostname string   `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Services []string `json:"services,omitempty" yaml:"services,omitempty"`
+	Via      []string `json:"via" yaml:"via"`
+	RTTMS    float64  `json:"rtt_ms" yaml:"rtt_ms"`
+}
+
+// discoverCommand updates the LAN inventory like the panel does, and
+// exits 1 when nothing answered.
+func discoverCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	opts := QCom.DefaultDiscoverOptions()
+	fs.StringVar(&opts.Interface, "i", "", "interface to sweep from")
+	configFlag(fs, "mdns", "discover.mdns", "listen for mDNS announcements")
+	configFlag(fs, "names", "discover.names", "look up host names")
+	configFlag(fs, "ports", "discover.tcp_ports", "TCP ports to try when ICMP gets no answer")
+	return func(ctx context.Context, c *cli, args []string) int {
+		if opts.Interface == "" || len(args) > 1 {
+			return c.usage("usage: qube discover -i iface [prefix]")
+		}
+		opts.MDNS, opts.Names = config.Discover.MDNS, config.Discover.Names
+		var err error
+		if len(args) == 1 {
+			opts.Prefix, err = QCom.ParsePrefix(args[0])
+		} else {
+			opts.Prefix, err = QCom.InterfacePrefix(opts.Interface)
+		}
+		if err != nil {
+			return c.usage(err.Error())
+		}
+		if ports := config.Discover.TCPPorts; ports != "" {
+			if opts.TCPPorts, err = QCom.ParsePorts(ports); err != nil {
+				return c.usage(err.Error())
+			}
+		}
+		hosts, err := QCom.Discover(ctx, opts, nil)
+		if err != nil {
+			return c.fail(err)
+		}
+		if _, _, err := mergeInventory(opts.Prefix, hosts, time.Now()); err != nil {
+			return c.fail(err)
+		}
+		out := []discoverResult{}
+		for _, h := range hosts {
+			out = append(out, discoverResult{h.IP.String(), h.MAC, h.Vendor, h.Hostname, h.Services, h.Via, millis(h.RTT)})
+		}
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "IP\tMAC\tVENDOR\tHOSTNAME\tVIA")
+			for _, h := range out {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", h.IP, h.MAC, h.Vendor, h.Hostname, strings.Join(h.Via, ","))
+			}
+			fmt.Fprintf(w, "\n%d hosts up in %s\n", len(out), opts.Prefix)
+		}), len(hosts) > 0)
+	}
+}
+
+// wolCommand wakes a MAC address or a device saved in the panel.
+func wolCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	var d wakeDevice
+	configFlag(fs, "i", "wol.interface", "interface to send from")
+	fs.StringVar(&d.Broadcast, "b", "", "broadcast address")
+	fs.StringVar(&d.SecureOn, "pass", "", "SecureOn password")
+	return func(_ context.Context, c *cli, args []string) int {
+		if len(args) != 1 {
+			return c.usage("give a MAC address or saved device name")
+		}
+		d.Name, d.MAC, d.Interface = args[0], args[0], config.WoL.Interface
+		if _, err := net.ParseMAC(args[0]); err != nil {
+			found := false
+			for _, saved := range loadWakeDevices() {
+				if strings.EqualFold(saved.Name, args[0]) {
+					d, found = saved, true
+				}
+			}
+			if !found {
+				return c.usage(fmt.Sprintf("%q is neither a MAC address nor a saved device", args[0]))
+			}
+		}
+		if err := d.wake(); err != nil {
+			return c.fail(err)
+		}
+		sent := map[string]string{"sent": d.MAC}
+		return c.result(c.emit(sent, func(w io.Writer) {
+			fmt.Fprintf(w, "Sent a magic packet to %s\n", d.MAC)
+		}), true)
+	}
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Carsen/Qube/Login"
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything config.toml (or config.yaml) can set. Keys are
+// "section.key" after the toml tags, and every one can also come from
+// QUBE_SECTION_KEY in the environment or -set section.key=value on the
+// command line. The [keys] table is the exception: its keys are action
+// ids, which don't survive the trip through an environment name.
+type Config struct {
+	Database struct {
+		Path string `toml:"path"`
+	} `toml:"database"`
+	// Auth is policy: after the system file, later layers may only
+	// tighten it (turn things off, lower max_attempts).
+	Auth struct {
+		// AllowSignup lets the login prompt create accounts for new names.
+		AllowSignup bool `toml:"allow_signup"`
+		MaxAttempts int  `toml:"max_attempts"`
+		// Tokens and Admin allow the two ways into the command line.
+		Tokens bool `toml:"tokens"`
+		Admin  bool `toml:"admin"`
+	} `toml:"auth"`
+	UI struct {
+		// Theme is used until one is picked in the app.
+		Theme string `toml:"theme"`
+		Mouse bool   `toml:"mouse"`
+	} `toml:"ui"`
+	Log struct {
+		Level string `toml:"level"`
+	} `toml:"log"`
+	Scan struct {
+		Ports    string        `toml:"ports"`
+		Workers  int           `toml:"workers"`
+		HostRate int           `toml:"host_rate"`
+		Timeout  time.Duration `toml:"timeout"`
+	} `toml:"scan"`
+	Ping struct {
+		Count    int           `toml:"count"`
+		Interval time.Duration `toml:"interval"`
+		Timeout  time.Duration `toml:"timeout"`
+		Method   string        `toml:"method"`
+		TCPPort  int           `toml:"tcp_port"`
+	} `toml:"ping"`
+	DNS struct {
+		// Server is empty for the system resolver.
+		Server string `toml:"server"`
+		Type   string `toml:"type"`
+	} `toml:"dns"`
+	Trace struct {
+		Mode    string `toml:"mode"`
+		MaxHops int    `toml:"max_hops"`
+		Rounds  int    `toml:"rounds"`
+	} `toml:"trace"`
+	HTTP struct {
+		Method   string        `toml:"method"`
+		Timeout  time.Duration `toml:"timeout"`
+		Insecure bool          `toml:"insecure"`
+		WarnDays int           `toml:"warn_days"`
+	} `toml:"http"`
+	Discover struct {
+		TCPPorts string `toml:"tcp_ports"`
+		MDNS     bool   `toml:"mdns"`
+		Names    bool   `toml:"names"`
+	} `toml:"discover"`
+	Capture struct {
+		// Interface is preselected when it is up; Filter fills the
+		// capture filter field.
+		Interface string `toml:"interface"`
+		Filter    string `toml:"filter"`
+		Snaplen   int    `toml:"snaplen"`
+		// MaxPackets caps what the live view keeps in memory; a file
+		// being saved to still receives everything.
+		MaxPackets int `toml:"max_packets"`
+	} `toml:"capture"`
+	Perf struct {
+		Address  string        `toml:"address"`
+		Proto    string        `toml:"proto"`
+		Streams  int           `toml:"streams"`
+		Duration time.Duration `toml:"duration"`
+		Buffer   string        `toml:"buffer"`
+		Bitrate  string        `toml:"bitrate"`
+	} `toml:"perf"`
+	WoL struct {
+		Interface string `toml:"interface"`
+		Port      int    `toml:"port"`
+	} `toml:"wol"`
+	MDNS struct {
+		Interface string `toml:"interface"`
+		// Refresh is how often the service types are asked for again.
+		Refresh time.Duration `toml:"refresh"`
+	} `toml:"mdns"`
+	NetWatch struct {
+		Record  bool `toml:"record"`
+		History int  `toml:"history"`
+	} `toml:"netwatch"`
+	Checks struct {
+		// Interval, FailAfter and CertDays fill the form for new checks.
+		Interval  time.Duration `toml:"interval"`
+		FailAfter int           `toml:"fail_after"`
+		CertDays  int           `toml:"cert_days"`
+		History   int           `toml:"history"`
+		// Bell, Command and Webhook apply until alerts are saved in the
+		// panel.
+		Bell    bool   `toml:"bell"`
+		Command string `toml:"command"`
+		Webhook string `toml:"webhook"`
+	} `toml:"checks"`
+	// Keys binds action ids to keys, e.g. palette = ["Ctrl-K"]. Keys
+	// rebound in the app still win.
+	Keys map[string][]string `toml:"keys"`
+}
+
+func defaultConfig() Config {
+	var c Config
+	c.Database.Path = "./db"
+	c.Auth.AllowSignup, c.Auth.MaxAttempts, c.Auth.Tokens, c.Auth.Admin = true, 5, true, true
+	c.UI.Mouse = true
+	c.Log.Level = "info"
+	scan := QCom.DefaultScanOptions()
+	c.Scan.Ports, c.Scan.Workers, c.Scan.HostRate, c.Scan.Timeout = "1-1024", scan.Workers, scan.HostRate, scan.Timeout
+	c.Ping.Count, c.Ping.Interval, c.Ping.Timeout, c.Ping.Method, c.Ping.TCPPort = 4, time.Second, 2*time.Second, QCom.PingAuto, 443
+	c.DNS.Type = "A"
+	c.Trace.Mode, c.Trace.MaxHops, c.Trace.Rounds = QCom.TraceUDP, 30, 3
+	probe := QCom.DefaultHTTPProbeOptions()
+	c.HTTP.Method, c.HTTP.Timeout, c.HTTP.WarnDays = probe.Method, probe.Timeout, 30
+	c.Discover.TCPPorts, c.Discover.MDNS, c.Discover.Names = "22,80,443,445,139,62078", true, true
+	c.Capture.Snaplen, c.Capture.MaxPackets = 65535, 50000
+	perf := QCom.DefaultPerfConfig()
+	c.Perf.Address, c.Perf.Proto, c.Perf.Streams, c.Perf.Duration = "127.0.0.1", perf.Proto, perf.Streams, perf.Duration
+	c.Perf.Buffer, c.Perf.Bitrate = "128K", "10M"
+	c.WoL.Port = QCom.WakePort
+	c.MDNS.Refresh = 30 * time.Second
+	c.NetWatch.Record, c.NetWatch.History = true, 2000
+	c.Checks.Interval, c.Checks.FailAfter, c.Checks.CertDays, c.Checks.History = time.Minute, 3, 14, 1000
+	c.Keys = map[string][]string{}
+	return c
+}
+
+// config is the effective configuration; configFrom says where each key
+// not left at its default was set.
+var (
+	config     = defaultConfig()
+	configFrom = map[string]string{}
+)
+
+// configField is one settable key and the field behind it.
+type configField struct {
+	key string
+	v   reflect.Value
+}
+
+// configFields lists the section.key settings; Keys isn't one of them.
+func configFields(c *Config) []configField {
+	var out []configField
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i).Tag.Get("toml")
+		fields := sections.Field(i)
+		if fields.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < fields.NumField(); j++ {
+			out = append(out, configField{section + "." + fields.Type().Field(j).Tag.Get("toml"), fields.Field(j)})
+		}
+	}
+	return out
+}
+
+func lookupConfig(key string) (reflect.Value, bool) {
+	for _, f := range configFields(&config) {
+		if f.key == key {
+			return f.v, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setConfigString parses s into v, as from the environment or a flag.
+func setConfigString(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("expected a duration like \"2s\" or \"500ms\", got %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", s)
+		}
+		v.SetInt(int64(n))
+	default:
+		v.SetString(s)
+	}
+	return nil
+}
+
+// setConfigValue stores a value decoded from a TOML or YAML file.
+func setConfigValue(v reflect.Value, raw any) error {
+	if s, ok := raw.(string); ok {
+		if v.Kind() == reflect.String {
+			v.SetString(s)
+			return nil
+		}
+		if v.Type() == durationType {
+			return setConfigString(v, s)
+		}
+	}
+	switch n := raw.(type) {
+	case bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(n)
+			return nil
+		}
+	case int:
+		if v.Kind() == reflect.Int && v.Type() != durationType {
+			v.SetInt(int64(n))
+			return nil
+		}
+	case int64:
+		if v.Kind() == reflect.Int && v.Type() != durationType {
+			v.SetInt(n)
+			return nil
+		}
+	}
+	switch {
+	case v.Type() == durationType:
+		return fmt.Errorf("expected a duration string like \"2s\", got %v", raw)
+	case v.Kind() == reflect.Bool:
+		return fmt.Errorf("expected true or false, got %v", raw)
+	case v.Kind() == reflect.Int:
+		return fmt.Errorf("expected a whole number, got %v", raw)
+	}
+	return fmt.Errorf("expected a string, got %v", raw)
+}
+
+// authFloor is config as the system file left it; nil while that file
+// is being read. Auth keys set after it may not be looser than this.
+var authFloor *Config
+
+// checkAuthFloor refuses a value for an auth key that loosens what the
+// system file set. Turning a switch on and raising max_attempts loosen.
+func checkAuthFloor(key string, v reflect.Value) error {
+	if authFloor == nil || !strings.HasPrefix(key, "auth.") {
+		return nil
+	}
+	for _, f := range configFields(authFloor) {
+		if f.key != key {
+			continue
+		}
+		looser := false
+		switch v.Kind() {
+		case reflect.Bool:
+			looser = v.Bool() && !f.v.Bool()
+		case reflect.Int:
+			looser = v.Int() > f.v.Int()
+		}
+		if looser {
+			return fmt.Errorf("can only tighten the system setting of %v", formatConfig(f.v))
+		}
+	}
+	return nil
+}
+
+// setLayered runs set on a copy of v and stores the result only if it
+// passes checkAuthFloor.
+func setLayered(key string, v reflect.Value, set func(reflect.Value) error) error {
+	nv := reflect.New(v.Type()).Elem()
+	nv.Set(v)
+	if err := set(nv); err != nil {
+		return err
+	}
+	if err := checkAuthFloor(key, nv); err != nil {
+		return err
+	}
+	v.Set(nv)
+	return nil
+}
+
+// formatConfig is v the way it would be written in a file.
+func formatConfig(v reflect.Value) any {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	return v.Interface()
+}
+
+// configDirs hold the system and user config files, lowest first. Each
+// file may be TOML or YAML, but only one per directory.
+func configDirs() []string {
+	dirs := []string{"/etc/qube"}
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "qube"))
+	}
+	return dirs
+}
+
+func findConfigFile(dir string) (string, error) {
+	var found []string
+	for _, name := range []string{"config.toml", "config.yaml", "config.yml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			found = append(found, filepath.Join(dir, name))
+		}
+	}
+	if len(found) > 1 {
+		return "", fmt.Errorf("%s: use one config file, not %s", dir, strings.Join(found, " and "))
+	}
+	if len(found) == 0 {
+		return "", nil
+	}
+	return found[0], nil
+}
+
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]any{}
+	if filepath.Ext(path) == ".toml" {
+		_, err = toml.Decode(string(data), &raw)
+	} else {
+		err = yaml.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return raw, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyConfigFile layers one file's values over config.
+func applyConfigFile(path string, raw map[string]any) error {
+	var keys []string
+	for _, f := range configFields(&config) {
+		keys = append(keys, f.key)
+	}
+	var errs []error
+	for _, section := range sortedKeys(raw) {
+		values, ok := raw[section].(map[string]any)
+		if ok && section == "keys" {
+			for _, id := range sortedKeys(values) {
+				if err := setKeyBinding(id, values[id], path); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", path, err))
+				}
+			}
+			continue
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s: expected a section of keys%s", path, section, suggestKey(section+".", keys)))
+			continue
+		}
+		for _, k := range sortedKeys(values) {
+			key, val := section+"."+k, values[k]
+			v, ok := lookupConfig(key)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown key %s%s", path, key, suggestKey(key, keys)))
+				continue
+			}
+			if err := setLayered(key, v, func(v reflect.Value) error { return setConfigValue(v, val) }); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+				continue
+			}
+			configFrom[key] = path
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setKeyBinding binds action id to raw, a key or a list of them, from a
+// file; -set passes a comma-separated string.
+func setKeyBinding(id string, raw any, from string) error {
+	var keys []string
+	switch v := raw.(type) {
+	case string:
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keys = append(keys, k)
+			}
+		}
+	case []any:
+		for _, k := range v {
+			s, ok := k.(string)
+			if !ok {
+				return fmt.Errorf("keys.%s: expected key names, got %v", id, k)
+			}
+			keys = append(keys, s)
+		}
+	default:
+		return fmt.Errorf("keys.%s: expected a key or a list of keys, got %v", id, raw)
+	}
+	// An empty list is allowed: it unbinds the action.
+	if keys == nil {
+		keys = []string{}
+	}
+	config.Keys[id] = keys
+	configFrom["keys."+id] = from
+	return nil
+}
+
+// setConfig sets key from a string, as the environment and -set do.
+func setConfig(key, value, from string) error {
+	if id, ok := strings.CutPrefix(key, "keys."); ok && id != "" {
+		if err := setKeyBinding(id, value, from); err != nil {
+			return fmt.Errorf("%s: %w", from, err)
+		}
+		return nil
+	}
+	v, ok := lookupConfig(key)
+	if !ok {
+		var keys []string
+		for _, f := range configFields(&config) {
+			keys = append(keys, f.key)
+		}
+		return fmt.Errorf("%s: unknown key %s%s", from, key, suggestKey(key, keys))
+	}
+	if err := setLayered(key, v, func(v reflect.Value) error { return setConfigString(v, value) }); err != nil {
+		return fmt.Errorf("%s: %s: %w", from, key, err)
+	}
+	configFrom[key] = from
+	return nil
+}
+
+func envName(key string) string {
+	return "QUBE_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// suggestKey offers the closest known key to a misspelt one.
+func suggestKey(key string, keys []string) string {
+	best, dist := "", 3
+	for _, k := range keys {
+		if d := editDistance(key, k); d < dist {
+			best, dist = k, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return " (did you mean " + best + "?)"
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// loadConfig layers defaults < system file < user file < environment
+// into config, then checks the result. Flags come later, from the
+// command line. Only the system file may loosen auth.
+func loadConfig() error {
+	config, configFrom, authFloor = defaultConfig(), map[string]string{}, nil
+	var errs []error
+	for i, dir := range configDirs() {
+		if i == 1 {
+			floor := config
+			authFloor = &floor
+		}
+		path, err := findConfigFile(dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if path == "" {
+			continue
+		}
+		raw, err := readConfigFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, applyConfigFile(path, raw))
+	}
+	if authFloor == nil {
+		floor := config
+		authFloor = &floor
+	}
+	for _, f := range configFields(&config) {
+		if s, ok := os.LookupEnv(envName(f.key)); ok {
+			errs = append(errs, setConfig(f.key, s, "env "+envName(f.key)))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return validateConfig()
+}
+
+// validateConfig checks the values that have a fixed set of choices or
+// a range, naming where a bad one came from.
+func validateConfig() error {
+	var errs []error
+	check := func(key string, ok bool, want string) {
+		if ok {
+			return
+		}
+		v, _ := lookupConfig(key)
+		from := configFrom[key]
+		if from == "" {
+			from = "default"
+		}
+		errs = append(errs, fmt.Errorf("%s: %v must be %s (from %s)", key, formatConfig(v), want, from))
+	}
+	oneOf := func(key, got string, choices ...string) {
+		for _, c := range choices {
+			if strings.EqualFold(got, c) {
+				return
+			}
+		}
+		check(key, false, "one of "+strings.Join(choices, ", "))
+	}
+	var level slog.Level
+	check("log.level", level.UnmarshalText([]byte(config.Log.Level)) == nil, "debug, info, warn or error")
+	check("database.path", config.Database.Path != "", "set")
+	check("auth.max_attempts", config.Auth.MaxAttempts >= 1, "at least 1")
+	_, err := QCom.ParsePorts(config.Scan.Ports)
+	check("scan.ports", err == nil && config.Scan.Ports != "", "a port list like 22,80,8000-8100")
+	check("scan.workers", config.Scan.Workers >= 1, "at least 1")
+	check("scan.host_rate", config.Scan.HostRate >= 0, "0 (no limit) or more")
+	check("scan.timeout", config.Scan.Timeout > 0, "positive")
+	check("ping.count", config.Ping.Count >= 0, "0 (until interrupted) or more")
+	check("ping.interval", config.Ping.Interval >= 100*time.Millisecond, "at least 100ms")
+	check("ping.timeout", config.Ping.Timeout > 0, "positive")
+	oneOf("ping.method", config.Ping.Method, QCom.PingAuto, QCom.PingICMP, QCom.PingTCP)
+	check("ping.tcp_port", config.Ping.TCPPort >= 1 && config.Ping.TCPPort <= 65535, "a port number")
+	_, err = QCom.ParseDNSType(config.DNS.Type)
+	check("dns.type", err == nil, "a record type like A, AAAA or MX")
+	oneOf("trace.mode", config.Trace.Mode, QCom.TraceUDP, QCom.TraceTCP)
+	check("trace.max_hops", config.Trace.MaxHops >= 1 && config.Trace.MaxHops <= 64, "1 to 64")
+	check("trace.rounds", config.Trace.Rounds >= 1, "at least 1")
+	check("http.timeout", config.HTTP.Timeout > 0, "positive")
+	check("http.warn_days", config.HTTP.WarnDays >= 0, "0 or more")
+	_, err = QCom.ParsePorts(config.Discover.TCPPorts)
+	check("discover.tcp_ports", err == nil || config.Discover.TCPPorts == "", "a port list like 22,80,443")
+	_, err = QCom.CompileBPF(config.Capture.Filter, QCom.LinkTypeEthernet, 0)
+	check("capture.filter", err == nil, "a filter like \"tcp port 80\"")
+	check("capture.snaplen", config.Capture.Snaplen >= 64 && config.Capture.Snaplen <= 262144, "64 to 262144")
+	check("capture.max_packets", config.Capture.MaxPackets >= 100, "at least 100")
+	oneOf("perf.proto", config.Perf.Proto, "tcp", "udp")
+	check("perf.streams", config.Perf.Streams >= 1 && config.Perf.Streams <= 128, "1 to 128")
+	check("perf.duration", config.Perf.Duration >= time.Second, "at least 1s")
+	_, err = parseSize(config.Perf.Buffer)
+	check("perf.buffer", err == nil, "a size like 128K")
+	_, err = QCom.ParseBitrate(config.Perf.Bitrate)
+	check("perf.bitrate", err == nil, "a rate like 10M")
+	check("wol.port", config.WoL.Port >= 1 && config.WoL.Port <= 65535, "a port number")
+	check("mdns.refresh", config.MDNS.Refresh == 0 || config.MDNS.Refresh >= time.Second, "0 (never) or at least 1s")
+	check("netwatch.history", config.NetWatch.History >= 1, "at least 1")
+	check("checks.interval", config.Checks.Interval >= time.Second, "at least 1s")
+	check("checks.fail_after", config.Checks.FailAfter >= 1, "at least 1")
+	check("checks.cert_days", config.Checks.CertDays >= 0, "0 or more")
+	check("checks.history", config.Checks.History >= 1, "at least 1")
+	for _, id := range sortedKeys(config.Keys) {
+		for _, k := range config.Keys[id] {
+			if _, err := parseKey(k); err != nil {
+				from := configFrom["keys."+id]
+				errs = append(errs, fmt.Errorf("keys.%s: %v (from %s)", id, err, from))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// logLevel is the level the log file keeps, set from log.level.
+var logLevel = new(slog.LevelVar)
+
+// applyConfig passes the settings other packages own on to them.
+func applyConfig() {
+	var level slog.Level
+	if level.UnmarshalText([]byte(config.Log.Level)) == nil {
+		logLevel.Set(level)
+	}
+	QbDB.SetPath(config.Database.Path)
+	Login.AllowSignup = config.Auth.AllowSignup
+	Login.MaxAttempts = config.Auth.MaxAttempts
+}
+
+// optionIndex is the position of the configured choice in a drop-down's
+// options, or the first.
+func optionIndex(options []string, s string) int {
+	for i, o := range options {
+		if strings.EqualFold(o, s) {
+			return i
+		}
+	}
+	return 0
+}
+
+// configValue binds a command line flag to a config key, so the flag
+// shows the configured default and counts as the flags layer.
+type configValue struct {
+	key string
+	v   reflect.Value
+}
+
+func (c *configValue) String() string {
+	if !c.v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(formatConfig(c.v))
+}
+
+func (c *configValue) Set(s string) error {
+	return setLayered(c.key, c.v, func(v reflect.Value) error { return setConfigString(v, s) })
+}
+
+func (c *configValue) IsBoolFlag() bool { return c.v.IsValid() && c.v.Kind() == reflect.Bool }
+
+// configFlag adds flag name to fs, bound to config key.
+func configFlag(fs *flag.FlagSet, name, key, usage string) {
+	v, ok := lookupConfig(key)
+	if !ok {
+		panic("no config key " + key)
+	}
+	fs.Var(&configValue{key, v}, name, usage+" (config "+key+")")
+}
+
+// setFlag is -set section.key=value, which may be repeated.
+type setFlag struct{}
+
+func (setFlag) String() string { return "" }
+
+func (setFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected section.key=value, got %q", s)
+	}
+	return setConfig(strings.TrimSpace(key), strings.TrimSpace(value), "flag -set")
+}
+
+type configEntry struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  any    `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+}
+
+// configCommand is "qube config show". It runs without logging in and
+// exits 1 when the configuration has errors, after listing them.
+func configCommand(fs *flag.FlagSet) func(context.Context, *cli, []string) int {
+	return func(_ context.Context, c *cli, args []string) int {
+		if len(args) != 1 || args[0] != "show" {
+			return c.usage("usage: qube config show")
+		}
+		out := []configEntry{}
+		for _, f := range configFields(&config) {
+			from := configFrom[f.key]
+			if from == "" {
+				from = "default"
+			}
+			out = append(out, configEntry{f.key, formatConfig(f.v), from})
+		}
+		for _, id := range sortedKeys(config.Keys) {
+			out = append(out, configEntry{"keys." + id, config.Keys[id], configFrom["keys."+id]})
+		}
+		if code := c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+			for _, e := range out {
+				fmt.Fprintf(w, "%s\t%v\t%s\n", e.Key, e.Value, e.Source)
+			}
+		}), true); code != exitOK {
+			return code
+		}
+		if c.configErr != nil {
+			return c.fail(c.configErr)
+		}
+		return exitOK
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// lanHost is one inventory entry, kept across sweeps.
+type lanHost struct {
+	IP        string    `json:"ip"`
+	MAC       string    `json:"mac,omitempty"`
+	Vendor    string    `json:"vendor,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Services  []string  `json:"services,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// lanSweep remembers which addresses answered the last sweep of a prefix
+// so the next one can say what changed.
+type lanSweep struct {
+	Time time.Time `json:"time"`
+	IPs  []string  `json:"ips"`
+}
+
+func loadInventory(p netip.Prefix) []lanHost {
+	keys, _ := QbDB.ListRecords("lanhost:")
+	var out []lanHost
+	for _, k := range keys {
+		var h lanHost
+		if QbDB.LoadRecord(k, &h) != nil {
+			continue
+		}
+		if a, err := netip.ParseAddr(h.IP); err == nil && p.Contains(a) {
+			out = append(out, h)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := netip.ParseAddr(out[i].IP)
+		b, _ := netip.ParseAddr(out[j].IP)
+		return a.Less(b)
+	})
+	return out
+}
+
+// mergeInventory folds a sweep into the stored inventory and returns the
+// addresses that appeared and disappeared since the previous sweep.
+func mergeInventory(p netip.Prefix, found []QCom.DiscoveredHost, now time.Time) (added, gone []string, err error) {
+	var prev lanSweep
+	hadPrev := QbDB.LoadRecord("lansweep:"+p.String(), &prev) == nil
+
+	seen := map[string]bool{}
+	for _, d := range found {
+		ip := d.IP.String()
+		seen[ip] = true
+		var h lanHost
+		if QbDB.LoadRecord("lanhost:"+ip, &h) != nil {
+			h = lanHost{IP: ip, FirstSeen: now}
+		}
+		h.LastSeen = now
+		// Keep what earlier sweeps learned when this one saw less.
+		if d.MAC != "" {
+			h.MAC, h.Vendor = d.MAC, d.Vendor
+		}
+		if d.Hostname != "" {
+			h.Hostname = d.Hostname
+		}
+		if len(d.Services) > 0 {
+			h.Services = d.Services
+		}
+		if err := QbDB.SaveRecord("lanhost:"+ip, h); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cur := lanSweep{Time: now}
+	for ip := range seen {
+		cur.IPs = append(cur.IPs, ip)
+	}
+	sort.Strings(cur.IPs)
+	if hadPrev {
+		was := map[string]bool{}
+		for _, ip := range prev.IPs {
+			was[ip] = true
+			if !seen[ip] {
+				gone = append(gone, ip)
+			}
+		}
+		for _, ip := range cur.IPs {
+			if !was[ip] {
+				added = append(added, ip)
+			}
+		}
+	}
+	return added, gone, QbDB.SaveRecord("lansweep:"+p.String(), cur)
+}
+
+func init() { registerPanel("discover", discoverPanel) }
+
+func discoverPanel(app *tview.Application) Panel {
+	var (
+		cancel context.CancelFunc
+		// run counts sweeps; only the UI goroutine touches it, and
+		// updates from a sweep that Sweep has since replaced are dropped.
+		run    int
+		latest = map[string]bool{}
+		added  = map[string]bool{}
+		gone   = map[string]bool{}
+	)
+
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	table.SetBorder(true).SetTitle(" Inventory ")
+	diff := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	diff.SetBorder(true).SetTitle(" Since last sweep ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	var ifaces []string
+	if list, err := net.Interfaces(); err == nil {
+		for _, ifi := range list {
+			if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, ifi.Name)
+			}
+		}
+	}
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	checked := func(label string) bool {
+		return form.GetFormItemByLabel(label).(*tview.Checkbox).IsChecked()
+	}
+
+	render := func(p netip.Prefix) {
+		table.Clear()
+		for i, h := range []string{"IP", "MAC", "Vendor", "Hostname", "Services", "First seen", "Last seen"} {
+			table.SetCell(0, i, tview.NewTableCell(h).
+				SetTextColor(tcell.ColorYellow).
+				SetSelectable(false))
+		}
+		for r, h := range loadInventory(p) {
+			color := tcell.ColorGray
+			switch {
+			case added[h.IP]:
+				color = tcell.ColorGreen
+			case gone[h.IP]:
+				color = tcell.ColorRed
+			case latest[h.IP]:
+				color = tcell.ColorWhite
+			}
+			for c, v := range []string{
+				h.IP, h.MAC, h.Vendor, h.Hostname, strings.Join(h.Services, " "),
+				h.FirstSeen.Format(time.DateTime), h.LastSeen.Format(time.DateTime),
+			} {
+				cell := tview.NewTableCell(tview.Escape(v)).SetTextColor(color)
+				if c == 4 {
+					cell.SetExpansion(1)
+				}
+				table.SetCell(r+1, c, cell)
+			}
+		}
+	}
+
+	renderDiff := func(a, g []string, prevRun bool) {
+		var sb strings.Builder
+		if !prevRun {
+			sb.WriteString("First sweep of this subnet; nothing to compare yet.\n")
+		}
+		for _, ip := range a {
+			fmt.Fprintf(&sb, "[green]+ %s[-]\n", ip)
+		}
+		for _, ip := range g {
+			fmt.Fprintf(&sb, "[red]- %s[-]\n", ip)
+		}
+		if prevRun && len(a)+len(g) == 0 {
+			sb.WriteString("No hosts appeared or disappeared.\n")
+		}
+		diff.SetText(sb.String())
+	}
+
+	// target is the prefix to sweep and the interface it is on. A typed
+	// subnet may belong to another link than the selected one, or to none.
+	target := func() (netip.Prefix, string, error) {
+		if s := field("Subnet"); s != "" {
+			p, err := QCom.ParsePrefix(s)
+			return p, QCom.PrefixInterface(p), err
+		}
+		_, iface := form.GetFormItemByLabel("Interface").(*tview.DropDown).GetCurrentOption()
+		p, err := QCom.InterfacePrefix(iface)
+		return p, iface, err
+	}
+
+	start := func() {
+		if cancel != nil {
+			cancel()
+		}
+		p, iface, err := target()
+		if err != nil {
+			status.SetText("[red]" + tview.Escape(err.Error()))
+			return
+		}
+		opts := QCom.DefaultDiscoverOptions()
+		opts.Interface, opts.Prefix = iface, p
+		opts.MDNS = checked("mDNS")
+		opts.Names = checked("Reverse DNS")
+		if ports := field("TCP ports"); ports != "" {
+			if opts.TCPPorts, err = QCom.ParsePorts(ports); err != nil {
+				status.SetText("[red]" + tview.Escape(err.Error()))
+				return
+			}
+		}
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		run++
+		gen := run
+		status.SetText("Sweeping " + p.String() + "...")
+		go func() {
+			var last time.Time
+			hosts, err := QCom.Discover(ctx, opts, func(done, total int) {
+				if time.Since(last) < 200*time.Millisecond && done < total {
+					return
+				}
+				last = time.Now()
+				app.QueueUpdateDraw(func() {
+					if gen != run {
+						return
+					}
+					status.SetText(fmt.Sprintf("Sweeping %s: %d/%d probed", p, done, total))
+				})
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					toast(sevWarn, "Sweep of %s stopped: %v", p, err)
+				}
+				app.QueueUpdateDraw(func() {
+					if gen != run {
+						return
+					}
+					status.SetText(fmt.Sprintf("[orange]Sweep stopped:[-] %s", tview.Escape(err.Error())))
+				})
+				return
+			}
+			// A sweep that finished just as it was replaced still counts
+			// for the inventory; only its display is stale.
+			var prev lanSweep
+			prevRun := QbDB.LoadRecord("lansweep:"+p.String(), &prev) == nil
+			a, g, err := mergeInventory(p, hosts, time.Now())
+			app.QueueUpdateDraw(func() {
+				if gen != run {
+					return
+				}
+				clear(latest)
+				clear(added)
+				clear(gone)
+				for _, h := range hosts {
+					latest[h.IP.String()] = true
+				}
+				for _, ip := range a {
+					added[ip] = true
+				}
+				for _, ip := range g {
+					gone[ip] = true
+				}
+				render(p)
+				renderDiff(a, g, prevRun)
+				msg := fmt.Sprintf("%d hosts up in %s, %d new, %d gone", len(hosts), p, len(a), len(g))
+				if err != nil {
+					msg = "[red]" + tview.Escape(err.Error()) + "[-] " + msg
+				}
+				status.SetText(msg)
+			})
+		}()
+	}
+
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			cancel = nil
+		}
+	}
+
+	form.AddDropDown("Interface", ifaces, 0, func(iface string, _ int) {
+		if p, err := QCom.InterfacePrefix(iface); err == nil {
+			if in := form.GetFormItemByLabel("Subnet"); in != nil {
+				in.(*tview.InputField).SetText(p.String())
+			}
+			render(p)
+		}
+	}).
+		AddInputField("Subnet", "", 0, nil, nil).
+		AddInputField("TCP ports", config.Discover.TCPPorts, 0, nil, nil).
+		AddCheckbox("mDNS", config.Discover.MDNS, nil).
+		AddCheckbox("Reverse DNS", config.Discover.Names, nil).
+		AddButton("Sweep", start).
+		AddButton("Stop", stop)
+	form.SetBorder(true).SetTitle(" LAN Discovery ")
+
+	// The dropdown callback ran before Subnet existed; fill it now.
+	if len(ifaces) > 0 {
+		if p, err := QCom.InterfacePrefix(ifaces[0]); err == nil {
+			form.GetFormItemByLabel("Subnet").(*tview.InputField).SetText(p.String())
+			render(p)
+		}
+	}
+
+	return &basicPanel{
+		title: "LAN Discovery",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(table, 0, 3, false).
+				AddItem(diff, 0, 1, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		stop: stop,
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/rivo/tview"
+)
+
+func init() { registerPanel("dns", dnsPanel) }
+
+func dnsPanel(app *tview.Application) Panel {
+	// run counts lookups; only the UI goroutine touches it, and the
+	// answer to a lookup that has since been replaced is dropped.
+	var run int
+
+	out := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	out.SetBorder(true).SetTitle(" Response ")
+
+	var typeNames []string
+	for _, t := range QCom.DNSTypes {
+		typeNames = append(typeNames, QCom.DNSTypeName(t))
+	}
+	modes := []string{"Query", "Reverse", "Trace"}
+
+	resolver := config.DNS.Server
+	if resolver == "" {
+		resolver = QCom.SystemResolver()
+	}
+	form := tview.NewForm()
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	option := func(label string) string {
+		_, o := form.GetFormItemByLabel(label).(*tview.DropDown).GetCurrentOption()
+		return o
+	}
+	checked := func(label string) bool {
+		return form.GetFormItemByLabel(label).(*tview.Checkbox).IsChecked()
+	}
+
+	lookup := func() {
+		name := field("Name")
+		if name == "" {
+			out.SetText("[red]Enter a name or address")
+			return
+		}
+		qtype, _ := QCom.ParseDNSType(option("Type"))
+		mode := option("Mode")
+		resolver := field("Resolver")
+		if resolver == "" {
+			resolver = QCom.SystemResolver()
+		}
+		opts := QCom.DefaultDNSQueryOptions()
+		opts.ForceTCP = checked("TCP only")
+
+		if mode == "Reverse" {
+			rev, err := QCom.ReverseName(name)
+			if err != nil {
+				out.SetText("[red]" + err.Error())
+				return
+			}
+			name, qtype = rev, QCom.TypePTR
+		}
+
+		run++
+		gen := run
+		out.SetText(fmt.Sprintf("Looking up %s %s...", name, QCom.DNSTypeName(qtype)))
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			var sb strings.Builder
+			if mode == "Trace" {
+				for _, step := range QCom.DNSTrace(ctx, name, qtype, nil, resolver, opts) {
+					fmt.Fprintf(&sb, "[yellow];; zone %s via %s\n", step.Zone, step.Server)
+					if step.Err != nil {
+						fmt.Fprintf(&sb, "[red];; %v\n\n", step.Err)
+						continue
+					}
+					writeDNSResponse(&sb, step.Response)
+				}
+			} else {
+				resp, err := QCom.DNSQuery(ctx, resolver, name, qtype, opts)
+				if err != nil {
+					fmt.Fprintf(&sb, "[red];; %v\n", err)
+				} else {
+					writeDNSResponse(&sb, resp)
+				}
+			}
+			app.QueueUpdateDraw(func() {
+				if gen != run {
+					return
+				}
+				out.SetText(sb.String()).ScrollToBeginning()
+			})
+		}()
+	}
+
+	form.AddInputField("Name", "", 0, nil, nil).
+		AddDropDown("Type", typeNames, optionIndex(typeNames, config.DNS.Type), nil).
+		AddDropDown("Mode", modes, 0, nil).
+		AddInputField("Resolver", resolver, 0, nil, nil).
+		AddCheckbox("TCP only", false, nil).
+		AddButton("Lookup", lookup)
+	form.SetBorder(true).SetTitle(" DNS Lookup ")
+
+	return &basicPanel{
+		title: "DNS Lookup",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(out, 0, 1, false),
+	}
+}
+
+func writeDNSResponse(sb *strings.Builder, r *QCom.DNSResponse) {
+	h := r.Msg.Header
+	fmt.Fprintf(sb, ";; status: %s, id: %d, flags: %s\n", QCom.DNSRcodeName(h.Rcode), h.ID, h.Flags())
+	fmt.Fprintf(sb, ";; server %s over %s, %d bytes in %s\n", r.Server, r.Proto, r.Size, r.RTT.Round(time.Microsecond))
+	for _, sec := range []struct {
+		name string
+		rrs  []QCom.DNSRecord
+	}{{"ANSWER", r.Msg.Answers}, {"AUTHORITY", r.Msg.Authority}, {"ADDITIONAL", r.Msg.Additional}} {
+		if len(sec.rrs) == 0 {
+			continue
+		}
+		fmt.Fprintf(sb, "\n[green];; %s\n[-]", sec.name)
+		for _, rr := range sec.rrs {
+			if rr.Type == QCom.TypeOPT {
+				continue
+			}
+			sb.WriteString(tview.Escape(rr.String()) + "\n")
+		}
+	}
+	sb.WriteString("\n")
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// sortRow is one table row; keys holds a sortable value per column
+// (float64 or string) and ref points back at whatever the row shows.
+type sortRow struct {
+	cells []string
+	keys  []any
+	ref   int
+}
+
+// sortTable is a Table whose columns sort with the number keys: pressing
+// a column's number sorts by it, pressing it again reverses the order.
+type sortTable struct {
+	*tview.Table
+	headers []string
+	rows    []sortRow
+	col     int
+	desc    bool
+}
+
+func newSortTable(headers ...string) *sortTable {
+	t := &sortTable{
+		Table:   tview.NewTable().SetFixed(1, 0).SetSelectable(true, false),
+		headers: headers,
+		col:     -1,
+	}
+	t.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		if ev.Key() != tcell.KeyRune || ev.Rune() < '1' || ev.Rune() > '9' {
+			return ev
+		}
+		c := int(ev.Rune() - '1')
+		if c >= len(t.headers) {
+			return ev
+		}
+		if c == t.col {
+			t.desc = !t.desc
+		} else {
+			// Numbers read best largest first, names alphabetically.
+			_, numeric := t.keyAt(0, c).(float64)
+			t.col, t.desc = c, numeric
+		}
+		t.render()
+		return nil
+	})
+	return t
+}
+
+func (t *sortTable) keyAt(row, col int) any {
+	if row < len(t.rows) {
+		return t.rows[row].keys[col]
+	}
+	return nil
+}
+
+func (t *sortTable) setRows(rows []sortRow) {
+	t.rows = rows
+	t.render()
+}
+
+// selected returns the ref of the highlighted row, or -1.
+func (t *sortTable) selected() int {
+	row, _ := t.GetSelection()
+	if row < 1 || row-1 >= len(t.rows) {
+		return -1
+	}
+	return t.rows[row-1].ref
+}
+
+func (t *sortTable) render() {
+	if t.col >= 0 {
+		sort.SliceStable(t.rows, func(i, j int) bool {
+			a, b := t.rows[i].keys[t.col], t.rows[j].keys[t.col]
+			var less bool
+			switch x := a.(type) {
+			case float64:
+				less = x < b.(float64)
+			case string:
+				less = x < b.(string)
+			}
+			if t.desc {
+				return !less && a != b
+			}
+			return less
+		})
+	}
+	t.Clear()
+	for i, h := range t.headers {
+		label := strconv.Itoa(i+1) + " " + h
+		if i == t.col {
+			label += map[bool]string{false: " ▲", true: " ▼"}[t.desc]
+		}
+		t.SetCell(0, i, tview.NewTableCell(label).
+			SetTextColor(tcell.ColorYellow).
+			SetSelectable(false))
+	}
+	for r, row := range t.rows {
+		for c, s := range row.cells {
+			cell := tview.NewTableCell(tview.Escape(s))
+			if c > 0 {
+				cell.SetAlign(tview.AlignRight)
+			}
+			t.SetCell(r+1, c, cell)
+		}
+	}
+}
+
+func humanBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.Itoa(n) + " B"
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func fmtRTT(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64) + " ms"
+}
+
+// flowView shows conversations and top talkers for a set of decoded
+// packets, and exports a conversation's reassembled payload.
+type flowView struct {
+	root   *tview.Flex
+	tabs   *tview.Pages
+	conv   *sortTable
+	hosts  *sortTable
+	ports  *sortTable
+	form   *tview.Form
+	status *tview.TextView
+	flows  []*QCom.Flow
+}
+
+var flowTabs = []string{"Conversations", "Hosts", "Ports"}
+
+func newFlowView() *flowView {
+	v := &flowView{
+		tabs:   tview.NewPages(),
+		conv:   newSortTable("Proto", "Address A", "Address B", "Packets", "Bytes", "A→B", "B→A", "Duration", "Retrans", "RTT"),
+		hosts:  newSortTable("Host", "Flows", "Packets", "Bytes"),
+		ports:  newSortTable("Port", "Flows", "Packets", "Bytes"),
+		form:   tview.NewForm().SetHorizontal(true),
+		status: tview.NewTextView().SetDynamicColors(true),
+	}
+	v.tabs.AddPage(flowTabs[0], v.conv, true, true).
+		AddPage(flowTabs[1], v.hosts, true, false).
+		AddPage(flowTabs[2], v.ports, true, false)
+
+	v.form.AddDropDown("Show", flowTabs, 0, func(option string, _ int) {
+		v.tabs.SwitchToPage(option)
+	}).
+		AddDropDown("Direction", []string{"Both", "A→B", "B→A"}, 0, nil).
+		AddInputField("Export to", "flow.bin", 20, nil, nil).
+		AddButton("Export", v.export)
+
+	v.root = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(v.form, 3, 0, false).
+		AddItem(v.tabs, 0, 1, true).
+		AddItem(v.status, 1, 0, false)
+	return v
+}
+
+func (v *flowView) load(packets []*QCom.Packet) {
+	ft := QCom.NewFlowTable()
+	for _, p := range packets {
+		ft.Add(p)
+	}
+	v.flows = ft.Flows()
+
+	rows := make([]sortRow, len(v.flows))
+	for i, f := range v.flows {
+		retrans := strconv.Itoa(f.Retrans)
+		if f.Proto != "TCP" {
+			retrans = "-"
+		}
+		rows[i] = sortRow{
+			cells: []string{
+				f.Proto, f.A.String(), f.B.String(),
+				strconv.Itoa(f.Packets), humanBytes(f.Bytes),
+				humanBytes(f.BytesAB), humanBytes(f.BytesBA),
+				f.Duration().Round(time.Millisecond).String(), retrans, fmtRTT(f.RTT),
+			},
+			keys: []any{
+				f.Proto, f.A.String(), f.B.String(),
+				float64(f.Packets), float64(f.Bytes),
+				float64(f.BytesAB), float64(f.BytesBA),
+				float64(f.Duration()), float64(f.Retrans), float64(f.RTT),
+			},
+			ref: i,
+		}
+	}
+	v.conv.setRows(rows)
+	v.hosts.setRows(talkerRows(ft.TopHosts(0)))
+	v.ports.setRows(talkerRows(ft.TopPorts(0)))
+	v.status.SetText(fmt.Sprintf("%d conversations from %d packets; number keys sort columns", len(v.flows), len(packets)))
+}
+
+func talkerRows(ts []QCom.Talker) []sortRow {
+	rows := make([]sortRow, len(ts))
+	for i, t := range ts {
+		rows[i] = sortRow{
+			cells: []string{t.Key, strconv.Itoa(t.Flows), strconv.Itoa(t.Packets), humanBytes(t.Bytes)},
+			keys:  []any{t.Key, float64(t.Flows), float64(t.Packets), float64(t.Bytes)},
+			ref:   i,
+		}
+	}
+	return rows
+}
+
+func (v *flowView) export() {
+	i := v.conv.selected()
+	if i < 0 {
+		v.status.SetText("[red]select a conversation first")
+		return
+	}
+	f := v.flows[i]
+	dir, _ := v.form.GetFormItemByLabel("Direction").(*tview.DropDown).GetCurrentOption()
+	path := strings.TrimSpace(v.form.GetFormItemByLabel("Export to").(*tview.InputField).GetText())
+	data := f.Payload(QCom.FlowDir(dir))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		v.status.SetText("[red]" + err.Error())
+		return
+	}
+	msg := fmt.Sprintf("Wrote %d bytes of %s ↔ %s to %s", len(data), f.A, f.B, path)
+	if f.Truncated {
+		msg += " [orange](payload truncated)"
+	}
+	v.status.SetText(msg)
+}
+
+// flowKeys are the bindings of the packet list and its flow view.
+var flowKeys = []KeyHint{
+	{"1-9", "sort the flow table by that column, again to reverse"},
+	{"Tab/Esc", "move from a flow table to its export form"},
+}
+
+// withFlows stacks a flow view behind a packet view and returns the
+// toggle that switches between them, rebuilding the flows each time so a
+// running capture shows current numbers. Tab or Esc on a flow table moves
+// to the export form, and Esc there returns to the panel's own form.
+func withFlows(app *tview.Application, view *packetView, back *tview.Form) (*tview.Pages, func()) {
+	flows := newFlowView()
+	for _, t := range []*sortTable{flows.conv, flows.hosts, flows.ports} {
+		t.SetDoneFunc(func(tcell.Key) { app.SetFocus(flows.form) })
+	}
+	flows.form.SetCancelFunc(func() { app.SetFocus(back) })
+	pages := tview.NewPages().
+		AddPage("packets", view.root, true, true).
+		AddPage("flows", flows.root, true, false)
+	toggle := func() {
+		if name, _ := pages.GetFrontPage(); name == "flows" {
+			pages.SwitchToPage("packets")
+			return
+		}
+		flows.load(view.packets)
+		pages.SwitchToPage("flows")
+		app.SetFocus(flows.tabs)
+	}
+	return pages, toggle
+}
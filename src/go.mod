@@ -3,14 +3,16 @@ module github.com/Carsen/Qube
 go 1.22.5
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/Carsen/Qube/Login v0.0.0-20240804022631-ee527a56b12c
 	github.com/Carsen/Qube/QCom v0.0.0-20240804164409-f308b1a40823
+	github.com/Carsen/Qube/QbDB v0.0.0-20240804001514-5eabc43812e9
 	github.com/gdamore/tcell/v2 v2.7.4
 	github.com/rivo/tview v0.0.0-20240728114935-65571ae51e71
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/Carsen/Qube/QbDB v0.0.0-20240804001514-5eabc43812e9 // indirect
 	github.com/abcum/lcp v0.0.0-20201209214815-7a3f3840be81 // indirect
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/gofrs/flock v0.8.1 // indirect
@@ -28,3 +30,9 @@ require (
 	golang.org/x/term v0.17.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
 )
+
+replace (
+	github.com/Carsen/Qube/Login => ../Login
+	github.com/Carsen/Qube/QCom => ../QCom
+	github.com/Carsen/Qube/QbDB => ../QbDB
+)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders the last width values as block characters scaled
+// between their min and max. Negative values mark gaps and are drawn as a
+// red cross so losses stand out.
+func sparkline(values []float64, width int) string {
+	if width > 0 && len(values) > width {
+		values = values[len(values)-width:]
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if v < 0 {
+			continue
+		}
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	var sb strings.Builder
+	for _, v := range values {
+		switch {
+		case v < 0:
+			sb.WriteString("[red]×[-]")
+		case hi == lo:
+			sb.WriteRune(sparkBlocks[0])
+		default:
+			i := int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+			sb.WriteRune(sparkBlocks[i])
+		}
+	}
+	return sb.String()
+}
+
+// barChart draws the last width values as columns height rows tall,
+// scaled from zero to the largest value so bar heights compare directly.
+func barChart(values []float64, width, height int) string {
+	if width > 0 && len(values) > width {
+		values = values[len(values)-width:]
+	}
+	hi := 0.0
+	for _, v := range values {
+		hi = math.Max(hi, v)
+	}
+	levels := len(sparkBlocks)
+	var sb strings.Builder
+	for row := height - 1; row >= 0; row-- {
+		for _, v := range values {
+			// Eighths of a cell filled in this column, counted from the bottom.
+			filled := 0
+			if hi > 0 {
+				filled = int(math.Round(v / hi * float64(height*levels)))
+			}
+			switch n := filled - row*levels; {
+			case n >= levels:
+				sb.WriteRune(sparkBlocks[levels-1])
+			case n > 0:
+				sb.WriteRune(sparkBlocks[n-1])
+			default:
+				sb.WriteRune(' ')
+			}
+		}
+		if row > 0 {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/rivo/tview"
+)
+
+func init() { registerPanel("http", httpPanel) }
+
+func httpPanel(app *tview.Application) Panel {
+	var (
+		cancel context.CancelFunc
+		// run counts probes; only the UI goroutine touches it, and a
+		// result from a probe that has since been replaced is dropped.
+		run int
+	)
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			cancel = nil
+		}
+	}
+
+	out := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	out.SetBorder(true).SetTitle(" Response ")
+
+	form := tview.NewForm()
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	option := func(label string) string {
+		_, o := form.GetFormItemByLabel(label).(*tview.DropDown).GetCurrentOption()
+		return o
+	}
+	checked := func(label string) bool {
+		return form.GetFormItemByLabel(label).(*tview.Checkbox).IsChecked()
+	}
+
+	probe := func() {
+		target := field("URL")
+		if target == "" {
+			out.SetText("[red]Enter a URL")
+			return
+		}
+		opts := QCom.DefaultHTTPProbeOptions()
+		opts.Method = option("Method")
+		opts.Insecure = checked("Insecure")
+		if days, err := strconv.Atoi(field("Warn days")); err == nil && days >= 0 {
+			opts.ExpiryWarn = time.Duration(days) * 24 * time.Hour
+		}
+		if h := field("Header"); h != "" {
+			k, v, ok := strings.Cut(h, ":")
+			if !ok {
+				out.SetText("[red]Header must look like Name: value")
+				return
+			}
+			opts.Header = http.Header{}
+			opts.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+
+		stop()
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		run++
+		gen := run
+		_, _, width, _ := out.GetInnerRect()
+		out.SetText("Probing " + tview.Escape(target) + "...")
+		go func() {
+			res, err := QCom.HTTPProbe(ctx, target, opts)
+			var sb strings.Builder
+			if res != nil {
+				writeHTTPProbe(&sb, res, width, opts.ExpiryWarn)
+			}
+			if err != nil {
+				fmt.Fprintf(&sb, "[red]%s[-]\n", tview.Escape(err.Error()))
+			}
+			app.QueueUpdateDraw(func() {
+				if gen != run {
+					return
+				}
+				out.SetText(sb.String()).ScrollToBeginning()
+			})
+		}()
+	}
+
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	form.AddInputField("URL", "https://", 0, nil, nil).
+		AddDropDown("Method", methods, optionIndex(methods, config.HTTP.Method), nil).
+		AddInputField("Header", "", 0, nil, nil).
+		AddInputField("Warn days", strconv.Itoa(config.HTTP.WarnDays), 5, tview.InputFieldInteger, nil).
+		AddCheckbox("Insecure", config.HTTP.Insecure, nil).
+		AddButton("Probe", probe)
+	form.SetBorder(true).SetTitle(" HTTP Probe ")
+
+	return &basicPanel{
+		title: "HTTP Probe",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(out, 0, 1, false),
+		stop: stop,
+	}
+}
+
+// writeHTTPProbe renders every hop with a timing waterfall scaled to width.
+func writeHTTPProbe(sb *strings.Builder, res *QCom.HTTPProbeResult, width int, warn time.Duration) {
+	for _, w := range res.Warnings {
+		fmt.Fprintf(sb, "[orange]! %s[-]\n", tview.Escape(w))
+	}
+	if len(res.Warnings) > 0 {
+		sb.WriteString("\n")
+	}
+	for i, h := range res.Hops {
+		color := "green"
+		switch {
+		case h.StatusCode >= 400 || h.StatusCode == 0:
+			color = "red"
+		case h.StatusCode >= 300:
+			color = "yellow"
+		}
+		fmt.Fprintf(sb, "[::b]%d. %s[::-]\n", i+1, tview.Escape(h.URL))
+		status := h.Status
+		if status == "" {
+			status = "no response"
+		}
+		fmt.Fprintf(sb, "   [%s]%s[-] %s from %s, %d bytes\n", color, status, h.Proto, h.RemoteAddr, h.BodySize)
+		writeTiming(sb, h.Timing, width)
+
+		if t := h.TLS; t != nil {
+			fmt.Fprintf(sb, "   [::b]TLS[::-] %s, %s", t.Version, t.Cipher)
+			if t.ALPN != "" {
+				fmt.Fprintf(sb, ", ALPN %s", t.ALPN)
+			}
+			if t.VerifyErr == "" {
+				sb.WriteString(", [green]trusted[-]\n")
+			} else {
+				sb.WriteString(", [red]untrusted[-]\n")
+			}
+			for j, c := range t.Chain {
+				left := time.Until(c.NotAfter)
+				exp := "green"
+				if left < 0 {
+					exp = "red"
+				} else if left < warn {
+					exp = "yellow"
+				}
+				fmt.Fprintf(sb, "   #%d %s\n", j, tview.Escape(c.Subject))
+				fmt.Fprintf(sb, "       issuer  %s\n", tview.Escape(c.Issuer))
+				if len(c.SANs) > 0 {
+					fmt.Fprintf(sb, "       SANs    %s\n", tview.Escape(strings.Join(c.SANs, ", ")))
+				}
+				fmt.Fprintf(sb, "       valid   %s to [%s]%s[-]\n",
+					c.NotBefore.Format(time.DateOnly), exp, c.NotAfter.Format(time.DateOnly))
+				fmt.Fprintf(sb, "       key     %s, %s\n", c.KeyType, c.SigAlg)
+			}
+		}
+
+		if len(h.Header) > 0 {
+			sb.WriteString("   [::b]Headers[::-]\n")
+			names := make([]string, 0, len(h.Header))
+			for k := range h.Header {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				for _, v := range h.Header[k] {
+					fmt.Fprintf(sb, "   [aqua]%s[-]: %s\n", k, tview.Escape(v))
+				}
+			}
+		}
+		sb.WriteString("\n")
+	}
+}
+
+func writeTiming(sb *strings.Builder, t QCom.HTTPTiming, width int) {
+	phases := []struct {
+		name  string
+		color string
+		d     time.Duration
+	}{
+		{"DNS", "blue", t.DNS},
+		{"Connect", "aqua", t.Connect},
+		{"TLS", "purple", t.TLS},
+		{"TTFB", "yellow", t.TTFB},
+	}
+	// Bars share the space left after the 28-column label and duration.
+	barW := max(width-28, 10)
+	offset := 0
+	for _, p := range phases {
+		n := 0
+		if t.Total > 0 {
+			n = int(float64(p.d) / float64(t.Total) * float64(barW))
+		}
+		if p.d > 0 && n == 0 {
+			n = 1
+		}
+		fmt.Fprintf(sb, "   %-8s %10s %s[%s]%s[-]\n", p.name, ms(p.d),
+			strings.Repeat(" ", min(offset, barW)), p.color, strings.Repeat("█", n))
+		offset += n
+	}
+	fmt.Fprintf(sb, "   %-8s %10s\n", "Total", ms(t.Total))
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64) + " ms"
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Carsen/Qube/Login"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// action is anything the keymap or the command palette can run.
+type action struct {
+	id    string
+	title string
+	run   func()
+}
+
+// defaultBindings are the global keys before any user overrides.
+var defaultBindings = map[string][]string{
+	"focus.next": {"F6"},
+	"focus.prev": {"Shift-F6"},
+	"panel.zoom": {"F11"},
+	"app.quit":   {"Ctrl-Q"},
+	"help":       {"?", "F1"},
+	"palette":    {":", "Ctrl-P"},
+}
+
+// keymap maps canonical key names to actions. Overrides holds only what
+// the user changed in the app, keyed by action id, so new defaults and
+// the config file's [keys] still reach them.
+type keymap struct {
+	actions   map[string]*action
+	order     []string
+	bindings  map[string]string
+	overrides map[string][]string
+}
+
+func keymapKey() string {
+	id := Login.UserID()
+	if id == "" {
+		id = "default"
+	}
+	return "keymap:" + id
+}
+
+func newKeymap() *keymap {
+	k := &keymap{actions: map[string]*action{}, overrides: map[string][]string{}}
+	QbDB.LoadRecord(keymapKey(), &k.overrides)
+	return k
+}
+
+func (k *keymap) add(id, title string, run func()) {
+	if _, ok := k.actions[id]; !ok {
+		k.order = append(k.order, id)
+	}
+	k.actions[id] = &action{id, title, run}
+}
+
+// keys returns what is bound to action id: the user's overrides, then
+// the config file, then the defaults.
+func (k *keymap) keys(id string) []string {
+	if keys, ok := k.overrides[id]; ok {
+		return keys
+	}
+	if keys, ok := config.Keys[id]; ok {
+		return keys
+	}
+	return defaultBindings[id]
+}
+
+// rebound reports whether id's keys come from the user or the config
+// rather than the defaults.
+func (k *keymap) rebound(id string) bool {
+	_, user := k.overrides[id]
+	_, configured := config.Keys[id]
+	return user || configured
+}
+
+// rebuild recomputes the key table. Overrides that no longer parse are
+// dropped rather than failing startup.
+func (k *keymap) rebuild() {
+	k.bindings = map[string]string{}
+	ids := append([]string(nil), k.order...)
+	// Rebound actions claim their keys after the defaults do.
+	sort.SliceStable(ids, func(i, j int) bool {
+		return !k.rebound(ids[i]) && k.rebound(ids[j])
+	})
+	for _, id := range ids {
+		for _, s := range k.keys(id) {
+			if name, err := parseKey(s); err == nil {
+				k.bindings[name] = id
+			}
+		}
+	}
+}
+
+// bind makes key the only binding for id, taking it from any other action.
+func (k *keymap) bind(id, key string) error {
+	name, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	for _, other := range k.order {
+		if other == id {
+			continue
+		}
+		keys := k.keys(other)
+		for i, s := range keys {
+			if n, _ := parseKey(s); n == name {
+				k.overrides[other] = append(append([]string{}, keys[:i]...), keys[i+1:]...)
+				break
+			}
+		}
+	}
+	k.overrides[id] = []string{name}
+	k.rebuild()
+	return QbDB.SaveRecord(keymapKey(), k.overrides)
+}
+
+// reset puts id back to its default keys.
+func (k *keymap) reset(id string) error {
+	delete(k.overrides, id)
+	k.rebuild()
+	return QbDB.SaveRecord(keymapKey(), k.overrides)
+}
+
+// lookup finds the action for ev. Plain characters are left alone while
+// a text field has focus so they can still be typed.
+func (k *keymap) lookup(ev *tcell.EventKey, focus tview.Primitive) *action {
+	if ev.Key() == tcell.KeyRune && ev.Modifiers()&(tcell.ModAlt|tcell.ModCtrl) == 0 {
+		switch focus.(type) {
+		case *tview.InputField, *tview.TextArea, *tview.DropDown:
+			return nil
+		}
+	}
+	if id, ok := k.bindings[keyName(ev)]; ok {
+		return k.actions[id]
+	}
+	return nil
+}
+
+func modPrefix(m tcell.ModMask) string {
+	var sb strings.Builder
+	if m&tcell.ModCtrl != 0 {
+		sb.WriteString("Ctrl-")
+	}
+	if m&tcell.ModAlt != 0 {
+		sb.WriteString("Alt-")
+	}
+	if m&tcell.ModShift != 0 {
+		sb.WriteString("Shift-")
+	}
+	return sb.String()
+}
+
+// keyName is the canonical name of ev, e.g. "Ctrl-P", "Shift-F6" or "?".
+func keyName(ev *tcell.EventKey) string {
+	m := ev.Modifiers()
+	if ev.Key() == tcell.KeyRune {
+		// The character already says whether shift was held.
+		return modPrefix(m&^tcell.ModShift) + string(ev.Rune())
+	}
+	base, ok := tcell.KeyNames[ev.Key()]
+	if !ok {
+		base = fmt.Sprintf("Key%d", ev.Key())
+	}
+	if b, found := strings.CutPrefix(base, "Ctrl-"); found {
+		base, m = b, m|tcell.ModCtrl
+	}
+	return modPrefix(m) + base
+}
+
+var keyMods = []struct {
+	name string
+	mask tcell.ModMask
+}{{"ctrl", tcell.ModCtrl}, {"alt", tcell.ModAlt}, {"shift", tcell.ModShift}}
+
+// parseKey turns a user-written key like "ctrl+p", "Shift-F6" or "?" into
+// the form keyName produces.
+func parseKey(s string) (string, error) {
+	var m tcell.ModMask
+	rest := strings.TrimSpace(s)
+	for stripped := true; stripped; {
+		stripped = false
+		for _, mod := range keyMods {
+			n := len(mod.name)
+			if len(rest) > n+1 && strings.EqualFold(rest[:n], mod.name) && (rest[n] == '-' || rest[n] == '+') {
+				m, rest, stripped = m|mod.mask, rest[n+1:], true
+			}
+		}
+	}
+	if rest == "" {
+		return "", fmt.Errorf("bad key %q", s)
+	}
+	if r, size := utf8.DecodeRuneInString(rest); size == len(rest) {
+		switch {
+		case m&tcell.ModCtrl != 0 && unicode.IsLetter(r):
+			// Terminals send Ctrl-letter as one control code.
+			return modPrefix(m) + string(unicode.ToUpper(r)), nil
+		case m&tcell.ModShift != 0:
+			return modPrefix(m&^tcell.ModShift) + string(unicode.ToUpper(r)), nil
+		}
+		return modPrefix(m) + rest, nil
+	}
+	for _, name := range tcell.KeyNames {
+		if b, isCtrl := strings.CutPrefix(name, "Ctrl-"); isCtrl && m&tcell.ModCtrl != 0 && strings.EqualFold(b, rest) {
+			return modPrefix(m) + b, nil
+		}
+		if strings.EqualFold(name, rest) {
+			if b, isCtrl := strings.CutPrefix(name, "Ctrl-"); isCtrl {
+				name, m = b, m|tcell.ModCtrl
+			}
+			return modPrefix(m) + name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key %q", s)
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Carsen/Qube/Login"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Layout slots. Main is the centre, Side the right-hand column and Extra
+// the strip along the bottom; Side and Extra fold away when empty.
+const (
+	slotMain  = "main"
+	slotSide  = "side"
+	slotExtra = "extra"
+)
+
+var slotOrder = []string{slotMain, slotSide, slotExtra}
+
+// savedLayout is one workspace tab: its name, which panel sits in each
+// slot and any split sizes dragged away from the defaults.
+type savedLayout struct {
+	Name  string            `json:"name"`
+	Slots map[string]string `json:"slots"`
+	Sizes map[string]int    `json:"sizes,omitempty"`
+}
+
+// savedLayouts is every tab and which one was showing.
+type savedLayouts struct {
+	Tabs   []savedLayout `json:"tabs"`
+	Active int           `json:"active"`
+	// Slots is the single layout saved before there were tabs.
+	Slots map[string]string `json:"slots,omitempty"`
+}
+
+func layoutKey() string {
+	id := Login.UserID()
+	if id == "" {
+		id = "default"
+	}
+	return "layout:" + id
+}
+
+// defaultTabs is what a new user starts with.
+func defaultTabs() []savedLayout {
+	return []savedLayout{
+		{Name: "Monitoring", Slots: map[string]string{slotMain: "uptime", slotSide: "netwatch"}},
+		{Name: "Diagnostics", Slots: map[string]string{slotMain: "ping", slotSide: "trace", slotExtra: "dns"}},
+		{Name: "Capture", Slots: map[string]string{slotMain: "capture", slotSide: "pcap"}},
+	}
+}
+
+func loadLayout() savedLayouts {
+	var l savedLayouts
+	if QbDB.LoadRecord(layoutKey(), &l) != nil {
+		l = savedLayouts{}
+	}
+	if len(l.Tabs) == 0 && l.Slots != nil {
+		l.Tabs = []savedLayout{{Name: "Main", Slots: l.Slots}}
+	}
+	if len(l.Tabs) == 0 {
+		l.Tabs = defaultTabs()
+	}
+	l.Slots = nil
+	l.Active = max(0, min(l.Active, len(l.Tabs)-1))
+	return l
+}
+
+// Size thresholds. Below narrowWidth the menu and side slot fold into tabs
+// over the main slot, below shortHeight the extra strip does too, and
+// below the minimum only a notice is drawn.
+const (
+	narrowWidth = 100
+	shortHeight = 30
+	minWidth    = 50
+	minHeight   = 14
+)
+
+// paneMenu names the menu alongside the slots when panes are folded.
+const paneMenu = "menu"
+
+func primTextView(text string) *tview.TextView {
+	return tview.NewTextView().
+		SetDynamicColors(true).
+		SetTextColor(tcell.ColorLime).
+		SetTextAlign(tview.AlignCenter).
+		SetText(text)
+}
+
+// workspace owns the grid, the panel menu and what sits in each slot.
+type workspace struct {
+	app    *tview.Application
+	root   *tview.Pages
+	grid   *tview.Grid
+	tabBar *tview.TextView
+	menu   *tview.List
+	footer *tview.TextView
+	empty  tview.Primitive
+	panels map[string]Panel
+	order  []string // panel ids in menu order
+	tabs   []savedLayout
+	active int
+	slots  map[string]string // slot → panel id in the active tab
+	keys   *keymap
+	zoomed bool
+	// width and height are the terminal size at the last draw; folded
+	// lists the panes sharing the main area as tabs, pane the one showing.
+	width, height int
+	small         bool
+	notice        *tview.TextView
+	folded        []string
+	pane          string
+	paneBar       *tview.TextView
+	paneStack     *tview.Pages
+	// splits are the borders on screen that can be dragged, dragging the
+	// one being dragged now.
+	splits   []string
+	dragging string
+	screen   *themedScreen
+	// themes are the built-in and user themes, themeErrs the user theme
+	// files that failed to load.
+	themes    []Theme
+	themeErrs []error
+	theme     string
+	// overlays are the open overlays, bottom first; restore is what had
+	// focus before zooming; binding, if set, takes the next key press.
+	overlays []overlayFocus
+	restore  tview.Primitive
+	binding  func(*tcell.EventKey)
+	// noticesChanged refreshes the notification drawer while it is open;
+	// stopNotices ends the goroutine watching for notices.
+	noticesChanged func()
+	stopNotices    context.CancelFunc
+}
+
+func newWorkspace(app *tview.Application, screen *themedScreen) *workspace {
+	w := &workspace{
+		app:     app,
+		screen:  screen,
+		grid:    tview.NewGrid().SetBorders(true),
+		tabBar:  tview.NewTextView().SetDynamicColors(true).SetRegions(true).SetWrap(false),
+		menu:    tview.NewList().ShowSecondaryText(false),
+		footer:  primTextView(""),
+		empty:   primTextView("\n\nPick a tool from the menu"),
+		notice:  primTextView(""),
+		pane:    paneMenu,
+		paneBar: tview.NewTextView().SetDynamicColors(true).SetRegions(true).SetWrap(false),
+		panels:  map[string]Panel{},
+		keys:    newKeymap(),
+	}
+	w.root = tview.NewPages().AddPage("grid", w.grid, true, true)
+	for id, build := range panelRegistry {
+		w.panels[id] = build(app)
+		w.order = append(w.order, id)
+	}
+	sort.Slice(w.order, func(i, j int) bool {
+		return strings.ToLower(w.panels[w.order[i]].Title()) < strings.ToLower(w.panels[w.order[j]].Title())
+	})
+
+	saved := loadLayout()
+	for _, tab := range saved.Tabs {
+		w.tabs = append(w.tabs, savedLayout{Name: tab.Name, Slots: w.validSlots(tab.Slots), Sizes: tab.Sizes})
+	}
+	w.active = saved.Active
+	w.slots = w.tabs[w.active].Slots
+
+	for _, id := range w.order {
+		w.menu.AddItem("", "", 0, func() { w.assign(slotMain, id) })
+	}
+	w.menu.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		if ev.Key() != tcell.KeyRune || w.menu.GetItemCount() == 0 {
+			return ev
+		}
+		id := w.order[w.menu.GetCurrentItem()]
+		switch ev.Rune() {
+		case 'm':
+			w.assign(slotMain, id)
+		case 's':
+			w.assign(slotSide, id)
+		case 'e':
+			w.assign(slotExtra, id)
+		case 'x':
+			w.remove(id)
+		default:
+			return ev
+		}
+		return nil
+	})
+
+	w.themes, w.themeErrs = loadThemes()
+	for _, err := range w.themeErrs {
+		toast(sevWarn, "Theme not loaded: %v", err)
+	}
+	if w.setTheme(savedTheme()) != nil {
+		w.setTheme("default")
+	}
+
+	w.registerActions()
+	w.clickBars()
+	w.updateFooter()
+	w.render()
+	app.SetBeforeDrawFunc(w.fit)
+	app.SetAfterDrawFunc(w.drawToasts)
+	return w
+}
+
+// validSlots keeps the usable part of a saved layout: ids saved by an
+// older build may be gone, and a panel can only be drawn in one place.
+func (w *workspace) validSlots(saved map[string]string) map[string]string {
+	slots := map[string]string{}
+	placed := map[string]bool{}
+	for _, slot := range slotOrder {
+		if id := saved[slot]; w.panels[id] != nil && !placed[id] {
+			slots[slot] = id
+			placed[id] = true
+		}
+	}
+	return slots
+}
+
+func (w *workspace) registerActions() {
+	k := w.keys
+	k.add("focus.next", "Focus next pane", func() { w.focusPane(1) })
+	k.add("focus.prev", "Focus previous pane", func() { w.focusPane(-1) })
+	k.add("panel.zoom", "Zoom the focused panel", w.toggleZoom)
+	k.add("help", "Show key bindings", w.showHelp)
+	k.add("palette", "Command palette", w.showPalette)
+	k.add("notices", "Notification history", w.showNotices)
+	k.add("app.quit", "Quit", w.app.Stop)
+	for _, id := range w.order {
+		title := w.panels[id].Title()
+		k.add("open."+id, "Open "+title, func() { w.assign(slotMain, id) })
+		k.add("side."+id, "Open "+title+" in the side slot", func() { w.assign(slotSide, id) })
+		k.add("extra."+id, "Open "+title+" in the extra slot", func() { w.assign(slotExtra, id) })
+	}
+	k.add("close.side", "Close the side slot", func() { w.clearSlot(slotSide) })
+	k.add("close.extra", "Close the extra slot", func() { w.clearSlot(slotExtra) })
+	w.registerTabActions()
+	k.add("theme.pick", "Choose a theme", w.showThemes)
+	for _, t := range w.themes {
+		k.add("theme."+t.Name, "Use the "+t.Name+" theme", func() { w.useTheme(t.Name) })
+	}
+	k.rebuild()
+}
+
+// updateFooter shows the user and the keys for getting around, which
+// may have been rebound.
+func (w *workspace) updateFooter() {
+	user := Login.Username()
+	if user == "" {
+		user = "guest"
+	}
+	var hints []string
+	for _, h := range []struct{ id, what string }{
+		{"help", "help"}, {"palette", "commands"}, {"notices", "notifications"}, {"focus.next", "next pane"}, {"panel.zoom", "zoom"}, {"app.quit", "quit"},
+	} {
+		if keys := w.keys.keys(h.id); len(keys) > 0 {
+			hints = append(hints, keys[0]+" "+h.what)
+		}
+	}
+	w.footer.SetText(tview.Escape(user) + "  [gray]" + tview.Escape(strings.Join(hints, "  ")))
+}
+
+// handleKey is the application's input capture: it runs keymap actions
+// and otherwise lets the key through to the focused widget.
+func (w *workspace) handleKey(ev *tcell.EventKey) *tcell.EventKey {
+	if w.binding != nil {
+		w.binding(ev)
+		return nil
+	}
+	a := w.keys.lookup(ev, w.app.GetFocus())
+	if w.small {
+		// Nothing is visible to type into.
+		if a != nil && a.id == "app.quit" {
+			a.run()
+		}
+		return nil
+	}
+	if a == nil {
+		return ev
+	}
+	// Overlays keep their keys, except for quitting.
+	if front, _ := w.root.GetFrontPage(); front != "grid" && front != "zoom" && a.id != "app.quit" {
+		return ev
+	}
+	a.run()
+	return nil
+}
+
+// slotOf reports which slot holds panel id.
+func (w *workspace) slotOf(id string) string {
+	for slot, p := range w.slots {
+		if p == id {
+			return slot
+		}
+	}
+	return ""
+}
+
+// assign puts panel id in slot. If it was already showing elsewhere the
+// two slots swap, so nothing is drawn twice.
+func (w *workspace) assign(slot, id string) {
+	if from := w.slotOf(id); from != "" && from != slot {
+		if prev, ok := w.slots[slot]; ok {
+			w.slots[from] = prev
+		} else {
+			delete(w.slots, from)
+		}
+	}
+	w.slots[slot] = id
+	w.panels[id].Refresh()
+	w.changed()
+	w.showPane(slot)
+}
+
+func (w *workspace) clearSlot(slot string) {
+	if _, ok := w.slots[slot]; ok {
+		delete(w.slots, slot)
+		w.changed()
+	}
+}
+
+func (w *workspace) remove(id string) {
+	if slot := w.slotOf(id); slot != "" {
+		delete(w.slots, slot)
+		w.changed()
+	}
+}
+
+func (w *workspace) changed() {
+	w.render()
+	QbDB.SaveRecord(layoutKey(), savedLayouts{Tabs: w.tabs, Active: w.active})
+}
+
+// render rebuilds the grid around the occupied slots and the terminal
+// size.
+func (w *workspace) render() {
+	for i, id := range w.order {
+		text := w.panels[id].Title()
+		if slot := w.slotOf(id); slot != "" {
+			text += "  [gray]" + slot
+		}
+		w.menu.SetItemText(i, text, "")
+	}
+	w.renderTabs()
+
+	// Size 0 means nothing has been drawn yet.
+	narrow := w.width > 0 && w.width < narrowWidth
+	short := w.height > 0 && w.height < shortHeight
+	_, hasSide := w.slots[slotSide]
+	_, hasExtra := w.slots[slotExtra]
+	w.folded = w.folded[:0]
+	if narrow {
+		w.folded = append(w.folded, paneMenu)
+	}
+	w.folded = append(w.folded, slotMain)
+	if hasSide && narrow {
+		w.folded, hasSide = append(w.folded, slotSide), false
+	}
+	if hasExtra && short {
+		w.folded, hasExtra = append(w.folded, slotExtra), false
+	}
+
+	center := w.panePrim(slotMain)
+	if len(w.folded) > 1 {
+		if !slices.Contains(w.folded, w.pane) {
+			w.pane = slotMain
+		}
+		// Keep whatever has focus on screen.
+		for _, name := range w.folded {
+			if w.panePrim(name).HasFocus() {
+				w.pane = name
+			}
+		}
+		w.paneStack = tview.NewPages()
+		for _, name := range w.folded {
+			w.paneStack.AddPage(name, w.panePrim(name), true, name == w.pane)
+		}
+		w.renderPaneBar()
+		center = tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(w.paneBar, 1, 0, false).
+			AddItem(w.paneStack, 0, 1, true)
+	}
+
+	w.splits = w.splits[:0]
+	cols := []int{w.split(splitMenu), 0}
+	if narrow {
+		cols = []int{0}
+	} else {
+		w.splits = append(w.splits, splitMenu)
+	}
+	mc := len(cols) - 1 // the main column
+	if hasSide {
+		cols = append(cols, w.split(splitSide))
+		w.splits = append(w.splits, splitSide)
+	}
+	rows := []int{1, 0}
+	if hasExtra {
+		rows = append(rows, w.split(splitExtra))
+		w.splits = append(w.splits, splitExtra)
+	}
+	rows = append(rows, 1)
+
+	g := w.grid.Clear().SetRows(rows...).SetColumns(cols...)
+	if !narrow {
+		g.AddItem(primTextView("Qube Network Tool"), 0, 0, 1, 1, 0, 0, false).
+			AddItem(w.menu, 1, 0, len(rows)-2, 1, 0, 0, true)
+	}
+	g.AddItem(w.tabBar, 0, mc, 1, len(cols)-mc, 0, 0, false).
+		AddItem(center, 1, mc, 1, 1, 0, 0, narrow).
+		AddItem(w.footer, len(rows)-1, 0, 1, len(cols), 0, 0, false)
+	if hasSide {
+		g.AddItem(w.panePrim(slotSide), 1, mc+1, 1, 1, 0, 0, false)
+	}
+	if hasExtra {
+		g.AddItem(w.panePrim(slotExtra), 2, mc, 1, len(cols)-mc, 0, 0, false)
+	}
+}
+
+// renderPaneBar labels the folded panes, highlighting the one showing.
+func (w *workspace) renderPaneBar() {
+	var sb strings.Builder
+	for _, name := range w.folded {
+		label := "Menu"
+		if name != paneMenu {
+			label = name
+			if id, ok := w.slots[name]; ok {
+				label = w.panels[id].Title()
+			}
+		}
+		fmt.Fprintf(&sb, `["%s"] %s [""] `, name, tview.Escape(label))
+	}
+	w.paneBar.SetText(sb.String()).Highlight(w.pane)
+}
+
+// panePrim is what fills pane name: the menu or a slot.
+func (w *workspace) panePrim(name string) tview.Primitive {
+	if name == paneMenu {
+		return w.menu
+	}
+	if id, ok := w.slots[name]; ok {
+		return w.panels[id].Primitive()
+	}
+	return w.empty
+}
+
+// panes lists the focusable areas in F6 order.
+func (w *workspace) panes() []string {
+	out := []string{paneMenu}
+	for _, slot := range slotOrder {
+		if _, ok := w.slots[slot]; ok {
+			out = append(out, slot)
+		}
+	}
+	return out
+}
+
+// showPane brings pane name to the front if it is folded and focuses it.
+func (w *workspace) showPane(name string) {
+	if slices.Contains(w.folded, name) && len(w.folded) > 1 {
+		w.pane = name
+		w.paneStack.SwitchToPage(name)
+		w.renderPaneBar()
+	}
+	w.app.SetFocus(w.panePrim(name))
+}
+
+// focusPane moves focus delta panes along from the one holding it.
+func (w *workspace) focusPane(delta int) {
+	if w.zoomed {
+		return
+	}
+	panes := w.panes()
+	for i, name := range panes {
+		if w.panePrim(name).HasFocus() {
+			w.showPane(panes[(i+delta+len(panes))%len(panes)])
+			return
+		}
+	}
+	w.showPane(paneMenu)
+}
+
+// fit re-renders when the terminal has changed size. Below the minimum
+// size it draws the notice instead of the workspace.
+func (w *workspace) fit(screen tcell.Screen) bool {
+	width, height := screen.Size()
+	if width != w.width || height != w.height {
+		w.width, w.height = width, height
+		w.render()
+	}
+	w.small = width < minWidth || height < minHeight
+	if !w.small {
+		return false
+	}
+	w.notice.SetText(fmt.Sprintf("\n\nTerminal too small\n\nQube needs at least %dx%d, this one is %dx%d",
+		minWidth, minHeight, width, height))
+	w.notice.SetRect(0, 0, width, height)
+	w.notice.Draw(screen)
+	return true
+}
+
+// toggleZoom fills the screen with the focused panel, or the main one
+// when the menu has focus, and puts the grid back the second time.
+func (w *workspace) toggleZoom() {
+	if w.zoomed {
+		w.zoomed = false
+		w.root.RemovePage("zoom").SwitchToPage("grid")
+		w.app.SetFocus(w.restore)
+		return
+	}
+	id, ok := w.slots[slotMain]
+	for _, slot := range slotOrder {
+		if p, in := w.slots[slot]; in && w.panels[p].Primitive().HasFocus() {
+			id, ok = p, true
+		}
+	}
+	if !ok {
+		return
+	}
+	w.zoomed = true
+	w.restore = w.app.GetFocus()
+	prim := w.panels[id].Primitive()
+	w.root.AddAndSwitchToPage("zoom", prim, true)
+	if !prim.HasFocus() {
+		w.app.SetFocus(prim)
+	}
+}
+
+func (w *workspace) start() {
+	var ctx context.Context
+	ctx, w.stopNotices = context.WithCancel(context.Background())
+	go w.watchNotices(ctx)
+	for _, id := range w.order {
+		w.panels[id].Start()
+	}
+}
+
+func (w *workspace) stop() {
+	if w.stopNotices != nil {
+		w.stopNotices()
+	}
+	for _, id := range w.order {
+		w.panels[id].Stop()
+	}
+}
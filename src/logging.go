@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logMaxSize = 5 << 20 // bytes before the log file is rotated
+	logBackups = 3       // rotated files kept as qube.log.1 … .3
+	logKeep    = 5000    // records held in memory for the log panel
+)
+
+// logPath is $XDG_STATE_HOME/qube/qube.log, or the same under
+// ~/.local/state.
+func logPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(os.TempDir(), "qube.log")
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "qube", "qube.log")
+}
+
+// rotatingFile appends to path and moves it aside to path.1 once it grows
+// past max, shifting older copies along and dropping the last.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	max     int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotating(path string, max int64, backups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, max: max, backups: backups}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, st.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	for i := r.backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.max {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// logEntry is one record as the log panel shows it.
+type logEntry struct {
+	Time  time.Time
+	Level slog.Level
+	Msg   string
+	Attrs string
+}
+
+// logRing holds the most recent records. seq changes on every add so the
+// panel can tell when to redraw.
+type logRing struct {
+	mu      sync.Mutex
+	entries []logEntry
+	seq     uint64
+}
+
+var logs = &logRing{}
+
+func (r *logRing) add(e logEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+	if len(r.entries) > logKeep {
+		r.entries = slices.Delete(r.entries, 0, len(r.entries)-logKeep)
+	}
+	r.seq++
+}
+
+func (r *logRing) snapshot() []logEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.entries)
+}
+
+func (r *logRing) version() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq
+}
+
+func (r *logRing) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+	r.seq++
+}
+
+// logHandler writes records through the wrapped handler and keeps a
+// flattened copy in the ring. The ring takes every level so the panel's
+// filter has something to show; only the file honours log.level.
+type logHandler struct {
+	slog.Handler
+	ring  *logRing
+	attrs string // from WithAttrs, already formatted
+	group string // dotted prefix from WithGroup
+}
+
+func appendAttr(sb *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(sb, prefix, ga)
+		}
+		return
+	}
+	if sb.Len() > 0 {
+		sb.WriteByte(' ')
+	}
+	fmt.Fprintf(sb, "%s%s=%v", prefix, a.Key, a.Value)
+}
+
+func (h *logHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&sb, h.group, a)
+		return true
+	})
+	h.ring.add(logEntry{Time: r.Time, Level: r.Level, Msg: r.Message, Attrs: sb.String()})
+	// Errors from anywhere, including the libraries, reach the user.
+	if r.Level >= slog.LevelError {
+		toast(sevError, "%s", strings.TrimSpace(r.Message+" "+sb.String()))
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&sb, h.group, a)
+	}
+	return &logHandler{Handler: h.Handler.WithAttrs(attrs), ring: h.ring, attrs: sb.String(), group: h.group}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &logHandler{Handler: h.Handler.WithGroup(name), ring: h.ring, attrs: h.attrs, group: h.group + name + "."}
+}
+
+// setupLogging points slog, and with it the log package, at the rotating
+// log file and the log panel. logLevel, from log.level once the config
+// is read, sets the lowest level written to the file; the panel keeps
+// every level. If the file can't be opened, records still reach the
+// panel and the returned error says why. The returned func closes the
+// file.
+func setupLogging() (func(), error) {
+	var out io.Writer = io.Discard
+	closeLog := func() {}
+	f, err := openRotating(logPath(), logMaxSize, logBackups)
+	if err == nil {
+		out, closeLog = f, func() { f.Close() }
+	}
+	h := &logHandler{Handler: slog.NewJSONHandler(out, &slog.HandlerOptions{Level: logLevel}), ring: logs}
+	slog.SetDefault(slog.New(h))
+	return closeLog, err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+var logLevels = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+func logLevelColor(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return "red"
+	case l >= slog.LevelWarn:
+		return "orange"
+	case l >= slog.LevelInfo:
+		return "aqua"
+	}
+	return "gray"
+}
+
+func init() { registerPanel("logs", logsPanel) }
+
+func logsPanel(app *tview.Application) Panel {
+	var cancel context.CancelFunc
+
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWrap(false)
+	view.SetBorder(true).SetTitle(" Records ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm().SetHorizontal(true)
+
+	render := func() {
+		entries := logs.snapshot()
+		_, name := form.GetFormItemByLabel("Level").(*tview.DropDown).GetCurrentOption()
+		var floor slog.Level
+		floor.UnmarshalText([]byte(name))
+		search := strings.ToLower(strings.TrimSpace(form.GetFormItemByLabel("Search").(*tview.InputField).GetText()))
+
+		var sb strings.Builder
+		shown := 0
+		for _, e := range entries {
+			if e.Level < floor {
+				continue
+			}
+			if search != "" && !strings.Contains(strings.ToLower(e.Msg+" "+e.Attrs), search) {
+				continue
+			}
+			shown++
+			fmt.Fprintf(&sb, "%s [%s]%-5s[-] %s  [gray]%s[-]\n", e.Time.Format("15:04:05.000"),
+				logLevelColor(e.Level), e.Level, tview.Escape(e.Msg), tview.Escape(e.Attrs))
+		}
+		view.SetText(sb.String())
+		if form.GetFormItemByLabel("Follow").(*tview.Checkbox).IsChecked() {
+			view.ScrollToEnd()
+		}
+		status.SetText(fmt.Sprintf("%d of %d records  [gray]%s", shown, len(entries), tview.Escape(logPath())))
+	}
+
+	var names []string
+	for _, l := range logLevels {
+		names = append(names, l.String())
+	}
+	form.AddDropDown("Level", names, 1, func(string, int) {
+		// Called once while the form is still being built.
+		if form.GetFormItemCount() > 0 {
+			render()
+		}
+	}).
+		AddInputField("Search", "", 24, nil, func(string) { render() }).
+		AddCheckbox("Follow", true, func(bool) { render() }).
+		AddButton("Clear", func() {
+			logs.clear()
+			render()
+		})
+	form.SetCancelFunc(func() { app.SetFocus(view) })
+	view.SetDoneFunc(func(tcell.Key) { app.SetFocus(form) })
+	render()
+
+	// New records are picked up a few times a second rather than per
+	// record, so a chatty scan doesn't redraw for every line.
+	tail := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			ticker := time.NewTicker(250 * time.Millisecond)
+			defer ticker.Stop()
+			seen := logs.version()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if v := logs.version(); v != seen {
+						seen = v
+						app.QueueUpdateDraw(render)
+					}
+				}
+			}
+		}()
+	}
+
+	return &basicPanel{
+		title: "Logs",
+		prim: tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(form, 3, 0, true).
+			AddItem(view, 0, 1, false).
+			AddItem(status, 1, 0, false),
+		keys:    []KeyHint{escHint},
+		refresh: render,
+		start:   tail,
+		stop: func() {
+			if cancel != nil {
+				cancel()
+			}
+		},
+	}
+}
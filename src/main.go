@@ -2,7 +2,8 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 
 	"github.com/Carsen/Qube/Login"
 	"github.com/gdamore/tcell/v2"
@@ -10,43 +11,50 @@ import (
 )
 
 func main() {
+	closeLog, err := setupLogging()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		alertError("Logging is not fully set up", err)
+	}
+	defer closeLog()
+	// fail ends the program on an error there is no way past.
+	fail := func(err error) {
+		slog.Error("exiting", "err", err)
+		fmt.Fprintln(os.Stderr, "qube:", err)
+		closeLog()
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		code := runCLI(os.Args[1:])
+		closeLog()
+		os.Exit(code)
+	}
+	if err := loadConfig(); err != nil {
+		fail(err)
+	}
+	applyConfig()
 	switch Login.Login(true) {
 	case true:
-		app := tview.NewApplication()
-
-		primTextView := func(text string) tview.Primitive {
-			return tview.NewTextView().
-				SetDynamicColors(true).
-				SetTextColor(tcell.ColorLime).
-				SetTextAlign(tview.AlignCenter).
-				SetText(text)
+		screen, err := tcell.NewScreen()
+		if err != nil {
+			fail(err)
 		}
-		//		primTextArea := func(text string) tview.Primitive {
-		//			return tview.NewTextArea().
-		//
-		//		}
-
-		grid := tview.NewGrid().
-			SetRows(1, 0, 20).
-			SetColumns(30, 0, 30).
-			SetBorders(true).
-			AddItem(primTextView("Qube Network Tool"), 0, 0, 1, 3, 0, 0, false)
-		//			AddItem(primTextView(strconv.Itoa(QCom.IfaceAmt())), 2, 0, 1, 3, 0, 0, false)
-
-		grid.AddItem(primTextView("Side Tool"), 0, 0, 0, 0, 0, 0, false).
-			AddItem(primTextView("Main Tool"), 1, 0, 1, 3, 0, 0, false).
-			AddItem(primTextView("Extra Tool"), 0, 0, 0, 0, 0, 0, false)
-
-		grid.AddItem(primTextView("Side Tool"), 1, 0, 1, 1, 0, 100, false).
-			AddItem(primTextView("Main Tool"), 1, 1, 1, 1, 0, 100, false).
-			AddItem(primTextView("Extra Tool"), 1, 2, 1, 1, 0, 100, false)
-
-		grid.AddItem(primTextView("Interfaces:"), 2, 0, 1, 2, 0, 0, false).
-			AddItem(primTextView("Carsen"), 2, 2, 1, 1, 0, 0, false)
-
-		if err := app.SetRoot(grid, true).SetFocus(grid).Run(); err != nil {
-			log.Fatal(err)
+		themed := newThemedScreen(screen)
+		app := tview.NewApplication().SetScreen(themed)
+		ws := newWorkspace(app, themed)
+		app.SetInputCapture(ws.handleKey).
+			SetMouseCapture(ws.handleMouse).
+			EnableMouse(config.UI.Mouse)
+
+		slog.Info("session started", "user", Login.UserID())
+		ws.start()
+		err = app.SetRoot(ws.root, true).SetFocus(ws.menu).Run()
+		ws.stop()
+		if err != nil {
+			fail(err)
 		}
+		slog.Info("session ended", "user", Login.UserID())
 	case false:
 		fmt.Println("Goodbye!")
 	}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// serviceNames labels the DNS-SD types people usually go looking for.
+var serviceNames = map[string]string{
+	"_ipp._tcp":             "Printers (IPP)",
+	"_ipps._tcp":            "Printers (IPPS)",
+	"_printer._tcp":         "Printers (LPD)",
+	"_pdl-datastream._tcp":  "Printers (raw)",
+	"_scanner._tcp":         "Scanners",
+	"_uscan._tcp":           "Scanners (eSCL)",
+	"_googlecast._tcp":      "Chromecast",
+	"_airplay._tcp":         "AirPlay",
+	"_raop._tcp":            "AirPlay audio",
+	"_spotify-connect._tcp": "Spotify Connect",
+	"_ssh._tcp":             "SSH",
+	"_sftp-ssh._tcp":        "SFTP",
+	"_smb._tcp":             "SMB file shares",
+	"_afpovertcp._tcp":      "AFP file shares",
+	"_nfs._tcp":             "NFS",
+	"_http._tcp":            "Web servers",
+	"_https._tcp":           "Web servers (TLS)",
+	"_hap._tcp":             "HomeKit",
+	"_matter._tcp":          "Matter",
+	"_workstation._tcp":     "Workstations",
+	"_device-info._tcp":     "Device info",
+	"_companion-link._tcp":  "Apple devices",
+	"_sleep-proxy._udp":     "Sleep proxies",
+}
+
+func init() { registerPanel("mdns", mdnsPanel) }
+
+func mdnsPanel(app *tview.Application) Panel {
+	var (
+		cancel context.CancelFunc
+		// run counts browses started and stopped; only the UI goroutine
+		// touches it, and a render queued by a browser that has since
+		// been stopped or replaced is dropped.
+		run int
+	)
+
+	root := tview.NewTreeNode("local.").SetColor(tcell.ColorYellow)
+	tree := tview.NewTreeView().SetRoot(root).SetCurrentNode(root)
+	tree.SetBorder(true).SetTitle(" Services ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	// Rebuilding the tree would collapse everything, so remember which
+	// nodes the user opened by their reference.
+	expanded := map[string]bool{}
+	tree.SetSelectedFunc(func(n *tview.TreeNode) {
+		n.SetExpanded(!n.IsExpanded())
+		if ref, ok := n.GetReference().(string); ok {
+			expanded[ref] = n.IsExpanded()
+		}
+	})
+
+	render := func(services []QCom.ServiceInstance) {
+		var currentRef string
+		if cur := tree.GetCurrentNode(); cur != nil {
+			currentRef, _ = cur.GetReference().(string)
+		}
+		var current *tview.TreeNode
+		node := func(text, ref string, color tcell.Color) *tview.TreeNode {
+			n := tview.NewTreeNode(tview.Escape(text)).SetReference(ref).SetColor(color)
+			n.SetExpanded(expanded[ref])
+			if ref == currentRef {
+				current = n
+			}
+			return n
+		}
+
+		root.ClearChildren()
+		var typeNode *tview.TreeNode
+		lastType := ""
+		for _, s := range services {
+			if s.Type != lastType {
+				label := s.Type
+				if name, ok := serviceNames[s.Type]; ok {
+					label = name + "  " + s.Type
+				}
+				typeNode = node(label, s.Type, tcell.ColorAqua)
+				root.AddChild(typeNode)
+				lastType = s.Type
+			}
+			inst := node(s.Instance, s.Name, tcell.ColorWhite)
+			typeNode.AddChild(inst)
+			detail := func(text string) {
+				inst.AddChild(tview.NewTreeNode(tview.Escape(text)).SetSelectable(false).SetColor(tcell.ColorGray))
+			}
+			if s.Host != "" {
+				detail(fmt.Sprintf("host  %s:%d", strings.TrimSuffix(s.Host, "."), s.Port))
+			}
+			for _, a := range s.Addrs {
+				detail("addr  " + a.String())
+			}
+			for _, t := range s.Text {
+				if t != "" {
+					detail("txt   " + t)
+				}
+			}
+			detail("seen  " + s.Updated.Format(time.TimeOnly))
+		}
+		root.SetExpanded(true)
+		if current != nil {
+			tree.SetCurrentNode(current)
+		} else {
+			tree.SetCurrentNode(root)
+		}
+	}
+
+	var ifaces []string
+	if list, err := net.Interfaces(); err == nil {
+		for _, ifi := range list {
+			if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 && ifi.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, ifi.Name)
+			}
+		}
+	}
+
+	stop := func() {
+		run++
+		if cancel != nil {
+			cancel()
+			cancel = nil
+		}
+	}
+
+	start := func() {
+		stop()
+		_, iface := form.GetFormItemByLabel("Interface").(*tview.DropDown).GetCurrentOption()
+		browser := QCom.NewMDNSBrowser()
+		render(nil)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		gen := run
+		status.SetText("Browsing on " + iface + "...")
+
+		// Responses arrive in bursts, so redraw at most a few times a second.
+		dirty := make(chan struct{}, 1)
+		go func() {
+			t := time.NewTicker(300 * time.Millisecond)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					select {
+					case <-dirty:
+						services := browser.Services()
+						app.QueueUpdateDraw(func() {
+							if gen != run {
+								return
+							}
+							render(services)
+							status.SetText(fmt.Sprintf("Browsing on %s: %d services", iface, len(services)))
+						})
+					default:
+					}
+				}
+			}
+		}()
+
+		go func() {
+			err := QCom.MDNSBrowse(ctx, iface, browser, config.MDNS.Refresh, func() {
+				select {
+				case dirty <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					toast(sevError, "mDNS browsing stopped: %v", err)
+				}
+				app.QueueUpdateDraw(func() {
+					if gen != run {
+						return
+					}
+					status.SetText("[red]" + tview.Escape(err.Error()))
+				})
+			}
+		}()
+	}
+
+	form.AddDropDown("Interface", ifaces, optionIndex(ifaces, config.MDNS.Interface), nil).
+		AddButton("Browse", start).
+		AddButton("Stop", func() {
+			stop()
+			status.SetText("Stopped")
+		})
+	form.SetBorder(true).SetTitle(" mDNS Browser ")
+	// Esc hops between the form and the tree.
+	form.SetCancelFunc(func() { app.SetFocus(tree) })
+	tree.SetDoneFunc(func(tcell.Key) { app.SetFocus(form) })
+
+	return &basicPanel{
+		title: "mDNS Browser",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(tree, 0, 1, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		keys: []KeyHint{escHint, {"Enter", "expand or collapse a node"}},
+		stop: stop,
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// The splits that can be dragged, as keys into savedLayout.Sizes.
+const (
+	splitMenu  = "menu"  // width of the tool menu
+	splitSide  = "side"  // width of the side slot; 0 shares evenly with main
+	splitExtra = "extra" // height of the extra strip
+)
+
+// split is the size of split name in the active tab, kept between its
+// floor and a share of the terminal so the main slot stays usable.
+func (w *workspace) split(name string) int {
+	n := w.tabs[w.active].Sizes[name]
+	var floor, ceil, def int
+	switch name {
+	case splitMenu:
+		floor, ceil, def = 12, w.width/3, 26
+	case splitSide:
+		floor, ceil = 20, w.width/2
+	case splitExtra:
+		floor, ceil, def = 5, w.height/2, 14
+	}
+	if n == 0 {
+		return def
+	}
+	// Size 0 means nothing has been drawn yet.
+	if ceil > floor {
+		n = min(n, ceil)
+	}
+	return max(n, floor)
+}
+
+func (w *workspace) setSplit(name string, n int) {
+	tab := &w.tabs[w.active]
+	if tab.Sizes == nil {
+		tab.Sizes = map[string]int{}
+	}
+	tab.Sizes[name] = n
+	tab.Sizes[name] = w.split(name)
+	w.render()
+}
+
+// splitAt reports which split border, if any, is at x, y.
+func (w *workspace) splitAt(x, y int) string {
+	for _, name := range w.splits {
+		switch name {
+		case splitMenu:
+			mx, my, mw, mh := w.menu.GetRect()
+			if x == mx+mw && y >= my && y < my+mh {
+				return name
+			}
+		case splitSide:
+			sx, sy, _, sh := w.panePrim(slotSide).GetRect()
+			if x == sx-1 && y >= sy && y < sy+sh {
+				return name
+			}
+		case splitExtra:
+			ex, ey, ew, _ := w.panePrim(slotExtra).GetRect()
+			if y == ey-1 && x >= ex && x < ex+ew {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// handleMouse is the application's mouse capture. It drags the grid's
+// split borders and keeps the pointer inside an open overlay; everything
+// else goes through to the widget under the pointer.
+func (w *workspace) handleMouse(ev *tcell.EventMouse, action tview.MouseAction) (*tcell.EventMouse, tview.MouseAction) {
+	if w.small {
+		return nil, action
+	}
+	x, y := ev.Position()
+	if w.dragging == "" {
+		front, page := w.root.GetFrontPage()
+		if px, py, pw, ph := page.GetRect(); front != "grid" && front != "zoom" &&
+			(x < px || y < py || x >= px+pw || y >= py+ph) {
+			return nil, action
+		}
+		if front != "grid" || action != tview.MouseLeftDown {
+			return ev, action
+		}
+		if w.dragging = w.splitAt(x, y); w.dragging == "" {
+			return ev, action
+		}
+		return nil, action
+	}
+	switch action {
+	case tview.MouseMove:
+		switch w.dragging {
+		case splitMenu:
+			mx, _, _, _ := w.menu.GetRect()
+			w.setSplit(splitMenu, x-mx)
+		case splitSide:
+			sx, _, sw, _ := w.panePrim(slotSide).GetRect()
+			w.setSplit(splitSide, sx+sw-x-1)
+		case splitExtra:
+			_, ey, _, eh := w.panePrim(slotExtra).GetRect()
+			w.setSplit(splitExtra, ey+eh-y-1)
+		}
+	case tview.MouseLeftUp:
+		w.dragging = ""
+		w.changed()
+	}
+	return nil, action
+}
+
+// clickBars lets the tab bar and the folded pane bar be clicked. A click
+// highlights the region under it; one off the labels clears the
+// highlight, which is put back.
+func (w *workspace) clickBars() {
+	w.tabBar.SetHighlightedFunc(func(added, _, _ []string) {
+		if len(added) == 0 {
+			w.renderTabs()
+			return
+		}
+		if i, err := strconv.Atoi(added[0]); err == nil && i != w.active {
+			w.switchTab(i)
+		}
+	})
+	w.paneBar.SetHighlightedFunc(func(added, _, _ []string) {
+		if len(added) == 0 {
+			w.renderPaneBar()
+			return
+		}
+		if added[0] != w.pane {
+			w.showPane(added[0])
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// netEventTrimBatch is how far past netwatch.history the saved events
+// may grow before the oldest are trimmed, so most events cost one write.
+const netEventTrimBatch = 200
+
+// netEventStore numbers events as they are saved: one netlink read turns
+// into several events with the same timestamp, and the sequence keeps
+// their keys apart and in order. stored is the number of saved events,
+// or -1 until they have been counted.
+var netEventStore = struct {
+	sync.Mutex
+	seq    uint64
+	stored int
+}{stored: -1}
+
+// recordNetEvent saves ev and trims the history. It reads the database,
+// so call it off the UI goroutine.
+func recordNetEvent(ev QCom.NetEvent) error {
+	keep := config.NetWatch.History
+	st := &netEventStore
+	st.Lock()
+	defer st.Unlock()
+	st.seq++
+	key := fmt.Sprintf("netevent:%020d.%010d", ev.Time.UnixNano(), st.seq)
+	if err := QbDB.SaveRecord(key, ev); err != nil {
+		return err
+	}
+	if st.stored >= 0 {
+		st.stored++
+		if st.stored <= keep+netEventTrimBatch {
+			return nil
+		}
+	}
+	keys, err := QbDB.ListRecords("netevent:")
+	if err != nil {
+		return err
+	}
+	st.stored = len(keys)
+	if len(keys) <= keep {
+		return nil
+	}
+	sort.Strings(keys)
+	for _, k := range keys[:len(keys)-keep] {
+		if err := QbDB.DeleteRecord(k); err != nil {
+			return err
+		}
+		st.stored--
+	}
+	return nil
+}
+
+// clearNetEvents deletes the saved history and returns how many events
+// it held.
+func clearNetEvents() int {
+	st := &netEventStore
+	st.Lock()
+	defer st.Unlock()
+	keys, _ := QbDB.ListRecords("netevent:")
+	for _, k := range keys {
+		QbDB.DeleteRecord(k)
+	}
+	st.stored = 0
+	return len(keys)
+}
+
+func loadNetEvents(n int) []QCom.NetEvent {
+	keys, _ := QbDB.ListRecords("netevent:")
+	sort.Strings(keys)
+	if len(keys) > n {
+		keys = keys[len(keys)-n:]
+	}
+	var out []QCom.NetEvent
+	for _, k := range keys {
+		var ev QCom.NetEvent
+		if QbDB.LoadRecord(k, &ev) == nil {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func netEventColor(kind string) string {
+	switch kind {
+	case QCom.EventLinkUp, QCom.EventLinkAdded, QCom.EventAddrAdded:
+		return "green"
+	case QCom.EventLinkDown, QCom.EventLinkRemoved, QCom.EventAddrRemoved:
+		return "red"
+	case QCom.EventDefaultRoute:
+		return "yellow"
+	}
+	return "aqua"
+}
+
+func init() { registerPanel("netwatch", netwatchPanel) }
+
+func netwatchPanel(app *tview.Application) Panel {
+	var (
+		events  = loadNetEvents(500)
+		pending *time.Timer
+		cancel  context.CancelFunc
+		// record mirrors the Record checkbox for the watch goroutine.
+		record atomic.Bool
+	)
+	record.Store(config.NetWatch.Record)
+
+	links := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	links.SetBorder(true).SetTitle(" Interfaces ")
+	routes := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	routes.SetBorder(true).SetTitle(" Routes ")
+	eventLog := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	eventLog.SetBorder(true).SetTitle(" Events ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	header := func(t *tview.Table, cols ...string) {
+		t.Clear()
+		for i, h := range cols {
+			t.SetCell(0, i, tview.NewTableCell(h).
+				SetTextColor(tcell.ColorYellow).
+				SetSelectable(false))
+		}
+	}
+
+	renderTables := func() {
+		header(links, "#", "Name", "State", "MTU", "MAC", "Addresses")
+		ls, err := QCom.Links()
+		if err != nil {
+			status.SetText("[red]" + tview.Escape(err.Error()))
+		}
+		for r, l := range ls {
+			state, color := "up", tcell.ColorGreen
+			switch {
+			case !l.Up:
+				state, color = "disabled", tcell.ColorGray
+			case !l.Running:
+				state, color = "no carrier", tcell.ColorRed
+			}
+			addrs := make([]string, len(l.Addrs))
+			for i, a := range l.Addrs {
+				addrs[i] = a.String()
+			}
+			for c, v := range []string{strconv.Itoa(l.Index), l.Name, state, strconv.Itoa(l.MTU), l.MAC, strings.Join(addrs, " ")} {
+				cell := tview.NewTableCell(tview.Escape(v))
+				if c == 2 {
+					cell.SetTextColor(color)
+				}
+				if c == 5 {
+					cell.SetExpansion(1)
+				}
+				links.SetCell(r+1, c, cell)
+			}
+		}
+
+		header(routes, "Destination", "Gateway", "Interface", "Proto", "Metric")
+		rs, err := QCom.Routes()
+		if err != nil {
+			routes.SetCell(1, 0, tview.NewTableCell(tview.Escape(err.Error())).SetTextColor(tcell.ColorRed))
+			return
+		}
+		for r, rt := range rs {
+			dst, gw := rt.Dst.String(), "-"
+			if rt.IsDefault() {
+				dst = "default"
+			}
+			if rt.Gateway.IsValid() {
+				gw = rt.Gateway.String()
+			}
+			for c, v := range []string{dst, gw, rt.Interface, rt.Protocol, strconv.Itoa(rt.Metric)} {
+				cell := tview.NewTableCell(tview.Escape(v))
+				if rt.IsDefault() {
+					cell.SetTextColor(tcell.ColorYellow)
+				}
+				if c == 0 {
+					cell.SetExpansion(1)
+				}
+				routes.SetCell(r+1, c, cell)
+			}
+		}
+	}
+
+	renderLog := func() {
+		_, show := form.GetFormItemByLabel("Show").(*tview.DropDown).GetCurrentOption()
+		var sb strings.Builder
+		for _, ev := range events {
+			switch show {
+			case "Links":
+				if !strings.HasPrefix(ev.Kind, "link-") {
+					continue
+				}
+			case "Addresses":
+				if !strings.HasPrefix(ev.Kind, "addr-") {
+					continue
+				}
+			case "Routes":
+				if !strings.Contains(ev.Kind, "route") {
+					continue
+				}
+			}
+			fmt.Fprintf(&sb, "%s  [%s]%-13s[-] %s\n", ev.Time.Format(time.DateTime),
+				netEventColor(ev.Kind), ev.Kind, tview.Escape(ev.Detail))
+		}
+		eventLog.SetText(sb.String()).ScrollToEnd()
+	}
+
+	form.AddDropDown("Show", []string{"All", "Links", "Addresses", "Routes"}, 0, func(string, int) {
+		// Called once while the form is still being built.
+		if form.GetFormItemCount() > 0 {
+			renderLog()
+		}
+	}).
+		AddCheckbox("Record", config.NetWatch.Record, func(checked bool) { record.Store(checked) }).
+		AddButton("Refresh", renderTables).
+		AddButton("Clear history", func() {
+			n := clearNetEvents()
+			events = nil
+			renderLog()
+			status.SetText(fmt.Sprintf("Deleted %d events", n))
+		})
+	form.SetBorder(true).SetTitle(" Network Events ")
+	// Esc cycles from the form through the three views.
+	form.SetCancelFunc(func() { app.SetFocus(links) })
+	links.SetDoneFunc(func(tcell.Key) { app.SetFocus(routes) })
+	routes.SetDoneFunc(func(tcell.Key) { app.SetFocus(eventLog) })
+	eventLog.SetDoneFunc(func(tcell.Key) { app.SetFocus(form) })
+
+	renderTables()
+	renderLog()
+	status.SetText("Watching for link, address and route changes")
+
+	watch := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			err := QCom.WatchNetwork(ctx, func(ev QCom.NetEvent) {
+				var saveErr error
+				if record.Load() {
+					saveErr = recordNetEvent(ev)
+				}
+				app.QueueUpdateDraw(func() {
+					if saveErr != nil {
+						status.SetText("[red]" + tview.Escape(saveErr.Error()))
+					}
+					events = append(events, ev)
+					if keep := config.NetWatch.History; len(events) > keep {
+						events = events[len(events)-keep:]
+					}
+					renderLog()
+					// Changes come in bursts (a link going down drops its
+					// addresses and routes too), so re-read the tables once
+					// the burst has passed.
+					if pending == nil {
+						pending = time.AfterFunc(200*time.Millisecond, func() {
+							app.QueueUpdateDraw(func() {
+								pending = nil
+								renderTables()
+							})
+						})
+					}
+				})
+			})
+			if err != nil {
+				toast(sevError, "Network events stopped: %v", err)
+				app.QueueUpdateDraw(func() {
+					status.SetText("[red]" + tview.Escape(err.Error()))
+				})
+			}
+		}()
+	}
+
+	return &basicPanel{
+		title: "Network Events",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(links, 0, 1, false).
+				AddItem(routes, 0, 1, false).
+				AddItem(eventLog, 0, 2, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		keys:    []KeyHint{{"Esc", "cycle through the form, interfaces, routes and events"}},
+		refresh: renderTables,
+		start:   watch,
+		stop: func() {
+			if cancel != nil {
+				cancel()
+			}
+		},
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func init() { defaultBindings["notices"] = []string{"F2"} }
+
+// severity picks a notice's colour and how long its toast stays up.
+type severity int
+
+const (
+	sevInfo severity = iota
+	sevSuccess
+	sevWarn
+	sevError
+)
+
+func (s severity) String() string {
+	return [...]string{"Info", "Success", "Warning", "Error"}[s]
+}
+
+// color is a base colour, so themes remap it like any other.
+func (s severity) color() tcell.Color {
+	return [...]tcell.Color{tcell.ColorAqua, tcell.ColorGreen, tcell.ColorOrange, tcell.ColorRed}[s]
+}
+
+// ttl is how long a toast stays up; errors stay longest, which toasts
+// relies on.
+func (s severity) ttl() time.Duration {
+	return [...]time.Duration{4 * time.Second, 4 * time.Second, 6 * time.Second, 10 * time.Second}[s]
+}
+
+const (
+	noticeKeep = 200 // notices kept for the history drawer
+	maxToasts  = 4   // toasts on screen at once
+	toastWidth = 48
+	toastLines = 4 // text lines shown in a toast before it is cut short
+)
+
+type notice struct {
+	Time     time.Time
+	Severity severity
+	Title    string
+	Text     string
+	// modal notices are shown as a dialog rather than a toast.
+	modal bool
+}
+
+// notifier collects notices from any goroutine. Nothing here touches the
+// UI: kick tells the workspace to look, so posting never blocks, even
+// from the UI goroutine or before the application runs.
+type notifier struct {
+	mu      sync.Mutex
+	history []notice // oldest first
+	dialogs []notice // waiting to be shown
+	bell    bool     // ring the terminal bell at the next look
+	kick    chan struct{}
+}
+
+var notices = &notifier{kick: make(chan struct{}, 1)}
+
+func (n *notifier) post(e notice) {
+	n.mu.Lock()
+	n.history = append(n.history, e)
+	if len(n.history) > noticeKeep {
+		n.history = slices.Delete(n.history, 0, len(n.history)-noticeKeep)
+	}
+	if e.modal {
+		n.dialogs = append(n.dialogs, e)
+	}
+	n.mu.Unlock()
+	n.poke()
+	if !e.modal {
+		// Once more when the toast expires, so it is drawn away.
+		time.AfterFunc(e.Severity.ttl(), n.poke)
+	}
+}
+
+func (n *notifier) poke() {
+	select {
+	case n.kick <- struct{}{}:
+	default:
+	}
+}
+
+// toasts are the unexpired toasts, newest first. TTLs differ by
+// severity, so an expired Info toast can sit above an Error one that is
+// still showing; only a notice older than the longest TTL ends the walk.
+func (n *notifier) toasts(now time.Time) []notice {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []notice
+	for i := len(n.history) - 1; i >= 0 && len(out) < maxToasts; i-- {
+		e := n.history[i]
+		age := now.Sub(e.Time)
+		if age >= sevError.ttl() {
+			break
+		}
+		if age < e.Severity.ttl() && !e.modal {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (n *notifier) snapshot() []notice {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.history)
+}
+
+func (n *notifier) clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.history = nil
+}
+
+func (n *notifier) takeBell() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	b := n.bell
+	n.bell = false
+	return b
+}
+
+func (n *notifier) nextDialog() (notice, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.dialogs) == 0 {
+		return notice{}, false
+	}
+	e := n.dialogs[0]
+	n.dialogs = n.dialogs[1:]
+	return e, true
+}
+
+// toast shows a short-lived message in the corner of the screen. It is
+// safe to call from any goroutine.
+func toast(sev severity, format string, args ...any) {
+	notices.post(notice{Time: time.Now(), Severity: sev, Text: fmt.Sprintf(format, args...)})
+}
+
+// bell rings the terminal bell through the screen, so the escape never
+// lands in the middle of a frame. It is safe to call from any goroutine.
+func bell() {
+	notices.mu.Lock()
+	notices.bell = true
+	notices.mu.Unlock()
+	notices.poke()
+}
+
+// alertError shows err in a dialog that stays until it is acknowledged.
+// It is safe to call from any goroutine.
+func alertError(title string, err error) {
+	notices.post(notice{Time: time.Now(), Severity: sevError, Title: title, Text: err.Error(), modal: true})
+}
+
+// watchNotices redraws whenever a notice arrives or a toast expires,
+// until ctx is done.
+func (w *workspace) watchNotices(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-notices.kick:
+			w.app.QueueUpdateDraw(func() {
+				if notices.takeBell() {
+					w.screen.Beep()
+				}
+				if w.noticesChanged != nil {
+					w.noticesChanged()
+				}
+				w.showDialogs()
+			})
+		}
+	}
+}
+
+// showDialogs opens the next waiting dialog unless one is already up.
+func (w *workspace) showDialogs() {
+	if w.root.HasPage("dialog") {
+		return
+	}
+	if e, ok := notices.nextDialog(); ok {
+		w.openDialog(e)
+	}
+}
+
+func (w *workspace) openDialog(e notice) {
+	text := e.Text
+	if e.Title != "" {
+		text = e.Title + "\n\n" + text
+	}
+	m := tview.NewModal().SetText(text).AddButtons([]string{"OK"})
+	m.SetBorderColor(e.Severity.color()).
+		SetTitle(" " + e.Severity.String() + " ").
+		SetTitleColor(e.Severity.color())
+	m.SetDoneFunc(func(int, string) {
+		w.closeOverlay("dialog")
+		w.showDialogs()
+	})
+	w.show("dialog", m, m)
+}
+
+// drawToasts is the after-draw hook: toasts are painted over whatever is
+// on screen and never take the focus.
+func (w *workspace) drawToasts(screen tcell.Screen) {
+	if w.small {
+		return
+	}
+	width, height := screen.Size()
+	tw := min(toastWidth, width-2)
+	y := 1
+	for _, e := range notices.toasts(time.Now()) {
+		lines := tview.WordWrap(tview.Escape(e.Text), tw-2)
+		if len(lines) > toastLines {
+			lines = lines[:toastLines]
+			lines[toastLines-1] = strings.TrimRight(lines[toastLines-1], " ") + "…"
+		}
+		th := len(lines) + 2
+		if y+th > height {
+			break
+		}
+		view := tview.NewTextView().SetDynamicColors(true).SetText(strings.Join(lines, "\n"))
+		view.SetBorder(true).
+			SetBorderColor(e.Severity.color()).
+			SetTitle(" " + e.Severity.String() + " ").
+			SetTitleColor(e.Severity.color()).
+			SetTitleAlign(tview.AlignLeft)
+		view.SetRect(width-tw-1, y, tw, th)
+		view.Draw(screen)
+		y += th
+	}
+}
+
+// drawer docks a primitive against the right edge at full height.
+type drawer struct {
+	tview.Primitive
+	width int
+}
+
+func (d *drawer) SetRect(x, y, width, height int) {
+	w := min(d.width, width)
+	d.Primitive.SetRect(x+width-w, y, w, height)
+}
+
+// showNotices opens the notification history, newest first.
+func (w *workspace) showNotices() {
+	table := tview.NewTable().SetSelectable(true, false)
+	table.SetBorder(true).SetTitle(" Notifications ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	status.SetText("[gray]Enter details  c clear  Esc close")
+	var shown []notice
+
+	render := func() {
+		shown = notices.snapshot()
+		slices.Reverse(shown)
+		table.Clear()
+		for i, e := range shown {
+			text := e.Text
+			if e.Title != "" {
+				text = e.Title + ": " + text
+			}
+			table.SetCell(i, 0, tview.NewTableCell(e.Time.Format("15:04:05")).SetTextColor(tcell.ColorGray))
+			table.SetCell(i, 1, tview.NewTableCell(e.Severity.String()).SetTextColor(e.Severity.color()))
+			table.SetCell(i, 2, tview.NewTableCell(tview.Escape(text)).SetExpansion(1))
+		}
+		if len(shown) == 0 {
+			table.SetCell(0, 0, tview.NewTableCell("Nothing yet").
+				SetTextColor(tcell.ColorGray).
+				SetSelectable(false))
+		}
+	}
+	render()
+
+	table.SetSelectedFunc(func(row, _ int) {
+		if row < len(shown) {
+			w.openDialog(shown[row])
+		}
+	})
+	close := func() {
+		w.noticesChanged = nil
+		w.closeOverlay("notices")
+	}
+	table.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case ev.Key() == tcell.KeyEsc, ev.Key() == tcell.KeyRune && ev.Rune() == 'q':
+			close()
+			return nil
+		case ev.Key() == tcell.KeyRune && ev.Rune() == 'c':
+			notices.clear()
+			render()
+			return nil
+		}
+		return ev
+	})
+	w.noticesChanged = render
+
+	box := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(status, 1, 0, false)
+	w.show("notices", &drawer{box, 72}, box)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// modal centres a primitive over whatever is behind it, shrinking it to
+// fit small terminals.
+type modal struct {
+	tview.Primitive
+	width, height int
+}
+
+func (m *modal) SetRect(x, y, width, height int) {
+	w, h := min(m.width, width), min(m.height, height)
+	m.Primitive.SetRect(x+(width-w)/2, y+(height-h)/2, w, h)
+}
+
+// overlayFocus is an open overlay and what had focus before it.
+type overlayFocus struct {
+	name string
+	back tview.Primitive
+}
+
+// fuzzyScore reports whether the letters of pattern appear in s in order.
+// Consecutive letters and letters that start a word score higher.
+func fuzzyScore(pattern, s string) (int, bool) {
+	p := []rune(strings.ToLower(strings.ReplaceAll(pattern, " ", "")))
+	t := []rune(strings.ToLower(s))
+	score, pi, prev := 0, 0, -2
+	for i, r := range t {
+		if pi == len(p) {
+			break
+		}
+		if r != p[pi] {
+			continue
+		}
+		score++
+		if i == prev+1 {
+			score += 2
+		}
+		if i == 0 || t[i-1] == ' ' || t[i-1] == '-' {
+			score += 3
+		}
+		prev, pi = i, pi+1
+	}
+	return score, pi == len(p)
+}
+
+// overlay shows p on top of the grid and remembers what had focus.
+func (w *workspace) overlay(name string, p tview.Primitive, width, height int) {
+	w.show(name, &modal{p, width, height}, p)
+}
+
+// show adds page name over everything else and focuses p. Overlays
+// stack: closing one that is covered hands its saved focus to the one
+// above, so only the top one ever gives the focus back.
+func (w *workspace) show(name string, page, p tview.Primitive) {
+	w.dropOverlay(name)
+	w.overlays = append(w.overlays, overlayFocus{name, w.app.GetFocus()})
+	w.root.AddPage(name, page, true, true)
+	w.app.SetFocus(p)
+}
+
+func (w *workspace) closeOverlay(name string) {
+	w.root.RemovePage(name)
+	if back := w.dropOverlay(name); back != nil {
+		w.app.SetFocus(back)
+	}
+}
+
+// dropOverlay forgets overlay name, returning what to focus if it was on
+// top.
+func (w *workspace) dropOverlay(name string) tview.Primitive {
+	i := slices.IndexFunc(w.overlays, func(o overlayFocus) bool { return o.name == name })
+	if i < 0 {
+		return nil
+	}
+	back := w.overlays[i].back
+	w.overlays = slices.Delete(w.overlays, i, i+1)
+	if i < len(w.overlays) {
+		w.overlays[i].back = back
+		return nil
+	}
+	return back
+}
+
+// ask prompts for one line of text. done returning an error brings the
+// prompt back with the error showing.
+func (w *workspace) ask(title, label, text string, done func(string) error) {
+	input := tview.NewInputField().SetLabel(label).SetText(text)
+	status := tview.NewTextView().SetDynamicColors(true)
+	status.SetText("[gray]Enter ok  Esc cancel")
+	box := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(input, 1, 0, true).
+		AddItem(status, 0, 1, false)
+	box.SetBorder(true).SetTitle(" " + title + " ")
+
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			// Closed first so done can move the focus.
+			w.closeOverlay("ask")
+			if err := done(strings.TrimSpace(input.GetText())); err != nil {
+				status.SetText("[red]" + tview.Escape(err.Error()))
+				w.overlay("ask", box, 64, 5)
+			}
+		case tcell.KeyEsc:
+			w.closeOverlay("ask")
+		}
+	})
+	w.overlay("ask", box, 64, 5)
+}
+
+// captureKey makes the next key pressed the binding for action id.
+func (w *workspace) captureKey(id string, done func(msg string)) {
+	w.binding = func(ev *tcell.EventKey) {
+		w.binding = nil
+		if ev.Key() == tcell.KeyEsc {
+			done("Cancelled")
+			return
+		}
+		key := keyName(ev)
+		if err := w.keys.bind(id, key); err != nil {
+			done("[red]" + tview.Escape(err.Error()))
+			return
+		}
+		w.updateFooter()
+		done(tview.Escape(key) + " now runs " + tview.Escape(w.keys.actions[id].title))
+	}
+}
+
+// showHelp lists every binding that is active right now: the global
+// keymap, the menu, and the panels currently on screen.
+func (w *workspace) showHelp() {
+	table := tview.NewTable().SetSelectable(true, false)
+	table.SetBorder(true).SetTitle(" Key Bindings ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	status.SetText("[gray]Enter rebind  Del reset to default  Esc close")
+
+	row := 0
+	section := func(name string) {
+		if row > 0 {
+			table.SetCell(row, 0, tview.NewTableCell("").SetSelectable(false))
+			row++
+		}
+		table.SetCell(row, 0, tview.NewTableCell(tview.Escape(name)).
+			SetTextColor(tcell.ColorYellow).
+			SetSelectable(false))
+		row++
+	}
+	line := func(key, what string, ref any) {
+		table.SetCell(row, 0, tview.NewTableCell(" "+tview.Escape(key)).SetReference(ref))
+		table.SetCell(row, 1, tview.NewTableCell(tview.Escape(what)).SetExpansion(1))
+		row++
+	}
+	render := func() {
+		table.Clear()
+		row = 0
+		section("Global")
+		for _, id := range w.keys.order {
+			keys := w.keys.keys(id)
+			_, core := defaultBindings[id]
+			if len(keys) == 0 && !core {
+				continue
+			}
+			line(strings.Join(keys, ", "), w.keys.actions[id].title, id)
+		}
+		section("Tool menu")
+		line("Enter, m", "show the tool in the main slot", nil)
+		line("s", "show the tool in the side slot", nil)
+		line("e", "show the tool in the extra slot", nil)
+		line("x", "take the tool off the screen", nil)
+		for _, slot := range slotOrder {
+			id, ok := w.slots[slot]
+			if !ok || len(w.panels[id].Keys()) == 0 {
+				continue
+			}
+			section(w.panels[id].Title())
+			for _, k := range w.panels[id].Keys() {
+				line(k.Key, k.Action, nil)
+			}
+		}
+	}
+	render()
+	table.Select(1, 0)
+
+	id := func() string {
+		r, _ := table.GetSelection()
+		s, _ := table.GetCell(r, 0).GetReference().(string)
+		return s
+	}
+	table.SetSelectedFunc(func(int, int) {
+		if a := id(); a != "" {
+			status.SetText("Press the new key for " + tview.Escape(w.keys.actions[a].title) + ", or Esc")
+			w.captureKey(a, func(msg string) {
+				render()
+				status.SetText(msg)
+			})
+		}
+	})
+	table.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case ev.Key() == tcell.KeyEsc, ev.Key() == tcell.KeyRune && (ev.Rune() == '?' || ev.Rune() == 'q'):
+			w.closeOverlay("help")
+			return nil
+		case ev.Key() == tcell.KeyDelete, ev.Key() == tcell.KeyBackspace, ev.Key() == tcell.KeyBackspace2:
+			if a := id(); a != "" {
+				if err := w.keys.reset(a); err != nil {
+					status.SetText("[red]" + tview.Escape(err.Error()))
+					return nil
+				}
+				w.updateFooter()
+				render()
+				status.SetText(tview.Escape(w.keys.actions[a].title) + " is back to its default keys")
+			}
+			return nil
+		}
+		return ev
+	})
+
+	w.overlay("help", tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(table, 0, 1, true).
+		AddItem(status, 1, 0, false), 72, 30)
+}
+
+// showPalette opens the fuzzy command palette over every registered
+// action.
+func (w *workspace) showPalette() {
+	input := tview.NewInputField().SetLabel(": ")
+	list := tview.NewList().ShowSecondaryText(false)
+	status := tview.NewTextView().SetDynamicColors(true)
+	status.SetText("[gray]Enter run  Tab bind a key  Esc close")
+	var matches []string
+
+	filter := func(text string) {
+		type scored struct {
+			id    string
+			score int
+		}
+		var hits []scored
+		for _, id := range w.keys.order {
+			if id == "palette" {
+				continue
+			}
+			if s, ok := fuzzyScore(text, w.keys.actions[id].title); ok {
+				hits = append(hits, scored{id, s})
+			}
+		}
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+		list.Clear()
+		matches = matches[:0]
+		for _, h := range hits {
+			label := tview.Escape(w.keys.actions[h.id].title)
+			if keys := w.keys.keys(h.id); len(keys) > 0 {
+				label += "  [gray]" + tview.Escape(strings.Join(keys, ", "))
+			}
+			list.AddItem(label, "", 0, nil)
+			matches = append(matches, h.id)
+		}
+	}
+	filter("")
+	input.SetChangedFunc(filter)
+
+	selected := func() string {
+		if i := list.GetCurrentItem(); i >= 0 && i < len(matches) {
+			return matches[i]
+		}
+		return ""
+	}
+	input.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		switch ev.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			list.InputHandler()(ev, nil)
+			return nil
+		}
+		return ev
+	})
+	input.SetDoneFunc(func(key tcell.Key) {
+		id := selected()
+		switch key {
+		case tcell.KeyEnter:
+			w.closeOverlay("palette")
+			if id != "" {
+				w.keys.actions[id].run()
+			}
+		case tcell.KeyTab:
+			if id != "" {
+				status.SetText("Press the new key for " + tview.Escape(w.keys.actions[id].title) + ", or Esc")
+				w.captureKey(id, func(msg string) {
+					filter(input.GetText())
+					status.SetText(msg)
+				})
+			}
+		case tcell.KeyEsc:
+			w.closeOverlay("palette")
+		}
+	})
+
+	box := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(input, 1, 0, true).
+		AddItem(list, 0, 1, false).
+		AddItem(status, 1, 0, false)
+	box.SetBorder(true).SetTitle(" Commands ")
+	w.overlay("palette", box, 64, 20)
+	w.app.SetFocus(input)
+}
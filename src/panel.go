@@ -0,0 +1,68 @@
+package main
+
+import "github.com/rivo/tview"
+
+// Panel is a tool that can be placed in any of the layout slots.
+type Panel interface {
+	Title() string
+	Primitive() tview.Primitive
+	// Refresh is called each time the panel is placed in a slot.
+	Refresh()
+	// Keys lists the panel's own bindings for the help screen.
+	Keys() []KeyHint
+	// Start is called once the application is up and Stop as it exits,
+	// so panels can own background work.
+	Start()
+	Stop()
+}
+
+type KeyHint struct {
+	Key    string
+	Action string
+}
+
+// escHint is the focus hop most panels share.
+var escHint = KeyHint{"Esc", "switch between the form and results"}
+
+// basicPanel adapts a primitive and optional hooks to Panel.
+type basicPanel struct {
+	title   string
+	prim    tview.Primitive
+	keys    []KeyHint
+	refresh func()
+	start   func()
+	stop    func()
+}
+
+func (p *basicPanel) Title() string              { return p.title }
+func (p *basicPanel) Primitive() tview.Primitive { return p.prim }
+func (p *basicPanel) Keys() []KeyHint            { return p.keys }
+
+func (p *basicPanel) Refresh() {
+	if p.refresh != nil {
+		p.refresh()
+	}
+}
+
+func (p *basicPanel) Start() {
+	if p.start != nil {
+		p.start()
+	}
+}
+
+func (p *basicPanel) Stop() {
+	if p.stop != nil {
+		p.stop()
+	}
+}
+
+var panelRegistry = map[string]func(*tview.Application) Panel{}
+
+// registerPanel makes a tool available to the layout under a stable id,
+// which is what saved layouts refer to. Tools call it from init.
+func registerPanel(id string, build func(*tview.Application) Panel) {
+	if _, dup := panelRegistry[id]; dup {
+		panic("panel registered twice: " + id)
+	}
+	panelRegistry[id] = build
+}
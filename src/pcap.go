@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// packetView is the list / detail / hex-dump trio shared by the capture
+// file reader and live capture.
+type packetView struct {
+	root    *tview.Flex
+	list    *tview.Table
+	detail  *tview.TreeView
+	dump    *tview.TextView
+	packets []*QCom.Packet
+	shown   []*QCom.Packet
+	filter  *QCom.DisplayFilter
+}
+
+func newPacketView() *packetView {
+	v := &packetView{
+		list:   tview.NewTable().SetFixed(1, 0).SetSelectable(true, false),
+		detail: tview.NewTreeView(),
+		dump:   tview.NewTextView().SetWrap(false),
+	}
+	v.detail.SetBorder(true).SetTitle(" Detail ")
+	v.dump.SetBorder(true).SetTitle(" Bytes ")
+	v.list.SetSelectionChangedFunc(func(row, column int) {
+		if row >= 1 && row-1 < len(v.shown) {
+			v.showPacket(v.shown[row-1])
+		}
+	})
+	v.header()
+	v.root = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(v.list, 0, 2, true).
+		AddItem(tview.NewFlex().
+			AddItem(v.detail, 0, 1, false).
+			AddItem(v.dump, 0, 1, false), 0, 1, false)
+	return v
+}
+
+func (v *packetView) header() {
+	v.list.Clear()
+	for i, h := range []string{"No.", "Time", "Source", "Destination", "Protocol", "Length", "Info"} {
+		v.list.SetCell(0, i, tview.NewTableCell(h).
+			SetTextColor(tcell.ColorYellow).
+			SetSelectable(false))
+	}
+}
+
+func (v *packetView) reset() {
+	v.packets, v.shown = nil, nil
+	v.header()
+	v.detail.SetRoot(nil)
+	v.dump.Clear()
+}
+
+// add appends a packet and shows it if it passes the current filter.
+func (v *packetView) add(p *QCom.Packet) {
+	v.packets = append(v.packets, p)
+	if v.filter.Match(p) {
+		v.appendRow(p)
+	}
+}
+
+func (v *packetView) appendRow(p *QCom.Packet) {
+	v.shown = append(v.shown, p)
+	var rel float64
+	if first := v.packets[0]; !first.Time.IsZero() {
+		rel = p.Time.Sub(first.Time).Seconds()
+	}
+	row := v.list.GetRowCount()
+	color := protoColor(p.Proto)
+	for c, s := range []string{
+		strconv.Itoa(p.Num), strconv.FormatFloat(rel, 'f', 6, 64), p.Src, p.Dst, p.Proto, strconv.Itoa(p.Len), p.Info,
+	} {
+		cell := tview.NewTableCell(tview.Escape(s)).SetTextColor(color)
+		if c == 6 {
+			cell.SetExpansion(1)
+		}
+		v.list.SetCell(row, c, cell)
+	}
+}
+
+func (v *packetView) setFilter(f *QCom.DisplayFilter) {
+	v.filter = f
+	v.shown = nil
+	v.header()
+	for _, p := range v.packets {
+		if f.Match(p) {
+			v.appendRow(p)
+		}
+	}
+	v.list.ScrollToBeginning()
+}
+
+func protoColor(proto string) tcell.Color {
+	switch proto {
+	case "TCP":
+		return tcell.ColorLightBlue
+	case "UDP":
+		return tcell.ColorLightCyan
+	case "DNS", "MDNS":
+		return tcell.ColorLightGreen
+	case "ICMP", "ICMPV6":
+		return tcell.ColorPink
+	case "ARP":
+		return tcell.ColorYellow
+	}
+	return tcell.ColorWhite
+}
+
+func (v *packetView) showPacket(p *QCom.Packet) {
+	root := tview.NewTreeNode(fmt.Sprintf("Frame %d: %d bytes", p.Num, p.Len))
+	for _, l := range p.Layers {
+		ln := tview.NewTreeNode(strings.ToUpper(l.Name)).SetColor(tcell.ColorYellow)
+		for _, f := range l.Fields {
+			ln.AddChild(tview.NewTreeNode(tview.Escape(f.Name + ": " + f.Value)).SetSelectable(false))
+		}
+		ln.SetExpanded(l.Name != "frame")
+		root.AddChild(ln)
+	}
+	v.detail.SetRoot(root).SetCurrentNode(root)
+	v.dump.SetText(hex.Dump(p.Data)).ScrollToBeginning()
+}
+
+func init() { registerPanel("pcap", pcapPanel) }
+
+func pcapPanel(app *tview.Application) Panel {
+	view := newPacketView()
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm().SetHorizontal(true)
+	body, toggleFlows := withFlows(app, view, form)
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+
+	open := func() {
+		path := field("File")
+		status.SetText("Reading " + path + "...")
+		go func() {
+			caps, err := QCom.ReadCaptureFile(path)
+			// Decoding a large file takes a while; do it here so the UI
+			// only has to fill in the rows.
+			packets := make([]*QCom.Packet, len(caps))
+			for i, c := range caps {
+				packets[i] = QCom.DecodePacket(i+1, c)
+			}
+			app.QueueUpdateDraw(func() {
+				view.reset()
+				for _, p := range packets {
+					view.add(p)
+				}
+				msg := fmt.Sprintf("%d packets, %d shown", len(view.packets), len(view.shown))
+				if err != nil {
+					msg = "[red]" + err.Error() + "[-] after " + msg
+				}
+				status.SetText(msg)
+			})
+		}()
+	}
+
+	apply := func() {
+		f, err := QCom.CompileFilter(field("Filter"))
+		if err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		view.setFilter(f)
+		status.SetText(fmt.Sprintf("%d packets, %d shown", len(view.packets), len(view.shown)))
+	}
+
+	form.AddInputField("File", "capture.pcapng", 30, nil, nil).
+		AddButton("Open", open).
+		AddInputField("Filter", "", 40, nil, nil).
+		AddButton("Apply", apply).
+		AddButton("Flows", toggleFlows)
+
+	return &basicPanel{
+		title: "Capture Files",
+		prim: tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(form, 3, 0, true).
+			AddItem(body, 0, 1, false).
+			AddItem(status, 1, 0, false),
+		keys: flowKeys,
+	}
+}
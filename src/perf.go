@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/rivo/tview"
+)
+
+// parseSize reads byte counts like 1400, 64K or 1M (binary multiples).
+func parseSize(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	mult := 1
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mult, s = 1<<10, s[:n-1]
+		case 'm', 'M':
+			mult, s = 1<<20, s[:n-1]
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return n * mult, nil
+}
+
+// perfLine formats an interval the way both the panel and the CLI print it.
+func perfLine(iv QCom.PerfInterval, proto string) string {
+	line := fmt.Sprintf("%6.2f-%-6.2f s  %10s  %11s",
+		iv.Start.Seconds(), iv.End.Seconds(), humanBytes(int(iv.Bytes)), QCom.FormatBits(iv.BitsPerSec()))
+	if proto == "udp" {
+		line += fmt.Sprintf("  %8s jitter  %d/%d lost (%.2g%%)",
+			fmtRTT(iv.Jitter), iv.Lost, iv.Packets+iv.Lost, iv.LossPercent())
+		if iv.Dups > 0 {
+			line += fmt.Sprintf("  %d dup", iv.Dups)
+		}
+	}
+	return line
+}
+
+func init() { registerPanel("perf", perfPanel) }
+
+func perfPanel(app *tview.Application) Panel {
+	var (
+		cancel context.CancelFunc
+		rates  []float64
+		lines  []string
+		// run counts starts; only the UI goroutine touches it. Anything
+		// a replaced run reports afterwards is dropped.
+		run int
+	)
+
+	graph := tview.NewTextView().SetDynamicColors(true)
+	graph.SetBorder(true).SetTitle(" Throughput ")
+	logView := tview.NewTextView().SetScrollable(true)
+	logView.SetBorder(true).SetTitle(" Intervals ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	option := func(label string) string {
+		_, o := form.GetFormItemByLabel(label).(*tview.DropDown).GetCurrentOption()
+		return o
+	}
+
+	redraw := func() {
+		_, _, w, h := graph.GetInnerRect()
+		peak := 0.0
+		for _, r := range rates {
+			peak = max(peak, r)
+		}
+		graph.SetTitle(" Throughput, peak " + QCom.FormatBits(peak) + " ")
+		graph.SetText("[green]" + barChart(rates, w, max(h, 1)))
+		logView.SetText(strings.Join(lines, "\n")).ScrollToEnd()
+	}
+
+	// record is called from QCom goroutines.
+	record := func(gen int, prefix string, iv QCom.PerfInterval, proto string) {
+		app.QueueUpdateDraw(func() {
+			if gen != run {
+				return
+			}
+			rates = append(rates, iv.BitsPerSec())
+			lines = append(lines, prefix+perfLine(iv, proto))
+			redraw()
+		})
+	}
+
+	stop := func() {
+		if cancel != nil {
+			cancel()
+			cancel = nil
+		}
+	}
+
+	start := func() {
+		stop()
+		run++
+		gen := run
+		rates, lines = nil, nil
+		redraw()
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+
+		if option("Mode") == "Server" {
+			srv, err := QCom.ListenPerf(field("Address"))
+			if err != nil {
+				status.SetText("[red]" + tview.Escape(err.Error()))
+				return
+			}
+			srv.OnInterval = func(remote string, cfg QCom.PerfConfig, iv QCom.PerfInterval) {
+				record(gen, remote+"  ", iv, cfg.Proto)
+			}
+			srv.OnDone = func(remote string, r *QCom.PerfResult) {
+				app.QueueUpdateDraw(func() {
+					if gen != run {
+						return
+					}
+					lines = append(lines, remote+"  total "+perfLine(r.Total, r.Config.Proto), "")
+					redraw()
+				})
+			}
+			status.SetText("Listening on " + srv.Addr().String())
+			go func() {
+				err := srv.Serve(ctx)
+				app.QueueUpdateDraw(func() {
+					if gen != run {
+						return
+					}
+					if err != nil {
+						status.SetText("[red]" + tview.Escape(err.Error()))
+					} else {
+						status.SetText("Server stopped")
+					}
+				})
+			}()
+			return
+		}
+
+		cfg := QCom.DefaultPerfConfig()
+		cfg.Proto = strings.ToLower(option("Protocol"))
+		cfg.Streams, _ = strconv.Atoi(field("Streams"))
+		secs, _ := strconv.Atoi(field("Seconds"))
+		cfg.Duration = time.Duration(secs) * time.Second
+		var err error
+		if cfg.BufSize, err = parseSize(field("Buffer")); err == nil {
+			cfg.Bitrate, err = QCom.ParseBitrate(field("Bitrate"))
+		}
+		if err != nil {
+			status.SetText("[red]" + tview.Escape(err.Error()))
+			return
+		}
+		addr := field("Address")
+		status.SetText("Testing " + cfg.Proto + " to " + tview.Escape(addr) + "...")
+		go func() {
+			r, err := QCom.RunPerfClient(ctx, addr, cfg, func(iv QCom.PerfInterval) {
+				record(gen, "", iv, cfg.Proto)
+			})
+			app.QueueUpdateDraw(func() {
+				if gen != run {
+					return
+				}
+				if err != nil {
+					status.SetText("[red]" + tview.Escape(err.Error()))
+					return
+				}
+				lines = append(lines, "", "receiver "+perfLine(r.Total, cfg.Proto),
+					fmt.Sprintf("sender   %s in %d stream(s)", humanBytes(int(r.Sent)), len(r.StreamBytes)))
+				redraw()
+				status.SetText("Done: " + QCom.FormatBits(r.Total.BitsPerSec()))
+			})
+		}()
+	}
+
+	protos := []string{"TCP", "UDP"}
+	form.AddDropDown("Mode", []string{"Client", "Server"}, 0, nil).
+		AddInputField("Address", config.Perf.Address, 0, nil, nil).
+		AddDropDown("Protocol", protos, optionIndex(protos, config.Perf.Proto), nil).
+		AddInputField("Streams", strconv.Itoa(config.Perf.Streams), 5, tview.InputFieldInteger, nil).
+		AddInputField("Seconds", strconv.Itoa(int(config.Perf.Duration/time.Second)), 5, tview.InputFieldInteger, nil).
+		AddInputField("Buffer", config.Perf.Buffer, 8, nil, nil).
+		AddInputField("Bitrate", config.Perf.Bitrate, 8, nil, nil).
+		AddButton("Start", start).
+		AddButton("Stop", stop)
+	form.SetBorder(true).SetTitle(" Throughput Test ")
+
+	return &basicPanel{
+		title: "Throughput",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(graph, 12, 0, false).
+				AddItem(logView, 0, 1, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		stop: stop,
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+)
+
+const perfUsage = `usage: qube perf server [-json|-yaml] [-addr host:port]
+       qube perf client [-json|-yaml] [-u] [-P streams] [-t secs] [-l size] [-b bitrate] [-i secs] host[:port]`
+
+type perfIntervalResult struct {
+	StartS     float64 `json:"start_s" yaml:"start_s"`
+	EndS       float64 `json:"end_s" yaml:"end_s"`
+	Bytes      int64   `json:"bytes" yaml:"bytes"`
+	BitsPerSec float64 `json:"bits_per_sec" yaml:"bits_per_sec"`
+	Packets    int64   `json:"packets,omitempty" yaml:"packets,omitempty"`
+	Lost       int64   `json:"lost,omitempty" yaml:"lost,omitempty"`
+	LossPct    float64 `json:"loss_pct,omitempty" yaml:"loss_pct,omitempty"`
+	Dups       int64   `json:"dups,omitempty" yaml:"dups,omitempty"`
+	JitterMS   float64 `json:"jitter_ms,omitempty" yaml:"jitter_ms,omitempty"`
+}
+
+// perfResult is one finished test. Client is set on the server's side,
+// Server and Sent on the client's.
+type perfResult struct {
+	Server      string               `json:"server,omitempty" yaml:"server,omitempty"`
+	Client      string               `json:"client,omitempty" yaml:"client,omitempty"`
+	Proto       string               `json:"proto" yaml:"proto"`
+	Streams     int                  `json:"streams" yaml:"streams"`
+	Intervals   []perfIntervalResult `json:"intervals" yaml:"intervals"`
+	Total       perfIntervalResult   `json:"total" yaml:"total"`
+	Sent        int64                `json:"sent,omitempty" yaml:"sent,omitempty"`
+	StreamBytes []int64              `json:"stream_bytes,omitempty" yaml:"stream_bytes,omitempty"`
+}
+
+func perfIntervalOut(iv QCom.PerfInterval) perfIntervalResult {
+	return perfIntervalResult{
+		StartS: iv.Start.Seconds(), EndS: iv.End.Seconds(), Bytes: iv.Bytes, BitsPerSec: iv.BitsPerSec(),
+		Packets: iv.Packets, Lost: iv.Lost, LossPct: iv.LossPercent(), Dups: iv.Dups, JitterMS: millis(iv.Jitter),
+	}
+}
+
+func perfResultOut(r *QCom.PerfResult) perfResult {
+	out := perfResult{
+		Proto: r.Config.Proto, Streams: r.Config.Streams, Total: perfIntervalOut(r.Total),
+		Sent: r.Sent, StreamBytes: r.StreamBytes, Intervals: []perfIntervalResult{},
+	}
+	for _, iv := range r.Intervals {
+		out.Intervals = append(out.Intervals, perfIntervalOut(iv))
+	}
+	return out
+}
+
+// perfFlags adds the output flags, which perf shares with the other
+// commands; it needs no login, so it takes nothing else of theirs.
+func perfFlags(fs *flag.FlagSet) func(c *cli) bool {
+	asJSON := fs.Bool("json", false, "print JSON")
+	asYAML := fs.Bool("yaml", false, "print YAML")
+	return func(c *cli) bool {
+		switch {
+		case *asJSON && *asYAML:
+			return false
+		case *asJSON:
+			c.format = "json"
+		case *asYAML:
+			c.format = "yaml"
+		}
+		return true
+	}
+}
+
+// runPerf handles "qube perf ..." without starting the TUI and returns the
+// process exit code.
+func runPerf(args []string) int {
+	c := &cli{name: "perf", format: "table", out: os.Stdout}
+	if len(args) == 0 {
+		return c.usage(perfUsage)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch args[0] {
+	case "server", "-s":
+		fs := flag.NewFlagSet("perf server", flag.ContinueOnError)
+		addr := fs.String("addr", fmt.Sprintf(":%d", QCom.PerfPort), "listen address")
+		format := perfFlags(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return exitUsage
+		}
+		if !format(c) {
+			return c.usage("-json and -yaml can't be used together")
+		}
+		srv, err := QCom.ListenPerf(*addr)
+		if err != nil {
+			return c.fail(err)
+		}
+		// Machine-readable output is one document per finished test.
+		// Clients are served concurrently, so output is serialised.
+		var (
+			mu      sync.Mutex
+			emitErr error
+		)
+		srv.OnInterval = func(remote string, cfg QCom.PerfConfig, iv QCom.PerfInterval) {
+			if c.format == "table" {
+				mu.Lock()
+				fmt.Fprintf(c.out, "[%s] %s\n", remote, perfLine(iv, cfg.Proto))
+				mu.Unlock()
+			}
+		}
+		srv.OnDone = func(remote string, r *QCom.PerfResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			out := perfResultOut(r)
+			out.Client = remote
+			if c.format == "yaml" {
+				fmt.Fprintln(c.out, "---")
+			}
+			if err := c.emit(out, func(w io.Writer) {
+				fmt.Fprintf(w, "[%s] total %s\n\n", remote, perfLine(r.Total, r.Config.Proto))
+			}); err != nil && emitErr == nil {
+				emitErr = err
+			}
+		}
+		if c.format == "table" {
+			fmt.Fprintf(c.out, "Listening on %s (tcp and udp)\n", srv.Addr())
+		}
+		if err := srv.Serve(ctx); err != nil {
+			return c.fail(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return c.result(emitErr, true)
+
+	case "client", "-c":
+		cfg := QCom.DefaultPerfConfig()
+		fs := flag.NewFlagSet("perf client", flag.ContinueOnError)
+		udp := fs.Bool("u", false, "use UDP")
+		fs.IntVar(&cfg.Streams, "P", cfg.Streams, "parallel streams")
+		secs := fs.Float64("t", cfg.Duration.Seconds(), "test length in seconds")
+		interval := fs.Float64("i", cfg.Interval.Seconds(), "report interval in seconds")
+		size := fs.String("l", "", "write size, e.g. 128K (default 128K for TCP, 1400 for UDP)")
+		rate := fs.String("b", "1M", "UDP target bitrate, e.g. 100M")
+		format := perfFlags(fs)
+		rest, err := parseFlags(fs, args[1:])
+		if err != nil {
+			return exitUsage
+		}
+		if !format(c) {
+			return c.usage("-json and -yaml can't be used together")
+		}
+		if len(rest) != 1 {
+			return c.usage(perfUsage)
+		}
+		if *udp {
+			cfg.Proto = "udp"
+		}
+		cfg.Duration = time.Duration(*secs * float64(time.Second))
+		cfg.Interval = time.Duration(*interval * float64(time.Second))
+		if *size != "" {
+			if cfg.BufSize, err = parseSize(*size); err != nil {
+				return c.usage(err.Error())
+			}
+		}
+		if cfg.Bitrate, err = QCom.ParseBitrate(*rate); err != nil {
+			return c.usage(err.Error())
+		}
+
+		server := rest[0]
+		if c.format == "table" {
+			fmt.Fprintf(c.out, "Connecting to %s, %s, %d stream(s), %s\n", server, cfg.Proto, cfg.Streams, cfg.Duration)
+		}
+		r, err := QCom.RunPerfClient(ctx, server, cfg, func(iv QCom.PerfInterval) {
+			if c.format == "table" {
+				fmt.Fprintln(c.out, perfLine(iv, cfg.Proto))
+			}
+		})
+		if err != nil {
+			return c.fail(err)
+		}
+		out := perfResultOut(r)
+		out.Server = server
+		return c.result(c.emit(out, func(w io.Writer) {
+			fmt.Fprintln(w, "- - - - - - - - - - - - - - - - - - - - - - - - -")
+			fmt.Fprintln(w, "receiver "+perfLine(r.Total, cfg.Proto))
+			for i, n := range r.StreamBytes {
+				fmt.Fprintf(w, "stream %d sent %s\n", i+1, humanBytes(int(n)))
+			}
+		}), true)
+	}
+	return c.usage(perfUsage)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// pingTrendLen bounds the stored per-minute history to about ten hours,
+// which keeps each record under bitcask's value size limit.
+const pingTrendLen = 600
+
+type pingTrend struct {
+	Time    time.Time `json:"t"`
+	AvgMs   float64   `json:"avg"`
+	Loss    float64   `json:"loss"`
+	Samples int       `json:"n"`
+}
+
+type pingMinute struct {
+	start      time.Time
+	sent, recv int
+	total      time.Duration
+}
+
+func pingTrendKey(target string) string { return "ping:" + target }
+
+func loadPingTrend(target string) []pingTrend {
+	var t []pingTrend
+	QbDB.LoadRecord(pingTrendKey(target), &t)
+	return t
+}
+
+func savePingTrend(target string, t []pingTrend) error {
+	if len(t) > pingTrendLen {
+		t = t[len(t)-pingTrendLen:]
+	}
+	if err := QbDB.SaveRecord(pingTrendKey(target), t); err != nil {
+		return fmt.Errorf("saving trend for %s: %w", target, err)
+	}
+	return nil
+}
+
+// addMinute appends a finished minute to trend. A minute already there,
+// from before a stop and restart, is merged with it instead.
+func addMinute(trend []pingTrend, b *pingMinute) []pingTrend {
+	recv, total := b.recv, b.total
+	sent := b.sent
+	if n := len(trend); n > 0 && trend[n-1].Time.Equal(b.start) {
+		last := trend[n-1]
+		lastRecv := int(math.Round(float64(last.Samples) * (1 - last.Loss/100)))
+		if lastRecv > 0 {
+			total += time.Duration(last.AvgMs * float64(time.Millisecond) * float64(lastRecv))
+		}
+		sent, recv = sent+last.Samples, recv+lastRecv
+		trend = trend[:n-1]
+	}
+	t := pingTrend{Time: b.start, Samples: sent, Loss: 100 * float64(sent-recv) / float64(sent), AvgMs: -1}
+	if recv > 0 {
+		t.AvgMs = float64(total/time.Duration(recv)) / float64(time.Millisecond)
+	}
+	return append(trend, t)
+}
+
+func init() { registerPanel("ping", pingPanel) }
+
+func pingPanel(app *tview.Application) Panel {
+	var (
+		mu      sync.Mutex
+		cancel  context.CancelFunc
+		monitor *QCom.PingMonitor
+		trends  = map[string][]pingTrend{}
+		minutes = map[string]*pingMinute{}
+		// run counts monitors started and stopped; samples that a
+		// stopped or replaced monitor delivers late are dropped.
+		run int
+	)
+
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	graph := tview.NewTextView().SetDynamicColors(true)
+	graph.SetBorder(true).SetTitle(" Latency ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
+	}
+
+	renderGraph := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		row, _ := table.GetSelection()
+		if monitor == nil || row < 1 {
+			return
+		}
+		stats := monitor.Stats()
+		if row-1 >= len(stats) {
+			return
+		}
+		s := stats[row-1]
+		_, _, w, _ := graph.GetInnerRect()
+		var live []float64
+		for _, h := range s.History {
+			live = append(live, float64(h)/float64(time.Millisecond))
+		}
+		var trend []float64
+		for _, t := range trends[s.Target] {
+			trend = append(trend, t.AvgMs)
+		}
+		graph.SetText(fmt.Sprintf("%s  last %s ms\n[lime]%s[-]\n\nper-minute average (%d min)\n[lime]%s[-]",
+			s.Target, ms(s.Last), sparkline(live, w), len(trend), sparkline(trend, w)))
+	}
+
+	render := func() {
+		table.Clear()
+		for i, h := range []string{"Target", "Via", "Sent", "Loss%", "Last", "Min", "Avg", "Max", "StdDev", "Jitter"} {
+			table.SetCell(0, i, tview.NewTableCell(h).
+				SetTextColor(tcell.ColorYellow).
+				SetSelectable(false))
+		}
+		mu.Lock()
+		m := monitor
+		mu.Unlock()
+		if m == nil {
+			return
+		}
+		for r, s := range m.Stats() {
+			color := tcell.ColorLime
+			if s.Loss() > 0 {
+				color = tcell.ColorOrange
+			}
+			if s.Sent > 0 && s.Received == 0 {
+				color = tcell.ColorRed
+			}
+			for c, v := range []string{
+				s.Target, s.Method, strconv.Itoa(s.Sent), strconv.FormatFloat(s.Loss(), 'f', 1, 64),
+				ms(s.Last), ms(s.Min), ms(s.Avg), ms(s.Max), ms(s.StdDev), ms(s.Jitter),
+			} {
+				table.SetCell(r+1, c, tview.NewTableCell(v).SetTextColor(color))
+			}
+		}
+		renderGraph()
+	}
+	table.SetSelectionChangedFunc(func(row, column int) { renderGraph() })
+
+	// record folds a sample into its minute bucket and persists the bucket
+	// once the minute rolls over. It runs on the monitor's goroutine, and
+	// saves under mu so halt's flush can't be overwritten by an older trend.
+	// It reports false, and records nothing, for a sample from run gen
+	// once that monitor has been stopped.
+	record := func(gen int, s QCom.PingSample) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if gen != run {
+			return false, nil
+		}
+		var err error
+		b := minutes[s.Target]
+		now := s.Time.Truncate(time.Minute)
+		if b != nil && !b.start.Equal(now) && b.sent > 0 {
+			trends[s.Target] = addMinute(trends[s.Target], b)
+			err = savePingTrend(s.Target, trends[s.Target])
+			b = nil
+		}
+		if b == nil {
+			b = &pingMinute{start: now}
+			minutes[s.Target] = b
+		}
+		b.sent++
+		if !s.Lost {
+			b.recv++
+			b.total += s.RTT
+		}
+		return true, err
+	}
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+
+	// halt stops the monitor and saves the minutes in progress, which
+	// would otherwise be lost.
+	halt := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		run++
+		if cancel == nil {
+			return nil
+		}
+		cancel()
+		cancel = nil
+		var errs []error
+		for target, b := range minutes {
+			if b.sent == 0 {
+				continue
+			}
+			trends[target] = addMinute(trends[target], b)
+			errs = append(errs, savePingTrend(target, trends[target]))
+		}
+		minutes = map[string]*pingMinute{}
+		return errors.Join(errs...)
+	}
+	stop := func() {
+		if err := halt(); err != nil {
+			status.SetText("[red]" + err.Error())
+		}
+	}
+
+	start := func() {
+		saveErr := halt()
+		targets := strings.FieldsFunc(field("Targets"), func(r rune) bool { return r == ',' || r == ' ' })
+		if len(targets) == 0 {
+			status.SetText("[red]No targets given")
+			return
+		}
+		for _, t := range targets {
+			// Keys are capped at 64 bytes, prefix included.
+			if len(pingTrendKey(t)) > 64 {
+				status.SetText("[red]Target name is too long: " + t)
+				return
+			}
+		}
+		interval, err := strconv.Atoi(field("Interval ms"))
+		if err != nil || interval < 100 {
+			interval = 1000
+		}
+		_, method := form.GetFormItemByLabel("Method").(*tview.DropDown).GetCurrentOption()
+
+		m := QCom.NewPingMonitor(targets)
+		m.Interval = time.Duration(interval) * time.Millisecond
+		m.Method, m.Timeout, m.TCPPort = method, config.Ping.Timeout, config.Ping.TCPPort
+		mu.Lock()
+		gen := run
+		mu.Unlock()
+		m.OnSample = func(s QCom.PingSample) {
+			current, err := record(gen, s)
+			if !current {
+				return
+			}
+			app.QueueUpdateDraw(func() {
+				mu.Lock()
+				stale := gen != run
+				mu.Unlock()
+				if stale {
+					return
+				}
+				render()
+				if err != nil {
+					status.SetText("[red]" + err.Error())
+				}
+			})
+		}
+		ctx, c := context.WithCancel(context.Background())
+		mu.Lock()
+		monitor, cancel = m, c
+		minutes = map[string]*pingMinute{}
+		for _, t := range targets {
+			trends[t] = loadPingTrend(t)
+		}
+		mu.Unlock()
+		render()
+		table.Select(1, 0)
+		msg := fmt.Sprintf("Monitoring %d targets every %dms", len(targets), interval)
+		if saveErr != nil {
+			msg += "; [red]" + saveErr.Error()
+		}
+		status.SetText(msg)
+		go m.Run(ctx)
+	}
+
+	methods := []string{QCom.PingAuto, QCom.PingICMP, QCom.PingTCP}
+	form.AddInputField("Targets", "127.0.0.1", 0, nil, nil).
+		AddInputField("Interval ms", strconv.FormatInt(config.Ping.Interval.Milliseconds(), 10), 6, tview.InputFieldInteger, nil).
+		AddDropDown("Method", methods, optionIndex(methods, config.Ping.Method), nil).
+		AddButton("Start", start).
+		AddButton("Stop", func() {
+			if err := halt(); err != nil {
+				status.SetText("[red]" + err.Error())
+				return
+			}
+			status.SetText("Stopped")
+		})
+	form.SetBorder(true).SetTitle(" Ping Monitor ")
+	render()
+
+	return &basicPanel{
+		title: "Ping",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(table, 0, 1, false).
+				AddItem(graph, 8, 0, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		stop: stop,
+	}
+}
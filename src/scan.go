@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+type savedScan struct {
+	Started time.Time         `json:"started"`
+	Targets string            `json:"targets"`
+	Ports   string            `json:"ports"`
+	Results []QCom.ScanResult `json:"results"`
+	// Part and Parts number the records a large scan is split across;
+	// part n > 1 is saved under the first part's key plus "#n".
+	Part  int `json:"part,omitempty"`
+	Parts int `json:"parts,omitempty"`
+}
+
+// scanPartSize is how many bytes of results go in one record, under
+// bitcask's 64KiB max_value_size with room for the other fields.
+const scanPartSize = 56 << 10
+
+// saveScan stores s under key, split into parts that each fit a record.
+// Parts left over from an earlier, larger save under the same key are
+// deleted, so they can't be read back as part of this scan.
+func saveScan(key string, s savedScan) (int, error) {
+	var parts [][]QCom.ScanResult
+	var part []QCom.ScanResult
+	size := 0
+	for _, r := range s.Results {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return 0, err
+		}
+		if size+len(b) > scanPartSize && len(part) > 0 {
+			parts, part, size = append(parts, part), nil, 0
+		}
+		part, size = append(part, r), size+len(b)+1
+	}
+	parts = append(parts, part)
+	for i, results := range parts {
+		s.Results, s.Part, s.Parts = results, i+1, len(parts)
+		k := key
+		if i > 0 {
+			k = fmt.Sprintf("%s#%d", key, i+1)
+		}
+		if err := QbDB.SaveRecord(k, s); err != nil {
+			return 0, err
+		}
+	}
+	old, err := QbDB.ListRecords(key + "#")
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range old {
+		if n, err := strconv.Atoi(strings.TrimPrefix(k, key+"#")); err == nil && n <= len(parts) {
+			continue
+		}
+		if err := QbDB.DeleteRecord(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(parts), nil
+}
+
+func init() { registerPanel("scan", scanPanel) }
+
+func scanPanel(app *tview.Application) Panel {
+	var (
+		mu      sync.Mutex
+		cancel  context.CancelFunc
+		results []QCom.ScanResult
+		// scanned is what the last Start scanned, for Save; run counts
+		// starts so a restarted scan's goroutine can tell it is stale.
+		scanned savedScan
+		run     int
+	)
+
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	header := func() {
+		table.Clear()
+		for i, h := range []string{"Host", "Port", "State", "RTT", "Banner"} {
+			table.SetCell(0, i, tview.NewTableCell(h).
+				SetTextColor(tcell.ColorYellow).
+				SetSelectable(false))
+		}
+	}
+	header()
+
+	showClosed := false
+	addRow := func(r QCom.ScanResult) {
+		if r.State != QCom.PortOpen && !showClosed {
+			return
+		}
+		color := tcell.ColorLime
+		if r.State != QCom.PortOpen {
+			color = tcell.ColorGray
+		}
+		row := table.GetRowCount()
+		table.SetCell(row, 0, tview.NewTableCell(r.Host).SetTextColor(color))
+		table.SetCell(row, 1, tview.NewTableCell(strconv.Itoa(r.Port)).SetTextColor(color))
+		table.SetCell(row, 2, tview.NewTableCell(string(r.State)).SetTextColor(color))
+		table.SetCell(row, 3, tview.NewTableCell(r.Latency.Round(time.Millisecond).String()).SetTextColor(color))
+		table.SetCell(row, 4, tview.NewTableCell(r.Banner).SetTextColor(color).SetExpansion(1))
+	}
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	number := func(label string, def int) int {
+		n, err := strconv.Atoi(field(label))
+		if err != nil {
+			return def
+		}
+		return n
+	}
+
+	start := func() {
+		hosts, err := QCom.ParseTargets(field("Targets"))
+		if err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		ports, err := QCom.ParsePorts(field("Ports"))
+		if err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		opts := QCom.DefaultScanOptions()
+		opts.Workers = number("Workers", opts.Workers)
+		opts.HostRate = number("Rate/host", opts.HostRate)
+		opts.Timeout = time.Duration(number("Timeout ms", int(opts.Timeout/time.Millisecond))) * time.Millisecond
+
+		mu.Lock()
+		if cancel != nil {
+			cancel()
+		}
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		results = nil
+		started := time.Now()
+		scanned = savedScan{Started: started, Targets: field("Targets"), Ports: field("Ports")}
+		run++
+		gen := run
+		mu.Unlock()
+
+		// current is false once Start has been pressed again; the old
+		// run's results and status then belong to nobody.
+		current := func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return gen == run
+		}
+
+		header()
+		total := len(hosts) * len(ports)
+		status.SetText(fmt.Sprintf("Scanning %d hosts x %d ports...", len(hosts), len(ports)))
+
+		go func() {
+			done, open := 0, 0
+			for r := range QCom.Scan(ctx, hosts, ports, opts) {
+				done++
+				if r.State == QCom.PortOpen {
+					open++
+				}
+				r, done, open := r, done, open
+				mu.Lock()
+				if gen == run {
+					results = append(results, r)
+				}
+				mu.Unlock()
+				app.QueueUpdateDraw(func() {
+					if !current() {
+						return
+					}
+					addRow(r)
+					status.SetText(fmt.Sprintf("%d/%d probed, [green]%d open", done, total, open))
+				})
+			}
+			app.QueueUpdateDraw(func() {
+				if !current() {
+					return
+				}
+				verb := "Finished"
+				if ctx.Err() != nil {
+					verb = "Stopped"
+				}
+				status.SetText(fmt.Sprintf("%s in %s: %d/%d probed, [green]%d open",
+					verb, time.Since(started).Round(time.Millisecond), done, total, open))
+			})
+		}()
+	}
+
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if cancel != nil {
+			cancel()
+			cancel = nil
+		}
+	}
+
+	snapshot := func() []QCom.ScanResult {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]QCom.ScanResult(nil), results...)
+	}
+
+	save := func() {
+		mu.Lock()
+		s := scanned
+		mu.Unlock()
+		if s.Started.IsZero() {
+			status.SetText("[red]Nothing scanned yet")
+			return
+		}
+		for _, r := range snapshot() {
+			if r.State == QCom.PortOpen {
+				s.Results = append(s.Results, r)
+			}
+		}
+		key := "scan:" + s.Started.Format("20060102T150405")
+		parts, err := saveScan(key, s)
+		if err != nil {
+			status.SetText("[red]Save failed: " + err.Error())
+			return
+		}
+		if parts > 1 {
+			status.SetText(fmt.Sprintf("Saved %s in %d parts", key, parts))
+			return
+		}
+		status.SetText("Saved " + key)
+	}
+
+	export := func() {
+		path := field("Export to")
+		f, err := os.Create(path)
+		if err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		defer f.Close()
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			err = QCom.WriteScanJSON(f, snapshot())
+		} else {
+			err = QCom.WriteScanCSV(f, snapshot())
+		}
+		if err != nil {
+			status.SetText("[red]Export failed: " + err.Error())
+			return
+		}
+		status.SetText("Exported to " + path)
+	}
+
+	form.AddInputField("Targets", "127.0.0.1", 0, nil, nil).
+		AddInputField("Ports", config.Scan.Ports, 0, nil, nil).
+		AddInputField("Workers", strconv.Itoa(config.Scan.Workers), 6, tview.InputFieldInteger, nil).
+		AddInputField("Rate/host", strconv.Itoa(config.Scan.HostRate), 6, tview.InputFieldInteger, nil).
+		AddInputField("Timeout ms", strconv.FormatInt(config.Scan.Timeout.Milliseconds(), 10), 6, tview.InputFieldInteger, nil).
+		AddCheckbox("Show closed", false, func(checked bool) {
+			showClosed = checked
+			header()
+			for _, r := range snapshot() {
+				addRow(r)
+			}
+		}).
+		AddInputField("Export to", "scan.csv", 0, nil, nil).
+		AddButton("Start", start).
+		AddButton("Stop", stop).
+		AddButton("Save", save).
+		AddButton("Export", export)
+	form.SetBorder(true).SetTitle(" Port Scan ")
+
+	return &basicPanel{
+		title: "Port Scan",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(table, 0, 1, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		stop: stop,
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/rivo/tview"
+)
+
+type addressPlan struct {
+	Name    string    `json:"name"`
+	Prefix  string    `json:"prefix"`
+	Subnets []string  `json:"subnets"`
+	Notes   string    `json:"notes,omitempty"`
+	Saved   time.Time `json:"saved"`
+}
+
+func init() { registerPanel("subnet", subnetPanel) }
+
+func subnetPanel(app *tview.Application) Panel {
+	out := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	out.SetBorder(true).SetTitle(" Result ")
+	form := tview.NewForm()
+
+	var lastSplit []netip.Prefix
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	setField := func(label, text string) {
+		form.GetFormItemByLabel(label).(*tview.InputField).SetText(text)
+	}
+	fail := func(err error) {
+		out.SetText("[red]" + tview.Escape(err.Error()))
+	}
+
+	info := func() {
+		p, err := QCom.ParsePrefix(field("Prefix"))
+		if err != nil {
+			fail(err)
+			return
+		}
+		i := QCom.PrefixDetails(p)
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "[yellow]Prefix[-]       %s\n", i.Prefix)
+		fmt.Fprintf(&sb, "[yellow]Network[-]      %s\n", i.Network)
+		if i.Prefix.Addr().Is4() {
+			fmt.Fprintf(&sb, "[yellow]Broadcast[-]    %s\n", i.Last)
+			fmt.Fprintf(&sb, "[yellow]Netmask[-]      %s\n", i.Netmask)
+			fmt.Fprintf(&sb, "[yellow]Wildcard[-]     %s\n", i.Wildcard)
+		} else {
+			fmt.Fprintf(&sb, "[yellow]Last address[-] %s\n", i.Last)
+		}
+		fmt.Fprintf(&sb, "[yellow]Host range[-]   %s - %s\n", i.FirstHost, i.LastHost)
+		fmt.Fprintf(&sb, "[yellow]Addresses[-]    %s\n", i.Addresses)
+		fmt.Fprintf(&sb, "[yellow]Usable hosts[-] %s\n", i.Hosts)
+		out.SetText(sb.String())
+	}
+
+	split := func() {
+		p, err := QCom.ParsePrefix(field("Prefix"))
+		if err != nil {
+			fail(err)
+			return
+		}
+		var subnets []netip.Prefix
+		if size := strings.TrimPrefix(field("Subnet size /"), "/"); size != "" {
+			bits, _ := strconv.Atoi(size)
+			subnets, err = QCom.SplitPrefix(p, bits)
+		} else {
+			n, _ := strconv.Atoi(field("Split into"))
+			subnets, err = QCom.SplitPrefixN(p, n)
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		lastSplit = subnets
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s split into %d subnets:\n\n", p, len(subnets))
+		for _, s := range subnets {
+			i := QCom.PrefixDetails(s)
+			fmt.Fprintf(&sb, "%-22s %s - %s (%s hosts)\n", s, i.FirstHost, i.LastHost, i.Hosts)
+		}
+		out.SetText(sb.String()).ScrollToBeginning()
+	}
+
+	summarize := func() {
+		ps, err := QCom.ParsePrefixes(field("List A"))
+		if err != nil {
+			fail(err)
+			return
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%d prefixes summarize to:\n\n", len(ps))
+		for _, p := range QCom.SummarizePrefixes(ps) {
+			sb.WriteString(p.String() + "\n")
+		}
+		out.SetText(sb.String()).ScrollToBeginning()
+	}
+
+	overlap := func() {
+		a, err := QCom.ParsePrefixes(field("List A"))
+		if err != nil {
+			fail(err)
+			return
+		}
+		b, err := QCom.ParsePrefixes(field("List B"))
+		if err != nil {
+			fail(err)
+			return
+		}
+		pairs := QCom.PrefixOverlaps(a, b)
+		if len(pairs) == 0 {
+			out.SetText("[green]No overlap between the two lists")
+			return
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "[orange]%d overlapping pairs:[-]\n\n", len(pairs))
+		for _, p := range pairs {
+			fmt.Fprintf(&sb, "%-22s overlaps %s\n", p[0], p[1])
+		}
+		out.SetText(sb.String()).ScrollToBeginning()
+	}
+
+	savePlan := func() {
+		name := field("Plan name")
+		if name == "" {
+			fail(fmt.Errorf("give the plan a name first"))
+			return
+		}
+		plan := addressPlan{Name: name, Prefix: field("Prefix"), Notes: field("List A"), Saved: time.Now()}
+		for _, s := range lastSplit {
+			plan.Subnets = append(plan.Subnets, s.String())
+		}
+		if err := QbDB.SaveRecord("ipplan:"+name, plan); err != nil {
+			fail(err)
+			return
+		}
+		out.SetText(fmt.Sprintf("Saved plan %q with %d subnets", name, len(plan.Subnets)))
+	}
+
+	loadPlan := func() {
+		name := field("Plan name")
+		if name == "" {
+			keys, err := QbDB.ListRecords("ipplan:")
+			if err != nil {
+				fail(err)
+				return
+			}
+			var sb strings.Builder
+			sb.WriteString("Saved plans:\n\n")
+			for _, k := range keys {
+				sb.WriteString(strings.TrimPrefix(k, "ipplan:") + "\n")
+			}
+			out.SetText(sb.String())
+			return
+		}
+		var plan addressPlan
+		if err := QbDB.LoadRecord("ipplan:"+name, &plan); err != nil {
+			fail(err)
+			return
+		}
+		setField("Prefix", plan.Prefix)
+		setField("List A", plan.Notes)
+		lastSplit = nil
+		for _, s := range plan.Subnets {
+			if p, err := netip.ParsePrefix(s); err == nil {
+				lastSplit = append(lastSplit, p)
+			}
+		}
+		out.SetText(fmt.Sprintf("[yellow]%s[-] (%s, saved %s)\n\n%s",
+			plan.Name, plan.Prefix, plan.Saved.Format(time.DateTime), strings.Join(plan.Subnets, "\n")))
+	}
+
+	form.AddInputField("Prefix", "192.168.0.0/24", 0, nil, nil).
+		AddInputField("Split into", "4", 6, tview.InputFieldInteger, nil).
+		AddInputField("Subnet size /", "", 4, tview.InputFieldInteger, nil).
+		AddInputField("List A", "", 0, nil, nil).
+		AddInputField("List B", "", 0, nil, nil).
+		AddInputField("Plan name", "", 0, nil, nil).
+		AddButton("Info", info).
+		AddButton("Split", split).
+		AddButton("Summarize", summarize).
+		AddButton("Overlap", overlap).
+		AddButton("Save", savePlan).
+		AddButton("Load", loadPlan)
+	form.SetBorder(true).SetTitle(" IP Calculator ")
+
+	return &basicPanel{
+		title: "IP Calculator",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(out, 0, 1, false),
+	}
+}
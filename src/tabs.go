@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+func init() {
+	// Bare digits are left to panels: the flow tables sort with them.
+	for i := 1; i <= 9; i++ {
+		defaultBindings[fmt.Sprintf("tab.%d", i)] = []string{fmt.Sprintf("Alt-%d", i)}
+	}
+	defaultBindings["tab.next"] = []string{"Alt-Right"}
+	defaultBindings["tab.prev"] = []string{"Alt-Left"}
+}
+
+// layoutDir is where workspace layouts are exported to by default.
+func layoutDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "qube", "layouts")
+}
+
+func (w *workspace) registerTabActions() {
+	k := w.keys
+	for i := 1; i <= 9; i++ {
+		k.add(fmt.Sprintf("tab.%d", i), fmt.Sprintf("Switch to workspace %d", i), func() { w.switchTab(i - 1) })
+	}
+	k.add("tab.next", "Next workspace", func() { w.switchTab((w.active + 1) % len(w.tabs)) })
+	k.add("tab.prev", "Previous workspace", func() { w.switchTab((w.active + len(w.tabs) - 1) % len(w.tabs)) })
+	k.add("tab.new", "New workspace", func() {
+		w.ask("New workspace", "Name: ", "", func(name string) error {
+			return w.addTab(savedLayout{Name: name, Slots: map[string]string{}})
+		})
+	})
+	k.add("tab.copy", "Duplicate this workspace", func() {
+		w.ask("Duplicate workspace", "Name: ", w.uniqueTabName(w.tabs[w.active].Name), func(name string) error {
+			return w.addTab(savedLayout{Name: name, Slots: maps.Clone(w.slots), Sizes: maps.Clone(w.tabs[w.active].Sizes)})
+		})
+	})
+	k.add("tab.rename", "Rename this workspace", func() {
+		w.ask("Rename workspace", "Name: ", w.tabs[w.active].Name, w.renameTab)
+	})
+	k.add("tab.close", "Close this workspace", w.closeTab)
+	k.add("tab.export", "Export this workspace layout", func() {
+		path := filepath.Join(layoutDir(), fileSafe(w.tabs[w.active].Name)+".json")
+		w.ask("Export layout", "File: ", path, w.exportTab)
+	})
+	k.add("tab.import", "Import a workspace layout", func() {
+		w.ask("Import layout", "File: ", layoutDir()+string(filepath.Separator), w.importTab)
+	})
+}
+
+// renderTabs draws the tab bar with the active tab highlighted.
+func (w *workspace) renderTabs() {
+	var sb strings.Builder
+	for i, t := range w.tabs {
+		fmt.Fprintf(&sb, `["%d"] %d %s [""] `, i, i+1, tview.Escape(t.Name))
+	}
+	w.tabBar.SetText(sb.String()).Highlight(strconv.Itoa(w.active))
+}
+
+func (w *workspace) switchTab(i int) {
+	if i < 0 || i >= len(w.tabs) {
+		return
+	}
+	if w.zoomed {
+		w.toggleZoom()
+	}
+	w.active = i
+	w.slots = w.tabs[i].Slots
+	for _, slot := range slotOrder {
+		if id, ok := w.slots[slot]; ok {
+			w.panels[id].Refresh()
+		}
+	}
+	w.changed()
+	w.showPane(paneMenu)
+}
+
+func (w *workspace) tabIndex(name string) int {
+	for i, t := range w.tabs {
+		if strings.EqualFold(t.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// uniqueTabName adds a number to name until no tab has it.
+func (w *workspace) uniqueTabName(name string) string {
+	try := name
+	for n := 2; w.tabIndex(try) >= 0; n++ {
+		try = fmt.Sprintf("%s %d", name, n)
+	}
+	return try
+}
+
+func (w *workspace) addTab(l savedLayout) error {
+	if l.Name == "" {
+		return errors.New("the workspace needs a name")
+	}
+	if w.tabIndex(l.Name) >= 0 {
+		return fmt.Errorf("there is already a workspace called %q", l.Name)
+	}
+	w.tabs = append(w.tabs, l)
+	w.switchTab(len(w.tabs) - 1)
+	return nil
+}
+
+func (w *workspace) renameTab(name string) error {
+	if name == "" {
+		return errors.New("the workspace needs a name")
+	}
+	if i := w.tabIndex(name); i >= 0 && i != w.active {
+		return fmt.Errorf("there is already a workspace called %q", name)
+	}
+	w.tabs[w.active].Name = name
+	w.changed()
+	return nil
+}
+
+// closeTab drops the active tab; the last one always stays.
+func (w *workspace) closeTab() {
+	if len(w.tabs) == 1 {
+		return
+	}
+	w.tabs = append(w.tabs[:w.active], w.tabs[w.active+1:]...)
+	w.switchTab(min(w.active, len(w.tabs)-1))
+}
+
+// fileSafe turns a tab name into something usable as a file name.
+func fileSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, name)
+}
+
+func (w *workspace) exportTab(path string) error {
+	if path == "" {
+		return errors.New("no file given")
+	}
+	data, err := json.MarshalIndent(w.tabs[w.active], "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// importTab adds the layout in path as a new tab.
+func (w *workspace) importTab(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var l savedLayout
+	if err := json.Unmarshal(data, &l); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if l.Name == "" {
+		l.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	l.Name, l.Slots = w.uniqueTabName(l.Name), w.validSlots(l.Slots)
+	return w.addTab(l)
+}
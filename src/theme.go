@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Carsen/Qube/Login"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Theme is a colour scheme. Each colour is a name ("red", "lightblue"),
+// a hex value ("#1e1e2e") or "default" for the terminal's own colour; a
+// role left empty is taken from the default theme.
+type Theme struct {
+	Name        string `json:"name" toml:"name"`
+	Foreground  string `json:"foreground" toml:"foreground"`
+	Background  string `json:"background" toml:"background"`
+	Border      string `json:"border" toml:"border"`
+	Title       string `json:"title" toml:"title"`
+	Accent      string `json:"accent" toml:"accent"`
+	Info        string `json:"info" toml:"info"`
+	Success     string `json:"success" toml:"success"`
+	Warning     string `json:"warning" toml:"warning"`
+	Error       string `json:"error" toml:"error"`
+	Muted       string `json:"muted" toml:"muted"`
+	Field       string `json:"field" toml:"field"`
+	SelectionFg string `json:"selection_fg" toml:"selection_fg"`
+	SelectionBg string `json:"selection_bg" toml:"selection_bg"`
+	// Styles adds attributes to a role, e.g. "accent" = "bold underline".
+	// The "selection" role covers anything drawn on a highlighted row.
+	Styles map[string]string `json:"styles" toml:"styles"`
+	// Colors replaces single base colours, e.g. "pink" = "#f5c2e7", for
+	// finer control than the roles give.
+	Colors map[string]string `json:"colors" toml:"colors"`
+}
+
+func (t *Theme) roles() map[string]string {
+	return map[string]string{
+		"foreground":   t.Foreground,
+		"background":   t.Background,
+		"border":       t.Border,
+		"title":        t.Title,
+		"accent":       t.Accent,
+		"info":         t.Info,
+		"success":      t.Success,
+		"warning":      t.Warning,
+		"error":        t.Error,
+		"muted":        t.Muted,
+		"field":        t.Field,
+		"selection_fg": t.SelectionFg,
+		"selection_bg": t.SelectionBg,
+	}
+}
+
+// builtinThemes starts with the default, which mostly leaves the panels'
+// own colours alone.
+var builtinThemes = []Theme{
+	{Name: "default", Border: "white", Title: "white"},
+	{
+		Name:        "high-contrast",
+		Foreground:  "#ffffff",
+		Background:  "#000000",
+		Border:      "#ffffff",
+		Title:       "#ffff00",
+		Accent:      "#ffff00",
+		Info:        "#00ffff",
+		Success:     "#00ff00",
+		Warning:     "#ff8700",
+		Error:       "#ff0000",
+		Muted:       "#c0c0c0",
+		Field:       "#0000af",
+		SelectionFg: "#000000",
+		SelectionBg: "#ffff00",
+		Styles:      map[string]string{"title": "bold", "accent": "bold", "error": "bold", "warning": "bold", "selection": "bold"},
+	},
+	{
+		// monochrome is picked by default when NO_COLOR is set and leans
+		// on attributes alone.
+		Name:        "monochrome",
+		Foreground:  "default",
+		Background:  "default",
+		Border:      "default",
+		Title:       "default",
+		Accent:      "default",
+		Info:        "default",
+		Success:     "default",
+		Warning:     "default",
+		Error:       "default",
+		Muted:       "default",
+		Field:       "default",
+		SelectionFg: "default",
+		SelectionBg: "default",
+		Styles: map[string]string{
+			"title": "bold", "accent": "bold", "error": "bold underline", "warning": "underline",
+			"muted": "dim", "field": "underline", "selection": "reverse",
+		},
+	},
+	{
+		Name:        "solarized-dark",
+		Foreground:  "#839496",
+		Background:  "#002b36",
+		Border:      "#586e75",
+		Title:       "#93a1a1",
+		Accent:      "#b58900",
+		Info:        "#2aa198",
+		Success:     "#859900",
+		Warning:     "#cb4b16",
+		Error:       "#dc322f",
+		Muted:       "#586e75",
+		Field:       "#073642",
+		SelectionFg: "#002b36",
+		SelectionBg: "#93a1a1",
+		Colors:      map[string]string{"lightblue": "#268bd2", "pink": "#d33682"},
+	},
+	{
+		Name:        "light",
+		Foreground:  "#1c1c1c",
+		Background:  "#ffffff",
+		Border:      "#8a8a8a",
+		Title:       "#1c1c1c",
+		Accent:      "#875f00",
+		Info:        "#005f87",
+		Success:     "#008700",
+		Warning:     "#af5f00",
+		Error:       "#d70000",
+		Muted:       "#808080",
+		Field:       "#dadada",
+		SelectionFg: "#ffffff",
+		SelectionBg: "#005faf",
+		Styles:      map[string]string{"title": "bold"},
+	},
+}
+
+// The panels, and tview underneath them, only ever draw with these
+// colours. Each stands for a role as text and as background, which is
+// what lets a theme recolour the whole screen without the panels knowing.
+var baseColors = []struct {
+	c      tcell.Color
+	fg, bg string
+}{
+	{tcell.ColorDefault, "foreground", "background"},
+	{tcell.ColorWhite, "foreground", "selection_bg"},
+	{tcell.ColorBlack, "selection_fg", "background"},
+	{tcell.ColorSilver, "border", "border"},
+	{tcell.ColorTeal, "title", "title"},
+	{tcell.ColorBlue, "info", "field"},
+	{tcell.ColorNavy, "muted", "field"},
+	{tcell.ColorYellow, "accent", "accent"},
+	{tcell.ColorOrange, "warning", "warning"},
+	{tcell.ColorPink, "warning", "warning"},
+	{tcell.ColorRed, "error", "error"},
+	{tcell.ColorGreen, "success", "success"},
+	{tcell.ColorLime, "success", "success"},
+	{tcell.ColorLightGreen, "success", "success"},
+	{tcell.ColorAqua, "info", "info"},
+	{tcell.ColorLightBlue, "info", "info"},
+	{tcell.ColorLightCyan, "info", "info"},
+	{tcell.ColorGray, "muted", "muted"},
+}
+
+func init() {
+	// Give borders, titles, input fields and inverse text colours of
+	// their own so the theme can tell them apart from panel text.
+	tview.Styles.BorderColor = tcell.ColorSilver
+	tview.Styles.GraphicsColor = tcell.ColorSilver
+	tview.Styles.TitleColor = tcell.ColorTeal
+	tview.Styles.MoreContrastBackgroundColor = tcell.ColorBlue
+	tview.Styles.InverseTextColor = tcell.ColorBlack
+}
+
+func parseColor(s string) (tcell.Color, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "default" {
+		return tcell.ColorDefault, nil
+	}
+	if c := tcell.GetColor(s); c != tcell.ColorDefault {
+		return c, nil
+	}
+	return tcell.ColorDefault, fmt.Errorf("unknown colour %q", s)
+}
+
+var attrNames = map[string]tcell.AttrMask{
+	"bold":          tcell.AttrBold,
+	"dim":           tcell.AttrDim,
+	"italic":        tcell.AttrItalic,
+	"underline":     tcell.AttrUnderline,
+	"reverse":       tcell.AttrReverse,
+	"blink":         tcell.AttrBlink,
+	"strikethrough": tcell.AttrStrikeThrough,
+}
+
+// parseAttrs reads a list like "bold underline" or "bold|dim".
+func parseAttrs(s string) (tcell.AttrMask, error) {
+	var m tcell.AttrMask
+	for _, f := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '|' || r == '+'
+	}) {
+		a, ok := attrNames[f]
+		if !ok {
+			return 0, fmt.Errorf("unknown style %q", f)
+		}
+		m |= a
+	}
+	return m, nil
+}
+
+// palette is a compiled theme: what each base colour becomes and which
+// attributes it picks up.
+type palette struct {
+	fg, bg    map[tcell.Color]tcell.Color
+	attrs     map[tcell.Color]tcell.AttrMask
+	field     tcell.AttrMask
+	selection tcell.AttrMask
+}
+
+func (t *Theme) compile() (*palette, error) {
+	roles := t.roles()
+	colors := map[string]tcell.Color{}
+	fallback := builtinThemes[0].roles()
+	for role, v := range roles {
+		if v == "" {
+			v = fallback[role]
+		}
+		if v == "" {
+			continue
+		}
+		c, err := parseColor(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", role, err)
+		}
+		colors[role] = c
+	}
+	attrs := map[string]tcell.AttrMask{}
+	for role, v := range t.Styles {
+		if _, ok := roles[role]; !ok && role != "selection" {
+			return nil, fmt.Errorf("styles: unknown role %q", role)
+		}
+		a, err := parseAttrs(v)
+		if err != nil {
+			return nil, fmt.Errorf("styles.%s: %w", role, err)
+		}
+		attrs[role] = a
+	}
+
+	p := &palette{
+		fg:        map[tcell.Color]tcell.Color{},
+		bg:        map[tcell.Color]tcell.Color{},
+		attrs:     map[tcell.Color]tcell.AttrMask{},
+		field:     attrs["field"],
+		selection: attrs["selection"],
+	}
+	for _, b := range baseColors {
+		if c, ok := colors[b.fg]; ok {
+			p.fg[b.c] = c
+		}
+		if c, ok := colors[b.bg]; ok {
+			p.bg[b.c] = c
+		}
+		p.attrs[b.c] = attrs[b.fg]
+	}
+	for name, v := range t.Colors {
+		base, err := parseColor(name)
+		if err != nil {
+			return nil, fmt.Errorf("colors: %w", err)
+		}
+		c, err := parseColor(v)
+		if err != nil {
+			return nil, fmt.Errorf("colors.%s: %w", name, err)
+		}
+		p.fg[base], p.bg[base] = c, c
+	}
+	return p, nil
+}
+
+func (p *palette) restyle(st tcell.Style) tcell.Style {
+	fg, bg, attr := st.Decompose()
+	attr |= p.attrs[fg]
+	switch bg {
+	case tcell.ColorDefault, tcell.ColorBlack:
+	case tcell.ColorBlue:
+		attr |= p.field
+	default:
+		// Anything else on a coloured background is a highlighted row.
+		attr |= p.selection
+	}
+	if c, ok := p.fg[fg]; ok {
+		st = st.Foreground(c)
+	}
+	if c, ok := p.bg[bg]; ok {
+		st = st.Background(c)
+	}
+	return st.Attributes(attr)
+}
+
+// themedScreen recolours everything drawn through it with the current
+// palette. It remembers the style each cell was drawn with, because tview
+// reads cells back to highlight them and must see its own colours.
+type themedScreen struct {
+	tcell.Screen
+	pal    atomic.Pointer[palette]
+	w, h   int
+	styles []tcell.Style
+}
+
+func newThemedScreen(s tcell.Screen) *themedScreen {
+	return &themedScreen{Screen: s}
+}
+
+// use switches to p; the next draw repaints with it.
+func (s *themedScreen) use(p *palette) {
+	s.pal.Store(p)
+	s.Screen.SetStyle(p.restyle(tcell.StyleDefault))
+}
+
+func (s *themedScreen) restyle(st tcell.Style) tcell.Style {
+	if p := s.pal.Load(); p != nil {
+		return p.restyle(st)
+	}
+	return st
+}
+
+// index finds x, y in styles, growing it when the terminal has.
+func (s *themedScreen) index(x, y int) int {
+	if x < 0 || y < 0 {
+		return -1
+	}
+	if x >= s.w || y >= s.h {
+		w, h := s.Screen.Size()
+		if x >= w || y >= h {
+			return -1
+		}
+		s.w, s.h = w, h
+		s.styles = make([]tcell.Style, w*h)
+	}
+	return y*s.w + x
+}
+
+func (s *themedScreen) SetContent(x, y int, primary rune, combining []rune, style tcell.Style) {
+	if i := s.index(x, y); i >= 0 {
+		s.styles[i] = style
+	}
+	s.Screen.SetContent(x, y, primary, combining, s.restyle(style))
+}
+
+func (s *themedScreen) GetContent(x, y int) (rune, []rune, tcell.Style, int) {
+	primary, combining, style, width := s.Screen.GetContent(x, y)
+	if x >= 0 && y >= 0 && x < s.w && y < s.h {
+		style = s.styles[y*s.w+x]
+	}
+	return primary, combining, style, width
+}
+
+func (s *themedScreen) Fill(r rune, style tcell.Style) {
+	s.index(0, 0)
+	for i := range s.styles {
+		s.styles[i] = style
+	}
+	s.Screen.Fill(r, s.restyle(style))
+}
+
+func (s *themedScreen) Clear() {
+	s.Fill(' ', tcell.StyleDefault)
+}
+
+// themeDir holds the user's own themes, one .toml or .json file each.
+func themeDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "qube", "themes")
+}
+
+func readTheme(path string) (Theme, error) {
+	var t Theme
+	switch filepath.Ext(path) {
+	case ".toml":
+		if _, err := toml.DecodeFile(path, &t); err != nil {
+			return t, err
+		}
+	case ".json":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return t, err
+		}
+		if err := json.Unmarshal(data, &t); err != nil {
+			return t, err
+		}
+	}
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	_, err := t.compile()
+	return t, err
+}
+
+// loadThemes returns the built-in themes followed by the user's. A user
+// theme with a built-in name replaces it; broken files are reported and
+// skipped.
+func loadThemes() ([]Theme, []error) {
+	themes := append([]Theme(nil), builtinThemes...)
+	var errs []error
+	dir := themeDir()
+	entries, _ := os.ReadDir(dir)
+	var mine []Theme
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); e.IsDir() || ext != ".toml" && ext != ".json" {
+			continue
+		}
+		t, err := readTheme(filepath.Join(dir, e.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name(), err))
+			continue
+		}
+		mine = append(mine, t)
+	}
+	sort.Slice(mine, func(i, j int) bool { return mine[i].Name < mine[j].Name })
+	for _, t := range mine {
+		i := 0
+		for i < len(themes) && themes[i].Name != t.Name {
+			i++
+		}
+		if i < len(themes) {
+			themes[i] = t
+		} else {
+			themes = append(themes, t)
+		}
+	}
+	return themes, errs
+}
+
+func themeKey() string {
+	id := Login.UserID()
+	if id == "" {
+		id = "default"
+	}
+	return "theme:" + id
+}
+
+// savedTheme is the user's choice, then ui.theme, then monochrome for
+// NO_COLOR users.
+func savedTheme() string {
+	var name string
+	if QbDB.LoadRecord(themeKey(), &name) == nil && name != "" {
+		return name
+	}
+	if config.UI.Theme != "" {
+		return config.UI.Theme
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return "monochrome"
+	}
+	return "default"
+}
+
+func (w *workspace) findTheme(name string) (Theme, bool) {
+	for _, t := range w.themes {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Theme{}, false
+}
+
+// setTheme switches the screen to the named theme without saving it.
+func (w *workspace) setTheme(name string) error {
+	t, ok := w.findTheme(name)
+	if !ok {
+		return fmt.Errorf("no theme named %q", name)
+	}
+	p, err := t.compile()
+	if err != nil {
+		return err
+	}
+	w.screen.use(p)
+	w.theme = name
+	return nil
+}
+
+func (w *workspace) useTheme(name string) error {
+	if err := w.setTheme(name); err != nil {
+		return err
+	}
+	return QbDB.SaveRecord(themeKey(), name)
+}
+
+// showThemes lists the themes, previewing each as it is highlighted.
+func (w *workspace) showThemes() {
+	before := w.theme
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true).SetTitle(" Themes ")
+	status := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	text := "[gray]Enter keep  Esc cancel"
+	if dir := themeDir(); dir != "" {
+		text += "\nYour themes: " + tview.Escape(dir)
+	}
+	for _, err := range w.themeErrs {
+		text += "\n[red]" + tview.Escape(err.Error())
+	}
+	status.SetText(text)
+
+	for i, t := range w.themes {
+		list.AddItem(tview.Escape(t.Name), "", 0, nil)
+		if t.Name == before {
+			list.SetCurrentItem(i)
+		}
+	}
+	list.SetChangedFunc(func(i int, _, _ string, _ rune) {
+		w.setTheme(w.themes[i].Name)
+	})
+	list.SetSelectedFunc(func(i int, _, _ string, _ rune) {
+		if err := w.useTheme(w.themes[i].Name); err != nil {
+			status.SetText("[red]" + tview.Escape(err.Error()))
+			return
+		}
+		w.closeOverlay("themes")
+	})
+	list.SetDoneFunc(func() {
+		w.setTheme(before)
+		w.closeOverlay("themes")
+	})
+
+	w.overlay("themes", tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(status, 3+len(w.themeErrs), 0, false), 56, 18+len(w.themeErrs))
+}
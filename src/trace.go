@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func init() { registerPanel("trace", tracePanel) }
+
+func tracePanel(app *tview.Application) Panel {
+	var (
+		mu     sync.Mutex
+		cancel context.CancelFunc
+		// run counts starts and stops, so rounds and errors from a tracer
+		// that has since been stopped or replaced are dropped.
+		run int
+	)
+
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+	}
+
+	render := func(hops []QCom.TraceHop) {
+		table.Clear()
+		for i, h := range []string{"Hop", "Host", "Loss%", "Sent", "Last", "Avg", "Best", "Worst", "StdDev"} {
+			table.SetCell(0, i, tview.NewTableCell(h).
+				SetTextColor(tcell.ColorYellow).
+				SetSelectable(false))
+		}
+		for r, h := range hops {
+			s := h.Stats
+			host := h.Addr
+			if host == "" {
+				host = "???"
+			} else if h.Name != "" {
+				host = fmt.Sprintf("%s (%s)", h.Name, h.Addr)
+			}
+			color := tcell.ColorLime
+			if s.Received == 0 {
+				color = tcell.ColorGray
+			} else if s.Loss() > 0 {
+				color = tcell.ColorOrange
+			}
+			for c, v := range []string{
+				strconv.Itoa(h.TTL), host, strconv.FormatFloat(s.Loss(), 'f', 1, 64), strconv.Itoa(s.Sent),
+				ms(s.Last), ms(s.Avg), ms(s.Min), ms(s.Max), ms(s.StdDev),
+			} {
+				cell := tview.NewTableCell(v).SetTextColor(color)
+				if c == 1 {
+					cell.SetExpansion(1)
+				}
+				table.SetCell(r+1, c, cell)
+			}
+		}
+	}
+	render(nil)
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		run++
+		if cancel != nil {
+			cancel()
+			cancel = nil
+		}
+	}
+
+	start := func() {
+		stop()
+		target := field("Target")
+		if target == "" {
+			status.SetText("[red]No target given")
+			return
+		}
+		t := QCom.NewTracer(target)
+		_, t.Mode = form.GetFormItemByLabel("Mode").(*tview.DropDown).GetCurrentOption()
+		t.Port, _ = strconv.Atoi(field("Port"))
+		if n, err := strconv.Atoi(field("Max hops")); err == nil && n > 0 && n <= 64 {
+			t.MaxHops = n
+		}
+
+		ctx, c := context.WithCancel(context.Background())
+		mu.Lock()
+		cancel = c
+		gen := run
+		mu.Unlock()
+		current := func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return gen == run
+		}
+
+		t.OnRound = func(hops []QCom.TraceHop) {
+			app.QueueUpdateDraw(func() {
+				if current() {
+					render(hops)
+				}
+			})
+		}
+		render(nil)
+		status.SetText(fmt.Sprintf("Tracing %s over %s...", target, t.Mode))
+		go func() {
+			if err := t.Run(ctx); err != nil {
+				app.QueueUpdateDraw(func() {
+					if current() {
+						status.SetText("[red]" + err.Error())
+					}
+				})
+			}
+		}()
+	}
+
+	modes := []string{QCom.TraceUDP, QCom.TraceTCP}
+	form.AddInputField("Target", "", 0, nil, nil).
+		AddDropDown("Mode", modes, optionIndex(modes, config.Trace.Mode), nil).
+		AddInputField("Port", "", 6, tview.InputFieldInteger, nil).
+		AddInputField("Max hops", strconv.Itoa(config.Trace.MaxHops), 4, tview.InputFieldInteger, nil).
+		AddButton("Start", start).
+		AddButton("Stop", func() {
+			stop()
+			status.SetText("Stopped")
+		})
+	form.SetBorder(true).SetTitle(" Traceroute ")
+
+	return &basicPanel{
+		title: "Traceroute",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(table, 0, 1, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		stop: stop,
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Carsen/Qube/QCom"
+	"github.com/Carsen/Qube/QbDB"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+type wakeDevice struct {
+	Name      string `json:"name"`
+	MAC       string `json:"mac"`
+	Interface string `json:"iface,omitempty"`
+	Broadcast string `json:"bcast,omitempty"`
+	// SecureOn goes into the magic packet as it is, so it can't be
+	// hashed; it sits in the database in plain text and is only ever
+	// shown masked.
+	SecureOn string `json:"secureon,omitempty"`
+}
+
+func (d wakeDevice) wake() error {
+	mac, err := net.ParseMAC(d.MAC)
+	if err != nil {
+		return err
+	}
+	pw, err := QCom.ParseSecureOn(d.SecureOn)
+	if err != nil {
+		return err
+	}
+	return QCom.SendWake(mac, pw, d.Interface, d.Broadcast, config.WoL.Port)
+}
+
+func loadWakeDevices() []wakeDevice {
+	keys, _ := QbDB.ListRecords("wol:")
+	var out []wakeDevice
+	for _, k := range keys {
+		var d wakeDevice
+		if QbDB.LoadRecord(k, &d) == nil {
+			out = append(out, d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func init() { registerPanel("wol", wolPanel) }
+
+func wolPanel(app *tview.Application) Panel {
+	devices := loadWakeDevices()
+
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	table.SetBorder(true).SetTitle(" Devices (Enter wakes, Esc to edit) ")
+	status := tview.NewTextView().SetDynamicColors(true)
+	form := tview.NewForm()
+
+	// The first option leaves the interface to the routing table.
+	ifaces := []string{"(any)"}
+	if list, err := net.Interfaces(); err == nil {
+		for _, ifi := range list {
+			if ifi.Flags&net.FlagLoopback == 0 && ifi.Flags&net.FlagBroadcast != 0 {
+				ifaces = append(ifaces, ifi.Name)
+			}
+		}
+	}
+
+	field := func(label string) string {
+		return strings.TrimSpace(form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+	setField := func(label, v string) {
+		form.GetFormItemByLabel(label).(*tview.InputField).SetText(v)
+	}
+	ifaceDrop := func() *tview.DropDown {
+		return form.GetFormItemByLabel("Interface").(*tview.DropDown)
+	}
+
+	render := func() {
+		table.Clear()
+		for i, h := range []string{"Name", "MAC", "Interface", "Broadcast", "SecureOn"} {
+			table.SetCell(0, i, tview.NewTableCell(h).
+				SetTextColor(tcell.ColorYellow).
+				SetSelectable(false))
+		}
+		for r, d := range devices {
+			pw := ""
+			if d.SecureOn != "" {
+				pw = "yes"
+			}
+			for c, v := range []string{d.Name, d.MAC, d.Interface, d.Broadcast, pw} {
+				table.SetCell(r+1, c, tview.NewTableCell(tview.Escape(v)))
+			}
+		}
+	}
+
+	fromForm := func() (wakeDevice, error) {
+		_, iface := ifaceDrop().GetCurrentOption()
+		if iface == ifaces[0] {
+			iface = ""
+		}
+		d := wakeDevice{
+			Name:      field("Name"),
+			MAC:       field("MAC"),
+			Interface: iface,
+			Broadcast: field("Broadcast"),
+			SecureOn:  field("SecureOn"),
+		}
+		mac, err := net.ParseMAC(d.MAC)
+		if err != nil {
+			return d, err
+		}
+		d.MAC = mac.String()
+		if _, err := QCom.ParseSecureOn(d.SecureOn); err != nil {
+			return d, err
+		}
+		return d, nil
+	}
+
+	wake := func(d wakeDevice) {
+		if err := d.wake(); err != nil {
+			status.SetText("[red]" + tview.Escape(err.Error()))
+			return
+		}
+		name := d.Name
+		if name == "" {
+			name = d.MAC
+		}
+		status.SetText("[green]Magic packet sent to " + tview.Escape(name))
+	}
+
+	save := func() {
+		d, err := fromForm()
+		if err == nil && d.Name == "" {
+			err = errors.New("give the device a name first")
+		}
+		if err != nil {
+			status.SetText("[red]" + tview.Escape(err.Error()))
+			return
+		}
+		if err := QbDB.SaveRecord("wol:"+d.Name, d); err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		devices = loadWakeDevices()
+		render()
+		msg := "Saved " + tview.Escape(d.Name)
+		if d.SecureOn != "" {
+			msg += " [yellow](SecureOn password stored unencrypted)"
+		}
+		status.SetText(msg)
+	}
+
+	remove := func() {
+		name := field("Name")
+		var d wakeDevice
+		if name == "" || QbDB.LoadRecord("wol:"+name, &d) != nil {
+			status.SetText("[red]No saved device named " + tview.Escape(name))
+			return
+		}
+		if err := QbDB.DeleteRecord("wol:" + name); err != nil {
+			status.SetText("[red]" + err.Error())
+			return
+		}
+		devices = loadWakeDevices()
+		render()
+		status.SetText("Removed " + tview.Escape(name))
+	}
+
+	table.SetSelectedFunc(func(row, _ int) {
+		if row >= 1 && row-1 < len(devices) {
+			wake(devices[row-1])
+		}
+	})
+	table.SetSelectionChangedFunc(func(row, _ int) {
+		if row < 1 || row-1 >= len(devices) {
+			return
+		}
+		d := devices[row-1]
+		setField("Name", d.Name)
+		setField("MAC", d.MAC)
+		setField("Broadcast", d.Broadcast)
+		setField("SecureOn", d.SecureOn)
+		ifaceDrop().SetCurrentOption(0)
+		for i, name := range ifaces {
+			if name == d.Interface {
+				ifaceDrop().SetCurrentOption(i)
+			}
+		}
+	})
+
+	form.AddInputField("Name", "", 0, nil, nil).
+		AddInputField("MAC", "", 0, nil, nil).
+		AddDropDown("Interface", ifaces, optionIndex(ifaces, config.WoL.Interface), nil).
+		AddInputField("Broadcast", "", 0, nil, nil).
+		AddPasswordField("SecureOn", "", 0, '*', nil).
+		AddButton("Wake", func() {
+			d, err := fromForm()
+			if err != nil {
+				status.SetText("[red]" + tview.Escape(err.Error()))
+				return
+			}
+			wake(d)
+		}).
+		AddButton("Save", save).
+		AddButton("Remove", remove)
+	form.SetBorder(true).SetTitle(" Wake-on-LAN ")
+
+	// Esc hops between the form and the device list.
+	form.SetCancelFunc(func() { app.SetFocus(table) })
+	table.SetDoneFunc(func(tcell.Key) { app.SetFocus(form) })
+	render()
+
+	return &basicPanel{
+		title: "Wake-on-LAN",
+		prim: tview.NewFlex().
+			AddItem(form, 36, 0, true).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(table, 0, 1, false).
+				AddItem(status, 1, 0, false), 0, 1, false),
+		keys: []KeyHint{escHint, {"Enter", "wake the selected device"}},
+	}
+}